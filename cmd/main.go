@@ -71,7 +71,9 @@ func start(configFile string) error {
 	config.InitEnv()
 
 	srv := server.NewServer()
-	srv.Init()
+	if err := srv.Init(); err != nil {
+		return err
+	}
 
 	prom.MetacenterNodeAlive.Set(1)
 	if err := srv.Run(); err != nil {
@@ -0,0 +1,253 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/aigw-project/metadata-center/pkg/ginx"
+	"github.com/aigw-project/metadata-center/pkg/meta/load"
+	"github.com/aigw-project/metadata-center/pkg/replicator"
+	"github.com/aigw-project/metadata-center/pkg/utils/errors"
+	"github.com/aigw-project/metadata-center/pkg/utils/logger"
+)
+
+// AdminAPI handles introspection HTTP endpoints for debugging load statistics
+type AdminAPI struct {
+}
+
+// requireLocalState rejects admin calls when the backend is shared, they only act on the in-memory state
+func requireLocalState(c *gin.Context) bool {
+	if err := load.CheckAdmin(); err != nil {
+		ginx.ResError(c, err)
+		return false
+	}
+	return true
+}
+
+// Clusters handles GET requests for listing tracked clusters
+func (a *AdminAPI) Clusters(c *gin.Context) {
+	if !requireLocalState(c) {
+		return
+	}
+	var pageParam load.PageRequest
+	if err := ginx.ParseQuery(c, &pageParam); err != nil {
+		logger.Errorf("admin api: list clusters request error: %v", err)
+		ginx.ResError(c, err)
+		return
+	}
+
+	ginx.ResSuccess(c, load.ListClusters(ginx.GetTenant(c), pageParam))
+}
+
+// EngineRequests handles GET requests for listing the in-flight requests of an engine
+func (a *AdminAPI) EngineRequests(c *gin.Context) {
+	if !requireLocalState(c) {
+		return
+	}
+	var queryParam load.EngineRequestsQuery
+	if err := ginx.ParseQuery(c, &queryParam); err != nil {
+		logger.Errorf("admin api: list engine requests request error: %v", err)
+		ginx.ResError(c, err)
+		return
+	}
+
+	scopeNames(c, &queryParam.Cluster)
+	page := load.ListEngineRequests(&queryParam)
+	for _, info := range page.Items {
+		unscopeRequestInfo(c, info)
+	}
+	ginx.ResSuccess(c, page)
+}
+
+// Request handles GET requests for looking up a single in-flight request
+func (a *AdminAPI) Request(c *gin.Context) {
+	if !requireLocalState(c) {
+		return
+	}
+	var queryParam load.RequestQuery
+	if err := ginx.ParseQuery(c, &queryParam); err != nil {
+		logger.Errorf("admin api: get request request error: %v", err)
+		ginx.ResError(c, err)
+		return
+	}
+
+	requestID := queryParam.RequestId
+	scopeNames(c, &queryParam.RequestId)
+	req, ok := load.GetRequest(&queryParam)
+	if !ok {
+		ginx.ResError(c, errors.NotFound("request %s not found", requestID))
+		return
+	}
+	unscopeRequestInfo(c, req)
+	ginx.ResSuccess(c, req)
+}
+
+// Evict handles DELETE requests for forcibly removing an in-flight request
+func (a *AdminAPI) Evict(c *gin.Context) {
+	if !requireLocalState(c) {
+		return
+	}
+	if !requireOperator(c) {
+		return
+	}
+	var reqParam load.EvictRequest
+	if err := ginx.ParseJSON(c, &reqParam); err != nil {
+		logger.Errorf("admin api: evict request error: %v", err)
+		ginx.ResError(c, err)
+		return
+	}
+
+	c.Set(RequestIdCtxKey, reqParam.RequestId)
+	scopeNames(c, &reqParam.RequestId)
+	result := load.Evict(&reqParam)
+	replicator.Replicate(c, load.LoadAdminEvictRequest, reqParam) // Replicate to other instances
+
+	ginx.ResSuccess(c, result)
+}
+
+// ResetEngine handles POST requests for rebuilding engine counters from tracked requests
+func (a *AdminAPI) ResetEngine(c *gin.Context) {
+	if !requireLocalState(c) {
+		return
+	}
+	if !requireOperator(c) {
+		return
+	}
+	var reqParam load.EngineAction
+	if err := ginx.ParseJSON(c, &reqParam); err != nil {
+		logger.Errorf("admin api: reset engine request error: %v", err)
+		ginx.ResError(c, err)
+		return
+	}
+
+	scopeNames(c, &reqParam.Cluster)
+	result := load.ResetEngine(&reqParam)
+	replicator.Replicate(c, load.LoadAdminResetEngine, reqParam) // Replicate to other instances
+
+	ginx.ResSuccess(c, result)
+}
+
+// DeleteEngine handles DELETE requests for removing an engine immediately
+func (a *AdminAPI) DeleteEngine(c *gin.Context) {
+	if !requireLocalState(c) {
+		return
+	}
+	if !requireOperator(c) {
+		return
+	}
+	var reqParam load.EngineAction
+	if err := ginx.ParseJSON(c, &reqParam); err != nil {
+		logger.Errorf("admin api: delete engine request error: %v", err)
+		ginx.ResError(c, err)
+		return
+	}
+
+	scopeNames(c, &reqParam.Cluster)
+	result := load.DeleteEngine(&reqParam)
+	replicator.Replicate(c, load.LoadAdminDeleteEngine, reqParam) // Replicate to other instances
+
+	ginx.ResSuccess(c, result)
+}
+
+// DeleteCluster handles DELETE requests for removing a cluster immediately
+func (a *AdminAPI) DeleteCluster(c *gin.Context) {
+	if !requireLocalState(c) {
+		return
+	}
+	if !requireOperator(c) {
+		return
+	}
+	var reqParam load.ClusterAction
+	if err := ginx.ParseJSON(c, &reqParam); err != nil {
+		logger.Errorf("admin api: delete cluster request error: %v", err)
+		ginx.ResError(c, err)
+		return
+	}
+
+	scopeNames(c, &reqParam.Cluster)
+	result := load.DeleteCluster(&reqParam)
+	replicator.Replicate(c, load.LoadAdminDeleteCluster, reqParam) // Replicate to other instances
+
+	ginx.ResSuccess(c, result)
+}
+
+// Tenants handles GET requests for listing tenants with their quotas and usage
+func (a *AdminAPI) Tenants(c *gin.Context) {
+	if !requireLocalState(c) {
+		return
+	}
+	if !requireOperator(c) {
+		return
+	}
+	var pageParam load.PageRequest
+	if err := ginx.ParseQuery(c, &pageParam); err != nil {
+		logger.Errorf("admin api: list tenants request error: %v", err)
+		ginx.ResError(c, err)
+		return
+	}
+
+	ginx.ResSuccess(c, load.ListTenants(pageParam))
+}
+
+// SetTenantLimit handles PUT requests for overriding the quotas of a tenant at runtime
+func (a *AdminAPI) SetTenantLimit(c *gin.Context) {
+	if !requireLocalState(c) {
+		return
+	}
+	if !requireOperator(c) {
+		return
+	}
+	var reqParam load.TenantLimit
+	if err := ginx.ParseJSON(c, &reqParam); err != nil {
+		logger.Errorf("admin api: set tenant limit request error: %v", err)
+		ginx.ResError(c, err)
+		return
+	}
+	if !load.ValidTenant(reqParam.Tenant) {
+		ginx.ResError(c, errors.InvalidInput("invalid tenant %q", reqParam.Tenant))
+		return
+	}
+
+	result := load.SetTenantLimit(&reqParam)
+	replicator.Replicate(c, load.LoadAdminSetTenantLimit, reqParam) // Replicate to other instances
+
+	ginx.ResSuccess(c, result)
+}
+
+// DeleteTenantLimit handles DELETE requests for restoring the configured quotas of a tenant
+func (a *AdminAPI) DeleteTenantLimit(c *gin.Context) {
+	if !requireLocalState(c) {
+		return
+	}
+	if !requireOperator(c) {
+		return
+	}
+	var reqParam load.TenantAction
+	if err := ginx.ParseJSON(c, &reqParam); err != nil {
+		logger.Errorf("admin api: delete tenant limit request error: %v", err)
+		ginx.ResError(c, err)
+		return
+	}
+	if !load.ValidTenant(reqParam.Tenant) {
+		ginx.ResError(c, errors.InvalidInput("invalid tenant %q", reqParam.Tenant))
+		return
+	}
+
+	result := load.DeleteTenantLimit(&reqParam)
+	replicator.Replicate(c, load.LoadAdminDeleteTenantLimit, reqParam) // Replicate to other instances
+
+	ginx.ResSuccess(c, result)
+}
@@ -0,0 +1,76 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/aigw-project/metadata-center/pkg/meta/load"
+	"github.com/aigw-project/metadata-center/pkg/utils/logger"
+)
+
+// ForwardedHeader marks a call forwarded to the consensus leader, it is never forwarded again
+const ForwardedHeader = "X-Forwarded-To-Leader"
+
+// forwardClient sends calls to the consensus leader
+var forwardClient = &http.Client{Timeout: 5 * time.Second}
+
+// forwardToLeader sends a call rejected by a follower to the consensus leader and writes its response
+// payload is the request before tenant scoping, the leader scopes it again from the forwarded headers
+// Returns false when err is not a follower rejection or the call cannot be forwarded, the caller then reports err
+func forwardToLeader(c *gin.Context, err error, payload any) bool {
+	var notLeader *load.NotLeaderError
+	if !errors.As(err, &notLeader) || notLeader.Leader == "" || c.GetHeader(ForwardedHeader) != "" {
+		return false
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return false
+	}
+
+	url := "http://" + notLeader.Leader + c.Request.URL.RequestURI()
+	req, err := http.NewRequestWithContext(c.Request.Context(), c.Request.Method, url, bytes.NewReader(body))
+	if err != nil {
+		logger.Errorf("load api: failed to forward to leader %s: %v", notLeader.Leader, err)
+		return false
+	}
+	req.Header = c.Request.Header.Clone()
+	req.Header.Set(ForwardedHeader, "true")
+	req.Header.Set("Content-Type", "application/json")
+	req.ContentLength = int64(len(body))
+	req.Header.Del("Content-Length")
+
+	resp, err := forwardClient.Do(req)
+	if err != nil {
+		logger.Errorf("load api: failed to forward to leader %s: %v", notLeader.Leader, err)
+		return false
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		logger.Errorf("load api: failed to read the response of leader %s: %v", notLeader.Leader, err)
+		return false
+	}
+	c.Data(resp.StatusCode, resp.Header.Get("Content-Type"), data)
+	c.Abort()
+	return true
+}
@@ -23,8 +23,11 @@ import (
 	"testing"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/stretchr/testify/require"
 
+	"github.com/aigw-project/metadata-center/pkg/config"
+	"github.com/aigw-project/metadata-center/pkg/ginx"
 	"github.com/aigw-project/metadata-center/pkg/meta/load"
 )
 
@@ -85,3 +88,197 @@ func TestLoadAPI_Params_Validate(t *testing.T) {
 		require.Equal(t, 400, w.Code)
 	})
 }
+
+func TestLoadAPI_Report_Validate(t *testing.T) {
+	loadAPI := LoadAPI{}
+	for name, p := range map[string]load.EngineReport{
+		"missing cluster":       {Ip: "1.1.1.1"},
+		"invalid ip":            {Cluster: "test", Ip: "invalid"},
+		"negative running":      {Cluster: "test", Ip: "1.1.1.1", RunningReqNum: -1},
+		"kv cache usage over 1": {Cluster: "test", Ip: "1.1.1.1", KVCacheUsage: 1.5},
+	} {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			b, _ := json.Marshal(p)
+			req, _ := http.NewRequest(http.MethodPost, "127.0.0.1", bytes.NewBuffer(b))
+			c.Request = req
+			loadAPI.Report(c)
+			require.Equal(t, 400, w.Code)
+		})
+	}
+}
+
+func TestLoadAPI_EngineIdentity_Validate(t *testing.T) {
+	tests := []struct {
+		name  string
+		req   load.InferenceRequest
+		valid bool
+	}{
+		{"ipv4 only", load.InferenceRequest{Cluster: "test", RequestId: "1", Ip: "1.1.1.1"}, true},
+		{"ipv6 only", load.InferenceRequest{Cluster: "test", RequestId: "1", Ip: "fd00::1"}, true},
+		{"ipv4 endpoint", load.InferenceRequest{Cluster: "test", RequestId: "1", Endpoint: "1.1.1.1:8000"}, true},
+		{"ipv6 endpoint", load.InferenceRequest{Cluster: "test", RequestId: "1", Endpoint: "[fd00::1]:8000"}, true},
+		{"ip and endpoint", load.InferenceRequest{Cluster: "test", RequestId: "1", Ip: "1.1.1.1", Endpoint: "1.1.1.1:8000"}, true},
+		{"missing identity", load.InferenceRequest{Cluster: "test", RequestId: "1"}, false},
+		{"endpoint without port", load.InferenceRequest{Cluster: "test", RequestId: "1", Endpoint: "1.1.1.1"}, false},
+		{"endpoint with hostname", load.InferenceRequest{Cluster: "test", RequestId: "1", Endpoint: "engine:8000"}, false},
+		{"endpoint with invalid port", load.InferenceRequest{Cluster: "test", RequestId: "1", Endpoint: "1.1.1.1:70000"}, false},
+		{"unbracketed ipv6 endpoint", load.InferenceRequest{Cluster: "test", RequestId: "1", Endpoint: "fd00::1:8000"}, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := binding.Validator.ValidateStruct(&tc.req)
+			if tc.valid {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestLoadAPI_QueryClusters_Validate(t *testing.T) {
+	loadAPI := LoadAPI{}
+	for _, query := range []string{
+		"",
+		"clusters=a&pattern=a*",
+		"pattern=[a",
+		"clusters=a&blend=invalid",
+	} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest(http.MethodGet, "/v1/load/stats/clusters?"+query, nil)
+		loadAPI.QueryClusters(c)
+		require.Equalf(t, 400, w.Code, "query %q", query)
+	}
+}
+
+func TestAdminAPI_Params_Validate(t *testing.T) {
+	adminAPI := AdminAPI{}
+	tests := []struct {
+		handler func(c *gin.Context)
+		query   string
+	}{
+		{adminAPI.Clusters, "offset=-1"},
+		{adminAPI.Clusters, "limit=1001"},
+		{adminAPI.EngineRequests, "cluster=test"},
+		{adminAPI.EngineRequests, "ip=1.1.1.1"},
+		{adminAPI.EngineRequests, "cluster=test&endpoint=1.1.1.1"},
+		{adminAPI.Request, ""},
+	}
+	for _, tc := range tests {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest(http.MethodGet, "/v1/admin?"+tc.query, nil)
+		tc.handler(c)
+		require.Equalf(t, 400, w.Code, "query %q", tc.query)
+	}
+}
+
+func TestAdminAPI_Actions_Validate(t *testing.T) {
+	config.C.Tenant.OperatorToken = "operator-secret"
+	defer func() { config.C.Tenant.OperatorToken = "" }()
+	adminAPI := AdminAPI{}
+	tests := []struct {
+		handler func(c *gin.Context)
+		body    string
+	}{
+		{adminAPI.Evict, `{}`},
+		{adminAPI.ResetEngine, `{"cluster":"test"}`},
+		{adminAPI.ResetEngine, `{"ip":"1.1.1.1"}`},
+		{adminAPI.DeleteEngine, `{"cluster":"test","endpoint":"invalid"}`},
+		{adminAPI.DeleteCluster, `{"operator":"ops"}`},
+		{adminAPI.SetTenantLimit, `{"max_requests":500}`},
+		{adminAPI.SetTenantLimit, `{"tenant":"team-a","max_requests":-1}`},
+		{adminAPI.SetTenantLimit, `{"tenant":"team/a","max_requests":500}`},
+		{adminAPI.SetTenantLimit, `{"tenant":"team a","max_requests":500}`},
+		{adminAPI.DeleteTenantLimit, `{"tenant":"team.a"}`},
+		{adminAPI.DeleteTenantLimit, `{}`},
+	}
+	for _, tc := range tests {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest(http.MethodPost, "/v1/admin", bytes.NewBufferString(tc.body))
+		c.Request.Header.Set(OperatorTokenHeader, "operator-secret")
+		tc.handler(c)
+		require.Equalf(t, 400, w.Code, "body %s", tc.body)
+	}
+}
+
+func TestAdminAPI_Forbidden(t *testing.T) {
+	defer func() { config.C.Tenant.OperatorToken = "" }()
+	adminAPI := AdminAPI{}
+	tests := []struct {
+		name   string
+		token  string
+		header string
+		tenant string
+	}{
+		{name: "no operator token configured", header: "operator-secret"},
+		{name: "no operator token configured, tenant caller", header: "operator-secret", tenant: "team-a"},
+		{name: "missing header", token: "operator-secret"},
+		{name: "missing header, tenant caller", token: "operator-secret", tenant: "team-a"},
+		{name: "wrong token", token: "operator-secret", header: "operator-secre"},
+	}
+	for _, tc := range tests {
+		config.C.Tenant.OperatorToken = tc.token
+		for _, handler := range []func(c *gin.Context){
+			adminAPI.Evict,
+			adminAPI.ResetEngine,
+			adminAPI.DeleteEngine,
+			adminAPI.DeleteCluster,
+			adminAPI.Tenants,
+			adminAPI.SetTenantLimit,
+			adminAPI.DeleteTenantLimit,
+		} {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest(http.MethodPut, "/v1/admin/tenant/limit", bytes.NewBufferString(`{"tenant":"team-a"}`))
+			if tc.header != "" {
+				c.Request.Header.Set(OperatorTokenHeader, tc.header)
+			}
+			if tc.tenant != "" {
+				c.Set(ginx.TenantCtxKey, tc.tenant)
+			}
+			handler(c)
+			require.Equalf(t, 403, w.Code, "%s: %s", tc.name, w.Body.String())
+		}
+	}
+}
+
+func TestLoadAPI_History_Validate(t *testing.T) {
+	loadAPI := LoadAPI{}
+	for _, query := range []string{
+		"",
+		"cluster=test",
+		"ip=1.1.1.1",
+		"cluster=test&endpoint=1.1.1.1",
+	} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest(http.MethodGet, "/v1/load/history?"+query, nil)
+		loadAPI.History(c)
+		require.Equalf(t, 400, w.Code, "query %q", query)
+	}
+}
+
+func TestLoadAPI_Score_Validate(t *testing.T) {
+	loadAPI := LoadAPI{}
+	tests := []struct {
+		handler func(c *gin.Context)
+		query   string
+	}{
+		{loadAPI.Score, ""},
+		{loadAPI.Score, "cluster=test&scorer=unknown"},
+		{loadAPI.Score, "cluster=test&blend=invalid"},
+		{loadAPI.Query, "cluster=test&scorer=unknown"},
+	}
+	for _, tc := range tests {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest(http.MethodGet, "/v1/load/score?"+tc.query, nil)
+		tc.handler(c)
+		require.Equalf(t, 400, w.Code, "query %q", tc.query)
+	}
+}
@@ -15,11 +15,14 @@
 package api
 
 import (
+	"time"
+
 	"github.com/gin-gonic/gin"
 
 	"github.com/aigw-project/metadata-center/pkg/ginx"
 	"github.com/aigw-project/metadata-center/pkg/meta/load"
 	"github.com/aigw-project/metadata-center/pkg/replicator"
+	"github.com/aigw-project/metadata-center/pkg/utils/errors"
 	"github.com/aigw-project/metadata-center/pkg/utils/logger"
 )
 
@@ -39,8 +42,89 @@ func (a *LoadAPI) Query(c *gin.Context) {
 		return
 	}
 
-	stat := load.Query(&metricParam)
-	ginx.ResSuccess(c, stat.ToEngines())
+	if metricParam.Scorer != "" {
+		if _, ok := load.GetScorer(metricParam.Scorer); !ok {
+			ginx.ResError(c, errors.InvalidInput("unknown scorer %s", metricParam.Scorer))
+			return
+		}
+	}
+
+	scopeNames(c, &metricParam.Cluster)
+	snapshots, err := load.Select(&metricParam)
+	if err != nil {
+		logger.Errorf("load api: query model error: %v", err)
+		ginx.ResError(c, err)
+		return
+	}
+	ginx.ResSuccess(c, snapshots)
+}
+
+// Score handles GET requests for ranking the engines of a cluster along with the scoring inputs
+func (a *LoadAPI) Score(c *gin.Context) {
+	var queryParam load.ScoreQueryRequest
+	if err := ginx.ParseQuery(c, &queryParam); err != nil {
+		logger.Errorf("load api: query score request error: %v", err)
+		ginx.ResError(c, err)
+		return
+	}
+
+	scopeNames(c, &queryParam.Cluster)
+	scores, err := load.Score(&queryParam)
+	if err != nil {
+		ginx.ResError(c, err)
+		return
+	}
+	ginx.ResSuccess(c, scores)
+}
+
+// QueryClusters handles GET requests for querying the load statistics of several clusters
+func (a *LoadAPI) QueryClusters(c *gin.Context) {
+	var queryParam load.MultiClusterQueryRequest
+	if err := ginx.ParseQuery(c, &queryParam); err != nil {
+		logger.Errorf("load api: query clusters request error: %v", err)
+		ginx.ResError(c, err)
+		return
+	}
+
+	tenant := ginx.GetTenant(c)
+	names := queryParam.ClusterNames()
+	for i := range names {
+		names[i] = load.ScopedName(tenant, names[i])
+	}
+	queryParam.Clusters = names
+	scopeNames(c, &queryParam.Pattern)
+
+	snapshot, err := load.QueryClusters(&queryParam)
+	if err != nil {
+		logger.Errorf("load api: query clusters error: %v", err)
+		ginx.ResError(c, err)
+		return
+	}
+	clusters := make(map[string][]*load.EngineSnapshot, len(snapshot.Clusters))
+	for name, engines := range snapshot.Clusters {
+		clusters[load.UnscopedName(tenant, name)] = engines
+	}
+	snapshot.Clusters = clusters
+	ginx.ResSuccess(c, snapshot)
+}
+
+// History handles GET requests for querying the sampled load history of an engine
+func (a *LoadAPI) History(c *gin.Context) {
+	var queryParam load.EngineHistoryQuery
+	if err := ginx.ParseQuery(c, &queryParam); err != nil {
+		logger.Errorf("load api: query history request error: %v", err)
+		ginx.ResError(c, err)
+		return
+	}
+
+	cluster := queryParam.Cluster
+	scopeNames(c, &queryParam.Cluster)
+	history, ok := load.History(&queryParam)
+	if !ok {
+		ginx.ResError(c, errors.NotFound("engine %s not found in cluster %s", queryParam.EngineKey(), cluster))
+		return
+	}
+	ginx.ResSuccess(c, history)
 }
 
 // Set handles POST requests for setting load statistics
@@ -53,8 +137,29 @@ func (a *LoadAPI) Set(c *gin.Context) {
 	}
 
 	c.Set(RequestIdCtxKey, reqParam.RequestId)
-	load.Set(&reqParam)
-	replicator.Replicate(c, load.LoadStatsSet, reqParam) // Replicate to other instances
+	scopeNames(c, &reqParam.Cluster, &reqParam.RequestId)
+	if err := load.CheckPriority(reqParam.Priority); err != nil {
+		ginx.ResError(c, err)
+		return
+	}
+	if err := load.CheckModel(reqParam.Cluster, reqParam.EngineKey(), reqParam.Model); err != nil {
+		ginx.ResError(c, err)
+		return
+	}
+	if err := load.CheckQuota(reqParam.Cluster, true); err != nil {
+		ginx.ResError(c, err)
+		return
+	}
+	if err := load.CheckCapacity(reqParam.Cluster, reqParam.EngineKey(), true); err != nil {
+		ginx.ResError(c, err)
+		return
+	}
+	if err := load.Set(&reqParam); err != nil {
+		logger.Errorf("load api: set request error: %v", err)
+		ginx.ResError(c, err)
+		return
+	}
+	replicate(c, load.LoadStatsSet, reqParam) // Replicate to other instances
 
 	ginx.ResOK(c)
 }
@@ -69,8 +174,13 @@ func (a *LoadAPI) Delete(c *gin.Context) {
 	}
 
 	c.Set(RequestIdCtxKey, reqParam.RequestId)
-	load.Delete(&reqParam)
-	replicator.Replicate(c, load.LoadStatsDelete, reqParam) // Replicate to other instances
+	scopeNames(c, &reqParam.RequestId)
+	if err := load.Delete(&reqParam); err != nil {
+		logger.Errorf("load api: delete request error: %v", err)
+		ginx.ResError(c, err)
+		return
+	}
+	replicate(c, load.LoadStatsDelete, reqParam) // Replicate to other instances
 
 	ginx.ResOK(c)
 }
@@ -85,8 +195,125 @@ func (a *LoadAPI) DeletePrompt(c *gin.Context) {
 	}
 
 	c.Set(RequestIdCtxKey, reqParam.RequestId)
-	load.PromptDelete(&reqParam)
-	replicator.Replicate(c, load.LoadPromptDelete, reqParam) // Replicate to other instances
+	scopeNames(c, &reqParam.RequestId)
+	if err := load.PromptDelete(&reqParam); err != nil {
+		logger.Errorf("load api: delete request prompt length error: %v", err)
+		ginx.ResError(c, err)
+		return
+	}
+	replicate(c, load.LoadPromptDelete, reqParam) // Replicate to other instances
 
 	ginx.ResOK(c) // Return success response
 }
+
+// Reserve handles POST requests for reserving an engine slot, the request is added only while the engine is below the limit
+func (a *LoadAPI) Reserve(c *gin.Context) {
+	var reqParam load.ReservationRequest
+	if err := ginx.ParseJSON(c, &reqParam); err != nil {
+		logger.Errorf("load api: reserve request error: %v", err)
+		ginx.ResError(c, err)
+		return
+	}
+
+	c.Set(RequestIdCtxKey, reqParam.RequestId)
+	original := reqParam
+	scopeNames(c, &reqParam.Cluster, &reqParam.RequestId)
+	if err := load.CheckPriority(reqParam.Priority); err != nil {
+		ginx.ResError(c, err)
+		return
+	}
+	if err := load.CheckModel(reqParam.Cluster, reqParam.EngineKey(), reqParam.Model); err != nil {
+		ginx.ResError(c, err)
+		return
+	}
+	if err := load.CheckQuota(reqParam.Cluster, true); err != nil {
+		ginx.ResError(c, err)
+		return
+	}
+	if err := load.CheckCapacity(reqParam.Cluster, reqParam.EngineKey(), true); err != nil {
+		ginx.ResError(c, err)
+		return
+	}
+	if err := load.Reserve(&reqParam); err != nil {
+		if forwardToLeader(c, err, original) {
+			return
+		}
+		logger.Errorf("load api: reserve request error: %v", err)
+		ginx.ResError(c, err)
+		return
+	}
+	if load.Consensus() {
+		replicate(c, load.LoadStatsSet, reqParam.InferenceRequest) // The log replicates the reservation, only its request is replicated
+	} else {
+		replicate(c, load.LoadReservationSet, reqParam) // Replicate to other instances
+	}
+
+	ginx.ResOK(c)
+}
+
+// Release handles DELETE requests for releasing a reservation along with its request
+func (a *LoadAPI) Release(c *gin.Context) {
+	var reqParam load.DeletionInferenceRequest
+	if err := ginx.ParseJSON(c, &reqParam); err != nil {
+		logger.Errorf("load api: release request error: %v", err)
+		ginx.ResError(c, err)
+		return
+	}
+
+	c.Set(RequestIdCtxKey, reqParam.RequestId)
+	original := reqParam
+	scopeNames(c, &reqParam.RequestId)
+	if err := load.Release(&reqParam); err != nil {
+		if forwardToLeader(c, err, original) {
+			return
+		}
+		logger.Errorf("load api: release request error: %v", err)
+		ginx.ResError(c, err)
+		return
+	}
+	if load.Consensus() {
+		replicate(c, load.LoadStatsDelete, reqParam) // The log replicates the release, only the delete of its request is replicated
+	} else {
+		replicate(c, load.LoadReservationRelease, reqParam) // Replicate to other instances
+	}
+
+	ginx.ResOK(c)
+}
+
+// Report handles POST requests for engine-reported load snapshots
+func (a *LoadAPI) Report(c *gin.Context) {
+	var reqParam load.EngineReport
+	if err := ginx.ParseJSON(c, &reqParam); err != nil {
+		logger.Errorf("load api: engine report error: %v", err)
+		ginx.ResError(c, err)
+		return
+	}
+
+	// The report is fresh from now on, a timestamp sent by the engine could keep it fresh forever
+	reqParam.ReportedTime = time.Now().UnixNano()
+	scopeNames(c, &reqParam.Cluster)
+	if err := load.CheckQuota(reqParam.Cluster, false); err != nil {
+		ginx.ResError(c, err)
+		return
+	}
+	if err := load.CheckCapacity(reqParam.Cluster, reqParam.EngineKey(), false); err != nil {
+		ginx.ResError(c, err)
+		return
+	}
+	if err := load.Report(&reqParam); err != nil {
+		logger.Errorf("load api: engine report error: %v", err)
+		ginx.ResError(c, err)
+		return
+	}
+	replicate(c, load.LoadEngineReport, reqParam) // Replicate to other instances
+
+	ginx.ResOK(c)
+}
+
+// replicate sends a load update to the other instances, unless they share the backend already
+func replicate(c *gin.Context, eventType string, payload any) {
+	if load.Shared() {
+		return
+	}
+	replicator.Replicate(c, eventType, payload)
+}
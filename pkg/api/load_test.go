@@ -18,12 +18,45 @@ import (
 	"bytes"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aigw-project/metadata-center/pkg/meta/load"
+	"github.com/aigw-project/metadata-center/pkg/replicator"
+	"github.com/aigw-project/metadata-center/pkg/utils/helper"
 )
 
+var initLoadOnce sync.Once
+
+// initLoad initializes the load statistics and a replicator without peers, once for all API tests
+func initLoad() {
+	initLoadOnce.Do(func() {
+		_ = os.Setenv(helper.MetaDataCenterPodIp, "127.0.0.1")
+		replicator.Init()
+		load.Init()
+	})
+}
+
+// serve calls an API handler with a JSON body and the given headers
+func serve(handler gin.HandlerFunc, method, target, body string, headers map[string]string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(method, target, bytes.NewBufferString(body))
+	for k, v := range headers {
+		c.Request.Header.Set(k, v)
+	}
+	handler(c)
+	return w
+}
+
 func TestDeletePrompt_InvalidJSON(t *testing.T) {
 	loadApi := LoadAPI{}
 	gin.SetMode(gin.TestMode)
@@ -42,3 +75,39 @@ func TestDeletePrompt_InvalidJSON(t *testing.T) {
 	t.Logf("Response body: %s", w.Body.String())
 	assert.JSONEq(t, expectedResponse, w.Body.String())
 }
+
+func TestLoadAPI_Report_ReportedTime(t *testing.T) {
+	initLoad()
+	loadAPI := LoadAPI{}
+	future := time.Now().Add(time.Hour).UnixNano()
+	body := `{"cluster":"report-time","ip":"10.0.20.1","running_req_num":1,"reported_time":` +
+		strconv.FormatInt(future, 10) + `}`
+	w := serve(loadAPI.Report, http.MethodPost, "/v1/load/report", body, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	snapshots, err := load.Select(&load.ModelQueryRequest{Cluster: "report-time"})
+	require.NoError(t, err)
+	require.Len(t, snapshots, 1)
+	require.NotNil(t, snapshots[0].Reported)
+	assert.LessOrEqual(t, snapshots[0].Reported.ReportedTime, time.Now().UnixNano(), "the reported time of the engine is ignored")
+	assert.False(t, snapshots[0].Reported.IsFresh(time.Now().Add(2*load.DefaultReportTTL).UnixNano()))
+}
+
+func TestLoadAPI_Set_Priority(t *testing.T) {
+	initLoad()
+	loadAPI := LoadAPI{}
+	for priority, code := range map[string]int{
+		"":                      http.StatusOK,
+		"interactive":           http.StatusOK,
+		"batch":                 http.StatusOK,
+		"unknown":               http.StatusBadRequest,
+		strings.Repeat("p", 33): http.StatusBadRequest,
+	} {
+		body := `{"cluster":"priority","request_id":"priority-` + priority + `","ip":"10.0.20.2","priority":"` + priority + `"}`
+		w := serve(loadAPI.Set, http.MethodPost, "/v1/load/stats", body, nil)
+		assert.Equalf(t, code, w.Code, "priority %q: %s", priority, w.Body.String())
+		body = strings.Replace(body, `"priority-`, `"reserve-`, 1)
+		w = serve(loadAPI.Reserve, http.MethodPost, "/v1/load/reservation", `{"limit":100,`+body[1:], nil)
+		assert.Equalf(t, code, w.Code, "reserve priority %q: %s", priority, w.Body.String())
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"crypto/subtle"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/aigw-project/metadata-center/pkg/config"
+	"github.com/aigw-project/metadata-center/pkg/ginx"
+	"github.com/aigw-project/metadata-center/pkg/meta/load"
+	"github.com/aigw-project/metadata-center/pkg/utils/errors"
+)
+
+// scopeNames moves cluster names and request IDs into the namespace of the request's tenant
+// Scoped values are what gets replicated, so peers apply them without knowing the tenant
+func scopeNames(c *gin.Context, names ...*string) {
+	tenant := ginx.GetTenant(c)
+	for _, name := range names {
+		*name = load.ScopedName(tenant, *name)
+	}
+}
+
+// unscopeRequestInfo rewrites an in-flight request as seen by the request's tenant
+func unscopeRequestInfo(c *gin.Context, info *load.RequestInfo) {
+	tenant := ginx.GetTenant(c)
+	info.Cluster = load.UnscopedName(tenant, info.Cluster)
+	info.RequestId = load.UnscopedName(tenant, info.RequestId)
+}
+
+// OperatorTokenHeader carries the operator token required by the endpoints that act on all tenants or change state
+const OperatorTokenHeader = "X-Operator-Token"
+
+// requireOperator rejects callers without the configured operator token from endpoints that act on all tenants
+// and from the admin corrections, which bypass the request path
+// The tenant of a request is only an identification, so it does not grant or deny access on its own
+// Returns false after writing the error response
+func requireOperator(c *gin.Context) bool {
+	token := config.C.Tenant.OperatorToken
+	if token == "" {
+		ginx.ResError(c, errors.Forbidden("admin actions are disabled, no operator token is configured"))
+		return false
+	}
+	if subtle.ConstantTimeCompare([]byte(c.GetHeader(OperatorTokenHeader)), []byte(token)) != 1 {
+		ginx.ResError(c, errors.Forbidden("a valid %s header is required for admin actions", OperatorTokenHeader))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,145 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aigw-project/metadata-center/pkg/config"
+	"github.com/aigw-project/metadata-center/pkg/ginx"
+	"github.com/aigw-project/metadata-center/pkg/meta/load"
+)
+
+// asTenant runs a handler on behalf of a tenant, as the tenant middleware would
+func asTenant(tenant string, handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(ginx.TenantCtxKey, tenant)
+		handler(c)
+	}
+}
+
+// queuedOf returns the queued requests of the engines in a query response
+func queuedOf(t *testing.T, body []byte) []int32 {
+	var resp struct {
+		Data []*load.EngineSnapshot `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(body, &resp))
+	var queued []int32
+	for _, snapshot := range resp.Data {
+		queued = append(queued, snapshot.QueuedReqNum)
+	}
+	return queued
+}
+
+func TestLoadAPI_TenantIsolation(t *testing.T) {
+	initLoad()
+	config.C.Tenant.OperatorToken = "operator-secret"
+	defer func() { config.C.Tenant.OperatorToken = "" }()
+	loadAPI := LoadAPI{}
+	adminAPI := AdminAPI{}
+	operator := map[string]string{OperatorTokenHeader: "operator-secret"}
+
+	w := serve(asTenant("team-a", loadAPI.Set), http.MethodPost, "/v1/load/stats",
+		`{"cluster":"isolated","request_id":"req-1","ip":"10.0.30.1"}`, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	t.Run("scoped names are rejected", func(t *testing.T) {
+		for _, tc := range []struct {
+			name    string
+			handler gin.HandlerFunc
+			method  string
+			target  string
+			body    string
+		}{
+			{"set", loadAPI.Set, http.MethodPost, "/v1/load/stats",
+				`{"cluster":"team-a/isolated","request_id":"req-2","ip":"10.0.30.1"}`},
+			{"set request id", loadAPI.Set, http.MethodPost, "/v1/load/stats",
+				`{"cluster":"isolated","request_id":"team-a/req-2","ip":"10.0.30.1"}`},
+			{"delete", loadAPI.Delete, http.MethodDelete, "/v1/load/stats", `{"request_id":"team-a/req-1"}`},
+			{"delete prompt", loadAPI.DeletePrompt, http.MethodDelete, "/v1/load/prompt", `{"request_id":"team-a/req-1"}`},
+			{"reserve", loadAPI.Reserve, http.MethodPost, "/v1/load/reservation",
+				`{"cluster":"team-a/isolated","request_id":"req-2","ip":"10.0.30.1","limit":10}`},
+			{"report", loadAPI.Report, http.MethodPost, "/v1/load/report",
+				`{"cluster":"team-a/isolated","ip":"10.0.30.1","running_req_num":0}`},
+			{"query", loadAPI.Query, http.MethodGet, "/v1/load/stats?cluster=team-a/isolated", ""},
+			{"score", loadAPI.Score, http.MethodGet, "/v1/load/score?cluster=team-a/isolated", ""},
+			{"history", loadAPI.History, http.MethodGet, "/v1/load/history?cluster=team-a/isolated&ip=10.0.30.1", ""},
+			{"query clusters", loadAPI.QueryClusters, http.MethodGet, "/v1/load/stats/clusters?clusters=team-a/isolated", ""},
+			{"query clusters list", loadAPI.QueryClusters, http.MethodGet, "/v1/load/stats/clusters?clusters=a,team-a/isolated", ""},
+			{"query clusters pattern", loadAPI.QueryClusters, http.MethodGet, "/v1/load/stats/clusters?pattern=*/*", ""},
+			{"admin request", adminAPI.Request, http.MethodGet, "/v1/admin/request?request_id=team-a/req-1", ""},
+			{"admin engine requests", adminAPI.EngineRequests, http.MethodGet,
+				"/v1/admin/engine/requests?cluster=team-a/isolated&ip=10.0.30.1", ""},
+			{"admin evict", adminAPI.Evict, http.MethodPost, "/v1/admin/request/evict", `{"request_id":"team-a/req-1"}`},
+			{"admin reset engine", adminAPI.ResetEngine, http.MethodPost, "/v1/admin/engine/reset",
+				`{"cluster":"team-a/isolated","ip":"10.0.30.1"}`},
+			{"admin delete engine", adminAPI.DeleteEngine, http.MethodDelete, "/v1/admin/engine",
+				`{"cluster":"team-a/isolated","ip":"10.0.30.1"}`},
+			{"admin delete cluster", adminAPI.DeleteCluster, http.MethodDelete, "/v1/admin/cluster", `{"cluster":"team-a/isolated"}`},
+		} {
+			for _, tenant := range []string{"", "team-b"} {
+				w := serve(asTenant(tenant, tc.handler), tc.method, tc.target, tc.body, operator)
+				assert.Equalf(t, http.StatusBadRequest, w.Code, "%s as tenant %q: %s", tc.name, tenant, w.Body.String())
+			}
+		}
+	})
+
+	t.Run("other tenants see nothing", func(t *testing.T) {
+		for _, tenant := range []string{"", "team-b"} {
+			w := serve(asTenant(tenant, loadAPI.Query), http.MethodGet, "/v1/load/stats?cluster=isolated", "", nil)
+			require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+			assert.Empty(t, queuedOf(t, w.Body.Bytes()), "tenant %q", tenant)
+
+			w = serve(asTenant(tenant, loadAPI.QueryClusters), http.MethodGet, "/v1/load/stats/clusters?pattern=*", "", nil)
+			require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+			assert.NotContains(t, w.Body.String(), "isolated", "tenant %q", tenant)
+
+			w = serve(asTenant(tenant, loadAPI.Delete), http.MethodDelete, "/v1/load/stats", `{"request_id":"req-1"}`, nil)
+			require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		}
+	})
+
+	// The deletions of the other tenants leave the request of team-a in place
+	w = serve(asTenant("team-a", loadAPI.Query), http.MethodGet, "/v1/load/stats?cluster=isolated", "", nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, []int32{1}, queuedOf(t, w.Body.Bytes()))
+}
+
+func TestAdminAPI_TenantLimit_Operator(t *testing.T) {
+	initLoad()
+	config.C.Tenant.OperatorToken = "operator-secret"
+	defer func() { config.C.Tenant.OperatorToken = "" }()
+	adminAPI := AdminAPI{}
+	operator := map[string]string{OperatorTokenHeader: "operator-secret"}
+
+	body := `{"tenant":"team-op","max_requests":5}`
+	w := serve(adminAPI.SetTenantLimit, http.MethodPut, "/v1/admin/tenant/limit", body, nil)
+	require.Equal(t, http.StatusForbidden, w.Code, "a caller without a tenant is no operator")
+	w = serve(adminAPI.SetTenantLimit, http.MethodPut, "/v1/admin/tenant/limit", body, operator)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = serve(adminAPI.Tenants, http.MethodGet, "/v1/admin/tenants", "", operator)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"tenant":"team-op","max_requests":5`)
+
+	w = serve(adminAPI.DeleteTenantLimit, http.MethodDelete, "/v1/admin/tenant/limit", `{"tenant":"team-op"}`, operator)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+}
@@ -22,6 +22,7 @@ import (
 
 	"github.com/koding/multiconfig"
 
+	"github.com/aigw-project/metadata-center/pkg/meta/load"
 	"github.com/aigw-project/metadata-center/pkg/utils/logger"
 )
 
@@ -40,10 +41,14 @@ func InitEnv() {
 			logger.Errorf("pprof serve exit: %v", http.ListenAndServe(pprofAddr, nil))
 		}()
 	}
+	// Federated regions are polled with the tenant header of this instance
+	load.SetTenantHeader(C.Tenant.Header)
 	// Parse environment variable configurations
 	for _, es := range envSetters {
 		es.setter(es.env)
 	}
+	// Polls of federated regions authenticate the tenants they are sent for
+	load.SetTenantTokens(C.Tenant.Tokens)
 }
 
 // Load loads configuration from file (toml/json/yaml)
@@ -73,9 +78,10 @@ func Load(file string) error {
 
 // Config holds all application configuration settings
 type Config struct {
-	HTTP  HTTP  // HTTP server configuration
-	PProf PProf // Profiling configuration
-	Log   Log   // Logging configuration
+	HTTP   HTTP   // HTTP server configuration
+	PProf  PProf  // Profiling configuration
+	Log    Log    // Logging configuration
+	Tenant Tenant // Tenant identification
 }
 
 // PProf configuration for performance profiling
@@ -104,3 +110,11 @@ type Log struct {
 	GinOutput     string // Gin framework log output destination
 	GinOutputFile string // Gin framework log file path
 }
+
+// Tenant configuration for multi-tenant isolation
+type Tenant struct {
+	Header        string // HTTP header carrying the tenant name, the basic auth username is used when it is absent
+	OperatorToken string // Token required from callers of the endpoints managing all tenants, which are disabled without one
+	// Tokens authenticate named tenants, keyed by tenant name. Without any the tenant of a request is trusted as asserted
+	Tokens map[string]string
+}
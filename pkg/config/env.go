@@ -17,6 +17,7 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/aigw-project/metadata-center/pkg/meta/load"
@@ -24,8 +25,72 @@ import (
 )
 
 const (
-	LoadGCInterval    = "METADATA_CENTER_LOAD_GC_INTERVAL"
-	LoadRequestExpire = "METADATA_CENTER_LOAD_REQ_EXPIRE"
+	LoadGCInterval     = "METADATA_CENTER_LOAD_GC_INTERVAL"
+	LoadRequestExpire  = "METADATA_CENTER_LOAD_REQ_EXPIRE"
+	LoadExpireInterval = "METADATA_CENTER_LOAD_EXPIRE_INTERVAL"
+	LoadTombstoneTTL   = "METADATA_CENTER_LOAD_TOMBSTONE_TTL"
+	LoadMaxTombstones  = "METADATA_CENTER_LOAD_MAX_TOMBSTONES"
+	LoadReportTTL      = "METADATA_CENTER_LOAD_REPORT_TTL"
+
+	LoadReconcileInterval      = "METADATA_CENTER_LOAD_RECONCILE_INTERVAL"
+	LoadReconcileMetricsPort   = "METADATA_CENTER_LOAD_RECONCILE_METRICS_PORT"
+	LoadReconcileMetricsPath   = "METADATA_CENTER_LOAD_RECONCILE_METRICS_PATH"
+	LoadReconcileScrapeTimeout = "METADATA_CENTER_LOAD_RECONCILE_SCRAPE_TIMEOUT"
+	LoadReconcileCorrect       = "METADATA_CENTER_LOAD_RECONCILE_CORRECT"
+
+	LoadInvariantCheckInterval = "METADATA_CENTER_LOAD_INVARIANT_CHECK_INTERVAL"
+	LoadInvariantFix           = "METADATA_CENTER_LOAD_INVARIANT_FIX"
+
+	LoadHistoryInterval = "METADATA_CENTER_LOAD_HISTORY_INTERVAL"
+	LoadHistorySize     = "METADATA_CENTER_LOAD_HISTORY_SIZE"
+
+	LoadLatencyWindow = "METADATA_CENTER_LOAD_LATENCY_WINDOW"
+	LoadOrigin        = "METADATA_CENTER_LOAD_ORIGIN"
+
+	LoadPriorityClasses = "METADATA_CENTER_LOAD_PRIORITY_CLASSES"
+	LoadMaxEngineModels = "METADATA_CENTER_LOAD_MAX_ENGINE_MODELS"
+
+	TenantMaxRequests   = "METADATA_CENTER_TENANT_MAX_REQUESTS"
+	TenantMaxClusters   = "METADATA_CENTER_TENANT_MAX_CLUSTERS"
+	TenantOperatorToken = "METADATA_CENTER_TENANT_OPERATOR_TOKEN"
+	TenantTokens        = "METADATA_CENTER_TENANT_TOKENS"
+
+	LoadStorageKind   = "METADATA_CENTER_LOAD_STORAGE"
+	LoadStorageShards = "METADATA_CENTER_LOAD_STORAGE_SHARDS"
+
+	LoadBackend       = "METADATA_CENTER_LOAD_BACKEND"
+	LoadRedisAddr     = "METADATA_CENTER_LOAD_REDIS_ADDR"
+	LoadRedisPassword = "METADATA_CENTER_LOAD_REDIS_PASSWORD"
+	LoadRedisDB       = "METADATA_CENTER_LOAD_REDIS_DB"
+	LoadRedisPrefix   = "METADATA_CENTER_LOAD_REDIS_PREFIX"
+
+	LoadWALDir           = "METADATA_CENTER_LOAD_WAL_DIR"
+	LoadWALSync          = "METADATA_CENTER_LOAD_WAL_SYNC"
+	LoadWALSyncInterval  = "METADATA_CENTER_LOAD_WAL_SYNC_INTERVAL"
+	LoadWALSegmentSize   = "METADATA_CENTER_LOAD_WAL_SEGMENT_SIZE"
+	LoadSnapshotInterval = "METADATA_CENTER_LOAD_SNAPSHOT_INTERVAL"
+
+	RaftID           = "METADATA_CENTER_RAFT_ID"
+	RaftAddr         = "METADATA_CENTER_RAFT_ADDR"
+	RaftPeers        = "METADATA_CENTER_RAFT_PEERS"
+	RaftDir          = "METADATA_CENTER_RAFT_DIR"
+	RaftApplyTimeout = "METADATA_CENTER_RAFT_APPLY_TIMEOUT"
+
+	LoadReplication         = "METADATA_CENTER_LOAD_REPLICATION"
+	LoadCounterSyncInterval = "METADATA_CENTER_LOAD_COUNTER_SYNC_INTERVAL"
+	LoadAntiEntropyInterval = "METADATA_CENTER_LOAD_ANTI_ENTROPY_INTERVAL"
+	LoadOriginGrace         = "METADATA_CENTER_LOAD_ORIGIN_GRACE"
+	LoadOriginCheckInterval = "METADATA_CENTER_LOAD_ORIGIN_CHECK_INTERVAL"
+	LoadOriginMissingChecks = "METADATA_CENTER_LOAD_ORIGIN_MISSING_CHECKS"
+
+	FederationRemotes      = "METADATA_CENTER_FEDERATION_REMOTES"
+	FederationInterval     = "METADATA_CENTER_FEDERATION_INTERVAL"
+	FederationMaxStaleness = "METADATA_CENTER_FEDERATION_MAX_STALENESS"
+
+	LoadMaxRequests          = "METADATA_CENTER_LOAD_MAX_REQUESTS"
+	LoadMaxClusters          = "METADATA_CENTER_LOAD_MAX_CLUSTERS"
+	LoadMaxEnginesPerCluster = "METADATA_CENTER_LOAD_MAX_ENGINES_PER_CLUSTER"
+	LoadCapacityPolicy       = "METADATA_CENTER_LOAD_CAPACITY_POLICY"
 )
 
 type EnvSetter struct {
@@ -40,6 +105,159 @@ var envSetters = []EnvSetter{
 	{LoadRequestExpire, func(env string) {
 		DurationFromEnv(env, load.SetRequestExpireDuration)
 	}},
+	{LoadExpireInterval, func(env string) {
+		DurationFromEnv(env, load.SetExpireInterval)
+	}},
+	{LoadTombstoneTTL, func(env string) {
+		DurationFromEnv(env, load.SetTombstoneTTL)
+	}},
+	{LoadMaxTombstones, func(env string) {
+		IntFromEnv(env, load.SetMaxTombstones)
+	}},
+	{LoadReportTTL, func(env string) {
+		DurationFromEnv(env, load.SetReportTTL)
+	}},
+	{LoadReconcileInterval, func(env string) {
+		DurationFromEnv(env, load.SetReconcileInterval)
+	}},
+	{LoadReconcileMetricsPort, func(env string) {
+		IntFromEnv(env, load.SetReconcileMetricsPort)
+	}},
+	{LoadReconcileMetricsPath, func(env string) {
+		StringFromEnv(env, load.SetReconcileMetricsPath)
+	}},
+	{LoadReconcileScrapeTimeout, func(env string) {
+		DurationFromEnv(env, load.SetReconcileScrapeTimeout)
+	}},
+	{LoadReconcileCorrect, func(env string) {
+		BoolFromEnv(env, load.SetReconcileCorrect)
+	}},
+	{LoadInvariantCheckInterval, func(env string) {
+		DurationFromEnv(env, load.SetInvariantCheckInterval)
+	}},
+	{LoadInvariantFix, func(env string) {
+		BoolFromEnv(env, load.SetInvariantFix)
+	}},
+	{LoadHistoryInterval, func(env string) {
+		DurationFromEnv(env, load.SetHistoryInterval)
+	}},
+	{LoadHistorySize, func(env string) {
+		IntFromEnv(env, load.SetHistorySize)
+	}},
+	{LoadLatencyWindow, func(env string) {
+		IntFromEnv(env, load.SetLatencyWindowSize)
+	}},
+	{LoadPriorityClasses, func(env string) {
+		StringFromEnv(env, load.SetPriorityClasses)
+	}},
+	{LoadMaxEngineModels, func(env string) {
+		IntFromEnv(env, load.SetMaxEngineModels)
+	}},
+	{LoadOrigin, func(env string) {
+		StringFromEnv(env, load.SetOrigin)
+	}},
+	{TenantMaxRequests, func(env string) {
+		IntFromEnv(env, load.SetTenantMaxRequests)
+	}},
+	{TenantMaxClusters, func(env string) {
+		IntFromEnv(env, load.SetTenantMaxClusters)
+	}},
+	{TenantOperatorToken, func(env string) {
+		SecretFromEnv(env, func(s string) { C.Tenant.OperatorToken = s })
+	}},
+	{TenantTokens, func(env string) {
+		SecretFromEnv(env, func(s string) { C.Tenant.Tokens = parseTenantTokens(s) })
+	}},
+	{LoadStorageKind, func(env string) {
+		StringFromEnv(env, load.SetStorageKind)
+	}},
+	{LoadStorageShards, func(env string) {
+		IntFromEnv(env, load.SetStorageShards)
+	}},
+	{LoadBackend, func(env string) {
+		StringFromEnv(env, load.SetBackend)
+	}},
+	{LoadRedisAddr, func(env string) {
+		StringFromEnv(env, load.SetRedisAddr)
+	}},
+	{LoadRedisPassword, func(env string) {
+		SecretFromEnv(env, load.SetRedisPassword)
+	}},
+	{LoadRedisDB, func(env string) {
+		IntFromEnv(env, load.SetRedisDB)
+	}},
+	{LoadRedisPrefix, func(env string) {
+		StringFromEnv(env, load.SetRedisPrefix)
+	}},
+	{LoadWALDir, func(env string) {
+		StringFromEnv(env, load.SetWALDir)
+	}},
+	{LoadWALSync, func(env string) {
+		StringFromEnv(env, load.SetWALSync)
+	}},
+	{LoadWALSyncInterval, func(env string) {
+		DurationFromEnv(env, load.SetWALSyncInterval)
+	}},
+	{LoadWALSegmentSize, func(env string) {
+		IntFromEnv(env, load.SetWALSegmentSize)
+	}},
+	{LoadSnapshotInterval, func(env string) {
+		DurationFromEnv(env, load.SetSnapshotInterval)
+	}},
+	{RaftID, func(env string) {
+		StringFromEnv(env, load.SetRaftID)
+	}},
+	{RaftAddr, func(env string) {
+		StringFromEnv(env, load.SetRaftAddr)
+	}},
+	{RaftPeers, func(env string) {
+		StringFromEnv(env, load.SetRaftPeers)
+	}},
+	{RaftDir, func(env string) {
+		StringFromEnv(env, load.SetRaftDir)
+	}},
+	{RaftApplyTimeout, func(env string) {
+		DurationFromEnv(env, load.SetRaftApplyTimeout)
+	}},
+	{LoadReplication, func(env string) {
+		StringFromEnv(env, load.SetReplicationMode)
+	}},
+	{LoadCounterSyncInterval, func(env string) {
+		DurationFromEnv(env, load.SetCounterSyncInterval)
+	}},
+	{LoadAntiEntropyInterval, func(env string) {
+		DurationFromEnv(env, load.SetAntiEntropyInterval)
+	}},
+	{LoadOriginGrace, func(env string) {
+		DurationFromEnv(env, load.SetOriginGrace)
+	}},
+	{LoadOriginCheckInterval, func(env string) {
+		DurationFromEnv(env, load.SetOriginCheckInterval)
+	}},
+	{LoadOriginMissingChecks, func(env string) {
+		IntFromEnv(env, load.SetOriginMissingChecks)
+	}},
+	{FederationRemotes, func(env string) {
+		StringFromEnv(env, load.SetFederationRemotes)
+	}},
+	{FederationInterval, func(env string) {
+		DurationFromEnv(env, load.SetFederationInterval)
+	}},
+	{FederationMaxStaleness, func(env string) {
+		DurationFromEnv(env, load.SetFederationMaxStaleness)
+	}},
+	{LoadMaxRequests, func(env string) {
+		IntFromEnv(env, load.SetMaxRequests)
+	}},
+	{LoadMaxClusters, func(env string) {
+		IntFromEnv(env, load.SetMaxClusters)
+	}},
+	{LoadMaxEnginesPerCluster, func(env string) {
+		IntFromEnv(env, load.SetMaxEnginesPerCluster)
+	}},
+	{LoadCapacityPolicy, func(env string) {
+		StringFromEnv(env, load.SetCapacityPolicy)
+	}},
 }
 
 // DurationFromEnv reads duration value from environment variable
@@ -58,6 +276,43 @@ func DurationFromEnv(env string, f func(d time.Duration)) {
 	logger.Infof("environment variable %s value set to %s", env, d.String())
 }
 
+// StringFromEnv reads string value from environment variable
+func StringFromEnv(env string, f func(s string)) {
+	v := os.Getenv(env)
+	if v == "" {
+		logger.Infof("environment variable %s not set", env)
+		return
+	}
+	f(v)
+	logger.Infof("environment variable %s value set to %s", env, v)
+}
+
+// SecretFromEnv reads a string value from environment variable without logging it
+func SecretFromEnv(env string, f func(s string)) {
+	v := os.Getenv(env)
+	if v == "" {
+		logger.Infof("environment variable %s not set", env)
+		return
+	}
+	f(v)
+	logger.Infof("environment variable %s set", env)
+}
+
+// parseTenantTokens parses comma separated tenant=token pairs, entries without a tenant or a token are skipped
+// The tokens are secrets, so skipped entries are logged by position only
+func parseTenantTokens(s string) map[string]string {
+	tokens := make(map[string]string)
+	for i, entry := range strings.Split(s, ",") {
+		tenant, token, _ := strings.Cut(strings.TrimSpace(entry), "=")
+		if tenant == "" || token == "" {
+			logger.Errorf("tenant token entry %d is not a tenant=token pair, skipped", i)
+			continue
+		}
+		tokens[tenant] = token
+	}
+	return tokens
+}
+
 // IntFromEnv reads integer value from environment variable
 func IntFromEnv(env string, f func(i int)) {
 	v := os.Getenv(env)
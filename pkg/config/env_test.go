@@ -66,3 +66,34 @@ func TestBoolFromEnv(t *testing.T) {
 	})
 	require.True(t, call)
 }
+
+func TestStringFromEnv(t *testing.T) {
+	env := "TEST-ENV"
+	os.Setenv(env, "")
+	StringFromEnv(env, nil)
+	os.Setenv(env, "/metrics")
+	call := false
+	StringFromEnv(env, func(s string) {
+		require.Equal(t, "/metrics", s)
+		call = true
+	})
+	require.True(t, call)
+}
+
+func TestSecretFromEnv(t *testing.T) {
+	env := "TEST-ENV"
+	os.Setenv(env, "")
+	SecretFromEnv(env, nil)
+	os.Setenv(env, "secret")
+	call := false
+	SecretFromEnv(env, func(s string) {
+		require.Equal(t, "secret", s)
+		call = true
+	})
+	require.True(t, call)
+}
+
+func TestParseTenantTokens(t *testing.T) {
+	tokens := parseTenantTokens(" team-a=secret-a,team-b=,=secret,team-c=s=c ,invalid")
+	require.Equal(t, map[string]string{"team-a": "secret-a", "team-c": "s=c"}, tokens)
+}
@@ -91,6 +91,14 @@ func ResError(c *gin.Context, err error) {
 	})
 }
 
+// TenantCtxKey is the context key for storing the tenant of a request
+const TenantCtxKey = "tenant"
+
+// GetTenant retrieves the tenant from gin context, empty for the default tenant
+func GetTenant(c *gin.Context) string {
+	return c.GetString(TenantCtxKey)
+}
+
 // GetTraceID retrieves trace ID from gin context
 func GetTraceID(c *gin.Context) string {
 	traceID, _ := c.Get(string(trace.TraceKey))
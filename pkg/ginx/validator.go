@@ -15,6 +15,10 @@
 package ginx
 
 import (
+	"net"
+	"path"
+	"strconv"
+
 	"github.com/gin-gonic/gin/binding"
 	"github.com/go-playground/validator/v10"
 )
@@ -22,12 +26,16 @@ import (
 const (
 	mutuallyExclusiveTag = "mutually_exclusive"
 	eitherOrTag          = "either_or"
+	ipPortTag            = "ip_port"
+	globTag              = "glob"
 )
 
 func init() {
 	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
 		_ = v.RegisterValidation(eitherOrTag, validateEitherOrTag)
 		_ = v.RegisterValidation(mutuallyExclusiveTag, validateMutuallyExclusiveTag)
+		_ = v.RegisterValidation(ipPortTag, validateIPPortTag)
+		_ = v.RegisterValidation(globTag, validateGlobTag)
 	}
 }
 
@@ -58,3 +66,23 @@ func validateMutuallyExclusiveTag(fl validator.FieldLevel) bool {
 	// Must have exactly one, cannot have both or none
 	return hasCurrent != hasOther // Exactly one must be true
 }
+
+// validateIPPortTag accepts host:port values whose host is an IPv4 or IPv6 address
+// IPv6 hosts must be bracketed, e.g. [fd00::1]:8000
+func validateIPPortTag(fl validator.FieldLevel) bool {
+	host, port, err := net.SplitHostPort(fl.Field().String())
+	if err != nil {
+		return false
+	}
+	if net.ParseIP(host) == nil {
+		return false
+	}
+	portNum, err := strconv.Atoi(port)
+	return err == nil && portNum > 0 && portNum <= 65535
+}
+
+// validateGlobTag accepts shell-style patterns understood by path.Match, e.g. qwen-*
+func validateGlobTag(fl validator.FieldLevel) bool {
+	_, err := path.Match(fl.Field().String(), "")
+	return err == nil
+}
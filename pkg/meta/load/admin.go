@@ -0,0 +1,389 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package load
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/aigw-project/metadata-center/pkg/utils/logger"
+)
+
+// DefaultPageLimit is the page size used when a paginated query does not set a limit
+const DefaultPageLimit = 100
+
+// PageRequest represents offset based pagination parameters
+type PageRequest struct {
+	Offset int `json:"offset,omitempty" binding:"gte=0" form:"offset"`
+	Limit  int `json:"limit,omitempty" binding:"omitempty,gte=1,lte=1000" form:"limit"`
+}
+
+// Page holds one page of items and the total number of items before pagination
+type Page[T any] struct {
+	Total  int `json:"total"`
+	Offset int `json:"offset"`
+	Limit  int `json:"limit"`
+	Items  []T `json:"items"`
+}
+
+// paginate returns the page of items selected by the request
+func paginate[T any](items []T, req PageRequest) *Page[T] {
+	limit := req.Limit
+	if limit <= 0 {
+		limit = DefaultPageLimit
+	}
+	start := min(req.Offset, len(items))
+	end := min(start+limit, len(items))
+	return &Page[T]{
+		Total:  len(items),
+		Offset: req.Offset,
+		Limit:  limit,
+		Items:  items[start:end],
+	}
+}
+
+// ClusterInfo summarizes a cluster tracked in RunningModelStats
+type ClusterInfo struct {
+	Cluster     string `json:"cluster"`
+	EngineCount int32  `json:"engine_count"`
+	UpdatedTime int64  `json:"updated_time"`
+}
+
+// RequestInfo describes an in-flight request tracked in LoadStats.Requests
+// PromptLength is the remaining prompt length, 0 once the prompt length was deleted
+type RequestInfo struct {
+	RequestId    string `json:"request_id"`
+	Cluster      string `json:"cluster"`
+	Engine       string `json:"engine"`
+	Model        string `json:"model,omitempty"`
+	Priority     string `json:"priority,omitempty"`
+	PromptLength int32  `json:"prompt_length"`
+	Origin       string `json:"origin,omitempty"`
+	CreateTime   int64  `json:"create_time"`
+	AgeMs        int64  `json:"age_ms"`
+}
+
+// newRequestInfo converts a tracked request into its admin view
+func newRequestInfo(req *InferenceRequest, now time.Time) *RequestInfo {
+	return &RequestInfo{
+		RequestId:    req.RequestId,
+		Cluster:      req.Cluster,
+		Engine:       req.EngineKey(),
+		Model:        req.Model,
+		Priority:     req.Priority,
+		PromptLength: atomic.LoadInt32(&req.PromptLength),
+		Origin:       req.Origin,
+		CreateTime:   req.CreateTime.UnixNano(),
+		AgeMs:        now.Sub(req.CreateTime).Milliseconds(),
+	}
+}
+
+// EngineRequestsQuery represents a query for the in-flight requests of one engine
+type EngineRequestsQuery struct {
+	Cluster  string `json:"cluster" binding:"required,excludes=/" form:"cluster"`
+	Ip       string `json:"ip,omitempty" binding:"required_without=Endpoint,omitempty,ip" form:"ip"`
+	Endpoint string `json:"endpoint,omitempty" binding:"required_without=Ip,omitempty,ip_port" form:"endpoint"`
+	PageRequest
+}
+
+// RequestQuery represents a lookup of a single in-flight request
+type RequestQuery struct {
+	RequestId string `json:"request_id" binding:"required,excludes=/" form:"request_id"`
+}
+
+// ListClusters returns the clusters of the tenant sorted by name
+func (ls *LoadStats) ListClusters(tenant string, page PageRequest) *Page[*ClusterInfo] {
+	var clusters []*ClusterInfo
+	ls.RunningModelStats.Range(func(key string, modelStats *ModelStats) bool {
+		if tenantOf(key) != tenant {
+			return true
+		}
+		clusters = append(clusters, &ClusterInfo{
+			Cluster:     UnscopedName(tenant, key),
+			EngineCount: modelStats.Size(),
+			UpdatedTime: modelStats.GetUpdateTime(),
+		})
+		return true
+	})
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i].Cluster < clusters[j].Cluster
+	})
+	return paginate(clusters, page)
+}
+
+// ListEngineRequests returns the in-flight requests of an engine, oldest first
+// Requests are scanned in full, this is meant for debugging rather than the request path
+func (ls *LoadStats) ListEngineRequests(query *EngineRequestsQuery) *Page[*RequestInfo] {
+	now := time.Now()
+	var requests []*RequestInfo
+	if modelStats := ls.GetModelStats(query.Cluster); modelStats != nil {
+		// Engines.Load does not refresh the cluster like ModelStats.Load, listing is no activity
+		if es, ok := modelStats.Engines.Load(engineKey(query.Ip, query.Endpoint)); ok {
+			es.requests.Range(func(_, value any) bool {
+				requests = append(requests, newRequestInfo(value.(*InferenceRequest), now))
+				return true
+			})
+		}
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		if requests[i].CreateTime != requests[j].CreateTime {
+			return requests[i].CreateTime < requests[j].CreateTime
+		}
+		return requests[i].RequestId < requests[j].RequestId
+	})
+	return paginate(requests, query.PageRequest)
+}
+
+// GetRequest returns the in-flight request with the given ID
+func (ls *LoadStats) GetRequest(requestID string) (*RequestInfo, bool) {
+	req, ok := ls.Requests.Load(requestID)
+	if !ok {
+		return nil, false
+	}
+	return newRequestInfo(req, time.Now()), true
+}
+
+// ListClusters returns the tracked clusters
+func ListClusters(tenant string, page PageRequest) *Page[*ClusterInfo] {
+	return loadStats.ListClusters(tenant, page)
+}
+
+// ListEngineRequests returns the in-flight requests of an engine
+func ListEngineRequests(query *EngineRequestsQuery) *Page[*RequestInfo] {
+	return loadStats.ListEngineRequests(query)
+}
+
+// GetRequest returns the in-flight request with the given ID
+func GetRequest(query *RequestQuery) (*RequestInfo, bool) {
+	return loadStats.GetRequest(query.RequestId)
+}
+
+// Sources of admin actions recorded in the audit log
+const (
+	// AuditSourceAPI marks actions received on the admin API of this instance
+	AuditSourceAPI = "api"
+	// AuditSourceReplica marks actions replicated from a peer
+	AuditSourceReplica = "replica"
+	// AuditSourceReconciler marks corrections made by the reconciler of this instance
+	AuditSourceReconciler = "reconciler"
+)
+
+// AdminAction holds the audit fields shared by all admin correction requests
+type AdminAction struct {
+	Operator string `json:"operator,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// EvictRequest represents a forced removal of an in-flight request
+type EvictRequest struct {
+	RequestId string `json:"request_id" binding:"required,excludes=/"`
+	AdminAction
+}
+
+// EngineAction represents an admin action on a single engine
+type EngineAction struct {
+	Cluster  string `json:"cluster" binding:"required,excludes=/"`
+	Ip       string `json:"ip,omitempty" binding:"required_without=Endpoint,omitempty,ip"`
+	Endpoint string `json:"endpoint,omitempty" binding:"required_without=Ip,omitempty,ip_port"`
+	AdminAction
+}
+
+// EngineKey returns the identity of the target engine
+func (a *EngineAction) EngineKey() string {
+	return engineKey(a.Ip, a.Endpoint)
+}
+
+// EngineCorrection represents a correction of the queued request count of an engine
+// Correction is the offset against the tracked requests, so instances tracking the same requests end up equal
+type EngineCorrection struct {
+	EngineAction
+	Correction int32 `json:"correction"`
+}
+
+// ClusterAction represents an admin action on a whole cluster
+type ClusterAction struct {
+	Cluster string `json:"cluster" binding:"required,excludes=/"`
+	AdminAction
+}
+
+// AdminResult describes the outcome of an admin correction
+// Requests is the number of tracked requests removed, Engine the engine state after a reset
+type AdminResult struct {
+	Found    bool            `json:"found"`
+	Requests int             `json:"requests,omitempty"`
+	Engine   *EngineSnapshot `json:"engine,omitempty"`
+}
+
+// audit records an admin action as a structured log entry, on every instance it is applied to
+func audit(action, target string, a *AdminAction, source string, result *AdminResult) {
+	logger.WithFields(logger.Fields{
+		"audit":    true,
+		"action":   action,
+		"target":   target,
+		"operator": a.Operator,
+		"reason":   a.Reason,
+		"source":   source,
+		"found":    result.Found,
+		"requests": result.Requests,
+	}).Info("audit")
+}
+
+// EvictRequest removes a request and decrements its engine, without leaving a tombstone like DeleteRequest
+func (ls *LoadStats) EvictRequest(req *EvictRequest, source string) *AdminResult {
+	now := time.Now()
+	result := &AdminResult{Found: ls.tryDeleteRequestStats(req.RequestId, now, false)}
+	if result.Found {
+		result.Requests = 1
+		ls.logRemoval(req.RequestId, now)
+	}
+	audit("evict_request", req.RequestId, &req.AdminAction, source, result)
+	return result
+}
+
+// ResetEngine rebuilds the counters of an engine from the requests present in Requests
+// Requests added or removed on the engine while it is rebuilt may be lost, the next reset fixes them
+func (ls *LoadStats) ResetEngine(action *EngineAction, source string) *AdminResult {
+	cluster, engine := action.Cluster, action.EngineKey()
+	result := &AdminResult{}
+	if modelStats := ls.GetModelStats(cluster); modelStats != nil {
+		if es, ok := modelStats.Load(engine); ok {
+			x := ls.countEngineRequests(cluster, engine)
+			es.ResetCounters(cluster, x.total, x.origins, x.models, x.priorities)
+			result.Found = true
+			result.Requests = int(x.total.QueuedReqNum)
+			result.Engine = es.Snapshot()
+		}
+	}
+	audit("reset_engine", cluster+"/"+engine, &action.AdminAction, source, result)
+	return result
+}
+
+// CorrectEngine applies a queued request count correction to an engine
+func (ls *LoadStats) CorrectEngine(correction *EngineCorrection, source string) *AdminResult {
+	cluster, engine := correction.Cluster, correction.EngineKey()
+	result := &AdminResult{}
+	if modelStats := ls.GetModelStats(cluster); modelStats != nil {
+		if es, ok := modelStats.Load(engine); ok {
+			es.SetCorrection(cluster, correction.Correction)
+			result.Found = true
+			result.Engine = es.Snapshot()
+		}
+	}
+	audit("correct_engine", cluster+"/"+engine, &correction.AdminAction, source, result)
+	return result
+}
+
+// DeleteEngine removes an engine, its metrics and its tracked requests immediately
+// The requests are dropped as well, so deleting them later cannot decrement a re-created engine
+func (ls *LoadStats) DeleteEngine(action *EngineAction, source string) *AdminResult {
+	cluster, engine := action.Cluster, action.EngineKey()
+	result := &AdminResult{}
+	result.Found, result.Requests = ls.removeEngine(cluster, engine)
+	audit("delete_engine", cluster+"/"+engine, &action.AdminAction, source, result)
+	return result
+}
+
+// DeleteCluster removes a cluster, its metrics and its tracked requests immediately
+func (ls *LoadStats) DeleteCluster(action *ClusterAction, source string) *AdminResult {
+	cluster := action.Cluster
+	result := &AdminResult{}
+	result.Found, result.Requests = ls.removeCluster(cluster)
+	audit("delete_cluster", cluster, &action.AdminAction, source, result)
+	return result
+}
+
+// removeEngine deletes an engine, its metrics and its tracked requests
+// Returns whether the engine existed and the number of requests dropped
+func (ls *LoadStats) removeEngine(cluster, engine string) (bool, int) {
+	modelStats := ls.GetModelStats(cluster)
+	if modelStats == nil {
+		return false, 0
+	}
+	es, ok := modelStats.Load(engine)
+	if !ok {
+		return false, 0
+	}
+	modelStats.Delete(engine)
+	es.MetricClean(cluster)
+	return true, ls.dropRequests(es)
+}
+
+// removeCluster deletes a cluster, its metrics and its tracked requests
+// Returns whether the cluster existed and the number of requests dropped
+func (ls *LoadStats) removeCluster(cluster string) (bool, int) {
+	ls.untrackCluster(cluster)
+	modelStats, ok := ls.RunningModelStats.LoadAndDelete(cluster)
+	if !ok {
+		return false, 0
+	}
+	ls.trackClusters(cluster, -1)
+	modelStats.MetricClean()
+	dropped := 0
+	for _, es := range modelStats.ToEngines() {
+		dropped += ls.dropRequests(es)
+	}
+	return true, dropped
+}
+
+// countEngineRequests sums the requests tracked for an engine, in total, per model and per priority
+func (ls *LoadStats) countEngineRequests(cluster, engine string) *engineExpectation {
+	expected := ls.expectedCounters(func(req *InferenceRequest) bool {
+		return req.Cluster == cluster && req.EngineKey() == engine
+	})
+	x, ok := expected[cluster][engine]
+	if !ok {
+		x = newEngineExpectation()
+	}
+	return x
+}
+
+// dropRequests removes the requests counted on a removed engine from Requests without touching its counters
+// Only the requests of the engine are visited, so removals cost the number of requests dropped
+func (ls *LoadStats) dropRequests(es *EngineStats) int {
+	dropped := 0
+	now := time.Now()
+	es.requests.Range(func(key, value any) bool {
+		req := value.(*InferenceRequest)
+		es.requests.Delete(key)
+		if ls.Requests.CompareAndDelete(req.RequestId, req) {
+			ls.untrackRequest(req)
+			ls.logRemoval(req.RequestId, now)
+			dropped++
+		}
+		return true
+	})
+	return dropped
+}
+
+// Evict forcibly removes an in-flight request
+func Evict(req *EvictRequest) *AdminResult {
+	return loadStats.EvictRequest(req, AuditSourceAPI)
+}
+
+// ResetEngine rebuilds the counters of an engine from its tracked requests
+func ResetEngine(action *EngineAction) *AdminResult {
+	return loadStats.ResetEngine(action, AuditSourceAPI)
+}
+
+// DeleteEngine removes an engine immediately
+func DeleteEngine(action *EngineAction) *AdminResult {
+	return loadStats.DeleteEngine(action, AuditSourceAPI)
+}
+
+// DeleteCluster removes a cluster immediately
+func DeleteCluster(action *ClusterAction) *AdminResult {
+	return loadStats.DeleteCluster(action, AuditSourceAPI)
+}
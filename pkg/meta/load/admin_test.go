@@ -0,0 +1,203 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package load
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPaginate(t *testing.T) {
+	items := []int{0, 1, 2, 3, 4}
+	tests := []struct {
+		name     string
+		req      PageRequest
+		expected []int
+		limit    int
+	}{
+		{"default limit", PageRequest{}, []int{0, 1, 2, 3, 4}, DefaultPageLimit},
+		{"first page", PageRequest{Limit: 2}, []int{0, 1}, 2},
+		{"last partial page", PageRequest{Offset: 4, Limit: 2}, []int{4}, 2},
+		{"offset beyond total", PageRequest{Offset: 10, Limit: 2}, []int{}, 2},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			page := paginate(items, tc.req)
+			assert.Equal(t, len(items), page.Total)
+			assert.Equal(t, tc.req.Offset, page.Offset)
+			assert.Equal(t, tc.limit, page.Limit)
+			assert.Equal(t, tc.expected, page.Items)
+		})
+	}
+}
+
+func TestLoadStats_Admin(t *testing.T) {
+	ls := NewLoadStats()
+	for i := 0; i < 3; i++ {
+		ls.AddRequest(&InferenceRequest{
+			Cluster:      "admin-b",
+			RequestId:    fmt.Sprintf("admin-b-%d", i),
+			PromptLength: 100,
+			Ip:           "192.168.50.1",
+			Model:        "m",
+		})
+	}
+	ls.AddRequest(&InferenceRequest{Cluster: "admin-b", RequestId: "admin-b-other", Ip: "192.168.50.2"})
+	ls.AddRequest(&InferenceRequest{Cluster: "admin-a", RequestId: "admin-a-0", Endpoint: "192.168.50.1:8000"})
+
+	t.Run("list clusters", func(t *testing.T) {
+		page := ls.ListClusters("", PageRequest{})
+		require.Equal(t, 2, page.Total)
+		assert.Equal(t, "admin-a", page.Items[0].Cluster)
+		assert.Equal(t, int32(1), page.Items[0].EngineCount)
+		assert.Equal(t, "admin-b", page.Items[1].Cluster)
+		assert.Equal(t, int32(2), page.Items[1].EngineCount)
+
+		page = ls.ListClusters("", PageRequest{Offset: 1, Limit: 1})
+		require.Len(t, page.Items, 1)
+		assert.Equal(t, "admin-b", page.Items[0].Cluster)
+	})
+
+	t.Run("list engine requests", func(t *testing.T) {
+		ls.DeletePromptLength(newDeletionInferenceRequest("admin-b-0"))
+
+		page := ls.ListEngineRequests(&EngineRequestsQuery{Cluster: "admin-b", Ip: "192.168.50.1"})
+		require.Equal(t, 3, page.Total)
+		for _, req := range page.Items {
+			assert.Equal(t, "192.168.50.1", req.Engine)
+			assert.Equal(t, "m", req.Model)
+			assert.GreaterOrEqual(t, req.AgeMs, int64(0))
+			if req.RequestId == "admin-b-0" {
+				assert.Equal(t, int32(0), req.PromptLength)
+			} else {
+				assert.Equal(t, int32(100), req.PromptLength)
+			}
+		}
+
+		page = ls.ListEngineRequests(&EngineRequestsQuery{Cluster: "admin-a", Endpoint: "192.168.50.1:8000"})
+		require.Equal(t, 1, page.Total)
+		assert.Equal(t, "admin-a-0", page.Items[0].RequestId)
+
+		page = ls.ListEngineRequests(&EngineRequestsQuery{Cluster: "admin-a", Ip: "192.168.50.1"})
+		assert.Equal(t, 0, page.Total)
+	})
+
+	t.Run("get request", func(t *testing.T) {
+		req, ok := ls.GetRequest("admin-b-other")
+		require.True(t, ok)
+		assert.Equal(t, "admin-b", req.Cluster)
+		assert.Equal(t, "192.168.50.2", req.Engine)
+
+		ls.DeleteRequest(newDeletionInferenceRequest("admin-b-other"))
+		_, ok = ls.GetRequest("admin-b-other")
+		assert.False(t, ok)
+	})
+}
+
+func TestLoadStats_AdminCorrections(t *testing.T) {
+	ls := NewLoadStats()
+	cluster := "admin-fix-domain"
+	ip := "192.168.51.1"
+	for i := 0; i < 4; i++ {
+		ls.AddRequest(&InferenceRequest{
+			Cluster:      cluster,
+			RequestId:    fmt.Sprintf("fix-%d", i),
+			PromptLength: 100,
+			Ip:           ip,
+			Model:        fmt.Sprintf("m%d", i%2),
+		})
+	}
+	ls.AddRequest(&InferenceRequest{Cluster: cluster, RequestId: "fix-other", PromptLength: 50, Ip: "192.168.51.2"})
+	ls.AddRequest(&InferenceRequest{Cluster: "admin-fix-other", RequestId: "fix-keep", Ip: ip})
+	ms := ls.GetModelStats(cluster)
+	es, ok := ms.Load(ip)
+	require.True(t, ok)
+
+	t.Run("evict request", func(t *testing.T) {
+		hook := test.NewGlobal()
+		defer hook.Reset()
+		result := ls.EvictRequest(&EvictRequest{RequestId: "fix-0", AdminAction: AdminAction{Operator: "ops", Reason: "stuck"}},
+			AuditSourceAPI)
+		assert.True(t, result.Found)
+		entry := hook.LastEntry()
+		require.NotNil(t, entry)
+		assert.Equal(t, "audit", entry.Message)
+		assert.Equal(t, logrus.Fields{
+			"audit":    true,
+			"action":   "evict_request",
+			"target":   "fix-0",
+			"operator": "ops",
+			"reason":   "stuck",
+			"source":   AuditSourceAPI,
+			"found":    true,
+			"requests": 1,
+		}, entry.Data)
+		assert.Equal(t, int32(3), es.GetQueuedReqNum())
+		assert.Equal(t, int32(300), es.GetPromptLength())
+
+		result = ls.EvictRequest(&EvictRequest{RequestId: "fix-0"}, AuditSourceAPI)
+		assert.False(t, result.Found)
+		assert.Equal(t, int32(3), es.GetQueuedReqNum())
+	})
+
+	t.Run("reset engine", func(t *testing.T) {
+		// Simulate drift: a lost delete and a stale model counter
+		es.IncrementQueuedReqNumAndPromptLength(&InferenceRequest{Cluster: cluster, Model: "stale"}, 500)
+		ls.DeletePromptLength(newDeletionInferenceRequest("fix-1"))
+
+		result := ls.ResetEngine(&EngineAction{Cluster: cluster, Ip: ip}, AuditSourceAPI)
+		require.True(t, result.Found)
+		assert.Equal(t, 3, result.Requests)
+		assert.Equal(t, int32(3), es.GetQueuedReqNum())
+		assert.Equal(t, int32(200), es.GetPromptLength())
+		assert.Equal(t, map[string]*LoadCounter{
+			"m0": {QueuedReqNum: 1, PromptLength: 100},
+			"m1": {QueuedReqNum: 2, PromptLength: 100},
+		}, result.Engine.Models)
+
+		result = ls.ResetEngine(&EngineAction{Cluster: cluster, Ip: "192.168.51.9"}, AuditSourceAPI)
+		assert.False(t, result.Found)
+	})
+
+	t.Run("delete engine", func(t *testing.T) {
+		result := ls.DeleteEngine(&EngineAction{Cluster: cluster, Ip: ip}, AuditSourceAPI)
+		assert.True(t, result.Found)
+		assert.Equal(t, 3, result.Requests)
+		assert.Equal(t, int32(1), ms.Size())
+		_, ok := ls.GetRequest("fix-1")
+		assert.False(t, ok)
+
+		// Late deletes of dropped requests must not touch a re-created engine
+		ls.AddRequest(&InferenceRequest{Cluster: cluster, RequestId: "fix-new", Ip: ip})
+		ls.DeleteRequest(newDeletionInferenceRequest("fix-2"))
+		es, ok = ms.Load(ip)
+		require.True(t, ok)
+		assert.Equal(t, int32(1), es.GetQueuedReqNum())
+	})
+
+	t.Run("delete cluster", func(t *testing.T) {
+		result := ls.DeleteCluster(&ClusterAction{Cluster: cluster}, AuditSourceAPI)
+		assert.True(t, result.Found)
+		assert.Equal(t, 2, result.Requests)
+		assert.Nil(t, ls.GetModelStats(cluster))
+		_, ok := ls.GetRequest("fix-keep")
+		assert.True(t, ok, "requests of other clusters are kept")
+	})
+}
@@ -0,0 +1,163 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package load
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// BackendMemory keeps the statistics in memory, instances are kept in sync by replication
+	BackendMemory = "memory"
+	// BackendRedis keeps the statistics in a Redis-protocol store shared by all instances
+	BackendRedis = "redis"
+)
+
+// Backend stores the request counters of the engines
+// The in-memory backend is local to the instance, a shared backend makes instances stateless
+type Backend interface {
+	AddRequest(req *InferenceRequest) error
+	DeleteRequest(req *DeletionInferenceRequest) error
+	DeletePromptLength(req *DeletionInferenceRequest) error
+	SetEngineReport(report *EngineReport) error
+	// Snapshots returns the engines of a cluster with the model filter and the blend mode applied
+	Snapshots(cluster, model, blend string, now int64) ([]*EngineSnapshot, error)
+	// Clusters returns the names of the clusters with statistics
+	Clusters() ([]string, error)
+	// Expire removes the requests that were neither deleted nor completed in time
+	Expire(now time.Time) (int, error)
+	// Shared reports whether all instances see the same state, replication is then not needed
+	Shared() bool
+}
+
+// newBackend creates the configured backend, the in-memory one wraps the given statistics
+func newBackend(ls *LoadStats) Backend {
+	if backendKind == BackendRedis {
+		client := redis.NewClient(&redis.Options{
+			Addr:     redisAddr,
+			Password: redisPassword,
+			DB:       redisDB,
+		})
+		return NewRedisBackend(client, redisPrefix)
+	}
+	return memoryBackend{ls: ls}
+}
+
+// checkSharedSettings rejects settings that only act on the in-memory state when the backend is shared
+func checkSharedSettings() error {
+	if backendKind != BackendRedis {
+		return nil
+	}
+	var unsupported []string
+	if tenantMaxRequests > 0 || tenantMaxClusters > 0 {
+		unsupported = append(unsupported, "tenant quotas")
+	}
+	if maxRequests > 0 || maxClusters > 0 || maxEnginesPerCluster > 0 {
+		unsupported = append(unsupported, "capacity caps")
+	}
+	if walDir != "" {
+		unsupported = append(unsupported, "the write-ahead log")
+	}
+	if raftID != "" {
+		unsupported = append(unsupported, "consensus reservations")
+	}
+	if len(unsupported) > 0 {
+		return fmt.Errorf("%s unsupported with the shared %s backend", strings.Join(unsupported, ", "), backendKind)
+	}
+	return nil
+}
+
+// memoryBackend is the Backend of the in-memory LoadStats, it never fails
+type memoryBackend struct {
+	ls *LoadStats
+}
+
+func (b memoryBackend) AddRequest(req *InferenceRequest) error {
+	b.ls.AddRequest(req)
+	return nil
+}
+
+func (b memoryBackend) DeleteRequest(req *DeletionInferenceRequest) error {
+	b.ls.DeleteRequest(req)
+	return nil
+}
+
+func (b memoryBackend) DeletePromptLength(req *DeletionInferenceRequest) error {
+	b.ls.DeletePromptLength(req)
+	return nil
+}
+
+func (b memoryBackend) SetEngineReport(report *EngineReport) error {
+	b.ls.SetEngineReport(report)
+	return nil
+}
+
+func (b memoryBackend) Snapshots(cluster, model, blend string, now int64) ([]*EngineSnapshot, error) {
+	return b.ls.GetModelStats(cluster).selectAt(model, blend, now), nil
+}
+
+func (b memoryBackend) Clusters() ([]string, error) {
+	var names []string
+	b.ls.RunningModelStats.Range(func(key string, _ *ModelStats) bool {
+		names = append(names, key)
+		return true
+	})
+	return names, nil
+}
+
+func (b memoryBackend) Expire(now time.Time) (int, error) {
+	return b.ls.expireRequests(now), nil
+}
+
+func (b memoryBackend) Shared() bool {
+	return false
+}
+
+// selectClusters returns engine snapshots of a backend grouped per cluster for the given names or pattern
+// Listed clusters without statistics are returned with no engines, a pattern only returns existing clusters
+func selectClusters(b Backend, req *MultiClusterQueryRequest) (*ClustersSnapshot, error) {
+	now := time.Now().UnixNano()
+	ret := &ClustersSnapshot{
+		SnapshotTime: now,
+		Clusters:     make(map[string][]*EngineSnapshot),
+	}
+	names := req.ClusterNames()
+	if req.Pattern != "" {
+		clusters, err := b.Clusters()
+		if err != nil {
+			return nil, err
+		}
+		names = names[:0]
+		for _, name := range clusters {
+			// The pattern is validated on input, a malformed one matches nothing
+			if matched, _ := path.Match(req.Pattern, name); matched {
+				names = append(names, name)
+			}
+		}
+	}
+	for _, name := range names {
+		snapshots, err := b.Snapshots(name, req.Model, req.Blend, now)
+		if err != nil {
+			return nil, err
+		}
+		ret.Clusters[name] = snapshots
+	}
+	return ret, nil
+}
@@ -0,0 +1,222 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package load
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/aigw-project/metadata-center/pkg/prom"
+	"github.com/aigw-project/metadata-center/pkg/utils/errors"
+	"github.com/aigw-project/metadata-center/pkg/utils/logger"
+)
+
+const (
+	// CapacityEvict makes room for new entries by removing the oldest ones
+	CapacityEvict = "evict"
+	// CapacityReject rejects API calls that would exceed a cap, replicated adds still evict
+	CapacityReject = "reject"
+)
+
+// Capacity kinds used in rejections and metrics
+const (
+	capacityRequests = "requests"
+	capacityClusters = "clusters"
+	capacityEngines  = "engines"
+)
+
+// lruIndex orders keys least recently used first, so the eviction candidate is found without a scan
+// The zero value is ready to use
+type lruIndex struct {
+	mu    sync.Mutex
+	order list.List
+	items map[string]*list.Element
+}
+
+// touch marks a key as the most recently used, indexing it if needed
+func (x *lruIndex) touch(key string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if e, ok := x.items[key]; ok {
+		x.order.MoveToBack(e)
+		return
+	}
+	if x.items == nil {
+		x.items = make(map[string]*list.Element)
+	}
+	x.items[key] = x.order.PushBack(key)
+}
+
+// remove drops a key from the index
+func (x *lruIndex) remove(key string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if e, ok := x.items[key]; ok {
+		x.order.Remove(e)
+		delete(x.items, key)
+	}
+}
+
+// oldest returns the least recently used key other than except, false when there is none
+func (x *lruIndex) oldest(except string) (string, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for e := x.order.Front(); e != nil; e = e.Next() {
+		if key := e.Value.(string); key != except {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// tenantClusters returns the cluster index of a tenant, creating it if needed
+func (ls *LoadStats) tenantClusters(tenant string) *lruIndex {
+	v, ok := ls.clusterLRUs.Load(tenant)
+	if !ok {
+		v, _ = ls.clusterLRUs.LoadOrStore(tenant, &lruIndex{})
+	}
+	return v.(*lruIndex)
+}
+
+// touchCluster marks a cluster and one of its engines as the most recently used
+// The indexes are only kept for the caps that are set, so unlimited instances pay no locking on the hot path
+func (ls *LoadStats) touchCluster(cluster string, modelStats *ModelStats, engine string) {
+	if maxClusters > 0 {
+		ls.clusterLRU.touch(cluster)
+		ls.tenantClusters(tenantOf(cluster)).touch(cluster)
+	}
+	if maxEnginesPerCluster > 0 {
+		modelStats.engineLRU.touch(engine)
+	}
+}
+
+// untrackCluster drops a removed cluster from the eviction indexes
+func (ls *LoadStats) untrackCluster(cluster string) {
+	ls.clusterLRU.remove(cluster)
+	if v, ok := ls.clusterLRUs.Load(tenantOf(cluster)); ok {
+		v.(*lruIndex).remove(cluster)
+	}
+}
+
+// isTracked reports whether the request is the one stored under its ID
+func (ls *LoadStats) isTracked(req *InferenceRequest) bool {
+	v, ok := ls.Requests.Load(req.RequestId)
+	return ok && v == req
+}
+
+// makeRequestRoom evicts the oldest requests until the tracked requests are within the cap
+func (ls *LoadStats) makeRequestRoom() {
+	for maxRequests > 0 && ls.requestCount.Load() > maxRequests {
+		if !ls.evictOldestRequest() {
+			return
+		}
+	}
+}
+
+// evictOldestRequest removes the oldest tracked request and decrements its engine
+func (ls *LoadStats) evictOldestRequest() bool {
+	req, ok := ls.popOldest()
+	if !ok || !ls.Requests.CompareAndDelete(req.RequestId, req) {
+		return false
+	}
+	ls.untrackRequest(req)
+	ls.decEngineStats(req)
+	ls.logRemoval(req.RequestId, time.Now())
+	prom.CapacityEvictedTotal.WithLabelValues(capacityRequests).Inc()
+	logger.Warnf("reqID [%s]: evicted, tracked requests at capacity %d", req.RequestId, maxRequests)
+	return true
+}
+
+// makeClusterRoom evicts the least recently used clusters until a new cluster fits
+// The clusters of the tenant creating the new one go first, so a tenant only evicts the clusters of other tenants
+// until it has one of its own
+func (ls *LoadStats) makeClusterRoom(cluster string) {
+	if maxClusters <= 0 {
+		return
+	}
+	own := ls.tenantClusters(tenantOf(cluster))
+	for ls.clusterCount.Load() >= maxClusters {
+		oldest, ok := own.oldest(cluster)
+		if !ok {
+			oldest, ok = ls.clusterLRU.oldest(cluster)
+		}
+		if !ok {
+			return
+		}
+		// A cluster removed by another path meanwhile has left the index as well
+		found, requests := ls.removeCluster(oldest)
+		if !found {
+			continue
+		}
+		prom.CapacityEvictedTotal.WithLabelValues(capacityClusters).Inc()
+		logger.Warnf("cluster %s evicted with %d requests, tracked clusters at capacity %d", oldest, requests, maxClusters)
+	}
+}
+
+// makeEngineRoom evicts the least recently used engines of a cluster until a new engine fits
+func (ls *LoadStats) makeEngineRoom(cluster string, modelStats *ModelStats, engine string) {
+	for maxEnginesPerCluster > 0 && modelStats.Size() >= maxEnginesPerCluster {
+		oldest, ok := modelStats.engineLRU.oldest(engine)
+		if !ok {
+			return
+		}
+		found, requests := ls.removeEngine(cluster, oldest)
+		if !found {
+			// The engine was removed by another path meanwhile
+			modelStats.engineLRU.remove(oldest)
+			continue
+		}
+		prom.CapacityEvictedTotal.WithLabelValues(capacityEngines).Inc()
+		logger.Warnf("engine %s on model %s evicted with %d requests, engines at capacity %d",
+			oldest, cluster, requests, maxEnginesPerCluster)
+	}
+}
+
+// CheckCapacity returns an error when tracking a request or report would exceed a cap under the reject policy
+// request is true when a new in-flight request is added, false for engine reports
+func (ls *LoadStats) CheckCapacity(cluster, engine string, request bool) error {
+	if capacityPolicy != CapacityReject {
+		return nil
+	}
+	if request && maxRequests > 0 && ls.requestCount.Load() >= maxRequests {
+		return rejectCapacity(capacityRequests, maxRequests)
+	}
+	modelStats := ls.GetModelStats(cluster)
+	if modelStats == nil {
+		if maxClusters > 0 && ls.clusterCount.Load() >= maxClusters {
+			return rejectCapacity(capacityClusters, maxClusters)
+		}
+		return nil
+	}
+	if maxEnginesPerCluster > 0 {
+		if _, ok := modelStats.Engines.Load(engine); !ok && modelStats.Size() >= maxEnginesPerCluster {
+			return rejectCapacity(capacityEngines, int64(maxEnginesPerCluster))
+		}
+	}
+	return nil
+}
+
+// rejectCapacity records a capacity rejection and returns the error reported to the client
+func rejectCapacity(kind string, limit int64) error {
+	prom.CapacityRejectedTotal.WithLabelValues(kind).Inc()
+	logger.Warnf("rejected, tracked %s at capacity %d", kind, limit)
+	return errors.CapacityExceeded("rejected: tracked %s at capacity %d", kind, limit)
+}
+
+// CheckCapacity returns an error when tracking a request or report would exceed a cap under the reject policy
+func CheckCapacity(cluster, engine string, request bool) error {
+	return loadStats.CheckCapacity(cluster, engine, request)
+}
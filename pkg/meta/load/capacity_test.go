@@ -0,0 +1,214 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package load
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aigw-project/metadata-center/pkg/utils/errors"
+)
+
+func TestLoadStats_CapacityEvict(t *testing.T) {
+	SetMaxRequests(3)
+	SetMaxClusters(2)
+	SetMaxEnginesPerCluster(2)
+	defer func() {
+		SetMaxRequests(int(DefaultMaxRequests))
+		SetMaxClusters(int(DefaultMaxClusters))
+		SetMaxEnginesPerCluster(int(DefaultMaxEnginesPerCluster))
+	}()
+
+	t.Run("oldest request is evicted", func(t *testing.T) {
+		ls := NewLoadStats()
+		for i := 0; i < 5; i++ {
+			ls.AddRequest(&InferenceRequest{Cluster: "cap-a", RequestId: fmt.Sprintf("cap-%d", i), PromptLength: 10, Ip: "192.168.90.1"})
+		}
+		ls.DeleteRequest(&DeletionInferenceRequest{RequestId: "cap-3"})
+		ls.AddRequest(&InferenceRequest{Cluster: "cap-a", RequestId: "cap-5", PromptLength: 10, Ip: "192.168.90.1"})
+
+		assert.Equal(t, int64(3), ls.requestCount.Load())
+		for id, tracked := range map[string]bool{"cap-1": false, "cap-2": true, "cap-4": true, "cap-5": true} {
+			_, ok := ls.Requests.Load(id)
+			assert.Equalf(t, tracked, ok, "request %s", id)
+		}
+		es, ok := ls.GetModelStats("cap-a").Load("192.168.90.1")
+		require.True(t, ok)
+		assert.Equal(t, int32(3), es.GetQueuedReqNum())
+		assert.Equal(t, int32(30), es.GetPromptLength())
+	})
+
+	t.Run("least recently used cluster is evicted", func(t *testing.T) {
+		ls := NewLoadStats()
+		ls.SetEngineReport(&EngineReport{Cluster: "cap-new", Ip: "192.168.90.1"})
+		ls.SetEngineReport(&EngineReport{Cluster: "cap-old", Ip: "192.168.90.1"})
+		ls.AddRequest(&InferenceRequest{Cluster: "cap-old", RequestId: "cap-old-1", Ip: "192.168.90.1"})
+		ls.AddRequest(&InferenceRequest{Cluster: "cap-new", RequestId: "cap-new-1", Ip: "192.168.90.1"})
+		ls.DeleteRequest(&DeletionInferenceRequest{RequestId: "cap-new-1"})
+
+		ls.SetEngineReport(&EngineReport{Cluster: "cap-next", Ip: "192.168.90.1"})
+		assert.Nil(t, ls.GetModelStats("cap-old"))
+		assert.NotNil(t, ls.GetModelStats("cap-new"))
+		assert.NotNil(t, ls.GetModelStats("cap-next"))
+		assert.Equal(t, int64(2), ls.clusterCount.Load())
+		assert.Equal(t, int64(0), ls.requestCount.Load(), "requests of the evicted cluster are dropped")
+	})
+
+	t.Run("least recently active engine is evicted", func(t *testing.T) {
+		ls := NewLoadStats()
+		ls.AddRequest(&InferenceRequest{Cluster: "cap-e", RequestId: "cap-e-1", Ip: "192.168.90.1"})
+		time.Sleep(time.Millisecond)
+		ls.AddRequest(&InferenceRequest{Cluster: "cap-e", RequestId: "cap-e-2", Ip: "192.168.90.2"})
+		time.Sleep(time.Millisecond)
+		ls.SetEngineReport(&EngineReport{Cluster: "cap-e", Ip: "192.168.90.3"})
+
+		modelStats := ls.GetModelStats("cap-e")
+		assert.Equal(t, int32(2), modelStats.Size())
+		_, ok := modelStats.Load("192.168.90.1")
+		assert.False(t, ok)
+		_, ok = ls.Requests.Load("cap-e-1")
+		assert.False(t, ok, "requests of the evicted engine are dropped")
+		_, ok = ls.Requests.Load("cap-e-2")
+		assert.True(t, ok, "requests of other engines are kept")
+		assert.Equal(t, int64(1), ls.requestCount.Load())
+	})
+
+	t.Run("clusters of the same tenant are evicted first", func(t *testing.T) {
+		ls := NewLoadStats()
+		other := ScopedName("team-b", "qwen")
+		ls.AddRequest(&InferenceRequest{Cluster: other, RequestId: ScopedName("team-b", "cap-1"), Ip: "192.168.90.1"})
+		ls.AddRequest(&InferenceRequest{Cluster: ScopedName("team-a", "a-1"), RequestId: ScopedName("team-a", "cap-1"), Ip: "192.168.90.1"})
+
+		// team-b holds the least recently used cluster, yet team-a evicts its own
+		ls.AddRequest(&InferenceRequest{Cluster: ScopedName("team-a", "a-2"), RequestId: ScopedName("team-a", "cap-2"), Ip: "192.168.90.1"})
+		assert.NotNil(t, ls.GetModelStats(other))
+		assert.Nil(t, ls.GetModelStats(ScopedName("team-a", "a-1")))
+		assert.Equal(t, int64(1), ls.GetTenantUsage("team-b").Requests)
+		assert.Equal(t, int64(1), ls.GetTenantUsage("team-a").Requests)
+
+		// A tenant without clusters falls back to the least recently used one of any tenant
+		ls.AddRequest(&InferenceRequest{Cluster: "qwen", RequestId: "cap-3", Ip: "192.168.90.1"})
+		assert.Nil(t, ls.GetModelStats(other))
+		assert.NotNil(t, ls.GetModelStats(ScopedName("team-a", "a-2")))
+		assert.Equal(t, int64(2), ls.clusterCount.Load())
+		assert.Equal(t, int64(2), ls.requestCount.Load())
+	})
+
+	t.Run("removed clusters and engines leave the indexes", func(t *testing.T) {
+		ls := NewLoadStats()
+		ls.SetEngineReport(&EngineReport{Cluster: "cap-x", Ip: "192.168.90.1"})
+		ls.SetEngineReport(&EngineReport{Cluster: "cap-x", Ip: "192.168.90.2"})
+		ls.SetEngineReport(&EngineReport{Cluster: "cap-y", Ip: "192.168.90.1"})
+		ls.DeleteEngine(&EngineAction{Cluster: "cap-x", Ip: "192.168.90.1"}, AuditSourceAPI)
+		ls.DeleteCluster(&ClusterAction{Cluster: "cap-y"}, AuditSourceAPI)
+
+		oldest, ok := ls.clusterLRU.oldest("")
+		require.True(t, ok)
+		assert.Equal(t, "cap-x", oldest)
+		_, ok = ls.clusterLRU.oldest("cap-x")
+		assert.False(t, ok)
+		oldest, ok = ls.GetModelStats("cap-x").engineLRU.oldest("")
+		require.True(t, ok)
+		assert.Equal(t, "192.168.90.2", oldest)
+	})
+}
+
+func TestLoadStats_CapacityPerReplica(t *testing.T) {
+	SetMaxRequests(2)
+	defer SetMaxRequests(int(DefaultMaxRequests))
+
+	// Evictions are not replicated, each replica bounds the state it holds on its own
+	ids := []string{"rep-1", "rep-2", "rep-3"}
+	local, replica := NewLoadStats(), NewLoadStats()
+	for _, id := range ids {
+		local.AddRequest(&InferenceRequest{Cluster: "cap-rep", RequestId: id, Ip: "192.168.90.1"})
+	}
+	for _, id := range []string{ids[1], ids[2], ids[0]} {
+		replica.AddRequest(&InferenceRequest{Cluster: "cap-rep", RequestId: id, Ip: "192.168.90.1"})
+	}
+
+	for ls, evicted := range map[*LoadStats]string{local: "rep-1", replica: "rep-2"} {
+		assert.Equal(t, int64(2), ls.requestCount.Load())
+		for _, id := range ids {
+			_, ok := ls.Requests.Load(id)
+			assert.Equalf(t, id != evicted, ok, "request %s", id)
+		}
+	}
+}
+
+func TestLoadStats_CapacityIndexesUnlimited(t *testing.T) {
+	ls := NewLoadStats()
+	ls.AddRequest(&InferenceRequest{Cluster: ScopedName("team-a", "cap-u"), RequestId: "cap-u-1", Ip: "192.168.90.1"})
+	ls.SetEngineReport(&EngineReport{Cluster: "cap-u", Ip: "192.168.90.2"})
+
+	_, ok := ls.clusterLRU.oldest("")
+	assert.False(t, ok, "clusters are not indexed without a cluster cap")
+	_, ok = ls.clusterLRUs.Load("team-a")
+	assert.False(t, ok)
+	_, ok = ls.GetModelStats("cap-u").engineLRU.oldest("")
+	assert.False(t, ok, "engines are not indexed without an engine cap")
+
+	ls.DeleteCluster(&ClusterAction{Cluster: ScopedName("team-a", "cap-u")}, AuditSourceAPI)
+	_, ok = ls.clusterLRUs.Load("team-a")
+	assert.False(t, ok)
+}
+
+func TestLoadStats_CheckCapacity(t *testing.T) {
+	SetMaxRequests(2)
+	SetMaxClusters(1)
+	SetMaxEnginesPerCluster(1)
+	SetCapacityPolicy(CapacityReject)
+	defer func() {
+		SetMaxRequests(int(DefaultMaxRequests))
+		SetMaxClusters(int(DefaultMaxClusters))
+		SetMaxEnginesPerCluster(int(DefaultMaxEnginesPerCluster))
+		SetCapacityPolicy(DefaultCapacityPolicy)
+	}()
+
+	ls := NewLoadStats()
+	require.NoError(t, ls.CheckCapacity("cap-r", "192.168.91.1", true))
+	ls.AddRequest(&InferenceRequest{Cluster: "cap-r", RequestId: "cap-r-1", Ip: "192.168.91.1"})
+	require.NoError(t, ls.CheckCapacity("cap-r", "192.168.91.1", true))
+	ls.AddRequest(&InferenceRequest{Cluster: "cap-r", RequestId: "cap-r-2", Ip: "192.168.91.1"})
+
+	tests := []struct {
+		name    string
+		cluster string
+		engine  string
+		request bool
+	}{
+		{"requests", "cap-r", "192.168.91.1", true},
+		{"clusters", "cap-other", "192.168.91.1", false},
+		{"engines", "cap-r", "192.168.91.2", false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ls.CheckCapacity(tc.cluster, tc.engine, tc.request)
+			require.Error(t, err)
+			assert.Equal(t, errors.CapacityExceededCode, err.(*errors.ErrorInfo).Code)
+			assert.Contains(t, err.(*errors.ErrorInfo).Reason, tc.name)
+		})
+	}
+	assert.NoError(t, ls.CheckCapacity("cap-r", "192.168.91.1", false), "reports on tracked engines are accepted")
+
+	SetCapacityPolicy("unknown")
+	assert.Equal(t, CapacityReject, capacityPolicy)
+	SetCapacityPolicy(CapacityEvict)
+	assert.NoError(t, ls.CheckCapacity("cap-r", "192.168.91.1", true))
+}
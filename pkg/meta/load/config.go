@@ -14,16 +14,24 @@
 
 package load
 
-import "time"
+import (
+	"strings"
+	"time"
+
+	"github.com/aigw-project/metadata-center/pkg/utils/logger"
+)
 
 var (
 	DefaultGCInterval            = 60 * time.Second
 	DefaultRequestExpireDuration = 660 * time.Second
+	// DefaultExpireInterval is how often expired requests are removed, 0 leaves them to GC
+	DefaultExpireInterval = time.Second
 )
 
 var (
 	gcInterval            = DefaultGCInterval
 	requestExpireDuration = DefaultRequestExpireDuration
+	expireInterval        = DefaultExpireInterval
 )
 
 // SetGCInterval sets the garbage collection interval
@@ -35,3 +43,515 @@ func SetGCInterval(d time.Duration) {
 func SetRequestExpireDuration(d time.Duration) {
 	requestExpireDuration = d
 }
+
+// SetExpireInterval sets how often expired requests are removed, 0 leaves them to GC
+func SetExpireInterval(d time.Duration) {
+	expireInterval = d
+}
+
+// DefaultReportTTL is how long an engine report is considered fresh for blending
+var DefaultReportTTL = 10 * time.Second
+
+var reportTTL = DefaultReportTTL
+
+// SetReportTTL sets how long an engine report is considered fresh
+func SetReportTTL(d time.Duration) {
+	reportTTL = d
+}
+
+var (
+	// DefaultReconcileInterval disables reconciliation unless explicitly configured
+	DefaultReconcileInterval      = time.Duration(0)
+	DefaultReconcileMetricsPort   = 8000
+	DefaultReconcileMetricsPath   = "/metrics"
+	DefaultReconcileScrapeTimeout = time.Second
+)
+
+var (
+	reconcileInterval      = DefaultReconcileInterval
+	reconcileMetricsPort   = DefaultReconcileMetricsPort
+	reconcileMetricsPath   = DefaultReconcileMetricsPath
+	reconcileScrapeTimeout = DefaultReconcileScrapeTimeout
+	reconcileCorrect       = false
+)
+
+// SetReconcileInterval sets the engine metrics reconciliation interval, 0 disables it
+func SetReconcileInterval(d time.Duration) {
+	reconcileInterval = d
+}
+
+// SetReconcileMetricsPort sets the port used to scrape engine metrics
+func SetReconcileMetricsPort(port int) {
+	reconcileMetricsPort = port
+}
+
+// SetReconcileMetricsPath sets the HTTP path used to scrape engine metrics
+func SetReconcileMetricsPath(path string) {
+	reconcileMetricsPath = path
+}
+
+// SetReconcileScrapeTimeout sets the timeout of a single engine scrape
+func SetReconcileScrapeTimeout(d time.Duration) {
+	reconcileScrapeTimeout = d
+}
+
+// SetReconcileCorrect enables overwriting counters with engine-reported values
+func SetReconcileCorrect(correct bool) {
+	reconcileCorrect = correct
+}
+
+// DefaultInvariantCheckInterval disables the counter invariant checker unless explicitly configured
+var DefaultInvariantCheckInterval = time.Duration(0)
+
+var (
+	invariantCheckInterval = DefaultInvariantCheckInterval
+	invariantFix           = false
+)
+
+// SetInvariantCheckInterval sets the counter invariant check interval, 0 disables it
+func SetInvariantCheckInterval(d time.Duration) {
+	invariantCheckInterval = d
+}
+
+// SetInvariantFix enables recomputing counters that violate the invariants
+func SetInvariantFix(fix bool) {
+	invariantFix = fix
+}
+
+var (
+	// DefaultHistoryInterval disables the per-engine load history unless explicitly configured
+	DefaultHistoryInterval = time.Duration(0)
+	// DefaultHistorySize is the number of samples kept per engine
+	DefaultHistorySize = 60
+)
+
+var (
+	historyInterval = DefaultHistoryInterval
+	historySize     = DefaultHistorySize
+)
+
+// SetHistoryInterval sets the load history sampling interval, 0 disables it
+func SetHistoryInterval(d time.Duration) {
+	historyInterval = d
+}
+
+// SetHistorySize sets the number of samples kept per engine, 0 disables the history
+func SetHistorySize(size int) {
+	historySize = size
+}
+
+// DefaultLatencyWindowSize is the number of recent latencies per engine used for percentiles
+var DefaultLatencyWindowSize = 256
+
+var latencyWindowSize = DefaultLatencyWindowSize
+
+// SetLatencyWindowSize sets the number of recent latencies kept per engine, 0 disables tracking
+func SetLatencyWindowSize(size int) {
+	latencyWindowSize = size
+}
+
+// DefaultPriorityClasses are the priority classes accepted from clients, comma separated
+var DefaultPriorityClasses = "interactive,batch"
+
+// priorityClasses bounds the priority sub-counters and metric series clients can create
+var priorityClasses = parsePriorityClasses(DefaultPriorityClasses)
+
+// SetPriorityClasses sets the comma separated priority classes accepted from clients
+func SetPriorityClasses(classes string) {
+	parsed := parsePriorityClasses(classes)
+	if len(parsed) == 0 {
+		logger.Errorf("no priority class in %q, keeping %s", classes, DefaultPriorityClasses)
+		return
+	}
+	priorityClasses = parsed
+}
+
+// parsePriorityClasses parses a comma separated list of priority classes
+func parsePriorityClasses(classes string) map[string]struct{} {
+	parsed := make(map[string]struct{})
+	for _, class := range strings.Split(classes, ",") {
+		if class = strings.TrimSpace(class); class != "" {
+			parsed[class] = struct{}{}
+		}
+	}
+	return parsed
+}
+
+// DefaultMaxEngineModels is the number of models tracked per engine, 0 means unlimited
+var DefaultMaxEngineModels = 32
+
+// maxEngineModels bounds the model sub-counters and metric series clients can create on an engine
+var maxEngineModels = DefaultMaxEngineModels
+
+// SetMaxEngineModels sets the number of models tracked per engine, 0 means unlimited
+func SetMaxEngineModels(n int) {
+	maxEngineModels = n
+}
+
+var origin string
+
+// SetOrigin sets the identity recorded on the requests accepted by this instance
+// When empty the pod IP is used, or the hostname without one
+func SetOrigin(id string) {
+	origin = id
+}
+
+var (
+	// DefaultTenantMaxRequests is the default in-flight request quota of a tenant, 0 means unlimited
+	DefaultTenantMaxRequests = int64(0)
+	// DefaultTenantMaxClusters is the default cluster quota of a tenant, 0 means unlimited
+	DefaultTenantMaxClusters = int64(0)
+)
+
+var (
+	tenantMaxRequests = DefaultTenantMaxRequests
+	tenantMaxClusters = DefaultTenantMaxClusters
+)
+
+// SetTenantMaxRequests sets the in-flight request quota of each tenant, 0 means unlimited
+func SetTenantMaxRequests(n int) {
+	tenantMaxRequests = int64(n)
+}
+
+// SetTenantMaxClusters sets the cluster quota of each tenant, 0 means unlimited
+func SetTenantMaxClusters(n int) {
+	tenantMaxClusters = int64(n)
+}
+
+var (
+	// DefaultMaxRequests caps the tracked requests, 0 means unlimited
+	DefaultMaxRequests = int64(0)
+	// DefaultMaxClusters caps the tracked clusters, 0 means unlimited
+	DefaultMaxClusters = int64(0)
+	// DefaultMaxEnginesPerCluster caps the engines tracked in one cluster, 0 means unlimited
+	DefaultMaxEnginesPerCluster = int32(0)
+	// DefaultCapacityPolicy evicts the oldest entries when a cap is reached
+	DefaultCapacityPolicy = CapacityEvict
+)
+
+var (
+	maxRequests          = DefaultMaxRequests
+	maxClusters          = DefaultMaxClusters
+	maxEnginesPerCluster = DefaultMaxEnginesPerCluster
+	capacityPolicy       = DefaultCapacityPolicy
+)
+
+// SetMaxRequests sets the maximum number of tracked requests, 0 means unlimited
+func SetMaxRequests(n int) {
+	maxRequests = int64(n)
+}
+
+// SetMaxClusters sets the maximum number of tracked clusters, 0 means unlimited
+func SetMaxClusters(n int) {
+	maxClusters = int64(n)
+}
+
+// SetMaxEnginesPerCluster sets the maximum number of engines tracked in one cluster, 0 means unlimited
+func SetMaxEnginesPerCluster(n int) {
+	maxEnginesPerCluster = int32(n)
+}
+
+// SetCapacityPolicy sets what happens when a cap is reached, evict or reject
+func SetCapacityPolicy(policy string) {
+	if policy != CapacityEvict && policy != CapacityReject {
+		logger.Errorf("unknown capacity policy %s, keeping %s", policy, capacityPolicy)
+		return
+	}
+	capacityPolicy = policy
+}
+
+// DefaultTombstoneTTL is how long a delete waits for its request to be added
+var DefaultTombstoneTTL = 60 * time.Second
+
+var tombstoneTTL = DefaultTombstoneTTL
+
+// SetTombstoneTTL sets how long a delete waits for its request to be added, 0 drops unmatched deletes
+func SetTombstoneTTL(d time.Duration) {
+	tombstoneTTL = d
+}
+
+// DefaultMaxTombstones caps the deletes waiting for their request, the oldest are evicted beyond it, 0 means unlimited
+var DefaultMaxTombstones = int64(100000)
+
+var maxTombstones = DefaultMaxTombstones
+
+// SetMaxTombstones sets the maximum number of deletes waiting for their request, 0 means unlimited
+func SetMaxTombstones(n int) {
+	maxTombstones = int64(n)
+}
+
+var (
+	// DefaultStorageKind is the storage of the tracked requests
+	DefaultStorageKind = StorageSharded
+	// DefaultStorageShards is the number of shards of the sharded storage
+	DefaultStorageShards = 64
+)
+
+var (
+	storageKind   = DefaultStorageKind
+	storageShards = DefaultStorageShards
+)
+
+// SetStorageKind sets the storage of the tracked requests, sharded or syncmap
+func SetStorageKind(kind string) {
+	if kind != StorageSharded && kind != StorageSyncMap {
+		logger.Errorf("unknown storage kind %s, keeping %s", kind, storageKind)
+		return
+	}
+	storageKind = kind
+}
+
+// SetStorageShards sets the number of shards of the sharded storage, rounded up to a power of two
+func SetStorageShards(n int) {
+	if n <= 0 {
+		logger.Errorf("invalid storage shard count %d, keeping %d", n, storageShards)
+		return
+	}
+	storageShards = n
+}
+
+var (
+	// DefaultBackend is the backend storing the load statistics
+	DefaultBackend = BackendMemory
+	// DefaultRedisAddr is the address of the Redis backend
+	DefaultRedisAddr = "localhost:6379"
+	// DefaultRedisPrefix is prepended to all keys of the Redis backend
+	DefaultRedisPrefix = "metadata-center:load:"
+)
+
+var (
+	backendKind   = DefaultBackend
+	redisAddr     = DefaultRedisAddr
+	redisPassword string
+	redisDB       int
+	redisPrefix   = DefaultRedisPrefix
+)
+
+// SetBackend sets the backend storing the load statistics, memory or redis
+func SetBackend(kind string) {
+	if kind != BackendMemory && kind != BackendRedis {
+		logger.Errorf("unknown load backend %s, keeping %s", kind, backendKind)
+		return
+	}
+	backendKind = kind
+}
+
+// SetRedisAddr sets the host:port address of the Redis backend
+func SetRedisAddr(addr string) {
+	redisAddr = addr
+}
+
+// SetRedisPassword sets the password of the Redis backend
+func SetRedisPassword(password string) {
+	redisPassword = password
+}
+
+// SetRedisDB sets the database number of the Redis backend
+func SetRedisDB(db int) {
+	redisDB = db
+}
+
+// SetRedisPrefix sets the prefix of the keys of the Redis backend, so several deployments can share a server
+func SetRedisPrefix(prefix string) {
+	redisPrefix = prefix
+}
+
+var (
+	// DefaultWALSync is the fsync policy of the write-ahead log
+	DefaultWALSync = WALSyncInterval
+	// DefaultWALSyncInterval is how often the write-ahead log is synced with the interval policy
+	DefaultWALSyncInterval = time.Second
+	// DefaultWALSegmentSize is the size in bytes after which the write-ahead log starts a new segment
+	DefaultWALSegmentSize int64 = 64 << 20
+	// DefaultSnapshotInterval is how often the state is snapshotted and the write-ahead log compacted
+	DefaultSnapshotInterval = 5 * time.Minute
+)
+
+var (
+	walDir           string
+	walSync          = DefaultWALSync
+	walSyncInterval  = DefaultWALSyncInterval
+	walSegmentSize   = DefaultWALSegmentSize
+	snapshotInterval = DefaultSnapshotInterval
+)
+
+// SetWALDir sets the directory of the snapshot and the write-ahead log, empty disables persistence
+func SetWALDir(dir string) {
+	walDir = dir
+}
+
+// SetWALSync sets the fsync policy of the write-ahead log, always, interval or none
+func SetWALSync(policy string) {
+	if policy != WALSyncAlways && policy != WALSyncInterval && policy != WALSyncNone {
+		logger.Errorf("unknown wal sync policy %s, keeping %s", policy, walSync)
+		return
+	}
+	walSync = policy
+}
+
+// SetWALSyncInterval sets how often the write-ahead log is synced with the interval policy
+func SetWALSyncInterval(d time.Duration) {
+	walSyncInterval = d
+}
+
+// SetWALSegmentSize sets the size in bytes after which the write-ahead log starts a new segment
+func SetWALSegmentSize(n int) {
+	walSegmentSize = int64(n)
+}
+
+// SetSnapshotInterval sets how often the state is snapshotted and the write-ahead log compacted, 0 disables it
+func SetSnapshotInterval(d time.Duration) {
+	snapshotInterval = d
+}
+
+// DefaultRaftApplyTimeout bounds how long the leader waits for a reservation to be committed
+var DefaultRaftApplyTimeout = time.Second
+
+var (
+	raftID           string
+	raftAddr         string
+	raftPeers        string
+	raftDir          string
+	raftApplyTimeout = DefaultRaftApplyTimeout
+)
+
+// SetRaftID sets the consensus server ID of this instance, its HTTP host:port, empty disables consensus mode
+func SetRaftID(id string) {
+	raftID = id
+}
+
+// SetRaftAddr sets the host:port the consensus transport binds to and advertises
+func SetRaftAddr(addr string) {
+	raftAddr = addr
+}
+
+// SetRaftPeers sets the comma separated id=address servers the cluster is bootstrapped with, this instance included
+func SetRaftPeers(peers string) {
+	raftPeers = peers
+}
+
+// SetRaftDir sets the directory of the consensus log and snapshots, empty keeps them in memory
+func SetRaftDir(dir string) {
+	raftDir = dir
+}
+
+// SetRaftApplyTimeout sets how long the leader waits for a reservation to be committed
+func SetRaftApplyTimeout(d time.Duration) {
+	raftApplyTimeout = d
+}
+
+// Replication modes of the engine counters
+const (
+	// ReplicationOps replays every add and delete on every instance
+	ReplicationOps = "ops"
+	// ReplicationCRDT merges per-origin counter partitions, each owned by the instance that accepted the requests
+	ReplicationCRDT = "crdt"
+)
+
+var (
+	// DefaultCounterSyncInterval is how often changed counter partitions are pushed in crdt mode
+	DefaultCounterSyncInterval = 100 * time.Millisecond
+	// DefaultAntiEntropyInterval is how often all owned counter partitions are pushed in crdt mode
+	DefaultAntiEntropyInterval = 30 * time.Second
+)
+
+var (
+	replicationMode     = ReplicationOps
+	counterSyncInterval = DefaultCounterSyncInterval
+	antiEntropyInterval = DefaultAntiEntropyInterval
+)
+
+// SetReplicationMode sets how engine counters are replicated, ops or crdt
+func SetReplicationMode(mode string) {
+	if mode != ReplicationOps && mode != ReplicationCRDT {
+		logger.Errorf("unknown replication mode %s, keeping %s", mode, replicationMode)
+		return
+	}
+	replicationMode = mode
+}
+
+// SetCounterSyncInterval sets how often changed counter partitions are pushed in crdt mode
+func SetCounterSyncInterval(d time.Duration) {
+	counterSyncInterval = d
+}
+
+// SetAntiEntropyInterval sets how often all owned counter partitions are pushed in crdt mode, 0 disables it
+func SetAntiEntropyInterval(d time.Duration) {
+	antiEntropyInterval = d
+}
+
+var (
+	// DefaultOriginGrace is how long the requests of an origin gone from service discovery are kept, 0 disables the failover
+	DefaultOriginGrace time.Duration = 0
+	// DefaultOriginCheckInterval is how often the origins of tracked requests are checked against service discovery
+	DefaultOriginCheckInterval = 5 * time.Second
+	// DefaultOriginMissingChecks is how many consecutive checks must miss an origin before its requests are removed
+	DefaultOriginMissingChecks = 3
+)
+
+var (
+	originGrace         = DefaultOriginGrace
+	originCheckInterval = DefaultOriginCheckInterval
+	originMissingChecks = DefaultOriginMissingChecks
+)
+
+// SetOriginGrace sets how long the requests of an origin gone from service discovery are kept, 0 disables the failover
+func SetOriginGrace(d time.Duration) {
+	originGrace = d
+}
+
+// SetOriginCheckInterval sets how often the origins of tracked requests are checked against service discovery
+func SetOriginCheckInterval(d time.Duration) {
+	originCheckInterval = d
+}
+
+// SetOriginMissingChecks sets how many consecutive checks must miss an origin before its requests are removed
+func SetOriginMissingChecks(n int) {
+	originMissingChecks = n
+}
+
+var (
+	// DefaultTenantHeader is the header carrying the tenant of the polls of federated regions
+	DefaultTenantHeader = "X-Tenant-Id"
+	// TenantTokenHeader carries the token authenticating the tenant of the polls of federated regions
+	TenantTokenHeader = "X-Tenant-Token"
+	// DefaultFederationInterval is how often the clusters of federated regions are polled
+	DefaultFederationInterval = 5 * time.Second
+	// DefaultFederationMaxStaleness is how long the clusters of a federated region are served without a successful poll
+	DefaultFederationMaxStaleness = time.Minute
+)
+
+var (
+	federationRemotes      string
+	federationInterval     = DefaultFederationInterval
+	federationMaxStaleness = DefaultFederationMaxStaleness
+	tenantHeader           = DefaultTenantHeader
+	tenantTokens           map[string]string
+)
+
+// SetFederationRemotes sets the comma separated region=url instances whose clusters are served under the region prefix
+func SetFederationRemotes(remotes string) {
+	federationRemotes = remotes
+}
+
+// SetFederationInterval sets how often the clusters of federated regions are polled
+func SetFederationInterval(d time.Duration) {
+	federationInterval = d
+}
+
+// SetFederationMaxStaleness sets how long the clusters of a federated region are served without a successful poll, 0 serves them forever
+func SetFederationMaxStaleness(d time.Duration) {
+	federationMaxStaleness = d
+}
+
+// SetTenantHeader sets the header carrying the tenant of the polls of federated regions, empty keeps the default
+func SetTenantHeader(header string) {
+	if header != "" {
+		tenantHeader = header
+	}
+}
+
+// SetTenantTokens sets the tokens authenticating the tenants of the polls of federated regions, keyed by tenant
+func SetTenantTokens(tokens map[string]string) {
+	tenantTokens = tokens
+}
@@ -0,0 +1,370 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package load
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+
+	"github.com/aigw-project/metadata-center/pkg/prom"
+	"github.com/aigw-project/metadata-center/pkg/utils/logger"
+)
+
+// Operations of the consensus log
+const (
+	raftReserve = "reserve"
+	raftRelease = "release"
+	raftExpire  = "expire"
+)
+
+// raftCommand is an entry of the consensus log
+// Time is the clock of the leader that proposed it, so every instance applies the same decision
+type raftCommand struct {
+	Op          string              `json:"op"`
+	Time        int64               `json:"time"`
+	Reservation *ReservationRequest `json:"reservation,omitempty"`
+	RequestId   string              `json:"request_id,omitempty"`
+}
+
+// NotLeaderError is returned by consensus operations on a follower
+// Leader is the server ID of the current leader, its HTTP address, empty while there is none
+type NotLeaderError struct {
+	Leader string
+}
+
+func (e *NotLeaderError) Error() string {
+	if e.Leader == "" {
+		return "no consensus leader elected"
+	}
+	return fmt.Sprintf("not the consensus leader, the leader is %s", e.Leader)
+}
+
+// raftFSM applies committed reservation commands to the reservation table of this instance
+// The requests of the reservations are added and deleted outside the log, like any other request
+type raftFSM struct {
+	table *reservationTable
+}
+
+func (f *raftFSM) Apply(l *raft.Log) any {
+	var cmd raftCommand
+	if err := json.Unmarshal(l.Data, &cmd); err != nil {
+		logger.Errorf("failed to decode consensus log entry %d: %v", l.Index, err)
+		return err
+	}
+	switch {
+	case cmd.Op == raftReserve && cmd.Reservation != nil:
+		return f.table.reserve(cmd.Reservation, cmd.Time, false)
+	case cmd.Op == raftRelease:
+		return f.table.release(cmd.RequestId)
+	case cmd.Op == raftExpire:
+		return len(f.table.expire(time.Unix(0, cmd.Time).Add(-requestExpireDuration).UnixNano()))
+	}
+	return fmt.Errorf("unknown consensus operation %s", cmd.Op)
+}
+
+func (f *raftFSM) Snapshot() (raft.FSMSnapshot, error) {
+	return &raftSnapshot{reservations: f.table.list()}, nil
+}
+
+// Restore replaces the reservations with those of a snapshot
+func (f *raftFSM) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+	var reservations []*reservation
+	if err := json.NewDecoder(rc).Decode(&reservations); err != nil {
+		return fmt.Errorf("failed to decode consensus snapshot: %w", err)
+	}
+	f.table.replace(reservations)
+	return nil
+}
+
+// raftSnapshot holds the reservations at the time of the snapshot, they are never modified in place
+type raftSnapshot struct {
+	reservations []*reservation
+}
+
+func (s *raftSnapshot) Persist(sink raft.SnapshotSink) error {
+	if err := json.NewEncoder(sink).Encode(s.reservations); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s *raftSnapshot) Release() {}
+
+// raftNode decides reservations through a leader-committed log, so all instances agree on every decision
+// Only the leader proposes, followers return a NotLeaderError naming the leader to forward to
+type raftNode struct {
+	raft *raft.Raft
+	ls   *LoadStats
+	// releases queues the reservations of requests removed on the leader, they are released through the log
+	releases chan string
+	done     chan struct{}
+}
+
+// raftReleaseQueueSize bounds the releases waiting to be proposed, further ones are left to expiry
+const raftReleaseQueueSize = 1024
+
+// newRaftNode starts a consensus node applying its log to ls, conf holds the ID of the node
+func newRaftNode(conf *raft.Config, ls *LoadStats, logs raft.LogStore, stable raft.StableStore, snaps raft.SnapshotStore, trans raft.Transport) (*raftNode, error) {
+	if conf.Logger == nil {
+		conf.Logger = hclog.New(&hclog.LoggerOptions{
+			Name:   "raft",
+			Level:  hclog.Warn,
+			Output: logger.StandardLogger().WriterLevel(logger.WarnLevel),
+		})
+	}
+	notify := make(chan bool, 1)
+	conf.NotifyCh = notify
+	r, err := raft.NewRaft(conf, &raftFSM{table: &ls.reservations}, logs, stable, snaps, trans)
+	if err != nil {
+		return nil, err
+	}
+	n := &raftNode{raft: r, ls: ls, releases: make(chan string, raftReleaseQueueSize), done: make(chan struct{})}
+	ls.consensus.Store(n)
+	go n.watchLeadership(notify)
+	go n.proposeReleases()
+	return n, nil
+}
+
+// watchLeadership exports the leadership of this node
+func (n *raftNode) watchLeadership(notify <-chan bool) {
+	for {
+		select {
+		case leader := <-notify:
+			if leader {
+				prom.RaftLeader.Set(1)
+				logger.Infof("became the consensus leader")
+			} else {
+				prom.RaftLeader.Set(0)
+				logger.Infof("lost the consensus leadership")
+			}
+		case <-n.done:
+			return
+		}
+	}
+}
+
+// bootstrap forms the cluster from servers, a node with existing state keeps its configuration
+func (n *raftNode) bootstrap(servers []raft.Server) error {
+	err := n.raft.BootstrapCluster(raft.Configuration{Servers: servers}).Error()
+	if err != nil && !errors.Is(err, raft.ErrCantBootstrap) {
+		return err
+	}
+	return nil
+}
+
+// apply commits a command on the leader and returns the result of applying it
+func (n *raftNode) apply(cmd *raftCommand) (any, error) {
+	if n.raft.State() != raft.Leader {
+		return nil, n.notLeader()
+	}
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return nil, err
+	}
+	f := n.raft.Apply(data, raftApplyTimeout)
+	if err := f.Error(); err != nil {
+		if errors.Is(err, raft.ErrNotLeader) || errors.Is(err, raft.ErrLeadershipLost) {
+			return nil, n.notLeader()
+		}
+		return nil, err
+	}
+	if err, ok := f.Response().(error); ok {
+		return nil, err
+	}
+	return f.Response(), nil
+}
+
+// notLeader returns the error for operations on a follower
+func (n *raftNode) notLeader() error {
+	_, id := n.raft.LeaderWithID()
+	return &NotLeaderError{Leader: string(id)}
+}
+
+// reserve commits a reservation and adds its request on the leader, the API replicates the request to the others
+// A request deleted before its reservation was committed cancels out against its tombstone and releases the reservation
+func (n *raftNode) reserve(req *ReservationRequest) error {
+	ret, err := n.apply(&raftCommand{Op: raftReserve, Time: time.Now().UnixNano(), Reservation: req})
+	if err != nil {
+		return err
+	}
+	outcome, _ := ret.(reserveOutcome)
+	if err := outcome.err(req); err != nil {
+		return rejectReservation(err)
+	}
+	n.ls.AddRequest(&req.InferenceRequest)
+	if !n.ls.isTracked(&req.InferenceRequest) {
+		n.releaseLater(req.RequestId)
+	}
+	prom.ReservationTotal.WithLabelValues(reservationAccepted).Inc()
+	return nil
+}
+
+// release commits the release of a reservation and deletes its request on the leader
+func (n *raftNode) release(req *DeletionInferenceRequest) error {
+	ret, err := n.apply(&raftCommand{Op: raftRelease, Time: time.Now().UnixNano(), RequestId: req.RequestId})
+	if err != nil {
+		return err
+	}
+	if released, _ := ret.(bool); !released {
+		return errNotReserved(req.RequestId)
+	}
+	n.ls.deleteTracked(req.RequestId)
+	return nil
+}
+
+// releaseLater queues the release of the reservation of a request removed on this node
+// Only the leader proposes, every instance removes the request, and a full queue leaves the reservation to expiry
+func (n *raftNode) releaseLater(requestID string) {
+	if n.raft.State() != raft.Leader || !n.ls.reservations.has(requestID) {
+		return
+	}
+	select {
+	case n.releases <- requestID:
+	default:
+		logger.Warnf("consensus release queue full, reservation %s is released on expiry", requestID)
+	}
+}
+
+// proposeReleases releases the queued reservations through the log
+func (n *raftNode) proposeReleases() {
+	for {
+		select {
+		case id := <-n.releases:
+			if _, err := n.apply(&raftCommand{Op: raftRelease, Time: time.Now().UnixNano(), RequestId: id}); err != nil {
+				logger.Warnf("failed to release reservation %s through consensus: %v", id, err)
+			}
+		case <-n.done:
+			return
+		}
+	}
+}
+
+// expire proposes the expiry of old reservations, only the leader does
+// Their requests are expired by the request expiry of every instance
+func (n *raftNode) expire(now time.Time) error {
+	if n.raft.State() != raft.Leader {
+		return nil
+	}
+	_, err := n.apply(&raftCommand{Op: raftExpire, Time: now.UnixNano()})
+	return err
+}
+
+func (n *raftNode) replicated() bool {
+	return true
+}
+
+// shutdown stops the node, its state stays in the log store
+func (n *raftNode) shutdown() error {
+	close(n.done)
+	return n.raft.Shutdown().Error()
+}
+
+// parseRaftPeers parses a comma separated list of id=address servers
+func parseRaftPeers(peers string) ([]raft.Server, error) {
+	var servers []raft.Server
+	for _, peer := range strings.Split(peers, ",") {
+		peer = strings.TrimSpace(peer)
+		if peer == "" {
+			continue
+		}
+		id, addr, ok := strings.Cut(peer, "=")
+		if !ok || id == "" || addr == "" {
+			return nil, fmt.Errorf("invalid raft peer %q, expected id=address", peer)
+		}
+		servers = append(servers, raft.Server{
+			Suffrage: raft.Voter,
+			ID:       raft.ServerID(id),
+			Address:  raft.ServerAddress(addr),
+		})
+	}
+	return servers, nil
+}
+
+// startRaftNode starts the consensus node of this instance from the raft settings
+// The log is kept in raftDir, or in memory when it is unset
+func startRaftNode(ls *LoadStats) (*raftNode, error) {
+	servers, err := parseRaftPeers(raftPeers)
+	if err != nil {
+		return nil, err
+	}
+	advertise, err := net.ResolveTCPAddr("tcp", raftAddr)
+	if err != nil {
+		return nil, err
+	}
+	trans, err := raft.NewTCPTransport(raftAddr, advertise, 3, 10*time.Second, logger.StandardLogger().WriterLevel(logger.WarnLevel))
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		logs   raft.LogStore
+		stable raft.StableStore
+		snaps  raft.SnapshotStore
+	)
+	if raftDir == "" {
+		store := raft.NewInmemStore()
+		logs, stable, snaps = store, store, raft.NewInmemSnapshotStore()
+	} else {
+		if err := os.MkdirAll(raftDir, 0o755); err != nil {
+			return nil, err
+		}
+		store, err := raftboltdb.NewBoltStore(filepath.Join(raftDir, "raft.db"))
+		if err != nil {
+			return nil, err
+		}
+		logs, stable = store, store
+		if snaps, err = raft.NewFileSnapshotStore(raftDir, 2, logger.StandardLogger().WriterLevel(logger.WarnLevel)); err != nil {
+			return nil, err
+		}
+	}
+
+	conf := raft.DefaultConfig()
+	conf.LocalID = raft.ServerID(raftID)
+	node, err := newRaftNode(conf, ls, logs, stable, snaps, trans)
+	if err != nil {
+		return nil, err
+	}
+	if len(servers) > 0 {
+		if err := node.bootstrap(servers); err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+// initRaft switches reservations to the consensus log
+// Returns an error when the node cannot start, deciding locally would let the instances disagree
+func initRaft() error {
+	node, err := startRaftNode(loadStats)
+	if err != nil {
+		return fmt.Errorf("failed to start consensus node %s at %s: %w", raftID, raftAddr, err)
+	}
+	reservations = node
+	logger.Infof("reservations are decided by consensus, node %s at %s, peers: %s", raftID, raftAddr, raftPeers)
+	return nil
+}
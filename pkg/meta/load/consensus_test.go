@@ -0,0 +1,302 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package load
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCluster is an in-process consensus cluster, each node with its own load statistics
+type testCluster struct {
+	nodes map[string]*raftNode
+	down  map[string]bool
+}
+
+func newTestCluster(t *testing.T, n int) *testCluster {
+	c := &testCluster{nodes: make(map[string]*raftNode), down: make(map[string]bool)}
+	transports := make([]*raft.InmemTransport, n)
+	servers := make([]raft.Server, n)
+	for i := range transports {
+		addr, trans := raft.NewInmemTransport("")
+		transports[i] = trans
+		servers[i] = raft.Server{Suffrage: raft.Voter, ID: raft.ServerID(fmt.Sprintf("node-%d", i)), Address: addr}
+	}
+	for _, a := range transports {
+		for _, b := range transports {
+			a.Connect(b.LocalAddr(), b)
+		}
+	}
+	for i, trans := range transports {
+		conf := raft.DefaultConfig()
+		conf.LocalID = servers[i].ID
+		conf.HeartbeatTimeout = 50 * time.Millisecond
+		conf.ElectionTimeout = 50 * time.Millisecond
+		conf.LeaderLeaseTimeout = 50 * time.Millisecond
+		conf.CommitTimeout = 5 * time.Millisecond
+		conf.Logger = hclog.NewNullLogger()
+		store := raft.NewInmemStore()
+		node, err := newRaftNode(conf, NewLoadStats(), store, store, raft.NewInmemSnapshotStore(), trans)
+		require.NoError(t, err)
+		c.nodes[string(conf.LocalID)] = node
+	}
+	require.NoError(t, c.nodes["node-0"].bootstrap(servers))
+	t.Cleanup(func() {
+		for id, node := range c.nodes {
+			if !c.down[id] {
+				node.shutdown()
+			}
+		}
+	})
+	return c
+}
+
+// leader waits for a leader among the running nodes
+func (c *testCluster) leader(t *testing.T) *raftNode {
+	var leader *raftNode
+	require.Eventually(t, func() bool {
+		for id, node := range c.nodes {
+			if !c.down[id] && node.raft.State() == raft.Leader {
+				leader = node
+				return true
+			}
+		}
+		return false
+	}, 5*time.Second, 10*time.Millisecond)
+	return leader
+}
+
+// reserve reserves through any node, following NotLeaderErrors like the API forwards them
+func (c *testCluster) reserve(node *raftNode, req *ReservationRequest) error {
+	for i := 0; i < 100; i++ {
+		err := node.reserve(req)
+		var notLeader *NotLeaderError
+		if !errors.As(err, &notLeader) {
+			return err
+		}
+		if notLeader.Leader != "" {
+			node = c.nodes[notLeader.Leader]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return errors.New("no leader")
+}
+
+// converged waits until every running node holds n reservations for the engine
+func (c *testCluster) converged(t *testing.T, cluster, engine string, n int32) {
+	require.Eventually(t, func() bool {
+		for id, node := range c.nodes {
+			if !c.down[id] && node.ls.reservations.count(cluster, engine) != n {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// follower returns a running node other than the leader
+func (c *testCluster) follower(leader *raftNode) *raftNode {
+	for id, node := range c.nodes {
+		if node != leader && !c.down[id] {
+			return node
+		}
+	}
+	return nil
+}
+
+// queued returns the queued request count of an engine on a node
+func queued(node *raftNode, cluster, engine string) int32 {
+	ms := node.ls.GetModelStats(cluster)
+	if ms == nil {
+		return 0
+	}
+	es, ok := ms.Load(engine)
+	if !ok {
+		return 0
+	}
+	return es.GetQueuedReqNum()
+}
+
+func TestRaftNode_Reserve(t *testing.T) {
+	c := newTestCluster(t, 3)
+	leader := c.leader(t)
+	cluster := "raft"
+	engine := "10.0.8.1"
+
+	follower := c.follower(leader)
+	// A follower names the leader once it heard from it
+	require.Eventually(t, func() bool {
+		_, id := follower.raft.LeaderWithID()
+		return id != ""
+	}, 5*time.Second, 10*time.Millisecond)
+	var notLeader *NotLeaderError
+	require.ErrorAs(t, follower.reserve(newReservationRequest(cluster, "follower", engine, 3)), &notLeader)
+	_, leaderID := leader.raft.LeaderWithID()
+	assert.Equal(t, string(leaderID), notLeader.Leader, "followers name the leader to forward to")
+
+	// Concurrent reservations through every node, only the limit is accepted
+	var wg sync.WaitGroup
+	var accepted atomic.Int32
+	ids := make([]string, 0, len(c.nodes))
+	for id := range c.nodes {
+		ids = append(ids, id)
+	}
+	for i := 0; i < 12; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			node := c.nodes[ids[i%len(ids)]]
+			if err := c.reserve(node, newReservationRequest(cluster, fmt.Sprintf("raft-%d", i), engine, 3)); err == nil {
+				accepted.Add(1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, int32(3), accepted.Load())
+	c.converged(t, cluster, engine, 3)
+	assert.Equal(t, int32(3), queued(leader, cluster, engine), "the leader adds the requests of its reservations")
+	assert.Equal(t, int32(0), queued(follower, cluster, engine), "the log carries reservations only, requests are replicated by the API")
+
+	var released string
+	for _, r := range leader.ls.reservations.list() {
+		released = r.Request.RequestId
+		break
+	}
+	require.NoError(t, leader.release(newDeletionInferenceRequest(released)))
+	assert.Error(t, leader.release(newDeletionInferenceRequest(released)))
+	c.converged(t, cluster, engine, 2)
+	assert.Equal(t, int32(2), queued(leader, cluster, engine))
+}
+
+func TestRaftNode_ReleaseUntracked(t *testing.T) {
+	c := newTestCluster(t, 3)
+	leader := c.leader(t)
+	follower := c.follower(leader)
+	cluster := "raft-untracked"
+	engine := "10.0.8.4"
+
+	reqs := make([]*ReservationRequest, 2)
+	for i := range reqs {
+		reqs[i] = newReservationRequest(cluster, fmt.Sprintf("untracked-%d", i), engine, 2)
+		require.NoError(t, c.reserve(leader, reqs[i]))
+		follower.ls.AddRequest(&reqs[i].InferenceRequest)
+	}
+	c.converged(t, cluster, engine, 2)
+
+	// A request removed on a follower leaves the reservation to the leader
+	follower.ls.DeleteRequest(newDeletionInferenceRequest(reqs[0].RequestId))
+	time.Sleep(100 * time.Millisecond)
+	c.converged(t, cluster, engine, 2)
+
+	// Removing it on the leader releases the reservation through the log
+	leader.ls.DeleteRequest(newDeletionInferenceRequest(reqs[0].RequestId))
+	c.converged(t, cluster, engine, 1)
+	assert.Equal(t, int32(1), queued(leader, cluster, engine))
+
+	// So does expiring it
+	leader.ls.expireRequests(time.Now().Add(requestExpireDuration + time.Second))
+	c.converged(t, cluster, engine, 0)
+}
+
+func TestRaftNode_Failover(t *testing.T) {
+	c := newTestCluster(t, 3)
+	leader := c.leader(t)
+	cluster := "raft-failover"
+	engine := "10.0.8.2"
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, c.reserve(leader, newReservationRequest(cluster, fmt.Sprintf("failover-%d", i), engine, 2)))
+	}
+	c.converged(t, cluster, engine, 2)
+
+	_, id := leader.raft.LeaderWithID()
+	require.NoError(t, leader.shutdown())
+	c.down[string(id)] = true
+	next := c.leader(t)
+	assert.NotEqual(t, leader, next)
+
+	// The new leader holds the committed reservations
+	assert.Error(t, c.reserve(next, newReservationRequest(cluster, "failover-2", engine, 2)))
+	require.NoError(t, next.release(newDeletionInferenceRequest("failover-0")))
+	require.NoError(t, c.reserve(next, newReservationRequest(cluster, "failover-2", engine, 2)))
+	c.converged(t, cluster, engine, 2)
+
+	// Expiry is decided by the leader clock and applied everywhere
+	require.NoError(t, next.expire(time.Now().Add(requestExpireDuration+time.Second)))
+	c.converged(t, cluster, engine, 0)
+}
+
+// snapshotSink collects a persisted snapshot
+type snapshotSink struct {
+	bytes.Buffer
+}
+
+func (s *snapshotSink) ID() string    { return "test" }
+func (s *snapshotSink) Cancel() error { return nil }
+func (s *snapshotSink) Close() error  { return nil }
+
+func TestRaftFSM_Snapshot(t *testing.T) {
+	cluster := "raft-snapshot"
+	engine := "10.0.8.3"
+	src := &raftFSM{table: &reservationTable{}}
+	for i := 0; i < 2; i++ {
+		require.Equal(t, reserveAccepted, src.table.reserve(newReservationRequest(cluster, fmt.Sprintf("snap-%d", i), engine, 2), time.Now().UnixNano(), false))
+	}
+	snapshot, err := src.Snapshot()
+	require.NoError(t, err)
+	sink := &snapshotSink{}
+	require.NoError(t, snapshot.Persist(sink))
+
+	dst := &raftFSM{table: &reservationTable{}}
+	require.Equal(t, reserveAccepted, dst.table.reserve(newReservationRequest(cluster, "stale", engine, 2), time.Now().UnixNano(), false))
+	require.Equal(t, reserveAccepted, dst.table.reserve(newReservationRequest(cluster, "snap-0", engine, 2), time.Now().UnixNano(), false))
+	require.NoError(t, dst.Restore(io.NopCloser(&sink.Buffer)))
+	assert.Equal(t, int32(2), dst.table.count(cluster, engine))
+	assert.False(t, dst.table.has("stale"), "reservations missing from the snapshot are dropped")
+	assert.True(t, dst.table.has("snap-1"))
+}
+
+func TestInitRaft_Failure(t *testing.T) {
+	SetRaftID("127.0.0.1:8080")
+	SetRaftAddr("invalid address")
+	defer func() {
+		SetRaftID("")
+		SetRaftAddr("")
+		Init()
+	}()
+	assert.ErrorContains(t, Init(), "failed to start consensus node", "a node that cannot start fails Init")
+}
+
+func TestParseRaftPeers(t *testing.T) {
+	servers, err := parseRaftPeers("10.0.0.1:8080=10.0.0.1:7000, 10.0.0.2:8080=10.0.0.2:7000,")
+	require.NoError(t, err)
+	require.Len(t, servers, 2)
+	assert.Equal(t, raft.ServerID("10.0.0.2:8080"), servers[1].ID)
+	assert.Equal(t, raft.ServerAddress("10.0.0.2:7000"), servers[1].Address)
+
+	_, err = parseRaftPeers("10.0.0.1:7000")
+	assert.Error(t, err)
+}
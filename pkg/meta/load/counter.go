@@ -0,0 +1,130 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package load
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// LoadCounter holds the queued request and prompt length counters of one slice of engine load
+type LoadCounter struct {
+	QueuedReqNum int32 `json:"queued_req_num"`
+	PromptLength int32 `json:"prompt_length"`
+}
+
+// add atomically adds the deltas to the counter
+// Returns true when a negative delta was clamped to keep the counter at 0
+func (c *LoadCounter) add(queuedReqNum, promptLength int32) bool {
+	clamped := false
+	if queuedReqNum != 0 {
+		clamped = addNonNegative(&c.QueuedReqNum, queuedReqNum) || clamped
+	}
+	if promptLength != 0 {
+		clamped = addNonNegative(&c.PromptLength, promptLength) || clamped
+	}
+	return clamped
+}
+
+// addNonNegative atomically adds delta and clamps the result at 0 when a decrement overshoots
+// Returns true when the counter went negative, which means a decrement had no matching increment
+func addNonNegative(addr *int32, delta int32) bool {
+	v := atomic.AddInt32(addr, delta)
+	if v >= 0 || delta >= 0 {
+		return false
+	}
+	// CAS instead of Store so a concurrent increment is not overwritten
+	for v < 0 && !atomic.CompareAndSwapInt32(addr, v, 0) {
+		v = atomic.LoadInt32(addr)
+	}
+	return true
+}
+
+// snapshot returns a copy of the counter with atomic reads
+func (c *LoadCounter) snapshot() *LoadCounter {
+	return &LoadCounter{
+		QueuedReqNum: atomic.LoadInt32(&c.QueuedReqNum),
+		PromptLength: atomic.LoadInt32(&c.PromptLength),
+	}
+}
+
+// counterSet holds LoadCounters keyed by a dimension value, such as the model name
+type counterSet struct {
+	counters sync.Map
+}
+
+// add adds the deltas to the counter of the given key, creating it if needed
+// Empty keys are ignored so requests without the dimension only update engine totals
+// The returned flag reports whether a negative delta was clamped
+func (cs *counterSet) add(key string, queuedReqNum, promptLength int32) (*LoadCounter, bool) {
+	if key == "" {
+		return nil, false
+	}
+	v, ok := cs.counters.Load(key)
+	if !ok {
+		v, _ = cs.counters.LoadOrStore(key, &LoadCounter{})
+	}
+	c := v.(*LoadCounter)
+	clamped := c.add(queuedReqNum, promptLength)
+	return c, clamped
+}
+
+// reset replaces the counters with the given values, counters of other keys are removed
+func (cs *counterSet) reset(values map[string]*LoadCounter) {
+	cs.counters.Range(func(key, _ any) bool {
+		if _, ok := values[key.(string)]; !ok {
+			cs.counters.Delete(key)
+		}
+		return true
+	})
+	for key, value := range values {
+		if c, _ := cs.add(key, 0, 0); c != nil {
+			atomic.StoreInt32(&c.QueuedReqNum, value.QueuedReqNum)
+			atomic.StoreInt32(&c.PromptLength, value.PromptLength)
+		}
+	}
+}
+
+// load returns the counter of the given key
+func (cs *counterSet) load(key string) (*LoadCounter, bool) {
+	v, ok := cs.counters.Load(key)
+	if !ok {
+		return nil, false
+	}
+	return v.(*LoadCounter), true
+}
+
+// size returns the number of counters
+func (cs *counterSet) size() int {
+	n := 0
+	cs.counters.Range(func(_, _ any) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// snapshot returns copies of all counters, nil if there are none
+func (cs *counterSet) snapshot() map[string]*LoadCounter {
+	var ret map[string]*LoadCounter
+	cs.counters.Range(func(key, value any) bool {
+		if ret == nil {
+			ret = make(map[string]*LoadCounter)
+		}
+		ret[key.(string)] = value.(*LoadCounter).snapshot()
+		return true
+	})
+	return ret
+}
@@ -0,0 +1,426 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package load
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aigw-project/metadata-center/pkg/prom"
+	"github.com/aigw-project/metadata-center/pkg/replicator"
+	"github.com/aigw-project/metadata-center/pkg/utils/logger"
+)
+
+// Results of merging a counter partition, the result label of load_counter_merges_total
+const (
+	counterMergeApplied = "applied"
+	counterMergeStale   = "stale"
+)
+
+// PNCounterSlot is the partition of the counters of an engine owned by one origin instance
+// Every component only grows, the counters are the increments minus the decrements, so merging takes the maximum
+// of each component and is commutative and idempotent. A restarted origin starts a new incarnation, which replaces the old one
+type PNCounterSlot struct {
+	Incarnation int64 `json:"incarnation"`
+	QueuedInc   int64 `json:"queued_inc"`
+	QueuedDec   int64 `json:"queued_dec"`
+	PromptInc   int64 `json:"prompt_inc"`
+	PromptDec   int64 `json:"prompt_dec"`
+}
+
+// add applies a change of the queued request count and the prompt length to the slot
+func (s *PNCounterSlot) add(queuedReqNum, promptLength int32) {
+	if queuedReqNum > 0 {
+		s.QueuedInc += int64(queuedReqNum)
+	} else {
+		s.QueuedDec -= int64(queuedReqNum)
+	}
+	if promptLength > 0 {
+		s.PromptInc += int64(promptLength)
+	} else {
+		s.PromptDec -= int64(promptLength)
+	}
+}
+
+// set grows the components until the slot holds the given counters
+func (s *PNCounterSlot) set(queuedReqNum, promptLength int64) {
+	if d := queuedReqNum - (s.QueuedInc - s.QueuedDec); d > 0 {
+		s.QueuedInc += d
+	} else {
+		s.QueuedDec -= d
+	}
+	if d := promptLength - (s.PromptInc - s.PromptDec); d > 0 {
+		s.PromptInc += d
+	} else {
+		s.PromptDec -= d
+	}
+}
+
+// merge merges another state of the slot, returns false if it brings nothing new
+func (s *PNCounterSlot) merge(o *PNCounterSlot) bool {
+	if o.Incarnation != s.Incarnation {
+		if o.Incarnation < s.Incarnation {
+			return false
+		}
+		*s = *o
+		return true
+	}
+	changed := false
+	for _, c := range []struct{ dst, src *int64 }{
+		{&s.QueuedInc, &o.QueuedInc},
+		{&s.QueuedDec, &o.QueuedDec},
+		{&s.PromptInc, &o.PromptInc},
+		{&s.PromptDec, &o.PromptDec},
+	} {
+		if *c.src > *c.dst {
+			*c.dst = *c.src
+			changed = true
+		}
+	}
+	return changed
+}
+
+// EngineCounterState is the partition of the counters of an engine owned by the sending origin
+type EngineCounterState struct {
+	Cluster string        `json:"cluster"`
+	Engine  string        `json:"engine"`
+	Slot    PNCounterSlot `json:"slot"`
+}
+
+// CounterState holds counter partitions owned by Origin, a delta of the changed engines or all of them for anti-entropy
+type CounterState struct {
+	Origin  string                `json:"origin"`
+	Engines []*EngineCounterState `json:"engines"`
+}
+
+// engineRef names an engine of a cluster
+type engineRef struct {
+	cluster string
+	engine  string
+}
+
+// counterOwner is the origin identity of an instance in crdt mode
+// It records the engines whose owned partition changed since the last push
+// It also indexes the engines holding a partition of each other origin, for failover
+type counterOwner struct {
+	origin      string
+	incarnation int64
+	mu          sync.Mutex
+	dirty       map[engineRef]struct{}
+	partitions  map[string]map[engineRef]struct{}
+}
+
+func newCounterOwner(origin string) *counterOwner {
+	return &counterOwner{
+		origin:      origin,
+		incarnation: time.Now().UnixNano(),
+		dirty:       make(map[engineRef]struct{}),
+		partitions:  make(map[string]map[engineRef]struct{}),
+	}
+}
+
+// addPartition records an engine holding a partition merged from another origin
+func (o *counterOwner) addPartition(origin, cluster, engine string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	refs, ok := o.partitions[origin]
+	if !ok {
+		refs = make(map[engineRef]struct{})
+		o.partitions[origin] = refs
+	}
+	refs[engineRef{cluster: cluster, engine: engine}] = struct{}{}
+}
+
+// partitionOrigins returns the other origins holding a partition
+func (o *counterOwner) partitionOrigins() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	ret := make([]string, 0, len(o.partitions))
+	for origin := range o.partitions {
+		ret = append(ret, origin)
+	}
+	return ret
+}
+
+// takePartitions returns and forgets the engines holding a partition of an origin
+func (o *counterOwner) takePartitions(origin string) map[engineRef]struct{} {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	refs := o.partitions[origin]
+	delete(o.partitions, origin)
+	return refs
+}
+
+func (o *counterOwner) markDirty(cluster, engine string) {
+	o.mu.Lock()
+	o.dirty[engineRef{cluster: cluster, engine: engine}] = struct{}{}
+	o.mu.Unlock()
+}
+
+// takeDirty returns the engines changed since the last call
+func (o *counterOwner) takeDirty() map[engineRef]struct{} {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	dirty := o.dirty
+	o.dirty = make(map[engineRef]struct{})
+	return dirty
+}
+
+// pnCounter holds the partitions of the counters of an engine, keyed by origin
+// The engine counters are the sum of the partitions. Requests without origin, sent by instances that do not
+// record one, are counted in a local partition under the empty key that is neither pushed nor merged
+type pnCounter struct {
+	owner   *counterOwner
+	cluster string
+	mu      sync.Mutex
+	slots   map[string]*PNCounterSlot
+}
+
+func newPNCounter(owner *counterOwner, cluster string) *pnCounter {
+	return &pnCounter{owner: owner, cluster: cluster, slots: make(map[string]*PNCounterSlot)}
+}
+
+// add counts a change of the counters of a request in its origin's partition, only on the origin itself
+// The other instances receive the change with the partition of the origin
+func (p *pnCounter) add(e *EngineStats, req *InferenceRequest, queuedReqNum, promptLength int32) {
+	if req.Origin != "" && req.Origin != p.owner.origin {
+		return
+	}
+	p.mu.Lock()
+	slot, ok := p.slots[req.Origin]
+	if !ok {
+		slot = &PNCounterSlot{}
+		if req.Origin != "" {
+			slot.Incarnation = p.owner.incarnation
+		}
+		p.slots[req.Origin] = slot
+	}
+	slot.add(queuedReqNum, promptLength)
+	p.storeLocked(e)
+	p.mu.Unlock()
+	if req.Origin != "" {
+		p.owner.markDirty(p.cluster, e.Key())
+	}
+}
+
+// merge merges the partition of another origin, returns false if it brings nothing new
+func (p *pnCounter) merge(e *EngineStats, origin string, slot *PNCounterSlot) bool {
+	if origin == "" || origin == p.owner.origin {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	current, ok := p.slots[origin]
+	if !ok {
+		current = &PNCounterSlot{}
+		p.slots[origin] = current
+		p.owner.addPartition(origin, p.cluster, e.Key())
+	}
+	if !current.merge(slot) && ok {
+		return false
+	}
+	p.storeLocked(e)
+	return true
+}
+
+// drop removes the partition of an origin, returns false if there is none
+func (p *pnCounter) drop(e *EngineStats, origin string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.slots[origin]; !ok {
+		return false
+	}
+	delete(p.slots, origin)
+	p.storeLocked(e)
+	return true
+}
+
+// origins returns the origins holding a partition
+func (p *pnCounter) origins() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ret := make([]string, 0, len(p.slots))
+	for o := range p.slots {
+		ret = append(ret, o)
+	}
+	return ret
+}
+
+// owned returns a copy of the partition owned by this instance
+func (p *pnCounter) owned() (PNCounterSlot, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	slot, ok := p.slots[p.owner.origin]
+	if !ok {
+		return PNCounterSlot{}, false
+	}
+	return *slot, true
+}
+
+// local returns the counters of the partitions this instance counts, its own and the one of requests without origin
+func (p *pnCounter) local() *LoadCounter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var queuedReqNum, promptLength int64
+	for _, origin := range []string{p.owner.origin, ""} {
+		if slot, ok := p.slots[origin]; ok {
+			queuedReqNum += slot.QueuedInc - slot.QueuedDec
+			promptLength += slot.PromptInc - slot.PromptDec
+		}
+	}
+	return &LoadCounter{QueuedReqNum: int32(queuedReqNum), PromptLength: int32(promptLength)}
+}
+
+// resetLocal sets the partitions this instance counts to the counters of the requests of their origin
+// The partitions of the other origins are left to their owners, the owned one reaches them with the next push
+func (p *pnCounter) resetLocal(e *EngineStats, origins map[string]*LoadCounter) {
+	p.mu.Lock()
+	for _, origin := range []string{p.owner.origin, ""} {
+		c, ok := origins[origin]
+		if !ok {
+			c = &LoadCounter{}
+		}
+		slot, ok := p.slots[origin]
+		if !ok {
+			slot = &PNCounterSlot{}
+			if origin != "" {
+				slot.Incarnation = p.owner.incarnation
+			}
+			p.slots[origin] = slot
+		}
+		slot.set(int64(c.QueuedReqNum), int64(c.PromptLength))
+	}
+	p.storeLocked(e)
+	p.mu.Unlock()
+	p.owner.markDirty(p.cluster, e.Key())
+}
+
+// storeLocked sets the engine counters to the sum of the partitions, must be called with mu held
+func (p *pnCounter) storeLocked(e *EngineStats) {
+	var queuedReqNum, promptLength int64
+	for _, slot := range p.slots {
+		queuedReqNum += slot.QueuedInc - slot.QueuedDec
+		promptLength += slot.PromptInc - slot.PromptDec
+	}
+	atomic.StoreInt32(&e.QueuedReqNum, int32(max(queuedReqNum, 0)))
+	atomic.StoreInt32(&e.PromptLength, int32(max(promptLength, 0)))
+}
+
+// enableCounterPartitions switches the engine counters to per-origin partitions owned by origin
+// Must be called before any engine is added
+func (ls *LoadStats) enableCounterPartitions(origin string) {
+	ls.owner = newCounterOwner(origin)
+}
+
+// MergeCounters merges the counter partitions of another instance
+// Engines are created for partitions holding load, other partitions of unknown engines are skipped
+func (ls *LoadStats) MergeCounters(state *CounterState) {
+	if ls.owner == nil || state.Origin == ls.owner.origin {
+		return
+	}
+	for _, s := range state.Engines {
+		var engineStats *EngineStats
+		if modelStats := ls.GetModelStats(s.Cluster); modelStats != nil {
+			engineStats, _ = modelStats.Load(s.Engine)
+		}
+		if engineStats == nil {
+			if s.Slot.QueuedInc == s.Slot.QueuedDec && s.Slot.PromptInc == s.Slot.PromptDec {
+				continue
+			}
+			engineStats = ls.loadOrStoreEngine(s.Cluster, s.Engine)
+		}
+		if engineStats.counters.merge(engineStats, state.Origin, &s.Slot) {
+			engineStats.touch()
+			prom.SetLoadMetric(s.Cluster, engineStats.Key(), engineStats.GetQueuedReqNum(), engineStats.GetPromptLength())
+			prom.CounterMergeTotal.WithLabelValues(counterMergeApplied).Inc()
+		} else {
+			prom.CounterMergeTotal.WithLabelValues(counterMergeStale).Inc()
+		}
+	}
+}
+
+// ownedCounters returns the partitions owned by this instance, of the given engines or of all engines when nil
+func (ls *LoadStats) ownedCounters(engines map[engineRef]struct{}) *CounterState {
+	state := &CounterState{Origin: ls.owner.origin}
+	add := func(cluster string, engineStats *EngineStats) {
+		if slot, ok := engineStats.counters.owned(); ok {
+			state.Engines = append(state.Engines, &EngineCounterState{Cluster: cluster, Engine: engineStats.Key(), Slot: slot})
+		}
+	}
+	if engines != nil {
+		for ref := range engines {
+			if modelStats := ls.GetModelStats(ref.cluster); modelStats != nil {
+				if engineStats, ok := modelStats.Load(ref.engine); ok {
+					add(ref.cluster, engineStats)
+				}
+			}
+		}
+		return state
+	}
+	ls.rangeEngines(add)
+	return state
+}
+
+// pushCounters sends owned counter partitions to the other instances
+var pushCounters = func(state *CounterState) {
+	replicator.Replicate(context.Background(), LoadCounterMerge, state)
+}
+
+// cronPushCounters pushes the changed partitions at every sync tick and all partitions at every anti-entropy tick
+// A nil anti-entropy ticker disables the full pushes
+func cronPushCounters(ticker, antiEntropy *time.Ticker, ls *LoadStats) {
+	defer func() {
+		if p := recover(); p != nil {
+			logger.Errorf("counter push goroutine panicked: %v", p)
+		}
+		ticker.Stop()
+		if antiEntropy != nil {
+			antiEntropy.Stop()
+		}
+		logger.Errorf("counter push goroutine exited")
+	}()
+
+	var full <-chan time.Time
+	if antiEntropy != nil {
+		full = antiEntropy.C
+	}
+	for {
+		select {
+		case <-ticker.C:
+			if dirty := ls.owner.takeDirty(); len(dirty) > 0 {
+				pushCounters(ls.ownedCounters(dirty))
+			}
+		case <-full:
+			// The changes are part of the full state
+			ls.owner.takeDirty()
+			state := ls.ownedCounters(nil)
+			pushCounters(state)
+			logger.Debugf("pushed %d counter partitions of origin %s for anti-entropy", len(state.Engines), state.Origin)
+		}
+	}
+}
+
+// initCounterPartitions starts pushing the owned counter partitions in crdt mode
+func initCounterPartitions() {
+	var antiEntropy *time.Ticker
+	if antiEntropyInterval > 0 {
+		antiEntropy = time.NewTicker(antiEntropyInterval)
+	}
+	go cronPushCounters(time.NewTicker(counterSyncInterval), antiEntropy, loadStats)
+	logger.Infof("engine counters are replicated as partitions of origin %s, sync interval: %s, anti-entropy interval: %s",
+		loadStats.owner.origin, counterSyncInterval, antiEntropyInterval)
+}
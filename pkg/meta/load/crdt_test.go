@@ -0,0 +1,189 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package load
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPNCounterSlot_Merge(t *testing.T) {
+	a := PNCounterSlot{Incarnation: 1, QueuedInc: 3, QueuedDec: 1, PromptInc: 30}
+	b := PNCounterSlot{Incarnation: 1, QueuedInc: 2, QueuedDec: 2, PromptDec: 10}
+
+	ab, ba := a, b
+	assert.True(t, ab.merge(&b))
+	assert.True(t, ba.merge(&a))
+	assert.Equal(t, ab, ba, "merging is commutative")
+	assert.False(t, ab.merge(&b), "merging is idempotent")
+	assert.Equal(t, PNCounterSlot{Incarnation: 1, QueuedInc: 3, QueuedDec: 2, PromptInc: 30, PromptDec: 10}, ab)
+
+	restarted := PNCounterSlot{Incarnation: 2, QueuedInc: 1}
+	assert.True(t, ab.merge(&restarted), "a new incarnation replaces the old one")
+	assert.Equal(t, restarted, ab)
+	assert.False(t, ab.merge(&a), "an old incarnation is ignored")
+}
+
+// newPartitionedStats creates the statistics of an instance in crdt mode
+func newPartitionedStats(origin string) *LoadStats {
+	ls := NewLoadStats()
+	ls.enableCounterPartitions(origin)
+	return ls
+}
+
+func TestLoadStats_MergeCounters(t *testing.T) {
+	cluster := "crdt"
+	ip := "10.0.9.1"
+	nodes := map[string]*LoadStats{"a": newPartitionedStats("a"), "b": newPartitionedStats("b"), "c": newPartitionedStats("c")}
+
+	// Each instance accepts its own requests, the others receive the adds in different orders
+	var ops []func(ls *LoadStats)
+	for _, origin := range []string{"a", "b"} {
+		for i := 0; i < 3; i++ {
+			req := &InferenceRequest{Cluster: cluster, RequestId: fmt.Sprintf("%s-%d", origin, i), PromptLength: 10, Ip: ip, Origin: origin}
+			ops = append(ops, func(ls *LoadStats) {
+				r := *req
+				ls.AddRequest(&r)
+			})
+		}
+	}
+	for _, op := range ops {
+		op(nodes["a"])
+		op(nodes["b"])
+	}
+	for i := len(ops) - 1; i >= 0; i-- {
+		ops[i](nodes["c"])
+	}
+	// Deletes are applied by every instance, only the origin of a request counts them
+	nodes["b"].DeletePromptLength(&DeletionInferenceRequest{RequestId: "a-1"})
+	nodes["b"].DeleteRequest(&DeletionInferenceRequest{RequestId: "a-0"})
+	nodes["a"].DeletePromptLength(&DeletionInferenceRequest{RequestId: "a-1"})
+	nodes["a"].DeleteRequest(&DeletionInferenceRequest{RequestId: "a-0"})
+	nodes["b"].DeleteRequest(&DeletionInferenceRequest{RequestId: "b-2"})
+	nodes["c"].DeleteRequest(&DeletionInferenceRequest{RequestId: "b-2"})
+
+	es, ok := nodes["b"].GetModelStats(cluster).Load(ip)
+	require.True(t, ok)
+	assert.Equal(t, int32(2), es.GetQueuedReqNum(), "only the partition of the instance itself is counted before merging")
+
+	// Anti-entropy in any order converges, pushing twice changes nothing
+	states := map[string]*CounterState{}
+	for origin, ls := range nodes {
+		states[origin] = ls.ownedCounters(nil)
+	}
+	for _, ls := range nodes {
+		for _, origin := range []string{"c", "b", "a", "b"} {
+			ls.MergeCounters(states[origin])
+		}
+	}
+	for origin, ls := range nodes {
+		es, ok := ls.GetModelStats(cluster).Load(ip)
+		require.True(t, ok, origin)
+		assert.Equal(t, int32(4), es.GetQueuedReqNum(), origin)
+		assert.Equal(t, int32(30), es.GetPromptLength(), origin)
+	}
+}
+
+func TestLoadStats_OwnedCounters(t *testing.T) {
+	ls := newPartitionedStats("a")
+	ls.AddRequest(&InferenceRequest{Cluster: "crdt-owned", RequestId: "own", PromptLength: 10, Ip: "10.0.9.2", Origin: "a"})
+	ls.AddRequest(&InferenceRequest{Cluster: "crdt-owned", RequestId: "other", PromptLength: 10, Ip: "10.0.9.3", Origin: "b"})
+	ls.AddRequest(&InferenceRequest{Cluster: "crdt-owned", RequestId: "legacy", PromptLength: 10, Ip: "10.0.9.4"})
+
+	dirty := ls.owner.takeDirty()
+	assert.Len(t, dirty, 1, "only changes of the owned partition are pushed")
+	state := ls.ownedCounters(dirty)
+	require.Len(t, state.Engines, 1)
+	assert.Equal(t, "10.0.9.2", state.Engines[0].Engine)
+	assert.Equal(t, int64(1), state.Engines[0].Slot.QueuedInc)
+	assert.Empty(t, ls.owner.takeDirty())
+
+	es, ok := ls.GetModelStats("crdt-owned").Load("10.0.9.4")
+	require.True(t, ok)
+	assert.Equal(t, int32(1), es.GetQueuedReqNum(), "requests without origin are counted locally")
+
+	// Partitions without load do not create engines
+	ls.MergeCounters(&CounterState{Origin: "b", Engines: []*EngineCounterState{
+		{Cluster: "crdt-owned", Engine: "10.0.9.5", Slot: PNCounterSlot{Incarnation: 1, QueuedInc: 1, QueuedDec: 1}},
+	}})
+	_, ok = ls.GetModelStats("crdt-owned").Load("10.0.9.5")
+	assert.False(t, ok)
+}
+
+func TestLoadStats_PartitionCorrections(t *testing.T) {
+	ip := "10.0.9.6"
+	// setup adds 3 requests owned by a, 2 by b and 1 without origin on both instances, b has pushed its partition to a
+	setup := func(t *testing.T, cluster string) (a, b *LoadStats, es *EngineStats) {
+		a, b = newPartitionedStats("a"), newPartitionedStats("b")
+		for i, origin := range []string{"a", "a", "a", "b", "b", ""} {
+			req := InferenceRequest{Cluster: cluster, RequestId: fmt.Sprintf("%s-%d", cluster, i), PromptLength: 10, Ip: ip, Origin: origin}
+			r1, r2 := req, req
+			a.AddRequest(&r1)
+			b.AddRequest(&r2)
+		}
+		a.MergeCounters(b.ownedCounters(nil))
+		es, ok := a.GetModelStats(cluster).Load(ip)
+		require.True(t, ok)
+		require.Equal(t, int32(6), es.GetQueuedReqNum())
+		a.owner.takeDirty()
+		return a, b, es
+	}
+	// drift miscounts the partition owned by a
+	drift := func(es *EngineStats) {
+		es.counters.add(es, &InferenceRequest{Origin: "a"}, 2, 20)
+	}
+
+	t.Run("reset rebuilds the local partitions", func(t *testing.T) {
+		cluster := "crdt-reset"
+		a, b, es := setup(t, cluster)
+		drift(es)
+		require.Equal(t, int32(8), es.GetQueuedReqNum())
+
+		result := a.ResetEngine(&EngineAction{Cluster: cluster, Ip: ip}, AuditSourceAPI)
+		require.True(t, result.Found)
+		assert.Equal(t, int32(6), es.GetQueuedReqNum(), "the partition of b is kept")
+		assert.Equal(t, int32(60), es.GetPromptLength())
+
+		// The corrected partition reaches the other instances with the next push
+		dirty := a.owner.takeDirty()
+		require.Len(t, dirty, 1)
+		b.MergeCounters(a.ownedCounters(dirty))
+		other, ok := b.GetModelStats(cluster).Load(ip)
+		require.True(t, ok)
+		assert.Equal(t, int32(6), other.GetQueuedReqNum())
+		assert.Equal(t, int32(60), other.GetPromptLength())
+	})
+
+	t.Run("invariants only check the local partitions", func(t *testing.T) {
+		cluster := "crdt-invariant"
+		unmerged := newPartitionedStats("a")
+		for i, origin := range []string{"a", "b", "b", ""} {
+			unmerged.AddRequest(&InferenceRequest{Cluster: cluster, RequestId: fmt.Sprintf("%s-%d", cluster, i), Ip: ip, Origin: origin})
+		}
+		assert.Empty(t, unmerged.CheckInvariants(false), "requests of b are counted once b pushes its partition")
+
+		a, _, es := setup(t, cluster)
+		drift(es)
+		violations := a.CheckInvariants(true)
+		require.Len(t, violations, 1)
+		assert.Equal(t, &LoadCounter{QueuedReqNum: 4, PromptLength: 40}, violations[0].Expected)
+		assert.Equal(t, &LoadCounter{QueuedReqNum: 6, PromptLength: 60}, violations[0].Live)
+		assert.Equal(t, int32(6), es.GetQueuedReqNum())
+		assert.Empty(t, a.CheckInvariants(false))
+	})
+}
@@ -15,6 +15,9 @@
 package load
 
 import (
+	"encoding/json"
+	"net"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -22,39 +25,113 @@ import (
 	"github.com/aigw-project/metadata-center/pkg/utils/logger"
 )
 
+// Counter names used when reporting counter invariant violations
+const (
+	counterQueuedReqNum = "queued_req_num"
+	counterPromptLength = "prompt_length"
+	counterModel        = "model"
+	counterPriority     = "priority"
+)
+
 // EngineStats holds engine load metrics
+// Endpoint is the host:port identity of the engine, empty for engines only known by IP
 type EngineStats struct {
 	Ip           string `json:"ip"`
+	Endpoint     string `json:"endpoint,omitempty"`
 	QueuedReqNum int32  `json:"queued_req_num"`
 	PromptLength int32  `json:"prompt_length"`
 	UpdatedTime  int64  `json:"updated_time"`
+	// correction is the offset of QueuedReqNum against the tracked requests set by the reconciler
+	correction int32
+	// reported stores the latest engine-originated snapshot next to the estimated counters
+	reported atomic.Pointer[EngineReport]
+	// models holds per-model sub-counters for engines serving several models
+	models counterSet
+	// priorities holds per-priority sub-counters, so schedulers can see the load of each request class
+	priorities counterSet
+	// history holds sampled counters for trend queries, nil when sampling is disabled
+	history *loadHistory
+	// latency holds rolling TTFT and end-to-end latencies, nil when disabled
+	latency *latencyTracker
+	// counters holds the per-origin partitions QueuedReqNum and PromptLength are summed from, nil unless in crdt mode
+	counters *pnCounter
+	// requests indexes the tracked requests counted on the engine by ID, so removing the engine drops only its own
+	requests sync.Map
+}
+
+// EngineSnapshot is a point-in-time copy of EngineStats used for query responses
+// EngineStats cannot be marshaled by value because atomic.Pointer must not be copied
+type EngineSnapshot struct {
+	Ip           string                  `json:"ip"`
+	Endpoint     string                  `json:"endpoint,omitempty"`
+	QueuedReqNum int32                   `json:"queued_req_num"`
+	PromptLength int32                   `json:"prompt_length"`
+	UpdatedTime  int64                   `json:"updated_time"`
+	Correction   int32                   `json:"correction,omitempty"`
+	Models       map[string]*LoadCounter `json:"models,omitempty"`
+	Priorities   map[string]*LoadCounter `json:"priorities,omitempty"`
+	Reported     *EngineReport           `json:"reported,omitempty"`
+	Trend        *LoadTrend              `json:"trend,omitempty"`
+	Latency      *LatencyStats           `json:"latency,omitempty"`
+	Score        *float64                `json:"score,omitempty"`
 }
 
 // NewEngineLoadStats creates a new EngineStats instance
-func NewEngineLoadStats(ip string) *EngineStats {
+// The key is either an IP address or a host:port endpoint
+func NewEngineLoadStats(key string) *EngineStats {
+	ip, endpoint := splitEngineKey(key)
 	return &EngineStats{
 		Ip:           ip,
+		Endpoint:     endpoint,
 		QueuedReqNum: 0,
 		PromptLength: 0,
 		UpdatedTime:  time.Now().UnixNano(),
+		history:      newLoadHistory(historyCapacity()),
+		latency:      newLatencyTracker(latencyWindowSize),
+	}
+}
+
+// splitEngineKey returns the IP and the endpoint of an engine key, the endpoint is empty for IP keys
+func splitEngineKey(key string) (string, string) {
+	if host, _, err := net.SplitHostPort(key); err == nil {
+		return host, key
+	}
+	return key, ""
+}
+
+// Key returns the engine identity used as ModelStats key and metric label
+func (e *EngineStats) Key() string {
+	if e.Endpoint != "" {
+		return e.Endpoint
 	}
+	return e.Ip
 }
 
 // IncrementQueuedReqNumAndPromptLength increments queue and prompt metrics
 func (e *EngineStats) IncrementQueuedReqNumAndPromptLength(req *InferenceRequest, promptLength int32) {
-	atomic.AddInt32(&e.QueuedReqNum, 1)
-	atomic.AddInt32(&e.PromptLength, promptLength)
-	e.UpdatedTime = time.Now().UnixNano()
+	if e.counters != nil {
+		e.counters.add(e, req, 1, promptLength)
+	} else {
+		atomic.AddInt32(&e.QueuedReqNum, 1)
+		atomic.AddInt32(&e.PromptLength, promptLength)
+	}
+	e.touch()
 
-	prom.SetLoadMetric(req.Cluster, req.Ip, e.GetQueuedReqNum(), e.GetPromptLength())
+	prom.SetLoadMetric(req.Cluster, e.Key(), e.GetQueuedReqNum(), e.GetPromptLength())
+	e.addSubCounters(req, 1, promptLength)
 }
 
 // DecrementQueuedReqNum decrements queue count
 func (e *EngineStats) DecrementQueuedReqNum(req *InferenceRequest) {
-	atomic.AddInt32(&e.QueuedReqNum, -1)
-	e.UpdatedTime = time.Now().UnixNano()
+	if e.counters != nil {
+		e.counters.add(e, req, -1, 0)
+	} else if addNonNegative(&e.QueuedReqNum, -1) && !e.absorbCorrection() {
+		e.reportNegative(req, counterQueuedReqNum)
+	}
+	e.touch()
 
-	prom.SetLoadMetric(req.Cluster, req.Ip, e.GetQueuedReqNum(), e.GetPromptLength())
+	prom.SetLoadMetric(req.Cluster, e.Key(), e.GetQueuedReqNum(), e.GetPromptLength())
+	e.addSubCounters(req, -1, 0)
 }
 
 // DecrementPromptLength decrements prompt length
@@ -70,9 +147,117 @@ func (e *EngineStats) DecrementPromptLength(req *InferenceRequest) {
 		logger.Warnf("DecrementPromptLength failed to swap prompt length for request: %s, expected: %d, current: %d", key, length, req.PromptLength)
 		return
 	}
-	atomic.AddInt32(&e.PromptLength, -length)
-	e.UpdatedTime = time.Now().UnixNano()
-	prom.SetLoadMetric(key, req.Ip, e.GetQueuedReqNum(), e.GetPromptLength())
+	if e.counters != nil {
+		e.counters.add(e, req, 0, -length)
+	} else if addNonNegative(&e.PromptLength, -length) {
+		e.reportNegative(req, counterPromptLength)
+	}
+	e.touch()
+	prom.SetLoadMetric(key, e.Key(), e.GetQueuedReqNum(), e.GetPromptLength())
+	e.addSubCounters(req, 0, -length)
+}
+
+// addSubCounters updates the sub-counters and metrics of the request's model and priority
+func (e *EngineStats) addSubCounters(req *InferenceRequest, queuedReqNum, promptLength int32) {
+	if c, clamped := e.models.add(req.Model, queuedReqNum, promptLength); c != nil {
+		if clamped {
+			e.reportNegative(req, counterModel)
+		}
+		s := c.snapshot()
+		prom.SetEngineModelLoadMetric(req.Cluster, e.Key(), req.Model, s.QueuedReqNum, s.PromptLength)
+	}
+	if c, clamped := e.priorities.add(req.Priority, queuedReqNum, promptLength); c != nil {
+		if clamped {
+			e.reportNegative(req, counterPriority)
+		}
+		s := c.snapshot()
+		prom.SetEnginePriorityLoadMetric(req.Cluster, e.Key(), req.Priority, s.QueuedReqNum, s.PromptLength)
+	}
+}
+
+// reportNegative records a counter that went negative and was clamped to 0
+func (e *EngineStats) reportNegative(req *InferenceRequest, counter string) {
+	prom.NegativeCounterTotal.WithLabelValues(req.Cluster, counter).Inc()
+	logger.Warnf("reqID [%s]: %s counter of engine %s on model %s went negative, clamped to 0",
+		req.RequestId, counter, e.Key(), req.Cluster)
+}
+
+// GetModelCounter returns the sub-counter of the given model
+func (e *EngineStats) GetModelCounter(model string) (*LoadCounter, bool) {
+	c, ok := e.models.load(model)
+	if !ok {
+		return nil, false
+	}
+	return c.snapshot(), true
+}
+
+// GetPriorityCounter returns the sub-counter of the given priority class
+func (e *EngineStats) GetPriorityCounter(priority string) (*LoadCounter, bool) {
+	c, ok := e.priorities.load(priority)
+	if !ok {
+		return nil, false
+	}
+	return c.snapshot(), true
+}
+
+// CorrectQueuedReqNum corrects the queued request count to an authoritative value
+// The correction is kept as an offset against the tracked requests, so requests finishing later still decrement the count.
+// Returns the offset, which other instances apply with SetCorrection
+func (e *EngineStats) CorrectQueuedReqNum(key string, queuedReqNum int32) int32 {
+	correction := queuedReqNum - (e.GetQueuedReqNum() - e.GetCorrection())
+	e.SetCorrection(key, correction)
+	return correction
+}
+
+// SetCorrection replaces the offset of the queued request count against the tracked requests
+func (e *EngineStats) SetCorrection(key string, correction int32) {
+	old := atomic.SwapInt32(&e.correction, correction)
+	addNonNegative(&e.QueuedReqNum, correction-old)
+	e.touch()
+	prom.SetLoadMetric(key, e.Key(), e.GetQueuedReqNum(), e.GetPromptLength())
+}
+
+// GetCorrection returns the offset of the queued request count against the tracked requests
+func (e *EngineStats) GetCorrection() int32 {
+	return atomic.LoadInt32(&e.correction)
+}
+
+// absorbCorrection moves a negative correction towards 0 after the queued request count was clamped
+// A tracked request finishing below the corrected count was already left out by the correction, so it is no drift
+func (e *EngineStats) absorbCorrection() bool {
+	for {
+		c := atomic.LoadInt32(&e.correction)
+		if c >= 0 {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&e.correction, c, c+1) {
+			return true
+		}
+	}
+}
+
+// ResetCounters overwrites all counters, including the per-model and per-priority sub-counters, with rebuilt values
+// In crdt mode origins, the counters per request origin, rebuild the partitions of this instance instead of the total
+func (e *EngineStats) ResetCounters(key string, total *LoadCounter, origins, models, priorities map[string]*LoadCounter) {
+	if e.counters != nil {
+		e.counters.resetLocal(e, origins)
+	} else {
+		atomic.StoreInt32(&e.QueuedReqNum, total.QueuedReqNum)
+		atomic.StoreInt32(&e.PromptLength, total.PromptLength)
+		atomic.StoreInt32(&e.correction, 0)
+	}
+	e.models.reset(models)
+	e.priorities.reset(priorities)
+	e.touch()
+	prom.SetLoadMetric(key, e.Key(), e.GetQueuedReqNum(), e.GetPromptLength())
+	prom.DeleteEngineModelMetric(key, e.Key())
+	for model, c := range models {
+		prom.SetEngineModelLoadMetric(key, e.Key(), model, c.QueuedReqNum, c.PromptLength)
+	}
+	prom.DeleteEnginePriorityMetric(key, e.Key())
+	for priority, c := range priorities {
+		prom.SetEnginePriorityLoadMetric(key, e.Key(), priority, c.QueuedReqNum, c.PromptLength)
+	}
 }
 
 // GetQueuedReqNum returns the current queued request count
@@ -85,7 +270,119 @@ func (e *EngineStats) GetPromptLength() int32 {
 	return atomic.LoadInt32(&e.PromptLength)
 }
 
+// GetUpdatedTime returns the time of the last counter update
+func (e *EngineStats) GetUpdatedTime() int64 {
+	return atomic.LoadInt64(&e.UpdatedTime)
+}
+
+// touch records a counter update, UpdatedTime is read concurrently by GC and queries
+func (e *EngineStats) touch() {
+	atomic.StoreInt64(&e.UpdatedTime, time.Now().UnixNano())
+}
+
+// SetReport stores the latest engine-reported load snapshot
+func (e *EngineStats) SetReport(report *EngineReport) {
+	e.reported.Store(report)
+}
+
+// GetReport returns the latest engine-reported load snapshot, nil if never reported
+func (e *EngineStats) GetReport() *EngineReport {
+	return e.reported.Load()
+}
+
+// LastActiveTime returns the latest of the counter update and report times
+// Used by GC so engines that only push reports are not removed
+func (e *EngineStats) LastActiveTime() int64 {
+	if report := e.GetReport(); report != nil && report.ReportedTime > e.GetUpdatedTime() {
+		return report.ReportedTime
+	}
+	return e.GetUpdatedTime()
+}
+
+// Snapshot returns a point-in-time copy of the engine statistics
+func (e *EngineStats) Snapshot() *EngineSnapshot {
+	return &EngineSnapshot{
+		Ip:           e.Ip,
+		Endpoint:     e.Endpoint,
+		QueuedReqNum: e.GetQueuedReqNum(),
+		PromptLength: e.GetPromptLength(),
+		UpdatedTime:  e.GetUpdatedTime(),
+		Correction:   e.GetCorrection(),
+		Models:       e.models.snapshot(),
+		Priorities:   e.priorities.snapshot(),
+		Reported:     e.GetReport(),
+		Trend:        e.history.trend(),
+		Latency:      e.latency.snapshot(),
+	}
+}
+
+// observeTTFT records the time to first token of a request on a nil-safe engine
+// promptLength is the prompt length before the deletion, used to normalize TTFT per prompt token
+func (e *EngineStats) observeTTFT(req *InferenceRequest, now time.Time, promptLength int32) {
+	if !req.acceptedHere() {
+		return
+	}
+	ms := float64(now.Sub(req.CreateTime).Microseconds()) / 1000
+	prom.ObserveRequestTTFTMillisecond(req.Cluster, ms)
+	if e != nil {
+		e.latency.observeTTFT(ms, promptLength)
+	}
+}
+
+// observeDuration records the end-to-end duration of a completed request on a nil-safe engine
+func (e *EngineStats) observeDuration(req *InferenceRequest, now time.Time) {
+	if !req.acceptedHere() {
+		return
+	}
+	ms := float64(now.Sub(req.CreateTime).Microseconds()) / 1000
+	prom.ObserveRequestDurationMillisecond(req.Cluster, ms)
+	if e != nil {
+		e.latency.observeDuration(ms)
+	}
+}
+
+// sample records the current counters into the engine history
+func (e *EngineStats) sample(now int64) {
+	if e.history == nil {
+		return
+	}
+	e.history.add(LoadSample{
+		Time:         now,
+		QueuedReqNum: e.GetQueuedReqNum(),
+		PromptLength: e.GetPromptLength(),
+	})
+}
+
+// History returns the sampled counters of the engine with the derived trend
+func (e *EngineStats) History() *EngineHistory {
+	return &EngineHistory{
+		Ip:         e.Ip,
+		Endpoint:   e.Endpoint,
+		IntervalMs: historyInterval.Milliseconds(),
+		Samples:    e.history.series(),
+		Trend:      e.history.trend(),
+	}
+}
+
+// MarshalJSON implements json.Marshaler with atomic reads of the counters
+func (e *EngineStats) MarshalJSON() ([]byte, error) {
+	return json.Marshal(e.Snapshot())
+}
+
+// Blend replaces the queued request count with a value blended from a fresh report
+func (s *EngineSnapshot) Blend(mode string, now int64) {
+	if !s.Reported.IsFresh(now) {
+		return
+	}
+	switch mode {
+	case BlendReported:
+		s.QueuedReqNum = s.Reported.QueuedReqNum()
+	case BlendMax:
+		s.QueuedReqNum = max(s.QueuedReqNum, s.Reported.QueuedReqNum())
+	}
+}
+
 // MetricClean removes engine metrics for the given key
 func (e *EngineStats) MetricClean(key string) {
-	prom.DeleteEngineMetric(key, e.Ip)
+	prom.DeleteEngineMetric(key, e.Key())
 }
@@ -0,0 +1,163 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package load
+
+import (
+	"container/heap"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/aigw-project/metadata-center/pkg/prom"
+	"github.com/aigw-project/metadata-center/pkg/utils/logger"
+)
+
+// expiryEntry is a tracked request indexed by its creation time, seq keeps insertion order on equal times
+type expiryEntry struct {
+	created int64
+	seq     uint64
+	req     *InferenceRequest
+}
+
+// expiryHeap is a min-heap of requests by creation time
+// All requests share the same expire duration, so the top is always the next request to expire
+type expiryHeap []expiryEntry
+
+func (h expiryHeap) Len() int { return len(h) }
+func (h expiryHeap) Less(i, j int) bool {
+	if h[i].created != h[j].created {
+		return h[i].created < h[j].created
+	}
+	return h[i].seq < h[j].seq
+}
+func (h expiryHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *expiryHeap) Push(x any) {
+	*h = append(*h, x.(expiryEntry))
+}
+
+func (h *expiryHeap) Pop() any {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = expiryEntry{}
+	*h = old[:n-1]
+	return e
+}
+
+// expiryIndex orders the tracked requests oldest first, for expiry and for capacity eviction
+// Requests removed by other paths are skipped lazily, the heap is compacted once most entries are stale
+type expiryIndex struct {
+	mu  sync.Mutex
+	h   expiryHeap
+	seq uint64
+}
+
+// push indexes a request, live is the number of tracked requests used to decide on compaction
+func (x *expiryIndex) push(req *InferenceRequest, live int64, tracked func(*InferenceRequest) bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.seq++
+	heap.Push(&x.h, expiryEntry{created: req.CreateTime.UnixNano(), seq: x.seq, req: req})
+	if int64(len(x.h)) > 2*live+64 {
+		x.compact(tracked)
+	}
+}
+
+// compact drops the entries that are no longer tracked, must be called with mu held
+func (x *expiryIndex) compact(tracked func(*InferenceRequest) bool) {
+	h := make(expiryHeap, 0, len(x.h)/2)
+	for _, e := range x.h {
+		if tracked(e.req) {
+			h = append(h, e)
+		}
+	}
+	heap.Init(&h)
+	x.h = h
+}
+
+// pop removes and returns the oldest tracked request created at or before the bound
+// Returns false when there is none
+func (x *expiryIndex) pop(bound int64, tracked func(*InferenceRequest) bool) (expiryEntry, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for len(x.h) > 0 && x.h[0].created <= bound {
+		e := heap.Pop(&x.h).(expiryEntry)
+		if tracked(e.req) {
+			return e, true
+		}
+	}
+	return expiryEntry{}, false
+}
+
+// len returns the number of indexed entries, stale ones included
+func (x *expiryIndex) len() int {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return len(x.h)
+}
+
+// popOldest removes and returns the oldest tracked request from the index
+func (ls *LoadStats) popOldest() (*InferenceRequest, bool) {
+	e, ok := ls.expiry.pop(math.MaxInt64, ls.isTracked)
+	return e.req, ok
+}
+
+// expireRequests removes the requests whose deadline has passed, at a cost proportional to the number expired
+// Expired tombstones are removed along the way
+func (ls *LoadStats) expireRequests(now time.Time) int {
+	bound := now.Add(-requestExpireDuration).UnixNano()
+	expired := 0
+	for {
+		e, ok := ls.expiry.pop(bound, ls.isTracked)
+		if !ok {
+			break
+		}
+		req := e.req
+		if !ls.Requests.CompareAndDelete(req.RequestId, req) {
+			continue
+		}
+		ls.untrackRequest(req)
+		ls.decEngineStats(req)
+		lag := now.Sub(time.Unix(0, e.created).Add(requestExpireDuration))
+		prom.ObserveRequestExpiryLagMillisecond(float64(lag.Microseconds()) / 1000)
+		logger.Infof("removed request from running requests, request=%v", req)
+		expired++
+	}
+	prom.RequestExpiryIndexSize.Set(float64(ls.expiry.len()))
+	ls.tombstones.expire(now, tombstoneTTL)
+	return expired
+}
+
+// cronExpire removes expired requests of a backend close to their deadline
+func cronExpire(ticker *time.Ticker, b Backend) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Errorf("load expiry goroutine panicked: %v", r)
+		}
+		ticker.Stop()
+		logger.Errorf("load expiry goroutine exited")
+	}()
+
+	for now := range ticker.C {
+		expired, err := b.Expire(now)
+		if err != nil {
+			logger.Errorf("failed to expire requests: %v", err)
+		}
+		if expired > 0 {
+			logger.Debugf("expired %d requests, duration: %s", expired, time.Since(now))
+		}
+	}
+}
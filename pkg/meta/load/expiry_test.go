@@ -0,0 +1,96 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package load
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// backdate moves the creation of a tracked request into the past and re-indexes its expiry
+func backdate(ls *LoadStats, req *InferenceRequest, d time.Duration) {
+	req.CreateTime = req.CreateTime.Add(-d)
+	ls.expiry.push(req, ls.requestCount.Load(), ls.isTracked)
+}
+
+func TestExpiryIndex(t *testing.T) {
+	live := map[*InferenceRequest]bool{}
+	tracked := func(req *InferenceRequest) bool { return live[req] }
+
+	var x expiryIndex
+	created := time.Now()
+	reqs := make([]*InferenceRequest, 100)
+	for i := range reqs {
+		// Pushed newest first, equal times keep insertion order
+		reqs[i] = &InferenceRequest{RequestId: fmt.Sprintf("x-%d", i), CreateTime: created.Add(-time.Duration(i/2) * time.Second)}
+		live[reqs[i]] = true
+		x.push(reqs[i], int64(len(live)), tracked)
+	}
+
+	// Stale entries are compacted away
+	for _, req := range reqs[10:] {
+		delete(live, req)
+	}
+	latest := &InferenceRequest{RequestId: "x-latest", CreateTime: created}
+	live[latest] = true
+	x.push(latest, int64(len(live)), tracked)
+	assert.Equal(t, 11, x.len())
+
+	e, ok := x.pop(math.MaxInt64, tracked)
+	require.True(t, ok)
+	assert.Equal(t, reqs[8], e.req)
+	e, _ = x.pop(math.MaxInt64, tracked)
+	assert.Equal(t, reqs[9], e.req)
+
+	// Only entries created at or before the bound are popped
+	bound := created.Add(-2 * time.Second).UnixNano()
+	for _, want := range []*InferenceRequest{reqs[6], reqs[7], reqs[4], reqs[5]} {
+		e, ok = x.pop(bound, tracked)
+		require.True(t, ok)
+		assert.Equal(t, want, e.req)
+	}
+	_, ok = x.pop(bound, tracked)
+	assert.False(t, ok)
+}
+
+func TestLoadStats_ExpireRequests(t *testing.T) {
+	SetRequestExpireDuration(time.Minute)
+	defer SetRequestExpireDuration(DefaultRequestExpireDuration)
+
+	ls := NewLoadStats()
+	cluster := "expiry_domain"
+	ip := "192.168.30.1"
+	addRequests(ls, cluster, ip, 4)
+	ls.DeleteRequest(newDeletionInferenceRequest(fmt.Sprintf("%s-%s-0", cluster, ip)))
+	assert.Equal(t, 0, ls.expireRequests(time.Now()))
+
+	now := time.Now()
+	assert.Equal(t, 3, ls.expireRequests(now.Add(time.Minute)), "deleted requests are skipped")
+	assert.Equal(t, int64(0), ls.requestCount.Load())
+	es, ok := ls.GetModelStats(cluster).Load(ip)
+	require.True(t, ok)
+	assert.Equal(t, int32(0), es.GetQueuedReqNum())
+	assert.Equal(t, 0, ls.expiry.len())
+
+	// A re-added request ID is indexed again and expires on its own deadline
+	addRequests(ls, cluster, ip, 1)
+	assert.Equal(t, 0, ls.expireRequests(time.Now()))
+	assert.Equal(t, 1, ls.expireRequests(time.Now().Add(2*time.Minute)))
+}
@@ -0,0 +1,345 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package load
+
+import (
+	"context"
+	"encoding/json"
+	goerrors "errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aigw-project/metadata-center/pkg/prom"
+	"github.com/aigw-project/metadata-center/pkg/utils/errors"
+	"github.com/aigw-project/metadata-center/pkg/utils/logger"
+)
+
+// regionSeparator separates the region prefix from the name of a federated cluster
+const regionSeparator = ":"
+
+// federationPattern selects the clusters polled from a remote, all the top-level clusters of the polled tenant
+const federationPattern = "*"
+
+// remote is the instance of another region whose clusters are polled, read-only
+type remote struct {
+	region string
+	url    string
+	mu     sync.RWMutex
+	// tenants holds the last successful poll of each tenant, so a failing tenant goes stale on its own
+	tenants map[string]*tenantClusters
+}
+
+// tenantClusters holds the engine snapshots of each cluster of a tenant as received, decoded for every query
+type tenantClusters struct {
+	clusters map[string]json.RawMessage
+	updated  time.Time
+}
+
+// clusterSnapshots is the data of a multi-cluster query response with the engines left encoded
+type clusterSnapshots struct {
+	SnapshotTime int64                      `json:"snapshot_time"`
+	Clusters     map[string]json.RawMessage `json:"clusters"`
+}
+
+// queryResponse is the response envelope of the query API
+type queryResponse struct {
+	Status string            `json:"status"`
+	Error  *errors.ErrorInfo `json:"error"`
+	Data   *clusterSnapshots `json:"data"`
+}
+
+// poll replaces the clusters of the remote with the current ones of the given tenants
+// Each tenant is queried on its own, the remote scopes the query to the tenant like any other caller.
+// A tenant whose query fails keeps its last clusters until they are too stale, the others are replaced.
+// Clusters the remote federates from other regions are skipped, they are polled from their own region
+func (r *remote) poll(ctx context.Context, client *http.Client, tenants []string, now time.Time) error {
+	polled := make(map[string]*tenantClusters, len(tenants))
+	var errs []error
+	for _, tenant := range tenants {
+		data, err := r.query(ctx, client, tenant, federationPattern)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("tenant %q: %w", tenant, err))
+			continue
+		}
+		clusters := make(map[string]json.RawMessage, len(data.Clusters))
+		for name, engines := range data.Clusters {
+			if !strings.Contains(name, regionSeparator) {
+				clusters[name] = engines
+			}
+		}
+		polled[tenant] = &tenantClusters{clusters: clusters, updated: now}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	next := make(map[string]*tenantClusters, len(tenants))
+	for _, tenant := range tenants {
+		if t, ok := polled[tenant]; ok {
+			next[tenant] = t
+		} else if t, ok := r.tenants[tenant]; ok {
+			next[tenant] = t
+		}
+	}
+	r.tenants = next
+	return goerrors.Join(errs...)
+}
+
+// query returns the clusters of a tenant of the remote matching a pattern
+func (r *remote) query(ctx context.Context, client *http.Client, tenant, pattern string) (*clusterSnapshots, error) {
+	u := r.url + "/v1/load/stats/clusters?" + url.Values{"pattern": {pattern}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if tenant != "" {
+		req.Header.Set(tenantHeader, tenant)
+		if token, ok := tenantTokens[tenant]; ok {
+			req.Header.Set(TenantTokenHeader, token)
+		}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var ret queryResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ret); err != nil {
+		return nil, fmt.Errorf("failed to decode the response of %s: %w", u, err)
+	}
+	if resp.StatusCode != http.StatusOK || ret.Data == nil {
+		return nil, fmt.Errorf("query %s returned status %d: %v", u, resp.StatusCode, ret.Error)
+	}
+	return ret.Data, nil
+}
+
+// fresh reports whether a polled tenant is within maxStaleness
+func (t *tenantClusters) fresh(now time.Time, maxStaleness time.Duration) bool {
+	return maxStaleness <= 0 || now.Sub(t.updated) <= maxStaleness
+}
+
+// snapshots decodes the engine snapshots of a cluster of a tenant
+// Returns nil when the remote does not have it, or the tenant was not polled within maxStaleness
+func (r *remote) snapshots(tenant, cluster string, now time.Time, maxStaleness time.Duration) ([]*EngineSnapshot, error) {
+	r.mu.RLock()
+	t, ok := r.tenants[tenant]
+	r.mu.RUnlock()
+	if !ok || !t.fresh(now, maxStaleness) {
+		return nil, nil
+	}
+	data, ok := t.clusters[cluster]
+	if !ok {
+		return nil, nil
+	}
+	var snapshots []*EngineSnapshot
+	if err := json.Unmarshal(data, &snapshots); err != nil {
+		return nil, err
+	}
+	return snapshots, nil
+}
+
+// names returns the LoadStats keys of the clusters of the fresh tenants of the remote, scoped to their tenant under
+// the region prefix
+func (r *remote) names(now time.Time, maxStaleness time.Duration) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var names []string
+	for tenant, t := range r.tenants {
+		if !t.fresh(now, maxStaleness) {
+			continue
+		}
+		for name := range t.clusters {
+			names = append(names, ScopedName(tenant, r.region+regionSeparator+name))
+		}
+	}
+	return names
+}
+
+// oldestUpdate returns the time of the least recent tenant poll, zero before the first one
+func (r *remote) oldestUpdate() time.Time {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var oldest time.Time
+	for _, t := range r.tenants {
+		if oldest.IsZero() || t.updated.Before(oldest) {
+			oldest = t.updated
+		}
+	}
+	return oldest
+}
+
+// federation serves the clusters of other regions next to the local ones, under the region prefix
+// The remote clusters are polled and never written to
+type federation struct {
+	client       *http.Client
+	remotes      map[string]*remote
+	maxStaleness time.Duration
+	// tenants returns the named tenants whose clusters are polled along with those of the default tenant
+	tenants func() []string
+}
+
+// parseFederationRemotes parses a comma separated list of region=url remotes
+func parseFederationRemotes(remotes string) (map[string]*remote, error) {
+	ret := make(map[string]*remote)
+	for _, item := range strings.Split(remotes, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		region, u, ok := strings.Cut(item, "=")
+		if !ok || region == "" || u == "" || strings.Contains(region, regionSeparator) {
+			return nil, fmt.Errorf("invalid federation remote %q, expected region=url", item)
+		}
+		if _, ok := ret[region]; ok {
+			return nil, fmt.Errorf("duplicate federation region %s", region)
+		}
+		ret[region] = &remote{region: region, url: strings.TrimSuffix(u, "/")}
+	}
+	return ret, nil
+}
+
+// remoteOf returns the remote of a federated cluster, the tenant owning the cluster and its name in the region
+// A federated cluster of a tenant is scoped like its local ones, tenant/region:cluster
+func (f *federation) remoteOf(cluster string) (*remote, string, string, bool) {
+	if f == nil {
+		return nil, "", "", false
+	}
+	tenant := tenantOf(cluster)
+	region, name, ok := strings.Cut(UnscopedName(tenant, cluster), regionSeparator)
+	if !ok {
+		return nil, "", "", false
+	}
+	r, ok := f.remotes[region]
+	return r, tenant, name, ok
+}
+
+// checkWritable rejects writes to federated clusters
+func (f *federation) checkWritable(cluster string) error {
+	if r, _, _, ok := f.remoteOf(cluster); ok {
+		return errors.InvalidInput("cluster %s is federated from region %s and read-only", cluster, r.region)
+	}
+	return nil
+}
+
+// pollAll polls every remote and exports how stale each one is
+func (f *federation) pollAll(ctx context.Context, now time.Time) {
+	tenants := []string{""}
+	if f.tenants != nil {
+		tenants = append(tenants, f.tenants()...)
+	}
+	var wg sync.WaitGroup
+	for _, r := range f.remotes {
+		wg.Add(1)
+		go func(r *remote) {
+			defer wg.Done()
+			if err := r.poll(ctx, f.client, tenants, now); err != nil {
+				prom.FederationPollErrors.WithLabelValues(r.region).Inc()
+				logger.Errorf("failed to poll load statistics of region %s at %s: %v", r.region, r.url, err)
+			}
+			if updated := r.oldestUpdate(); !updated.IsZero() {
+				prom.FederationStaleness.WithLabelValues(r.region).Set(now.Sub(updated).Seconds())
+			}
+		}(r)
+	}
+	wg.Wait()
+}
+
+// cronPollFederation polls the remotes periodically
+func cronPollFederation(ticker *time.Ticker, f *federation) {
+	defer func() {
+		if p := recover(); p != nil {
+			logger.Errorf("federation poll goroutine panicked: %v", p)
+		}
+		ticker.Stop()
+		logger.Errorf("federation poll goroutine exited")
+	}()
+
+	for now := range ticker.C {
+		f.pollAll(context.Background(), now)
+	}
+}
+
+// federatedBackend serves the federated clusters from their remotes and everything else from the local backend
+type federatedBackend struct {
+	Backend
+	fed *federation
+}
+
+func (b federatedBackend) AddRequest(req *InferenceRequest) error {
+	if err := b.fed.checkWritable(req.Cluster); err != nil {
+		return err
+	}
+	return b.Backend.AddRequest(req)
+}
+
+func (b federatedBackend) SetEngineReport(report *EngineReport) error {
+	if err := b.fed.checkWritable(report.Cluster); err != nil {
+		return err
+	}
+	return b.Backend.SetEngineReport(report)
+}
+
+// Snapshots of a federated cluster are empty while its tenant is stale on the remote
+func (b federatedBackend) Snapshots(cluster, model, blend string, now int64) ([]*EngineSnapshot, error) {
+	r, tenant, name, ok := b.fed.remoteOf(cluster)
+	if !ok {
+		return b.Backend.Snapshots(cluster, model, blend, now)
+	}
+	snapshots, err := r.snapshots(tenant, name, time.Unix(0, now), b.fed.maxStaleness)
+	if err != nil {
+		return nil, err
+	}
+	if snapshots == nil {
+		return []*EngineSnapshot{}, nil
+	}
+	return selectSnapshots(snapshots, model, blend, now), nil
+}
+
+// Clusters returns the local clusters and the clusters of the fresh tenants of the remotes
+func (b federatedBackend) Clusters() ([]string, error) {
+	names, err := b.Backend.Clusters()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for _, r := range b.fed.remotes {
+		names = append(names, r.names(now, b.fed.maxStaleness)...)
+	}
+	return names, nil
+}
+
+// initFederation serves the clusters of the configured remotes and starts polling them
+func initFederation() {
+	remotes, err := parseFederationRemotes(federationRemotes)
+	if err != nil {
+		logger.Errorf("federation disabled: %v", err)
+		return
+	}
+	if len(remotes) == 0 {
+		return
+	}
+	fed = &federation{
+		client:       &http.Client{Timeout: federationInterval},
+		remotes:      remotes,
+		maxStaleness: federationMaxStaleness,
+		tenants:      loadStats.tenantNames,
+	}
+	backend = federatedBackend{Backend: backend, fed: fed}
+	go cronPollFederation(time.NewTicker(federationInterval), fed)
+	logger.Infof("federating load statistics of %s, poll interval: %s, max staleness: %s",
+		federationRemotes, federationInterval, federationMaxStaleness)
+}
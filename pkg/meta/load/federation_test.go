@@ -0,0 +1,224 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package load
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aigw-project/metadata-center/pkg/utils/errors"
+)
+
+// newRemoteServer serves the multi-cluster query of a remote instance from its statistics, scoped to the tenant like the API
+// Queries of the tenants down reports fail
+func newRemoteServer(t *testing.T, ls *LoadStats, down func(tenant string) bool) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant := r.Header.Get(DefaultTenantHeader)
+		if down(tenant) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(`{"status":"error"}`))
+			return
+		}
+		assert.Equal(t, "/v1/load/stats/clusters", r.URL.Path)
+		if token, ok := tenantTokens[tenant]; ok {
+			assert.Equal(t, token, r.Header.Get(TenantTokenHeader), "polls authenticate their tenant")
+		}
+		snapshot := ls.SelectClusters(&MultiClusterQueryRequest{Pattern: ScopedName(tenant, r.URL.Query().Get("pattern"))})
+		clusters := make(map[string][]*EngineSnapshot, len(snapshot.Clusters))
+		for name, engines := range snapshot.Clusters {
+			clusters[UnscopedName(tenant, name)] = engines
+		}
+		snapshot.Clusters = clusters
+		_ = json.NewEncoder(w).Encode(map[string]any{"status": "ok", "data": snapshot})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestFederatedBackend(t *testing.T) {
+	remoteStats := NewLoadStats()
+	remoteStats.AddRequest(&InferenceRequest{Cluster: "qwen", RequestId: "fed-0", PromptLength: 10, Ip: "10.0.11.1", Model: "qwen-7b"})
+	remoteStats.AddRequest(&InferenceRequest{Cluster: "acme/llama", RequestId: "fed-1", PromptLength: 20, Ip: "10.0.11.2"})
+	var down atomic.Bool
+	srv := newRemoteServer(t, remoteStats, func(string) bool { return down.Load() })
+
+	remotes, err := parseFederationRemotes("us-east=" + srv.URL + "/")
+	require.NoError(t, err)
+	fed := &federation{client: srv.Client(), remotes: remotes, maxStaleness: time.Minute}
+	local := NewLoadStats()
+	local.AddRequest(&InferenceRequest{Cluster: "qwen", RequestId: "local-0", PromptLength: 5, Ip: "10.0.11.3"})
+	b := federatedBackend{Backend: memoryBackend{ls: local}, fed: fed}
+
+	snapshots, err := b.Snapshots("us-east:qwen", "", "", time.Now().UnixNano())
+	require.NoError(t, err)
+	assert.Empty(t, snapshots, "a region is not served before its first poll")
+
+	now := time.Now()
+	fed.pollAll(context.Background(), now)
+	snapshots, err = b.Snapshots("us-east:qwen", "qwen-7b", "", now.UnixNano())
+	require.NoError(t, err)
+	require.Len(t, snapshots, 1)
+	assert.Equal(t, "10.0.11.1", snapshots[0].Ip)
+	assert.Equal(t, int32(1), snapshots[0].Models["qwen-7b"].QueuedReqNum)
+	snapshots, err = b.Snapshots("qwen", "", "", now.UnixNano())
+	require.NoError(t, err)
+	require.Len(t, snapshots, 1)
+	assert.Equal(t, "10.0.11.3", snapshots[0].Ip, "local clusters are served locally")
+
+	names, err := b.Clusters()
+	require.NoError(t, err)
+	sort.Strings(names)
+	assert.Equal(t, []string{"qwen", "us-east:qwen"}, names, "the tenant clusters of the remote are not served to the default tenant")
+	clusters, err := selectClusters(b, &MultiClusterQueryRequest{Pattern: "us-east:*"})
+	require.NoError(t, err)
+	assert.Len(t, clusters.Clusters["us-east:qwen"], 1)
+	assert.NotContains(t, clusters.Clusters, "qwen")
+
+	err = b.AddRequest(&InferenceRequest{Cluster: "us-east:qwen", RequestId: "write", Ip: "10.0.11.1"})
+	require.Error(t, err)
+	assert.Equal(t, errors.InvalidInputCode, err.(*errors.ErrorInfo).Code)
+
+	// A failed poll keeps the last clusters until they are too stale
+	down.Store(true)
+	fed.pollAll(context.Background(), now.Add(30*time.Second))
+	snapshots, err = b.Snapshots("us-east:qwen", "", "", now.Add(30*time.Second).UnixNano())
+	require.NoError(t, err)
+	assert.Len(t, snapshots, 1)
+	snapshots, err = b.Snapshots("us-east:qwen", "", "", now.Add(2*time.Minute).UnixNano())
+	require.NoError(t, err)
+	assert.Empty(t, snapshots)
+}
+
+func TestFederatedBackend_Tenants(t *testing.T) {
+	SetTenantTokens(map[string]string{"acme": "acme-secret"})
+	defer SetTenantTokens(nil)
+	remoteStats := NewLoadStats()
+	remoteStats.AddRequest(&InferenceRequest{Cluster: "qwen", RequestId: "fed-t-0", Ip: "10.0.11.4"})
+	remoteStats.AddRequest(&InferenceRequest{Cluster: "acme/llama", RequestId: "acme/fed-t-1", Ip: "10.0.11.5"})
+	remoteStats.AddRequest(&InferenceRequest{Cluster: "acme/qwen", RequestId: "acme/fed-t-2", Ip: "10.0.11.6"})
+	remoteStats.AddRequest(&InferenceRequest{Cluster: "globex/secret", RequestId: "globex/fed-t-3", Ip: "10.0.11.7"})
+	var down atomic.Bool
+	srv := newRemoteServer(t, remoteStats, func(string) bool { return down.Load() })
+
+	remotes, err := parseFederationRemotes("us-east=" + srv.URL)
+	require.NoError(t, err)
+	local := NewLoadStats()
+	local.AddRequest(&InferenceRequest{Cluster: "acme/local", RequestId: "acme/local-0", Ip: "10.0.11.8"})
+	fed := &federation{client: srv.Client(), remotes: remotes, maxStaleness: time.Minute, tenants: local.tenantNames}
+	b := federatedBackend{Backend: memoryBackend{ls: local}, fed: fed}
+	now := time.Now()
+	fed.pollAll(context.Background(), now)
+
+	// Each tenant sees its own clusters of the remote under the region prefix, tenants unknown here are not polled
+	names, err := b.Clusters()
+	require.NoError(t, err)
+	sort.Strings(names)
+	assert.Equal(t, []string{"acme/local", "acme/us-east:llama", "acme/us-east:qwen", "us-east:qwen"}, names)
+	for _, tc := range []struct {
+		pattern string
+		want    []string
+	}{
+		{"*", []string{"us-east:qwen"}},
+		{"acme/*", []string{"acme/local", "acme/us-east:llama", "acme/us-east:qwen"}},
+		{"acme/us-east:*", []string{"acme/us-east:llama", "acme/us-east:qwen"}},
+	} {
+		clusters, err := selectClusters(b, &MultiClusterQueryRequest{Pattern: tc.pattern})
+		require.NoError(t, err)
+		got := make([]string, 0, len(clusters.Clusters))
+		for name := range clusters.Clusters {
+			got = append(got, name)
+		}
+		sort.Strings(got)
+		assert.Equal(t, tc.want, got, tc.pattern)
+	}
+
+	snapshots, err := b.Snapshots("acme/us-east:qwen", "", "", now.UnixNano())
+	require.NoError(t, err)
+	require.Len(t, snapshots, 1)
+	assert.Equal(t, "10.0.11.6", snapshots[0].Ip, "the cluster of the tenant, not the one of the default tenant")
+	snapshots, err = b.Snapshots("us-east:llama", "", "", now.UnixNano())
+	require.NoError(t, err)
+	assert.Empty(t, snapshots, "the default tenant cannot reach the clusters of a tenant")
+
+	err = b.AddRequest(&InferenceRequest{Cluster: "acme/us-east:qwen", RequestId: "acme/write", Ip: "10.0.11.6"})
+	require.Error(t, err)
+	assert.Equal(t, errors.InvalidInputCode, err.(*errors.ErrorInfo).Code)
+}
+
+func TestFederatedBackend_PartialPoll(t *testing.T) {
+	remoteStats := NewLoadStats()
+	remoteStats.AddRequest(&InferenceRequest{Cluster: "qwen", RequestId: "fed-p-0", Ip: "10.0.11.9"})
+	remoteStats.AddRequest(&InferenceRequest{Cluster: "eu-west:qwen", RequestId: "fed-p-1", Ip: "10.0.11.10"})
+	remoteStats.AddRequest(&InferenceRequest{Cluster: "acme/llama", RequestId: "acme/fed-p-2", Ip: "10.0.11.11"})
+	var acmeDown atomic.Bool
+	srv := newRemoteServer(t, remoteStats, func(tenant string) bool { return tenant == "acme" && acmeDown.Load() })
+
+	remotes, err := parseFederationRemotes("us-east=" + srv.URL)
+	require.NoError(t, err)
+	local := NewLoadStats()
+	local.AddRequest(&InferenceRequest{Cluster: "acme/local", RequestId: "acme/local-p", Ip: "10.0.11.12"})
+	fed := &federation{client: srv.Client(), remotes: remotes, maxStaleness: time.Minute, tenants: local.tenantNames}
+	b := federatedBackend{Backend: memoryBackend{ls: local}, fed: fed}
+	now := time.Now()
+	fed.pollAll(context.Background(), now)
+
+	names, err := b.Clusters()
+	require.NoError(t, err)
+	sort.Strings(names)
+	assert.Equal(t, []string{"acme/local", "acme/us-east:llama", "us-east:qwen"}, names,
+		"clusters the remote federates itself are not exported again")
+
+	// A failing tenant keeps its last clusters until they are too stale, the other tenants are still polled
+	acmeDown.Store(true)
+	remoteStats.AddRequest(&InferenceRequest{Cluster: "gemma", RequestId: "fed-p-3", Ip: "10.0.11.13"})
+	later := now.Add(45 * time.Second)
+	fed.pollAll(context.Background(), later)
+	snapshots, err := b.Snapshots("us-east:gemma", "", "", later.UnixNano())
+	require.NoError(t, err)
+	assert.Len(t, snapshots, 1, "the tenants that answered are replaced")
+	snapshots, err = b.Snapshots("acme/us-east:llama", "", "", later.UnixNano())
+	require.NoError(t, err)
+	assert.Len(t, snapshots, 1)
+
+	latest := now.Add(90 * time.Second)
+	fed.pollAll(context.Background(), latest)
+	snapshots, err = b.Snapshots("acme/us-east:llama", "", "", latest.UnixNano())
+	require.NoError(t, err)
+	assert.Empty(t, snapshots, "only the failing tenant goes stale")
+	snapshots, err = b.Snapshots("us-east:qwen", "", "", latest.UnixNano())
+	require.NoError(t, err)
+	assert.Len(t, snapshots, 1)
+}
+
+func TestParseFederationRemotes(t *testing.T) {
+	remotes, err := parseFederationRemotes("us-east=http://10.0.0.1:80, eu-west=http://10.1.0.1:80/,")
+	require.NoError(t, err)
+	require.Len(t, remotes, 2)
+	assert.Equal(t, "http://10.1.0.1:80", remotes["eu-west"].url)
+
+	for _, invalid := range []string{"http://10.0.0.1:80", "us:east=http://10.0.0.1:80", "a=http://x,a=http://y"} {
+		_, err = parseFederationRemotes(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
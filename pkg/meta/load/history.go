@@ -0,0 +1,185 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package load
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aigw-project/metadata-center/pkg/utils/logger"
+)
+
+// LoadSample is a point-in-time value of the engine counters
+type LoadSample struct {
+	Time         int64 `json:"time"`
+	QueuedReqNum int32 `json:"queued_req_num"`
+	PromptLength int32 `json:"prompt_length"`
+}
+
+// LoadTrend holds values derived from the sampled history of an engine
+// Rates are per second over the whole buffered window
+type LoadTrend struct {
+	QueuedReqNumEWMA float64 `json:"queued_req_num_ewma"`
+	PromptLengthEWMA float64 `json:"prompt_length_ewma"`
+	QueuedReqNumRate float64 `json:"queued_req_num_rate"`
+	PromptLengthRate float64 `json:"prompt_length_rate"`
+}
+
+// EngineHistoryQuery represents a query for the sampled history of one engine
+type EngineHistoryQuery struct {
+	Cluster  string `json:"cluster" binding:"required,excludes=/" form:"cluster"`
+	Ip       string `json:"ip,omitempty" binding:"required_without=Endpoint,omitempty,ip" form:"ip"`
+	Endpoint string `json:"endpoint,omitempty" binding:"required_without=Ip,omitempty,ip_port" form:"endpoint"`
+}
+
+// EngineKey returns the identity of the queried engine
+func (q *EngineHistoryQuery) EngineKey() string {
+	return engineKey(q.Ip, q.Endpoint)
+}
+
+// EngineHistory is the sampled history of an engine, oldest sample first
+type EngineHistory struct {
+	Ip         string       `json:"ip"`
+	Endpoint   string       `json:"endpoint,omitempty"`
+	IntervalMs int64        `json:"interval_ms"`
+	Samples    []LoadSample `json:"samples"`
+	Trend      *LoadTrend   `json:"trend,omitempty"`
+}
+
+// loadHistory is a fixed-size ring buffer of samples with a running EWMA
+// The EWMA uses the usual span smoothing, alpha = 2 / (size + 1)
+type loadHistory struct {
+	mu      sync.Mutex
+	samples []LoadSample
+	next    int
+	count   int
+	alpha   float64
+	ewma    [2]float64
+}
+
+// historyCapacity returns the number of samples kept per engine, 0 while sampling is disabled
+func historyCapacity() int {
+	if historyInterval <= 0 {
+		return 0
+	}
+	return historySize
+}
+
+// newLoadHistory creates a ring buffer holding up to size samples
+func newLoadHistory(size int) *loadHistory {
+	if size <= 0 {
+		return nil
+	}
+	return &loadHistory{
+		samples: make([]LoadSample, size),
+		alpha:   2 / float64(size+1),
+	}
+}
+
+// add appends a sample, overwriting the oldest one when the buffer is full
+func (h *loadHistory) add(s LoadSample) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.count == 0 {
+		h.ewma = [2]float64{float64(s.QueuedReqNum), float64(s.PromptLength)}
+	} else {
+		h.ewma[0] += h.alpha * (float64(s.QueuedReqNum) - h.ewma[0])
+		h.ewma[1] += h.alpha * (float64(s.PromptLength) - h.ewma[1])
+	}
+	h.samples[h.next] = s
+	h.next = (h.next + 1) % len(h.samples)
+	h.count = min(h.count+1, len(h.samples))
+}
+
+// series returns a copy of the buffered samples, oldest first
+func (h *loadHistory) series() []LoadSample {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ret := make([]LoadSample, 0, h.count)
+	start := (h.next - h.count + len(h.samples)) % len(h.samples)
+	for i := 0; i < h.count; i++ {
+		ret = append(ret, h.samples[(start+i)%len(h.samples)])
+	}
+	return ret
+}
+
+// trend returns the derived values, nil before the first sample
+func (h *loadHistory) trend() *LoadTrend {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.count == 0 {
+		return nil
+	}
+	t := &LoadTrend{
+		QueuedReqNumEWMA: h.ewma[0],
+		PromptLengthEWMA: h.ewma[1],
+	}
+	first := h.samples[(h.next-h.count+len(h.samples))%len(h.samples)]
+	last := h.samples[(h.next-1+len(h.samples))%len(h.samples)]
+	if elapsed := time.Duration(last.Time - first.Time).Seconds(); elapsed > 0 {
+		t.QueuedReqNumRate = float64(last.QueuedReqNum-first.QueuedReqNum) / elapsed
+		t.PromptLengthRate = float64(last.PromptLength-first.PromptLength) / elapsed
+	}
+	return t
+}
+
+// cronSampleHistory periodically records the counters of every engine into its history
+func cronSampleHistory(ticker *time.Ticker, stats *LoadStats) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Errorf("load history sampler goroutine panicked: %v", r)
+		}
+		ticker.Stop()
+		logger.Errorf("load history sampler goroutine exited")
+	}()
+
+	for now := range ticker.C {
+		stats.SampleHistory(now.UnixNano())
+	}
+}
+
+// SampleHistory records the current counters of every engine at the given time
+func (ls *LoadStats) SampleHistory(now int64) {
+	ls.RunningModelStats.Range(func(_ string, modelStats *ModelStats) bool {
+		for _, es := range modelStats.ToEngines() {
+			es.sample(now)
+		}
+		return true
+	})
+}
+
+// GetEngineHistory returns the sampled history of an engine
+func (ls *LoadStats) GetEngineHistory(query *EngineHistoryQuery) (*EngineHistory, bool) {
+	modelStats := ls.GetModelStats(query.Cluster)
+	if modelStats == nil {
+		return nil, false
+	}
+	es, ok := modelStats.Engines.Load(query.EngineKey())
+	if !ok {
+		return nil, false
+	}
+	return es.History(), true
+}
+
+// History returns the engine history for the given query
+func History(query *EngineHistoryQuery) (*EngineHistory, bool) {
+	return loadStats.GetEngineHistory(query)
+}
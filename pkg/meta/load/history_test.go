@@ -0,0 +1,100 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package load
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadHistory(t *testing.T) {
+	h := newLoadHistory(3)
+	assert.Empty(t, h.series())
+	assert.Nil(t, h.trend())
+
+	second := int64(time.Second)
+	for i := int64(0); i < 5; i++ {
+		h.add(LoadSample{Time: i * second, QueuedReqNum: int32(i * 2), PromptLength: int32(i * 100)})
+	}
+
+	series := h.series()
+	require.Len(t, series, 3)
+	assert.Equal(t, []int64{2 * second, 3 * second, 4 * second},
+		[]int64{series[0].Time, series[1].Time, series[2].Time}, "oldest samples are overwritten")
+
+	trend := h.trend()
+	require.NotNil(t, trend)
+	assert.InDelta(t, 2.0, trend.QueuedReqNumRate, 1e-9)
+	assert.InDelta(t, 100.0, trend.PromptLengthRate, 1e-9)
+	// alpha = 0.5 over queued 0, 2, 4, 6, 8: 0, 1, 2.5, 4.25, 6.125
+	assert.InDelta(t, 6.125, trend.QueuedReqNumEWMA, 1e-9)
+	assert.InDelta(t, 306.25, trend.PromptLengthEWMA, 1e-9)
+
+	assert.Nil(t, newLoadHistory(0))
+	var disabled *loadHistory
+	assert.Nil(t, disabled.series())
+	assert.Nil(t, disabled.trend())
+}
+
+func TestLoadStats_SampleHistory(t *testing.T) {
+	SetHistoryInterval(time.Second)
+	defer SetHistoryInterval(DefaultHistoryInterval)
+	ls := NewLoadStats()
+	cluster := "history-domain"
+	ip := "192.168.70.1"
+	second := int64(time.Second)
+
+	for i := 0; i < 3; i++ {
+		ls.AddRequest(&InferenceRequest{Cluster: cluster, RequestId: fmt.Sprintf("h-%d", i), PromptLength: 10, Ip: ip})
+		ls.SampleHistory(int64(i) * second)
+	}
+
+	history, ok := ls.GetEngineHistory(&EngineHistoryQuery{Cluster: cluster, Ip: ip})
+	require.True(t, ok)
+	assert.Equal(t, ip, history.Ip)
+	assert.Equal(t, time.Second.Milliseconds(), history.IntervalMs)
+	require.Len(t, history.Samples, 3)
+	assert.Equal(t, int32(3), history.Samples[2].QueuedReqNum)
+	require.NotNil(t, history.Trend)
+	assert.InDelta(t, 1.0, history.Trend.QueuedReqNumRate, 1e-9)
+
+	snapshots := ls.GetModelStats(cluster).Select(&ModelQueryRequest{Cluster: cluster})
+	require.Len(t, snapshots, 1)
+	assert.Equal(t, history.Trend, snapshots[0].Trend)
+
+	_, ok = ls.GetEngineHistory(&EngineHistoryQuery{Cluster: cluster, Ip: "192.168.70.2"})
+	assert.False(t, ok)
+	_, ok = ls.GetEngineHistory(&EngineHistoryQuery{Cluster: "unknown", Ip: ip})
+	assert.False(t, ok)
+}
+
+func TestLoadStats_HistoryDisabled(t *testing.T) {
+	ls := NewLoadStats()
+	cluster := "history-disabled"
+	ip := "192.168.70.3"
+	ls.AddRequest(&InferenceRequest{Cluster: cluster, RequestId: "h-off", PromptLength: 10, Ip: ip})
+	ls.SampleHistory(time.Now().UnixNano())
+
+	es, ok := ls.GetModelStats(cluster).Load(ip)
+	require.True(t, ok)
+	assert.Nil(t, es.history, "no ring buffer is allocated by default")
+	snapshots := ls.GetModelStats(cluster).Select(&ModelQueryRequest{Cluster: cluster})
+	require.Len(t, snapshots, 1)
+	assert.Nil(t, snapshots[0].Trend)
+}
@@ -0,0 +1,235 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package load
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/aigw-project/metadata-center/pkg/prom"
+	"github.com/aigw-project/metadata-center/pkg/utils/logger"
+)
+
+// engineExpectation holds the counters of one engine recomputed from the request table
+type engineExpectation struct {
+	total      *LoadCounter
+	origins    map[string]*LoadCounter
+	models     map[string]*LoadCounter
+	priorities map[string]*LoadCounter
+}
+
+// newEngineExpectation creates an empty engineExpectation
+func newEngineExpectation() *engineExpectation {
+	return &engineExpectation{
+		total:      &LoadCounter{},
+		origins:    make(map[string]*LoadCounter),
+		models:     make(map[string]*LoadCounter),
+		priorities: make(map[string]*LoadCounter),
+	}
+}
+
+// add counts a tracked request, its remaining prompt length is what the engine counter should hold
+func (x *engineExpectation) add(req *InferenceRequest) {
+	promptLength := atomic.LoadInt32(&req.PromptLength)
+	x.total.QueuedReqNum++
+	x.total.PromptLength += promptLength
+	c, ok := x.origins[req.Origin]
+	if !ok {
+		c = &LoadCounter{}
+		x.origins[req.Origin] = c
+	}
+	c.QueuedReqNum++
+	c.PromptLength += promptLength
+	addExpected(x.models, req.Model, promptLength)
+	addExpected(x.priorities, req.Priority, promptLength)
+}
+
+// local returns the expected counters of the partitions an owner counts, its own and the one of requests without origin
+func (x *engineExpectation) local(owner *counterOwner) *LoadCounter {
+	ret := &LoadCounter{}
+	for _, origin := range []string{owner.origin, ""} {
+		if c, ok := x.origins[origin]; ok {
+			ret.QueuedReqNum += c.QueuedReqNum
+			ret.PromptLength += c.PromptLength
+		}
+	}
+	return ret
+}
+
+// addExpected counts a request in the sub-counter of the given key, empty keys are not tracked
+func addExpected(counters map[string]*LoadCounter, key string, promptLength int32) {
+	if key == "" {
+		return
+	}
+	c, ok := counters[key]
+	if !ok {
+		c = &LoadCounter{}
+		counters[key] = c
+	}
+	c.QueuedReqNum++
+	c.PromptLength += promptLength
+}
+
+// InvariantViolation describes an engine whose counters disagree with the request table
+type InvariantViolation struct {
+	Cluster  string
+	Engine   string
+	Live     *LoadCounter
+	Expected *LoadCounter
+	// Counters lists the mismatching counters: queued_req_num, prompt_length, model or priority
+	Counters []string
+	Fixed    bool
+}
+
+// cronCheckInvariants runs the periodic counter invariant check
+func cronCheckInvariants(ticker *time.Ticker, stats *LoadStats) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Errorf("load invariant check goroutine panicked: %v", r)
+		}
+		ticker.Stop()
+		logger.Errorf("load invariant check goroutine exited")
+	}()
+
+	for range ticker.C {
+		start := time.Now()
+		violations := stats.CheckInvariants(invariantFix)
+		logger.Infof("completed load counter invariant check, violations: %d, duration: %d", len(violations), time.Since(start))
+	}
+}
+
+// expectedCounters recomputes engine counters from the matching tracked requests
+// Key: cluster name, then engine key
+func (ls *LoadStats) expectedCounters(match func(req *InferenceRequest) bool) map[string]map[string]*engineExpectation {
+	expected := make(map[string]map[string]*engineExpectation)
+	ls.Requests.Range(func(_ string, req *InferenceRequest) bool {
+		if match != nil && !match(req) {
+			return true
+		}
+		engines, ok := expected[req.Cluster]
+		if !ok {
+			engines = make(map[string]*engineExpectation)
+			expected[req.Cluster] = engines
+		}
+		engine := req.EngineKey()
+		x, ok := engines[engine]
+		if !ok {
+			x = newEngineExpectation()
+			engines[engine] = x
+		}
+		x.add(req)
+		return true
+	})
+	return expected
+}
+
+// CheckInvariants compares every engine's counters with the values recomputed from the request table
+// Engines updated while the table is scanned are skipped, their counters may include requests the scan missed
+// When fix is set, mismatching engines are reset to the recomputed values
+func (ls *LoadStats) CheckInvariants(fix bool) []*InvariantViolation {
+	start := time.Now().UnixNano()
+	expected := ls.expectedCounters(nil)
+	var violations []*InvariantViolation
+	ls.RunningModelStats.Range(func(cluster string, modelStats *ModelStats) bool {
+		for _, es := range modelStats.ToEngines() {
+			if es.GetUpdatedTime() >= start {
+				continue
+			}
+			x, ok := expected[cluster][es.Key()]
+			if !ok {
+				x = newEngineExpectation()
+			}
+			if v := ls.checkEngine(cluster, es, x, fix); v != nil {
+				violations = append(violations, v)
+			}
+		}
+		return true
+	})
+	return violations
+}
+
+// checkEngine compares a single engine with its expectation, nil when they agree
+func (ls *LoadStats) checkEngine(cluster string, es *EngineStats, x *engineExpectation, fix bool) *InvariantViolation {
+	engine := es.Key()
+	live := &LoadCounter{QueuedReqNum: es.GetQueuedReqNum(), PromptLength: es.GetPromptLength()}
+	expected := x.total
+	if c := es.GetCorrection(); c != 0 {
+		// A reconciler correction is an intended offset against the tracked requests
+		expected = &LoadCounter{QueuedReqNum: max(expected.QueuedReqNum+c, 0), PromptLength: expected.PromptLength}
+	}
+	if es.counters != nil {
+		// The partitions of other origins lag behind their requests until pushed, their owners check them
+		live, expected = es.counters.local(), x.local(es.counters.owner)
+	}
+	prom.SetInvariantMismatchMetric(cluster, engine,
+		live.QueuedReqNum-expected.QueuedReqNum, live.PromptLength-expected.PromptLength)
+
+	var counters []string
+	if live.QueuedReqNum != expected.QueuedReqNum {
+		counters = append(counters, counterQueuedReqNum)
+	}
+	if live.PromptLength != expected.PromptLength {
+		counters = append(counters, counterPromptLength)
+	}
+	if !subCountersEqual(es.models.snapshot(), x.models) {
+		counters = append(counters, counterModel)
+	}
+	if !subCountersEqual(es.priorities.snapshot(), x.priorities) {
+		counters = append(counters, counterPriority)
+	}
+	if len(counters) == 0 {
+		return nil
+	}
+
+	v := &InvariantViolation{
+		Cluster:  cluster,
+		Engine:   engine,
+		Live:     live,
+		Expected: expected,
+		Counters: counters,
+	}
+	for _, counter := range counters {
+		prom.InvariantViolationTotal.WithLabelValues(cluster, counter).Inc()
+	}
+	logger.Warnf("invariant: engine %s on model %s counters %v mismatch, live %+v, expected %+v",
+		engine, cluster, counters, *live, *expected)
+	if fix {
+		es.ResetCounters(cluster, x.total, x.origins, x.models, x.priorities)
+		prom.InvariantFixTotal.WithLabelValues(cluster).Inc()
+		v.Fixed = true
+		logger.Infof("invariant: recomputed engine %s on model %s counters to %+v", engine, cluster, *expected)
+	}
+	return v
+}
+
+// subCountersEqual compares per-model or per-priority counters, a missing counter equals a zero one
+// Sub-counters stay at zero once their last request finished, so absence and zero are not distinguished
+func subCountersEqual(live, expected map[string]*LoadCounter) bool {
+	for model, c := range live {
+		if x, ok := expected[model]; ok {
+			if *c != *x {
+				return false
+			}
+		} else if c.QueuedReqNum != 0 || c.PromptLength != 0 {
+			return false
+		}
+	}
+	for model, x := range expected {
+		if _, ok := live[model]; !ok && (x.QueuedReqNum != 0 || x.PromptLength != 0) {
+			return false
+		}
+	}
+	return true
+}
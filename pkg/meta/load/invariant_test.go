@@ -0,0 +1,137 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package load
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddNonNegative(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    int32
+		delta    int32
+		expected int32
+		clamped  bool
+	}{
+		{"increment", 1, 2, 3, false},
+		{"decrement", 3, -2, 1, false},
+		{"decrement to zero", 2, -2, 0, false},
+		{"decrement below zero", 1, -3, 0, true},
+		{"increment negative value", -2, 1, -1, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			v := tc.value
+			assert.Equal(t, tc.clamped, addNonNegative(&v, tc.delta))
+			assert.Equal(t, tc.expected, v)
+		})
+	}
+}
+
+func TestEngineStats_ClampNegative(t *testing.T) {
+	es := NewEngineLoadStats("192.168.60.1")
+	req := &InferenceRequest{Cluster: "clamp-domain", RequestId: "clamp-1", PromptLength: 100, Model: "m"}
+
+	es.DecrementQueuedReqNum(req)
+	es.DecrementPromptLength(req)
+
+	assert.Equal(t, int32(0), es.GetQueuedReqNum())
+	assert.Equal(t, int32(0), es.GetPromptLength())
+	c, ok := es.GetModelCounter("m")
+	require.True(t, ok)
+	assert.Equal(t, LoadCounter{}, *c)
+}
+
+func TestLoadStats_CheckInvariants(t *testing.T) {
+	ls := NewLoadStats()
+	cluster := "invariant-domain"
+	ip := "192.168.61.1"
+	for i := 0; i < 3; i++ {
+		ls.AddRequest(&InferenceRequest{
+			Cluster:      cluster,
+			RequestId:    fmt.Sprintf("inv-%d", i),
+			PromptLength: 100,
+			Ip:           ip,
+			Model:        "m",
+			Priority:     "interactive",
+		})
+	}
+	ls.AddRequest(&InferenceRequest{Cluster: cluster, RequestId: "inv-other", PromptLength: 10, Ip: "192.168.61.2"})
+	ls.DeletePromptLength(newDeletionInferenceRequest("inv-0"))
+	ls.DeleteRequest(newDeletionInferenceRequest("inv-other"))
+
+	require.Empty(t, ls.CheckInvariants(false), "counters maintained by the request path must agree")
+
+	es, ok := ls.GetModelStats(cluster).Load(ip)
+	require.True(t, ok)
+
+	t.Run("report without fix", func(t *testing.T) {
+		atomic.StoreInt32(&es.QueuedReqNum, 5)
+		es.UpdatedTime = 0
+
+		violations := ls.CheckInvariants(false)
+		require.Len(t, violations, 1)
+		v := violations[0]
+		assert.Equal(t, cluster, v.Cluster)
+		assert.Equal(t, ip, v.Engine)
+		assert.Equal(t, []string{counterQueuedReqNum}, v.Counters)
+		assert.Equal(t, int32(5), v.Live.QueuedReqNum)
+		assert.Equal(t, int32(3), v.Expected.QueuedReqNum)
+		assert.False(t, v.Fixed)
+		assert.Equal(t, int32(5), es.GetQueuedReqNum())
+	})
+
+	t.Run("skip engines updated during the check", func(t *testing.T) {
+		es.UpdatedTime = 1 << 62
+		assert.Empty(t, ls.CheckInvariants(true))
+		assert.Equal(t, int32(5), es.GetQueuedReqNum())
+	})
+
+	t.Run("fix", func(t *testing.T) {
+		// Prompt length without a request behind it, and stale model and priority counters
+		es.IncrementQueuedReqNumAndPromptLength(&InferenceRequest{Cluster: cluster, Model: "stale", Priority: "batch"}, 50)
+		es.UpdatedTime = 0
+
+		violations := ls.CheckInvariants(true)
+		require.Len(t, violations, 1)
+		assert.Equal(t, []string{counterQueuedReqNum, counterPromptLength, counterModel, counterPriority},
+			violations[0].Counters)
+		assert.True(t, violations[0].Fixed)
+		assert.Equal(t, int32(3), es.GetQueuedReqNum())
+		assert.Equal(t, int32(200), es.GetPromptLength())
+
+		es.UpdatedTime = 0
+		assert.Empty(t, ls.CheckInvariants(false))
+	})
+
+	t.Run("engine without requests", func(t *testing.T) {
+		other, ok := ls.GetModelStats(cluster).Load("192.168.61.2")
+		require.True(t, ok)
+		// Drift without a correction offset, as left by a lost event
+		atomic.StoreInt32(&other.QueuedReqNum, 2)
+		other.UpdatedTime = 0
+
+		violations := ls.CheckInvariants(true)
+		require.Len(t, violations, 1)
+		assert.Equal(t, int32(0), violations[0].Expected.QueuedReqNum)
+		assert.Equal(t, int32(0), other.GetQueuedReqNum())
+	})
+}
@@ -0,0 +1,131 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package load
+
+import (
+	"math"
+	"slices"
+	"sync"
+)
+
+// LatencyPercentiles holds rolling percentiles of one latency, in milliseconds
+type LatencyPercentiles struct {
+	Count int     `json:"count"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P99   float64 `json:"p99"`
+}
+
+// LatencyStats holds the rolling request latencies of an engine
+// TTFT is measured from the add event to the prompt deletion, Duration from the add event to the deletion
+// TTFTPerToken is TTFT divided by the prompt length, only for requests that sent one
+type LatencyStats struct {
+	TTFT         *LatencyPercentiles `json:"ttft_ms,omitempty"`
+	TTFTPerToken *LatencyPercentiles `json:"ttft_per_token_ms,omitempty"`
+	Duration     *LatencyPercentiles `json:"duration_ms,omitempty"`
+}
+
+// latencyWindow is a ring buffer of the most recent latency values
+type latencyWindow struct {
+	values []float64
+	next   int
+	count  int
+}
+
+// add appends a value, overwriting the oldest one when the window is full
+func (w *latencyWindow) add(v float64) {
+	w.values[w.next] = v
+	w.next = (w.next + 1) % len(w.values)
+	w.count = min(w.count+1, len(w.values))
+}
+
+// percentiles returns nearest-rank percentiles of the window, nil when empty
+func (w *latencyWindow) percentiles() *LatencyPercentiles {
+	if w.count == 0 {
+		return nil
+	}
+	sorted := slices.Clone(w.values[:w.count])
+	slices.Sort(sorted)
+	rank := func(p float64) float64 {
+		i := int(math.Ceil(p*float64(len(sorted)))) - 1
+		return sorted[max(i, 0)]
+	}
+	return &LatencyPercentiles{
+		Count: w.count,
+		P50:   rank(0.5),
+		P90:   rank(0.9),
+		P99:   rank(0.99),
+	}
+}
+
+// latencyTracker keeps rolling TTFT and end-to-end latency windows of an engine
+type latencyTracker struct {
+	mu           sync.Mutex
+	ttft         latencyWindow
+	ttftPerToken latencyWindow
+	duration     latencyWindow
+}
+
+// newLatencyTracker creates a tracker keeping up to size values per latency, nil when size is not positive
+func newLatencyTracker(size int) *latencyTracker {
+	if size <= 0 {
+		return nil
+	}
+	return &latencyTracker{
+		ttft:         latencyWindow{values: make([]float64, size)},
+		ttftPerToken: latencyWindow{values: make([]float64, size)},
+		duration:     latencyWindow{values: make([]float64, size)},
+	}
+}
+
+// observeTTFT records a time-to-first-token value and its per prompt token value
+func (t *latencyTracker) observeTTFT(ms float64, promptLength int32) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.ttft.add(ms)
+	if promptLength > 0 {
+		t.ttftPerToken.add(ms / float64(promptLength))
+	}
+}
+
+// observeDuration records an end-to-end duration value
+func (t *latencyTracker) observeDuration(ms float64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.duration.add(ms)
+}
+
+// snapshot returns the current percentiles, nil before the first observation
+func (t *latencyTracker) snapshot() *LatencyStats {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.ttft.count == 0 && t.duration.count == 0 {
+		return nil
+	}
+	return &LatencyStats{
+		TTFT:         t.ttft.percentiles(),
+		TTFTPerToken: t.ttftPerToken.percentiles(),
+		Duration:     t.duration.percentiles(),
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package load
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatencyWindow_Percentiles(t *testing.T) {
+	w := latencyWindow{values: make([]float64, 100)}
+	assert.Nil(t, w.percentiles())
+
+	w.add(5)
+	assert.Equal(t, &LatencyPercentiles{Count: 1, P50: 5, P90: 5, P99: 5}, w.percentiles())
+
+	// 1..150 into a window of 100 keeps 51..150
+	w = latencyWindow{values: make([]float64, 100)}
+	for i := 150; i >= 1; i-- {
+		w.add(float64(151 - i))
+	}
+	assert.Equal(t, &LatencyPercentiles{Count: 100, P50: 100, P90: 140, P99: 149}, w.percentiles())
+}
+
+func TestLoadStats_RequestLatency(t *testing.T) {
+	ls := NewLoadStats()
+	cluster := "latency-domain"
+	ip := "192.168.80.1"
+
+	start := func(id string, age time.Duration) *InferenceRequest {
+		req := &InferenceRequest{Cluster: cluster, RequestId: id, PromptLength: 10, Ip: ip}
+		ls.AddRequest(req)
+		req.CreateTime = req.CreateTime.Add(-age)
+		return req
+	}
+	for i := 0; i < 4; i++ {
+		start(fmt.Sprintf("lat-%d", i), time.Duration(i+1)*100*time.Millisecond)
+		ls.DeletePromptLength(newDeletionInferenceRequest(fmt.Sprintf("lat-%d", i)))
+	}
+	// A second prompt deletion is not a new first token
+	ls.DeletePromptLength(newDeletionInferenceRequest("lat-0"))
+	ls.DeleteRequest(newDeletionInferenceRequest("lat-0"))
+	ls.DeleteRequest(newDeletionInferenceRequest("lat-1"))
+	// Forced removals are not completions
+	start("lat-evicted", time.Second)
+	ls.EvictRequest(&EvictRequest{RequestId: "lat-evicted"}, AuditSourceAPI)
+	// Requests accepted by a peer are observed by that peer only
+	ls.AddRequest(&InferenceRequest{Cluster: cluster, RequestId: "lat-peer", PromptLength: 10, Ip: ip, Origin: "10.1.0.9"})
+	ls.DeletePromptLength(newDeletionInferenceRequest("lat-peer"))
+	ls.DeleteRequest(newDeletionInferenceRequest("lat-peer"))
+
+	es, ok := ls.GetModelStats(cluster).Load(ip)
+	require.True(t, ok)
+	latency := es.Snapshot().Latency
+	require.NotNil(t, latency)
+
+	require.NotNil(t, latency.TTFT)
+	assert.Equal(t, 4, latency.TTFT.Count)
+	assert.InDelta(t, 200, latency.TTFT.P50, 50)
+	assert.InDelta(t, 400, latency.TTFT.P99, 50)
+
+	require.NotNil(t, latency.TTFTPerToken)
+	assert.InDelta(t, 20, latency.TTFTPerToken.P50, 5)
+
+	require.NotNil(t, latency.Duration)
+	assert.Equal(t, 2, latency.Duration.Count)
+	assert.InDelta(t, 100, latency.Duration.P50, 50)
+	assert.InDelta(t, 200, latency.Duration.P90, 50)
+}
+
+func TestLatencyTracker_Disabled(t *testing.T) {
+	tracker := newLatencyTracker(0)
+	assert.Nil(t, tracker)
+	tracker.observeTTFT(1, 1)
+	tracker.observeDuration(1)
+	assert.Nil(t, tracker.snapshot())
+
+	// Requests whose engine is gone still feed the histograms
+	var es *EngineStats
+	req := &InferenceRequest{Cluster: "latency-domain", CreateTime: time.Now()}
+	es.observeTTFT(req, time.Now(), 1)
+	es.observeDuration(req, time.Now())
+}
@@ -15,42 +15,244 @@
 package load
 
 import (
+	"net"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/aigw-project/metadata-center/pkg/utils/errors"
 	"github.com/aigw-project/metadata-center/pkg/utils/logger"
 )
 
+// Blend modes for combining estimated and engine-reported load in query responses
+const (
+	// BlendEstimated returns gateway-derived counters only
+	BlendEstimated = "estimated"
+	// BlendReported prefers a fresh engine report over gateway-derived counters
+	BlendReported = "reported"
+	// BlendMax returns the larger of the estimated and fresh reported values
+	BlendMax = "max"
+)
+
 // ModelQueryRequest represents a query request for model statistics
 type ModelQueryRequest struct {
-	Cluster string `json:"cluster" binding:"required" form:"cluster"`
+	Cluster string `json:"cluster" binding:"required,excludes=/" form:"cluster"`
+	Model   string `json:"model,omitempty" form:"model"`
+	Blend   string `json:"blend,omitempty" binding:"omitempty,oneof=estimated reported max" form:"blend"`
+	// Scorer adds the score of each engine to the snapshots, lower is better
+	Scorer string `json:"scorer,omitempty" form:"scorer"`
+}
+
+// MultiClusterQueryRequest represents a query for the statistics of several clusters in one call
+// Exactly one of Clusters and Pattern is required, Pattern is a glob such as qwen-* matched against cluster names
+type MultiClusterQueryRequest struct {
+	Clusters []string `json:"clusters,omitempty" binding:"omitempty,dive,excludes=/" form:"clusters"`
+	Pattern  string   `json:"pattern,omitempty" binding:"mutually_exclusive=Clusters,omitempty,glob,excludes=/" form:"pattern"`
+	Model    string   `json:"model,omitempty" form:"model"`
+	Blend    string   `json:"blend,omitempty" binding:"omitempty,oneof=estimated reported max" form:"blend"`
+}
+
+// ClusterNames returns the requested cluster names, comma separated values are split
+func (r *MultiClusterQueryRequest) ClusterNames() []string {
+	var names []string
+	for _, value := range r.Clusters {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// ClustersSnapshot holds engine snapshots grouped per cluster, all taken at SnapshotTime
+type ClustersSnapshot struct {
+	SnapshotTime int64                        `json:"snapshot_time"`
+	Clusters     map[string][]*EngineSnapshot `json:"clusters"`
 }
 
 // InferenceRequest represents an inference request with load metrics
+// The engine is identified by Endpoint (host:port) when given, otherwise by Ip
+// Model is optional and breaks engine load down when one engine pool serves several models
+// Priority is an optional class such as interactive or batch, tracked as a per-engine sub-counter as well
+// Only the configured classes are accepted, each one creates counters and metric series
+// Models are bounded per engine by METADATA_CENTER_LOAD_MAX_ENGINE_MODELS for the same reason
 type InferenceRequest struct {
-	Cluster      string    `json:"cluster" binding:"required" form:"cluster"`
-	RequestId    string    `json:"request_id" binding:"required" form:"request_id"`
-	PromptLength int32     `json:"prompt_length,omitempty" binding:"gte=0"`
-	Ip           string    `json:"ip" binding:"required,ipv4" form:"ip"`
-	TimeStamp    int64     `json:"timestamp,omitempty" form:"timestamp"`
-	CreateTime   time.Time `json:"-"`
+	Cluster      string `json:"cluster" binding:"required,excludes=/" form:"cluster"`
+	RequestId    string `json:"request_id" binding:"required,excludes=/" form:"request_id"`
+	PromptLength int32  `json:"prompt_length,omitempty" binding:"gte=0"`
+	Ip           string `json:"ip,omitempty" binding:"required_without=Endpoint,omitempty,ip" form:"ip"`
+	Endpoint     string `json:"endpoint,omitempty" binding:"required_without=Ip,omitempty,ip_port" form:"endpoint"`
+	Model        string `json:"model,omitempty" binding:"omitempty,max=128" form:"model"`
+	Priority     string `json:"priority,omitempty" binding:"omitempty,max=32" form:"priority"`
+	TimeStamp    int64  `json:"timestamp,omitempty" form:"timestamp"`
+	// Origin is the instance that accepted the request, set by that instance and kept by replicas
+	Origin     string    `json:"origin,omitempty"`
+	CreateTime time.Time `json:"-"`
+	// firstTokenTime is set by the first prompt length deletion, which marks the first token
+	firstTokenTime int64
+}
+
+// EngineKey returns the engine identity of the request
+func (r *InferenceRequest) EngineKey() string {
+	return engineKey(r.Ip, r.Endpoint)
+}
+
+// acceptedHere reports whether this instance accepted the request, requests of peers predating origins have none
+// Only the accepting instance observes the latency of a request, measured from its own receive time,
+// so each request is counted once across the fleet and replication delay does not skew the values
+func (r *InferenceRequest) acceptedHere() bool {
+	return r.Origin == "" || r.Origin == origin
+}
+
+// markFirstToken records the first token time, returns false if it was already recorded
+func (r *InferenceRequest) markFirstToken(now time.Time) bool {
+	return atomic.CompareAndSwapInt64(&r.firstTokenTime, 0, now.UnixNano())
 }
 
 // DeletionInferenceRequest represents an inference request for deletion
 type DeletionInferenceRequest struct {
-	RequestId string `json:"request_id" binding:"required" form:"request_id"`
+	RequestId string `json:"request_id" binding:"required,excludes=/" form:"request_id"`
 	TimeStamp int64  `json:"timestamp,omitempty" form:"timestamp"`
 }
 
-var loadStats *LoadStats
+// EngineReport represents an authoritative load snapshot pushed by an engine or its sidecar
+// ReportedTime is set by the instance receiving the report from the engine, replicas keep the original value
+type EngineReport struct {
+	Cluster       string  `json:"cluster" binding:"required,excludes=/"`
+	Ip            string  `json:"ip,omitempty" binding:"required_without=Endpoint,omitempty,ip"`
+	Endpoint      string  `json:"endpoint,omitempty" binding:"required_without=Ip,omitempty,ip_port"`
+	RunningReqNum int32   `json:"running_req_num" binding:"gte=0"`
+	WaitingReqNum int32   `json:"waiting_req_num" binding:"gte=0"`
+	KVCacheUsage  float64 `json:"kv_cache_usage" binding:"gte=0,lte=1"`
+	TimeStamp     int64   `json:"timestamp,omitempty"`
+	ReportedTime  int64   `json:"reported_time"`
+}
+
+// EngineKey returns the engine identity of the report
+func (r *EngineReport) EngineKey() string {
+	return engineKey(r.Ip, r.Endpoint)
+}
+
+// QueuedReqNum returns the engine-side equivalent of EngineStats.QueuedReqNum
+func (r *EngineReport) QueuedReqNum() int32 {
+	return r.RunningReqNum + r.WaitingReqNum
+}
+
+// IsFresh reports whether the report is recent enough to be used for blending
+func (r *EngineReport) IsFresh(now int64) bool {
+	return r != nil && now-r.ReportedTime <= int64(reportTTL)
+}
+
+// engineKey returns the engine identity, the host:port endpoint when given, otherwise the IP
+// Old clients only send an IPv4 address, so the IP alone stays a valid identity
+// IP addresses are canonicalized so different IPv6 spellings map to the same engine
+func engineKey(ip, endpoint string) string {
+	if endpoint != "" {
+		host, port, err := net.SplitHostPort(endpoint)
+		if err != nil {
+			return endpoint
+		}
+		if parsed := net.ParseIP(host); parsed != nil {
+			host = parsed.String()
+		}
+		return net.JoinHostPort(host, port)
+	}
+	if parsed := net.ParseIP(ip); parsed != nil {
+		return parsed.String()
+	}
+	return ip
+}
+
+var (
+	loadStats *LoadStats
+	// backend serves the load API, the in-memory one wraps loadStats
+	backend Backend
+	// reservations decides engine slot reservations, through the consensus log in consensus mode
+	reservations reserver
+	// fed serves the clusters of other regions, nil unless federation is configured
+	fed *federation
+)
 
 // Init initializes the load statistics system
-func Init() {
-	loadStats = NewLoadStats()
-	go func() {
-		ticker := time.NewTicker(gcInterval)
-		cronClean(ticker, loadStats)
-	}()
+// Returns an error when the configured settings cannot be served by the configured backend
+func Init() error {
+	if err := checkSharedSettings(); err != nil {
+		return err
+	}
+	resolveOrigin()
+	loadStats = newInstanceStats()
+	backend = newBackend(loadStats)
+	if backend.Shared() {
+		logger.Infof("load statistics are stored in %s at %s with prefix %s", backendKind, redisAddr, redisPrefix)
+	} else if walDir != "" {
+		initWAL()
+	}
+	if loadStats.owner != nil {
+		initCounterPartitions()
+	}
+	if federationRemotes != "" {
+		initFederation()
+	}
+	if originGrace > 0 && !backend.Shared() {
+		go cronFailOrigins(time.NewTicker(originCheckInterval), loadStats)
+	}
+	reservations = localReserver{ls: loadStats}
+	if raftID != "" && !backend.Shared() {
+		if err := initRaft(); err != nil {
+			return err
+		}
+	}
+	go cronClean(time.NewTicker(gcInterval), loadStats)
+	if expireInterval > 0 {
+		go cronExpire(time.NewTicker(expireInterval), backend)
+		go cronExpireReservations(time.NewTicker(expireInterval), reservations)
+	} else {
+		go cronExpireReservations(time.NewTicker(gcInterval), reservations)
+	}
+	if reconcileInterval > 0 {
+		go cronReconcile(time.NewTicker(reconcileInterval), NewReconciler(loadStats))
+		if reconcileCorrect && !reconcileCorrects() {
+			logger.Warnf("reconcile correction disabled, counters are fixed by the invariant checker")
+		}
+		logger.Infof("engine metrics reconciliation enabled, interval: %s, correct: %t", reconcileInterval, reconcileCorrects())
+	}
+	if historyInterval > 0 && historySize > 0 {
+		go cronSampleHistory(time.NewTicker(historyInterval), loadStats)
+	}
+	if invariantCheckInterval > 0 {
+		go cronCheckInvariants(time.NewTicker(invariantCheckInterval), loadStats)
+		logger.Infof("load counter invariant check enabled, interval: %s, fix: %t", invariantCheckInterval, invariantFix)
+	}
 	logger.Infof("initializing metadata: load process")
+	return nil
+}
+
+// newInstanceStats creates the statistics of this instance, with counter partitions in crdt mode
+func newInstanceStats() *LoadStats {
+	ls := NewLoadStats()
+	if replicationMode == ReplicationCRDT && backendKind != BackendRedis {
+		ls.enableCounterPartitions(origin)
+	}
+	return ls
+}
+
+// initWAL restores the state from walDir and starts logging to it
+// When the state cannot be restored the instance starts empty without persistence, leaving the files for inspection
+func initWAL() {
+	if err := loadStats.restore(walDir); err != nil {
+		logger.Errorf("failed to restore load statistics from %s, persistence disabled: %v", walDir, err)
+		loadStats = newInstanceStats()
+		backend = newBackend(loadStats)
+		return
+	}
+	if walSync == WALSyncInterval && walSyncInterval > 0 {
+		go cronSyncWAL(time.NewTicker(walSyncInterval), loadStats.wal)
+	}
+	if snapshotInterval > 0 {
+		go cronSnapshot(time.NewTicker(snapshotInterval), loadStats)
+	}
+	logger.Infof("load statistics persisted to %s, wal sync: %s, snapshot interval: %s", walDir, walSync, snapshotInterval)
 }
 
 // cronClean runs periodic garbage collection for load statistics
@@ -70,22 +272,98 @@ func cronClean(ticker *time.Ticker, stats *LoadStats) {
 	}
 }
 
-// Query retrieves model statistics for the given cluster
+// Query retrieves the in-memory model statistics for the given cluster
 func Query(req *ModelQueryRequest) *ModelStats {
 	return loadStats.GetModelStats(req.Cluster)
 }
 
-// Set adds a new inference request to load statistics
-func Set(req *InferenceRequest) {
-	loadStats.AddRequest(req)
+// Select returns the engine snapshots of the queried cluster from the backend, scored when a scorer is requested
+func Select(req *ModelQueryRequest) ([]*EngineSnapshot, error) {
+	snapshots, err := backend.Snapshots(req.Cluster, req.Model, req.Blend, time.Now().UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	if scorer, ok := GetScorer(req.Scorer); ok {
+		applyScores(scorer, snapshots)
+	}
+	return snapshots, nil
+}
+
+// QueryClusters retrieves statistics for several clusters with a single snapshot time
+func QueryClusters(req *MultiClusterQueryRequest) (*ClustersSnapshot, error) {
+	return selectClusters(backend, req)
+}
+
+// Report stores an engine-reported load snapshot
+func Report(report *EngineReport) error {
+	return backend.SetEngineReport(report)
+}
+
+// CheckPriority rejects priority classes that are not configured
+func CheckPriority(priority string) error {
+	if _, ok := priorityClasses[priority]; priority != "" && !ok {
+		return errors.InvalidInput("unknown priority class %s", priority)
+	}
+	return nil
+}
+
+// CheckModel rejects models beyond the configured number of models per engine
+func CheckModel(cluster, engine, model string) error {
+	return loadStats.CheckModel(cluster, engine, model)
+}
+
+// Set adds a new inference request to load statistics, accepted by this instance
+func Set(req *InferenceRequest) error {
+	req.Origin = origin
+	return backend.AddRequest(req)
 }
 
 // Delete removes an inference request from load statistics
-func Delete(req *DeletionInferenceRequest) {
-	loadStats.DeleteRequest(req)
+func Delete(req *DeletionInferenceRequest) error {
+	return backend.DeleteRequest(req)
 }
 
 // PromptDelete removes prompt length from statistics
-func PromptDelete(req *DeletionInferenceRequest) {
-	loadStats.DeletePromptLength(req)
+func PromptDelete(req *DeletionInferenceRequest) error {
+	return backend.DeletePromptLength(req)
+}
+
+// Reserve adds the request if its engine holds fewer than req.Limit reservations
+// In consensus mode a follower returns a NotLeaderError, the call is to be forwarded to the leader
+func Reserve(req *ReservationRequest) error {
+	if backend.Shared() {
+		return errors.InvalidInput("reservations are not supported by the %s backend", backendKind)
+	}
+	if err := fed.checkWritable(req.Cluster); err != nil {
+		return err
+	}
+	req.Origin = origin
+	return reservations.reserve(req)
+}
+
+// Release removes a reservation along with its request
+func Release(req *DeletionInferenceRequest) error {
+	if backend.Shared() {
+		return errors.InvalidInput("reservations are not supported by the %s backend", backendKind)
+	}
+	return reservations.release(req)
+}
+
+// Consensus reports whether reservations are decided by the consensus log
+// The reservations then need no replication, only their requests are replicated like those added by Set
+func Consensus() bool {
+	return reservations.replicated()
+}
+
+// CheckAdmin rejects admin calls when the backend is shared, they inspect and correct the in-memory state only
+func CheckAdmin() error {
+	if backend != nil && backend.Shared() {
+		return errors.InvalidInput("admin endpoints are unsupported with the shared %s backend", backendKind)
+	}
+	return nil
+}
+
+// Shared reports whether the backend is shared by all instances, load updates then need no replication
+func Shared() bool {
+	return backend.Shared()
 }
@@ -16,190 +16,315 @@ package load
 
 import (
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/aigw-project/metadata-center/pkg/prom"
+	"github.com/aigw-project/metadata-center/pkg/utils/errors"
 	"github.com/aigw-project/metadata-center/pkg/utils/logger"
 )
 
 // LoadStats holds all load statistics
 type LoadStats struct {
-	// RunningModelStats stores detailed load information for each model
-	// Key: Model index using {backend_type}_{model_name} format
-	// Value: ModelStats data for the corresponding model
-	RunningModelStats sync.Map
+	// RunningModelStats stores detailed load information for each cluster
+	// Key: Cluster name, models served by a cluster are tracked as engine sub-counters
+	// Value: ModelStats data for the corresponding cluster
+	RunningModelStats store[*ModelStats]
 	// Requests stores detailed information for each request
 	// Key: RequestID
 	// Value: Request details
-	Requests sync.Map
+	Requests store[*InferenceRequest]
+	// tenants holds the usage of each tenant, keyed by tenant name
+	tenants sync.Map
+	// limits holds the quota overrides set at runtime, keyed by tenant name
+	limits sync.Map
+	// requestCount and clusterCount are the sizes of Requests and RunningModelStats, checked against the caps
+	requestCount atomic.Int64
+	clusterCount atomic.Int64
+	// expiry indexes the tracked requests oldest first, for expiry and capacity eviction
+	expiry expiryIndex
+	// clusterLRU indexes all clusters least recently used first, clusterLRUs those of each tenant, for capacity eviction
+	clusterLRU  lruIndex
+	clusterLRUs sync.Map
+	// tombstones holds the deletes that arrived before their request was added
+	tombstones tombstoneTable
+	// reservations holds the accepted engine slot reservations, only the consensus log changes them in consensus mode
+	reservations reservationTable
+	// consensus is the consensus node deciding the reservations, nil unless in consensus mode
+	consensus atomic.Pointer[raftNode]
+	// wal logs the request events for crash recovery, nil when disabled
+	wal *wal
+	// originTracker records the origins of tracked requests missing from service discovery
+	originTracker originTracker
+	// origins indexes the tracked requests by origin
+	origins originIndex
+	// owner is the origin owning a partition of the engine counters, nil unless in crdt mode
+	owner *counterOwner
 }
 
 // NewLoadStats creates a new LoadStats instance
 func NewLoadStats() *LoadStats {
-	return &LoadStats{}
+	return &LoadStats{
+		RunningModelStats: newStore[*ModelStats](storageShards),
+		Requests:          newStore[*InferenceRequest](storageShards),
+	}
 }
 
 // GetModelStats retrieves model statistics for the given key
 func (ls *LoadStats) GetModelStats(key string) *ModelStats {
-	v, ok := ls.RunningModelStats.Load(key)
+	modelStats, _ := ls.RunningModelStats.Load(key)
+	return modelStats
+}
+
+// CheckModel rejects a model the engine does not track yet once it tracks the configured number of models
+// Concurrent requests with new models may exceed the limit by a few, which still bounds the series per engine
+func (ls *LoadStats) CheckModel(cluster, engine, model string) error {
+	if model == "" || maxEngineModels <= 0 {
+		return nil
+	}
+	modelStats := ls.GetModelStats(cluster)
+	if modelStats == nil {
+		return nil
+	}
+	es, ok := modelStats.Load(engine)
 	if !ok {
 		return nil
 	}
-	return v.(*ModelStats)
+	if _, ok := es.models.load(model); ok {
+		return nil
+	}
+	if n := es.models.size(); n >= maxEngineModels {
+		return errors.InvalidInput("engine %s already tracks %d models, model %s rejected", engine, n, model)
+	}
+	return nil
+}
+
+// SelectClusters returns engine snapshots grouped per cluster for the given names or pattern
+// Listed clusters without statistics are returned with no engines, a pattern only returns existing clusters
+func (ls *LoadStats) SelectClusters(req *MultiClusterQueryRequest) *ClustersSnapshot {
+	// The in-memory backend never fails
+	ret, _ := selectClusters(memoryBackend{ls: ls}, req)
+	return ret
 }
 
 // AddRequest adds a new inference request to load statistics
 func (ls *LoadStats) AddRequest(req *InferenceRequest) {
-	req.CreateTime = time.Now()
+	now := time.Now()
 	prom.SetReplicationLatencyMillisecond(req.TimeStamp, req.RequestId)
+	ls.wal.append(LoadStatsSet, now, req)
+	ls.addRequest(req, now)
+}
+
+// addRequest adds an inference request created at the given time
+func (ls *LoadStats) addRequest(req *InferenceRequest, created time.Time) {
+	req.CreateTime = created
+	if ls.matchTombstone(req) {
+		return
+	}
 	promptLength := req.PromptLength
 	_, loaded := ls.Requests.LoadOrStore(req.RequestId, req)
 	if loaded {
 		logger.Infof("reqID [%s]: request ID already exists, ignoring add action", req.RequestId)
 		return
 	}
-	key := req.Cluster
-	v, loaded := ls.RunningModelStats.Load(key)
+	ls.trackRequests(req.Cluster, 1)
+	ls.origins.add(req)
+	ls.expiry.push(req, ls.requestCount.Load(), ls.isTracked)
+	ls.makeRequestRoom()
+	engineStats := ls.loadOrStoreEngine(req.Cluster, req.EngineKey())
+	engineStats.requests.Store(req.RequestId, req)
+	engineStats.IncrementQueuedReqNumAndPromptLength(req, promptLength)
+	// A delete that missed the request while it was added left a tombstone
+	ls.applyTombstone(req.RequestId)
+}
+
+// loadOrStoreEngine returns the statistics of an engine, creating its cluster and itself if needed
+// Caps are enforced before anything is created, by evicting the oldest cluster or engine
+func (ls *LoadStats) loadOrStoreEngine(cluster, engine string) *EngineStats {
+	modelStats, loaded := ls.RunningModelStats.Load(cluster)
 	if !loaded {
-		v, loaded = ls.RunningModelStats.LoadOrStore(key, NewModelStats(key))
-		if loaded {
-			logger.Infof("reqID [%s]: added new model stats %s", req.RequestId, key)
+		ls.makeClusterRoom(cluster)
+		created := NewModelStats(cluster)
+		created.owner = ls.owner
+		modelStats, loaded = ls.RunningModelStats.LoadOrStore(cluster, created)
+		if !loaded {
+			ls.trackClusters(cluster, 1)
+			logger.Infof("added new model stats %s", cluster)
 		}
 	}
-	modelStats := v.(*ModelStats)
-	engineStats := modelStats.LoadOrStore(req.Ip)
-	engineStats.IncrementQueuedReqNumAndPromptLength(req, promptLength)
+	if _, ok := modelStats.Engines.Load(engine); !ok {
+		ls.makeEngineRoom(cluster, modelStats, engine)
+	}
+	engineStats := modelStats.LoadOrStore(engine)
+	ls.touchCluster(cluster, modelStats, engine)
+	return engineStats
+}
+
+// SetEngineReport stores an engine-reported load snapshot next to the estimated counters
+func (ls *LoadStats) SetEngineReport(report *EngineReport) {
+	if report.ReportedTime == 0 {
+		report.ReportedTime = time.Now().UnixNano()
+	}
+	engineStats := ls.loadOrStoreEngine(report.Cluster, report.EngineKey())
+	engineStats.SetReport(report)
+	logger.Debugf("engine %s on model %s reported running %d, waiting %d, kv cache usage %.2f",
+		report.EngineKey(), report.Cluster, report.RunningReqNum, report.WaitingReqNum, report.KVCacheUsage)
 }
 
 // DeleteRequest removes an inference request from load statistics
 func (ls *LoadStats) DeleteRequest(req *DeletionInferenceRequest) {
-	requestID := req.RequestId
-	prom.SetReplicationLatencyMillisecond(req.TimeStamp, requestID)
-	// Do delete first, skip delay if successful
-	if ls.tryDeleteRequestStats(requestID) {
+	now := time.Now()
+	prom.SetReplicationLatencyMillisecond(req.TimeStamp, req.RequestId)
+	ls.wal.append(LoadStatsDelete, now, req)
+	ls.deleteRequest(req.RequestId, now)
+}
+
+// deleteRequest removes an inference request completed at the given time
+func (ls *LoadStats) deleteRequest(requestID string, now time.Time) {
+	if ls.tryDeleteRequestStats(requestID, now, true) {
 		return
 	}
 
-	// Delayed retry: handle cases where Delete occurs before Add in concurrent scenarios
-	logger.Infof("reqID [%s]: request ID not found, delaying request deletion", requestID)
-	time.AfterFunc(time.Second, func() {
-		if !ls.tryDeleteRequestStats(requestID) {
-			logger.Warnf("reqID [%s]: request ID still not found after delay, statistics may be inaccurate", requestID)
-			return
-		}
-		logger.Infof("reqID [%s]: delayed request deletion completed", requestID)
-	})
+	// Delete before Add, e.g. replication reordering: the add cancels out against the tombstone
+	ls.storeTombstone(requestID, tombstoneRequest)
 }
 
 // tryDeleteRequestStats attempts to delete request statistics
-func (ls *LoadStats) tryDeleteRequestStats(requestID string) bool {
-	if v, ok := ls.Requests.LoadAndDelete(requestID); ok && v != nil {
-		ls.decEngineStats(v.(*InferenceRequest))
+// completed marks a request finished at now whose end-to-end duration is recorded, as opposed to a forced removal
+func (ls *LoadStats) tryDeleteRequestStats(requestID string, now time.Time, completed bool) bool {
+	if req, ok := ls.Requests.LoadAndDelete(requestID); ok && req != nil {
+		ls.untrackRequest(req)
+		engineStats := ls.decEngineStats(req)
+		if completed {
+			engineStats.observeDuration(req, now)
+		}
 		return true
 	}
 
 	return false
 }
 
+// untrackRequest accounts for a request removed from Requests, a reservation of the request is released with it
+// In consensus mode the release goes through the log, proposed by the leader
+func (ls *LoadStats) untrackRequest(req *InferenceRequest) {
+	ls.trackRequests(req.Cluster, -1)
+	ls.origins.remove(req)
+	if node := ls.consensus.Load(); node != nil {
+		node.releaseLater(req.RequestId)
+	} else {
+		ls.reservations.release(req.RequestId)
+	}
+}
+
+// logRemoval logs a request removed without a delete, so a restore does not add it back
+// Unlike a delete, replaying the entry leaves no tombstone
+func (ls *LoadStats) logRemoval(requestID string, now time.Time) {
+	ls.wal.append(LoadAdminEvictRequest, now, &DeletionInferenceRequest{RequestId: requestID})
+}
+
 // decEngineStats decrements engine statistics for a request
-func (ls *LoadStats) decEngineStats(req *InferenceRequest) {
+// Returns the engine, nil if it no longer exists
+func (ls *LoadStats) decEngineStats(req *InferenceRequest) *EngineStats {
 	key := req.Cluster
-	v, ok := ls.RunningModelStats.Load(key)
+	modelStats, ok := ls.RunningModelStats.Load(key)
 	if !ok {
 		logger.Debugf("reqID [%s]: load stats cannot find model %s", req.RequestId, key)
-		return
+		return nil
 	}
-	modelStats := v.(*ModelStats)
-	engineStats, ok := modelStats.Load(req.Ip)
+	engine := req.EngineKey()
+	engineStats, ok := modelStats.Load(engine)
 	if !ok {
-		logger.Debugf("reqID [%s]: load stats cannot find engine %s on model %s", req.RequestId, req.Ip, key)
-		return
+		logger.Debugf("reqID [%s]: load stats cannot find engine %s on model %s", req.RequestId, engine, key)
+		return nil
 	}
+	engineStats.requests.CompareAndDelete(req.RequestId, req)
 	engineStats.DecrementQueuedReqNum(req)
-	logger.Debugf("reqID [%s]: load stats decrement queue on model %s engine %s", req.RequestId, key, req.Ip)
+	logger.Debugf("reqID [%s]: load stats decrement queue on model %s engine %s", req.RequestId, key, engine)
 
 	// 1. Onlog phase API call: promptLength comes from cached request
 	// 2. GC call: promptLength is 0 if already deleted via DELETE /api/load/prompt, otherwise original value
 	engineStats.DecrementPromptLength(req)
-	logger.Debugf("reqID [%s]: load stats decrement prompt length on model %s engine %s", req.RequestId, key, req.Ip)
+	logger.Debugf("reqID [%s]: load stats decrement prompt length on model %s engine %s", req.RequestId, key, engine)
+	return engineStats
 }
 
 // DeletePromptLength removes prompt length from statistics
 func (ls *LoadStats) DeletePromptLength(req *DeletionInferenceRequest) {
-	requestID := req.RequestId
-	prom.SetReplicationLatencyMillisecond(req.TimeStamp, requestID)
-	// Do delete first, skip delay if successful
-	if ls.tryDecPromptLength(requestID) {
+	now := time.Now()
+	prom.SetReplicationLatencyMillisecond(req.TimeStamp, req.RequestId)
+	ls.wal.append(LoadPromptDelete, now, req)
+	ls.deletePromptLength(req.RequestId, now)
+}
+
+// deletePromptLength removes the prompt length of a request whose first token came at the given time
+func (ls *LoadStats) deletePromptLength(requestID string, now time.Time) {
+	if ls.tryDecPromptLength(requestID, now) {
 		return
 	}
 
-	// Delayed retry: handle cases where Delete occurs before Add in concurrent scenarios
-	logger.Infof("reqID [%s]: request ID not found, delaying prompt length deletion", requestID)
-	time.AfterFunc(time.Second, func() {
-		if !ls.tryDecPromptLength(requestID) {
-			logger.Warnf("reqID [%s]: request ID still not found after delay for prompt length deletion, may be already removed by DeleteRequest", requestID)
-			return
-		}
-		logger.Infof("reqID [%s]: delayed prompt length deletion completed", requestID)
-	})
+	// Delete before Add, the request is added without its prompt length
+	// The request may also be removed already, the tombstone then expires unmatched
+	ls.storeTombstone(requestID, tombstonePrompt)
 }
 
-// tryDecPromptLength attempts to decrement prompt length
-func (ls *LoadStats) tryDecPromptLength(requestID string) bool {
-	if v, ok := ls.Requests.Load(requestID); ok && v != nil {
-		ls.decEnginePromptLength(v.(*InferenceRequest))
+// tryDecPromptLength attempts to decrement prompt length, now is the time of the first token
+func (ls *LoadStats) tryDecPromptLength(requestID string, now time.Time) bool {
+	if req, ok := ls.Requests.Load(requestID); ok && req != nil {
+		promptLength := atomic.LoadInt32(&req.PromptLength)
+		engineStats := ls.decEnginePromptLength(req)
+		// The prompt deletion is sent with the first token
+		if req.markFirstToken(now) {
+			engineStats.observeTTFT(req, now, promptLength)
+		}
 		return true
 	}
 	return false
 }
 
 // decEnginePromptLength decrements prompt length for engine statistics
-func (ls *LoadStats) decEnginePromptLength(req *InferenceRequest) {
+// Returns the engine, nil if it no longer exists
+func (ls *LoadStats) decEnginePromptLength(req *InferenceRequest) *EngineStats {
 	key := req.Cluster
-	v, ok := ls.RunningModelStats.Load(key)
+	modelStats, ok := ls.RunningModelStats.Load(key)
 	if !ok {
 		logger.Debugf("reqID [%s]: load stats cannot find model %s", req.RequestId, key)
-		return
+		return nil
 	}
-	modelStats := v.(*ModelStats)
-	engineStats, ok := modelStats.Load(req.Ip)
+	engine := req.EngineKey()
+	engineStats, ok := modelStats.Load(engine)
 	if !ok {
-		logger.Debugf("reqID [%s]: load stats cannot find engine %s on model %s", req.RequestId, req.Ip, key)
-		return
+		logger.Debugf("reqID [%s]: load stats cannot find engine %s on model %s", req.RequestId, engine, key)
+		return nil
 	}
 	engineStats.DecrementPromptLength(req)
-	modelStats.UpdateTime = time.Now().UnixNano()
-	logger.Debugf("reqID [%s]: load stats decrement prompt length on model %s engine %s", req.RequestId, key, req.Ip)
+	modelStats.touch()
+	logger.Debugf("reqID [%s]: load stats decrement prompt length on model %s engine %s", req.RequestId, key, engine)
+	return engineStats
 }
 
 // GC performs garbage collection on expired requests and statistics
+// Requests are usually expired earlier by the expiry loop, only clusters and engines are scanned
 func (ls *LoadStats) GC() {
 	now := time.Now()
-	ls.Requests.Range(func(key, value any) bool {
-		req := value.(*InferenceRequest)
-		if req.CreateTime.Add(requestExpireDuration).Before(now) {
-			ls.Requests.Delete(key)
-			ls.decEngineStats(req)
-			logger.Infof("removed request from running requests, request=%v", req)
-		}
-		return true
-	})
+	ls.expireRequests(now)
 	nowStamps := now.UnixNano()
 	expire := int64(requestExpireDuration)
-	ls.RunningModelStats.Range(func(key, value any) bool {
-		modelStats := value.(*ModelStats)
-		if nowStamps >= modelStats.UpdateTime+expire {
-			ls.RunningModelStats.Delete(key)
-			modelStats.MetricClean()
-			logger.Infof("removed model %s", key)
+	ls.RunningModelStats.Range(func(key string, modelStats *ModelStats) bool {
+		if nowStamps >= modelStats.GetUpdateTime()+expire {
+			// A cluster removed by another path meanwhile has been cleaned up already
+			if ls.RunningModelStats.CompareAndDelete(key, modelStats) {
+				ls.untrackCluster(key)
+				ls.trackClusters(key, -1)
+				modelStats.MetricClean()
+				logger.Infof("removed model %s", key)
+			}
 			return true
 		}
-		modelStats.Engines.Range(func(k, v any) bool {
-			engineStats := v.(*EngineStats)
-			if nowStamps >= engineStats.UpdatedTime+expire {
+		modelStats.Engines.Range(func(k string, engineStats *EngineStats) bool {
+			if nowStamps >= engineStats.LastActiveTime()+expire {
 				// Ensure length data correctness by calling interface
-				modelStats.Delete(k.(string))
-				engineStats.MetricClean(key.(string))
+				modelStats.Delete(k)
+				engineStats.MetricClean(key)
 				logger.Infof("removed engine %s on model %s", k, key)
 			}
 			return true
@@ -15,6 +15,7 @@
 package load
 
 import (
+	"encoding/json"
 	"fmt"
 	"math/rand"
 	"os"
@@ -23,6 +24,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -145,12 +147,11 @@ func TestNewLoadStats(t *testing.T) {
 			continue
 		}
 		require.Equalf(t, tc.totalEnginesLen, ms.Size(), "case %s engines size not expected", tc.name)
-		v, ok := ms.Engines.Load(tc.req.Ip)
+		es, ok := ms.Engines.Load(tc.req.Ip)
 		if !ok {
 			require.Equalf(t, int32(-1), tc.curQueueLen, "case %s match engine failed", tc.name)
 			continue
 		}
-		es := v.(*EngineStats)
 		require.Equalf(t, tc.curQueueLen, es.GetQueuedReqNum(), "case[%s] engines length not expected", tc.name)
 		require.Equalf(t, tc.curPromptLen, es.GetPromptLength(), "case[%s] engines prompt length not expected", tc.name)
 	}
@@ -237,9 +238,8 @@ func TestLoadStats_GC(t *testing.T) {
 			}
 			require.NotNilf(t, ms, "case %d ip %s", idx, d.ip)
 			require.Equalf(t, d.ms_expected_count, ms.Size(), "case %d ip %s", idx, d.ip)
-			v, ok := ms.Engines.Load(d.ip)
+			es, ok := ms.Engines.Load(d.ip)
 			require.Truef(t, ok, "case %d ip %s", idx, d.ip)
-			es := v.(*EngineStats)
 			require.Equalf(t, d.es_expecetd_count, es.GetQueuedReqNum(), "case %d ip %s", idx, d.ip)
 			require.Equalf(t, d.es_expecetd_prompt, es.GetPromptLength(), "case %d ip %s", idx, d.ip)
 		}
@@ -264,6 +264,8 @@ func TestMain(m *testing.M) {
 			RequestId:    strconv.Itoa(rand.Int()),
 		}
 	}
+	// Tests drive expiry themselves and change its settings, the expiry loops started by Init would race with them
+	SetExpireInterval(0)
 	os.Exit(m.Run())
 }
 
@@ -386,11 +388,10 @@ func TestDescPromptLength(t *testing.T) {
 	ls.AddRequest(req1)
 	ms = ls.GetModelStats(req1.Cluster)
 	require.Equal(t, int32(1), ms.Size())
-	v, ok := ms.Engines.Load(req1.Ip)
+	es, ok := ms.Engines.Load(req1.Ip)
 	if !ok {
 		t.Errorf("%s engines not found", req1.Ip)
 	}
-	es := v.(*EngineStats)
 	require.Equalf(t, int32(1), es.GetQueuedReqNum(), "%s engines length not expected", req1.Ip)
 	require.Equalf(t, int32(512), es.GetPromptLength(), "%s engines prompt length not expected", req1.Ip)
 
@@ -466,27 +467,16 @@ func TestDelayDeleteWithLateAdd(t *testing.T) {
 	}
 
 	ls.DeleteRequest(deletionReq)
+	assert.Equal(t, int64(1), ls.tombstones.len())
 
-	time.Sleep(500 * time.Millisecond)
+	// The late add cancels out against the tombstone instead of incrementing
+	time.Sleep(1100 * time.Millisecond)
 	ls.AddRequest(req)
 
-	ms := ls.GetModelStats(req.Cluster)
-	require.NotNil(t, ms)
-	v, ok := ms.Engines.Load(req.Ip)
-	require.True(t, ok)
-	es := v.(*EngineStats)
-	require.Equal(t, int32(1), es.GetQueuedReqNum())
-	require.Equal(t, int32(512), es.GetPromptLength())
-
-	time.Sleep(600 * time.Millisecond)
-
-	ms = ls.GetModelStats(req.Cluster)
-	require.NotNil(t, ms)
-	v, ok = ms.Engines.Load(req.Ip)
-	require.True(t, ok)
-	es = v.(*EngineStats)
-	require.Equal(t, int32(0), es.GetQueuedReqNum())
-	require.Equal(t, int32(0), es.GetPromptLength())
+	assert.Nil(t, ls.GetModelStats(req.Cluster))
+	_, ok := ls.Requests.Load(req.RequestId)
+	assert.False(t, ok)
+	assert.Equal(t, int64(0), ls.tombstones.len())
 }
 
 func TestConcurrencyUpdateLoadAndPromptLength(t *testing.T) {
@@ -541,9 +531,8 @@ func TestConcurrencyUpdateLoadAndPromptLength(t *testing.T) {
 
 	ms := ls.GetModelStats("test_domain")
 	if ms != nil {
-		v, ok := ms.Engines.Load("192.168.100.1")
+		es, ok := ms.Engines.Load("192.168.100.1")
 		if ok {
-			es := v.(*EngineStats)
 			assert.Equal(t, int32(0), es.GetQueuedReqNum(), "Queue length should be 0 after all requests are processed")
 			assert.Equal(t, int32(0), es.GetPromptLength(), "Prompt length should be 0 after all requests are processed")
 		} else {
@@ -553,3 +542,349 @@ func TestConcurrencyUpdateLoadAndPromptLength(t *testing.T) {
 		t.Log("Model stats has been removed as expected when no requests remain")
 	}
 }
+
+func TestLoadStats_SetEngineReport(t *testing.T) {
+	ls := NewLoadStats()
+	cluster := "report_domain"
+	ip := "192.168.10.1"
+
+	// Report for an engine without gateway traffic creates the engine
+	ls.SetEngineReport(&EngineReport{
+		Cluster:       cluster,
+		Ip:            ip,
+		RunningReqNum: 4,
+		WaitingReqNum: 2,
+		KVCacheUsage:  0.5,
+	})
+	ms := ls.GetModelStats(cluster)
+	require.NotNil(t, ms)
+	es, ok := ms.Load(ip)
+	require.True(t, ok)
+	report := es.GetReport()
+	require.NotNil(t, report)
+	assert.NotZero(t, report.ReportedTime)
+	assert.Equal(t, int32(6), report.QueuedReqNum())
+	assert.Equal(t, int32(0), es.GetQueuedReqNum())
+
+	addRequests(ls, cluster, ip, 3)
+
+	now := time.Now().UnixNano()
+	tests := []struct {
+		mode     string
+		now      int64
+		expected int32
+	}{
+		{BlendEstimated, now, 3},
+		{BlendReported, now, 6},
+		{BlendMax, now, 6},
+		// Stale reports fall back to the estimated value
+		{BlendReported, now + int64(2*DefaultReportTTL), 3},
+	}
+	for _, tc := range tests {
+		snapshot := es.Snapshot()
+		snapshot.Blend(tc.mode, tc.now)
+		assert.Equalf(t, tc.expected, snapshot.QueuedReqNum, "mode %s", tc.mode)
+		assert.Equalf(t, int32(300), snapshot.PromptLength, "mode %s", tc.mode)
+		assert.Samef(t, report, snapshot.Reported, "mode %s", tc.mode)
+	}
+	assert.Equal(t, int32(3), es.GetQueuedReqNum(), "blending must not modify the live counters")
+
+	engines := ms.Select(&ModelQueryRequest{Cluster: cluster, Blend: BlendReported})
+	require.Len(t, engines, 1)
+	assert.Equal(t, int32(6), engines[0].QueuedReqNum)
+
+	b, err := json.Marshal(engines)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), `"queued_req_num":6`)
+	assert.Contains(t, string(b), `"reported":{"cluster":"report_domain"`)
+}
+
+func TestLoadStats_GCKeepsReportingEngine(t *testing.T) {
+	ls := NewLoadStats()
+	interval := 10 * time.Millisecond
+	SetRequestExpireDuration(4 * interval)
+	defer SetRequestExpireDuration(DefaultRequestExpireDuration)
+
+	cluster := "report_gc_domain"
+	ip := "192.168.10.2"
+	addRequests(ls, cluster, ip, 1)
+	time.Sleep(3 * interval)
+	ls.SetEngineReport(&EngineReport{Cluster: cluster, Ip: ip, RunningReqNum: 1})
+	time.Sleep(2 * interval)
+	ls.GC()
+
+	ms := ls.GetModelStats(cluster)
+	require.NotNil(t, ms)
+	_, ok := ms.Load(ip)
+	assert.True(t, ok)
+}
+
+func TestLoadStats_ModelSubCounters(t *testing.T) {
+	ls := NewLoadStats()
+	cluster := "multi_model_domain"
+	ip := "192.168.20.1"
+	ip2 := "192.168.20.2"
+
+	requests := []*InferenceRequest{
+		{Cluster: cluster, RequestId: "m-1", PromptLength: 100, Ip: ip, Model: "qwen"},
+		{Cluster: cluster, RequestId: "m-2", PromptLength: 200, Ip: ip, Model: "qwen"},
+		{Cluster: cluster, RequestId: "m-3", PromptLength: 300, Ip: ip, Model: "llama"},
+		{Cluster: cluster, RequestId: "m-4", PromptLength: 400, Ip: ip},
+		{Cluster: cluster, RequestId: "m-5", PromptLength: 500, Ip: ip2, Model: "llama"},
+	}
+	for _, req := range requests {
+		ls.AddRequest(req)
+	}
+
+	es, ok := ls.GetModelStats(cluster).Load(ip)
+	require.True(t, ok)
+	assert.Equal(t, int32(4), es.GetQueuedReqNum())
+	assert.Equal(t, int32(1000), es.GetPromptLength())
+	qwen, ok := es.GetModelCounter("qwen")
+	require.True(t, ok)
+	assert.Equal(t, &LoadCounter{QueuedReqNum: 2, PromptLength: 300}, qwen)
+	llama, ok := es.GetModelCounter("llama")
+	require.True(t, ok)
+	assert.Equal(t, &LoadCounter{QueuedReqNum: 1, PromptLength: 300}, llama)
+
+	ls.DeletePromptLength(newDeletionInferenceRequest("m-1"))
+	ls.DeleteRequest(newDeletionInferenceRequest("m-2"))
+	qwen, _ = es.GetModelCounter("qwen")
+	assert.Equal(t, &LoadCounter{QueuedReqNum: 1, PromptLength: 0}, qwen)
+	assert.Equal(t, int32(700), es.GetPromptLength())
+
+	// Filtering by model keeps only engines serving it with the model's sub-counter
+	engines := ls.GetModelStats(cluster).Select(&ModelQueryRequest{Cluster: cluster, Model: "qwen"})
+	require.Len(t, engines, 1)
+	assert.Equal(t, ip, engines[0].Ip)
+	assert.Equal(t, map[string]*LoadCounter{"qwen": {QueuedReqNum: 1, PromptLength: 0}}, engines[0].Models)
+	assert.Equal(t, int32(1), engines[0].QueuedReqNum, "counters are the model's, not the engine totals")
+	assert.Equal(t, int32(0), engines[0].PromptLength)
+
+	engines = ls.GetModelStats(cluster).Select(&ModelQueryRequest{Cluster: cluster, Model: "llama"})
+	assert.Len(t, engines, 2)
+
+	engines = ls.GetModelStats(cluster).Select(&ModelQueryRequest{Cluster: cluster, Model: "unknown"})
+	assert.Empty(t, engines)
+
+	engines = ls.GetModelStats(cluster).Select(&ModelQueryRequest{Cluster: cluster})
+	assert.Len(t, engines, 2)
+
+	// Removing the engine removes the series of its models as well
+	require.Equal(t, 2, seriesCount(t, "engine_model_queued_num", engineLabels(cluster, ip)))
+	ls.DeleteEngine(&EngineAction{Cluster: cluster, Ip: ip}, AuditSourceAPI)
+	assert.Equal(t, 0, seriesCount(t, "engine_model_queued_num", engineLabels(cluster, ip)))
+	assert.Equal(t, 0, seriesCount(t, "engine_model_prompt_length", engineLabels(cluster, ip)))
+	assert.Equal(t, 1, seriesCount(t, "engine_model_queued_num", engineLabels(cluster, ip2)))
+}
+
+func TestLoadStats_CheckModel(t *testing.T) {
+	SetMaxEngineModels(2)
+	defer SetMaxEngineModels(DefaultMaxEngineModels)
+	ls := NewLoadStats()
+	cluster := "check_model_domain"
+	ip := "192.168.21.1"
+
+	assert.NoError(t, ls.CheckModel(cluster, ip, "qwen"), "unknown engines are not limited yet")
+	for i, model := range []string{"qwen", "llama"} {
+		ls.AddRequest(&InferenceRequest{Cluster: cluster, RequestId: fmt.Sprintf("cm-%d", i), Ip: ip, Model: model})
+	}
+
+	assert.NoError(t, ls.CheckModel(cluster, ip, "qwen"), "tracked models are accepted")
+	assert.NoError(t, ls.CheckModel(cluster, ip, ""))
+	assert.Error(t, ls.CheckModel(cluster, ip, "mistral"))
+	assert.NoError(t, ls.CheckModel(cluster, "192.168.21.2", "mistral"), "the limit applies per engine")
+
+	SetMaxEngineModels(0)
+	assert.NoError(t, ls.CheckModel(cluster, ip, "mistral"))
+}
+
+// seriesCount returns the number of series of a metric of the default registry carrying the given labels
+func seriesCount(t *testing.T, name string, labels map[string]string) int {
+	families, err := prometheus.DefaultGatherer.Gather()
+	require.NoError(t, err)
+	n := 0
+	for _, mf := range families {
+		if mf.GetName() != name {
+			continue
+		}
+	series:
+		for _, m := range mf.GetMetric() {
+			values := make(map[string]string)
+			for _, label := range m.GetLabel() {
+				values[label.GetName()] = label.GetValue()
+			}
+			for k, v := range labels {
+				if values[k] != v {
+					continue series
+				}
+			}
+			n++
+		}
+	}
+	return n
+}
+
+func TestLoadStats_PrioritySubCounters(t *testing.T) {
+	ls := NewLoadStats()
+	SetRequestExpireDuration(time.Hour)
+	defer SetRequestExpireDuration(DefaultRequestExpireDuration)
+	cluster := "priority_domain"
+	ip := "192.168.21.1"
+
+	requests := []*InferenceRequest{
+		{Cluster: cluster, RequestId: "p-1", PromptLength: 100, Ip: ip, Priority: "interactive", Model: "qwen"},
+		{Cluster: cluster, RequestId: "p-2", PromptLength: 200, Ip: ip, Priority: "interactive"},
+		{Cluster: cluster, RequestId: "p-3", PromptLength: 300, Ip: ip, Priority: "batch"},
+		{Cluster: cluster, RequestId: "p-4", PromptLength: 400, Ip: ip},
+	}
+	for _, req := range requests {
+		ls.AddRequest(req)
+	}
+
+	es, ok := ls.GetModelStats(cluster).Load(ip)
+	require.True(t, ok)
+	interactive, ok := es.GetPriorityCounter("interactive")
+	require.True(t, ok)
+	assert.Equal(t, &LoadCounter{QueuedReqNum: 2, PromptLength: 300}, interactive)
+	batch, ok := es.GetPriorityCounter("batch")
+	require.True(t, ok)
+	assert.Equal(t, &LoadCounter{QueuedReqNum: 1, PromptLength: 300}, batch)
+
+	ls.DeletePromptLength(newDeletionInferenceRequest("p-1"))
+	ls.DeleteRequest(newDeletionInferenceRequest("p-2"))
+	interactive, _ = es.GetPriorityCounter("interactive")
+	assert.Equal(t, &LoadCounter{QueuedReqNum: 1, PromptLength: 0}, interactive)
+
+	// GC releases expired requests from their class as well
+	backdate(ls, requests[2], 2*time.Hour)
+	ls.GC()
+	batch, _ = es.GetPriorityCounter("batch")
+	assert.Equal(t, &LoadCounter{}, batch)
+	assert.Equal(t, int32(2), es.GetQueuedReqNum())
+
+	engines := ls.GetModelStats(cluster).Select(&ModelQueryRequest{Cluster: cluster})
+	require.Len(t, engines, 1)
+	assert.Equal(t, map[string]*LoadCounter{
+		"interactive": {QueuedReqNum: 1, PromptLength: 0},
+		"batch":       {},
+	}, engines[0].Priorities)
+	assert.Empty(t, ls.CheckInvariants(false))
+}
+
+func TestEngineKey(t *testing.T) {
+	tests := []struct {
+		ip       string
+		endpoint string
+		expected string
+	}{
+		{"192.168.1.1", "", "192.168.1.1"},
+		{"fd00:0:0::1", "", "fd00::1"},
+		{"", "192.168.1.1:8000", "192.168.1.1:8000"},
+		{"192.168.1.1", "192.168.1.1:8001", "192.168.1.1:8001"},
+		{"", "[fd00:0::1]:8000", "[fd00::1]:8000"},
+		{"", "", ""},
+	}
+	for _, tc := range tests {
+		req := &InferenceRequest{Ip: tc.ip, Endpoint: tc.endpoint}
+		assert.Equalf(t, tc.expected, req.EngineKey(), "ip %q endpoint %q", tc.ip, tc.endpoint)
+	}
+}
+
+func TestLoadStats_EngineEndpoints(t *testing.T) {
+	ls := NewLoadStats()
+	cluster := "endpoint_domain"
+
+	requests := []*InferenceRequest{
+		{Cluster: cluster, RequestId: "e-1", PromptLength: 100, Endpoint: "192.168.30.1:8000"},
+		{Cluster: cluster, RequestId: "e-2", PromptLength: 200, Endpoint: "192.168.30.1:8001"},
+		{Cluster: cluster, RequestId: "e-3", PromptLength: 300, Ip: "192.168.30.1"},
+		{Cluster: cluster, RequestId: "e-4", PromptLength: 400, Endpoint: "[fd00::1]:8000"},
+		{Cluster: cluster, RequestId: "e-5", PromptLength: 500, Endpoint: "[fd00:0::1]:8000"},
+	}
+	for _, req := range requests {
+		ls.AddRequest(req)
+	}
+
+	ms := ls.GetModelStats(cluster)
+	require.NotNil(t, ms)
+	require.Equal(t, int32(4), ms.Size(), "engines sharing a host on different ports must not collide")
+
+	es, ok := ms.Load("192.168.30.1:8001")
+	require.True(t, ok)
+	assert.Equal(t, "192.168.30.1", es.Ip)
+	assert.Equal(t, "192.168.30.1:8001", es.Endpoint)
+	assert.Equal(t, int32(200), es.GetPromptLength())
+
+	es, ok = ms.Load("192.168.30.1")
+	require.True(t, ok)
+	assert.Equal(t, "192.168.30.1", es.Ip)
+	assert.Empty(t, es.Endpoint)
+
+	es, ok = ms.Load("[fd00::1]:8000")
+	require.True(t, ok)
+	assert.Equal(t, "fd00::1", es.Ip)
+	assert.Equal(t, int32(2), es.GetQueuedReqNum())
+
+	ls.DeleteRequest(newDeletionInferenceRequest("e-5"))
+	assert.Equal(t, int32(1), es.GetQueuedReqNum())
+	assert.Equal(t, int32(400), es.GetPromptLength())
+}
+
+func TestLoadStats_SelectClusters(t *testing.T) {
+	ls := NewLoadStats()
+	for i, cluster := range []string{"qwen-east", "qwen-west", "llama-east"} {
+		ls.AddRequest(&InferenceRequest{
+			Cluster:      cluster,
+			RequestId:    fmt.Sprintf("multi-%d", i),
+			PromptLength: 100,
+			Ip:           "192.168.40.1",
+			Model:        "m",
+		})
+	}
+
+	tests := []struct {
+		name     string
+		req      *MultiClusterQueryRequest
+		expected map[string]int
+	}{
+		{
+			name:     "listed clusters",
+			req:      &MultiClusterQueryRequest{Clusters: []string{"qwen-east", "llama-east"}},
+			expected: map[string]int{"qwen-east": 1, "llama-east": 1},
+		},
+		{
+			name:     "comma separated clusters with unknown one",
+			req:      &MultiClusterQueryRequest{Clusters: []string{"qwen-west, unknown"}},
+			expected: map[string]int{"qwen-west": 1, "unknown": 0},
+		},
+		{
+			name:     "prefix pattern",
+			req:      &MultiClusterQueryRequest{Pattern: "qwen-*"},
+			expected: map[string]int{"qwen-east": 1, "qwen-west": 1},
+		},
+		{
+			name:     "glob pattern",
+			req:      &MultiClusterQueryRequest{Pattern: "*-east"},
+			expected: map[string]int{"qwen-east": 1, "llama-east": 1},
+		},
+		{
+			name:     "model filter",
+			req:      &MultiClusterQueryRequest{Pattern: "*", Model: "other"},
+			expected: map[string]int{"qwen-east": 0, "qwen-west": 0, "llama-east": 0},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ret := ls.SelectClusters(tc.req)
+			require.NotZero(t, ret.SnapshotTime)
+			require.Len(t, ret.Clusters, len(tc.expected))
+			for cluster, n := range tc.expected {
+				engines, ok := ret.Clusters[cluster]
+				require.Truef(t, ok, "cluster %s missing", cluster)
+				require.NotNil(t, engines)
+				assert.Len(t, engines, n)
+			}
+		})
+	}
+}
@@ -15,7 +15,6 @@
 package load
 
 import (
-	"sync"
 	"sync/atomic"
 	"time"
 
@@ -28,64 +27,81 @@ import (
 type ModelStats struct {
 	name string
 	// Engines contains load information for each engine
-	// Key: Engine IP
+	// Key: Engine key, host:port endpoint or IP for clients that only send an IP
 	// Value: EngineStats
-	Engines sync.Map
+	Engines store[*EngineStats]
 	// Length records the current number of engines
 	// Used for fast len(Engines) implementation
 	Length int32
 	// UpdateTime records last update time for GC
 	UpdateTime int64
+	// owner partitions the counters of new engines by origin, nil unless in crdt mode
+	owner *counterOwner
+	// engineLRU indexes the engines least recently used first, for capacity eviction
+	engineLRU lruIndex
 }
 
 // NewModelStats creates a new ModelStats instance
 func NewModelStats(name string) *ModelStats {
 	return &ModelStats{
 		name:       name,
-		Engines:    sync.Map{},
+		Engines:    newStore[*EngineStats](engineShards),
 		Length:     0,
 		UpdateTime: time.Now().UnixNano(),
 	}
 }
 
-// LoadOrStore loads or stores engine statistics for the given IP
-func (ms *ModelStats) LoadOrStore(ip string) *EngineStats {
-	v, loaded := ms.Engines.Load(ip)
+// LoadOrStore loads or stores engine statistics for the given engine key
+func (ms *ModelStats) LoadOrStore(key string) *EngineStats {
+	engineStats, loaded := ms.Engines.Load(key)
 	if !loaded {
 		// Avoid duplicate counting, use LoadOrStore
-		v, loaded = ms.Engines.LoadOrStore(ip, NewEngineLoadStats(ip))
+		created := NewEngineLoadStats(key)
+		if ms.owner != nil {
+			created.counters = newPNCounter(ms.owner, ms.name)
+		}
+		engineStats, loaded = ms.Engines.LoadOrStore(key, created)
 		if !loaded {
 			atomic.AddInt32(&ms.Length, 1)
 			// Update metrics promptly
 			prom.ModelEngineCount.WithLabelValues(ms.name).Set(float64(ms.Size()))
-			logger.Infof("model %s added new engine load stats %s", ms.name, ip)
+			prom.TrackedEngines.Inc()
+			logger.Infof("model %s added new engine load stats %s", ms.name, key)
 		}
 	}
-	ms.UpdateTime = time.Now().UnixNano()
-	return v.(*EngineStats)
+	ms.touch()
+	return engineStats
 }
 
-// Load retrieves engine statistics for the given IP
-func (ms *ModelStats) Load(ip string) (*EngineStats, bool) {
-	ms.UpdateTime = time.Now().UnixNano()
-	v, ok := ms.Engines.Load(ip)
-	if !ok {
-		return nil, false
-	}
-	return v.(*EngineStats), true
+// Load retrieves engine statistics for the given engine key
+func (ms *ModelStats) Load(key string) (*EngineStats, bool) {
+	ms.touch()
+	return ms.Engines.Load(key)
 }
 
-// Delete removes engine statistics for the given IP
-func (ms *ModelStats) Delete(ip string) {
-	_, loaded := ms.Engines.LoadAndDelete(ip)
+// Delete removes engine statistics for the given engine key
+func (ms *ModelStats) Delete(key string) {
+	ms.engineLRU.remove(key)
+	_, loaded := ms.Engines.LoadAndDelete(key)
 	// If loaded, deletion was successful
 	if loaded {
 		atomic.AddInt32(&ms.Length, -1)
 		// Update metrics promptly
 		prom.ModelEngineCount.WithLabelValues(ms.name).Set(float64(ms.Size()))
-		logger.Infof("model %s deleted engine load stats %s", ms.name, ip)
+		prom.TrackedEngines.Dec()
+		logger.Infof("model %s deleted engine load stats %s", ms.name, key)
 	}
-	ms.UpdateTime = time.Now().UnixNano()
+	ms.touch()
+}
+
+// GetUpdateTime returns the time the model was last used
+func (ms *ModelStats) GetUpdateTime() int64 {
+	return atomic.LoadInt64(&ms.UpdateTime)
+}
+
+// touch records a use of the model, UpdateTime is read concurrently by GC and queries
+func (ms *ModelStats) touch() {
+	atomic.StoreInt64(&ms.UpdateTime, time.Now().UnixNano())
 }
 
 // Size returns the number of engines in this model
@@ -93,8 +109,9 @@ func (ms *ModelStats) Size() int32 {
 	return atomic.LoadInt32(&ms.Length)
 }
 
-// MetricClean removes metrics for this model
+// MetricClean removes metrics for this model, called once the model is removed with its engines
 func (ms *ModelStats) MetricClean() {
+	prom.TrackedEngines.Sub(float64(ms.Size()))
 	prom.ModelEngineCount.DeleteLabelValues(ms.name)
 	prom.DeleteModelMetric(ms.name)
 }
@@ -106,10 +123,51 @@ func (ms *ModelStats) ToEngines() []*EngineStats {
 		return nil
 	}
 	engines := make([]*EngineStats, 0, ms.Size())
-	ms.Engines.Range(func(_, value any) bool {
-		es := value.(*EngineStats)
+	ms.Engines.Range(func(_ string, es *EngineStats) bool {
 		engines = append(engines, es)
 		return true
 	})
 	return engines
 }
+
+// Select returns snapshots of the engines matching the query with the blend mode applied
+// When a model is given only engines serving it are returned, with the model's sub-counter as their counters
+// Engines are scored when a known scorer is requested
+func (ms *ModelStats) Select(req *ModelQueryRequest) []*EngineSnapshot {
+	snapshots := ms.selectAt(req.Model, req.Blend, time.Now().UnixNano())
+	if scorer, ok := GetScorer(req.Scorer); ok {
+		applyScores(scorer, snapshots)
+	}
+	return snapshots
+}
+
+// selectAt is Select with an explicit blend time, so several clusters can share one snapshot time
+func (ms *ModelStats) selectAt(model, blend string, now int64) []*EngineSnapshot {
+	engines := ms.ToEngines()
+	snapshots := make([]*EngineSnapshot, 0, len(engines))
+	for _, es := range engines {
+		snapshots = append(snapshots, es.Snapshot())
+	}
+	return selectSnapshots(snapshots, model, blend, now)
+}
+
+// selectSnapshots applies the model filter and the blend mode to engine snapshots in place
+// Engine reports cover all models of an engine, so they are not blended into the counters of a single model
+func selectSnapshots(snapshots []*EngineSnapshot, model, blend string, now int64) []*EngineSnapshot {
+	selected := snapshots[:0]
+	for _, snapshot := range snapshots {
+		if model != "" {
+			c, ok := snapshot.Models[model]
+			if !ok {
+				continue
+			}
+			snapshot.Models = map[string]*LoadCounter{model: c}
+			snapshot.QueuedReqNum, snapshot.PromptLength = c.QueuedReqNum, c.PromptLength
+			selected = append(selected, snapshot)
+			continue
+		}
+		snapshot.Blend(blend, now)
+		selected = append(selected, snapshot)
+	}
+	return selected
+}
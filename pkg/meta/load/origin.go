@@ -0,0 +1,251 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package load
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aigw-project/metadata-center/pkg/prom"
+	"github.com/aigw-project/metadata-center/pkg/replicator"
+	"github.com/aigw-project/metadata-center/pkg/utils/helper"
+	"github.com/aigw-project/metadata-center/pkg/utils/logger"
+)
+
+// originTracker records since when and for how many consecutive checks the origins of tracked requests are missing
+// from service discovery
+type originTracker struct {
+	mu   sync.Mutex
+	gone map[string]*goneOrigin
+}
+
+// goneOrigin is an origin missing from service discovery since the first of checks consecutive checks
+type goneOrigin struct {
+	since  time.Time
+	checks int
+}
+
+// update marks the given origins missing at now, and forgets the others
+// Returns the origins missing for at least grace and at least checks consecutive checks
+func (t *originTracker) update(missing map[string]struct{}, now time.Time, grace time.Duration, checks int) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.gone == nil {
+		t.gone = make(map[string]*goneOrigin)
+	}
+	for o := range t.gone {
+		if _, ok := missing[o]; !ok {
+			delete(t.gone, o)
+			logger.Infof("origin %s is back in service discovery", o)
+		}
+	}
+	var failed []string
+	for o := range missing {
+		g, ok := t.gone[o]
+		if !ok {
+			g = &goneOrigin{since: now}
+			t.gone[o] = g
+			logger.Warnf("origin %s left service discovery, its requests are removed in %s", o, grace)
+		}
+		g.checks++
+		if g.checks >= checks && now.Sub(g.since) >= grace {
+			failed = append(failed, o)
+			delete(t.gone, o)
+		}
+	}
+	prom.GoneOrigins.Set(float64(len(t.gone)))
+	return failed
+}
+
+// originIndex indexes the tracked requests by origin, so failover finds the requests of an origin without a scan
+type originIndex struct {
+	mu       sync.Mutex
+	requests map[string]map[string]struct{}
+}
+
+// add indexes a request stored in Requests, requests without origin are not indexed
+func (x *originIndex) add(req *InferenceRequest) {
+	if req.Origin == "" {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.requests == nil {
+		x.requests = make(map[string]map[string]struct{})
+	}
+	ids, ok := x.requests[req.Origin]
+	if !ok {
+		ids = make(map[string]struct{})
+		x.requests[req.Origin] = ids
+	}
+	ids[req.RequestId] = struct{}{}
+}
+
+// remove drops a request removed from Requests
+func (x *originIndex) remove(req *InferenceRequest) {
+	if req.Origin == "" {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	ids := x.requests[req.Origin]
+	delete(ids, req.RequestId)
+	if len(ids) == 0 {
+		delete(x.requests, req.Origin)
+	}
+}
+
+// origins returns the origins of the tracked requests
+func (x *originIndex) origins() []string {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	ret := make([]string, 0, len(x.requests))
+	for o := range x.requests {
+		ret = append(ret, o)
+	}
+	return ret
+}
+
+// requestIDs returns the IDs of the tracked requests of an origin
+func (x *originIndex) requestIDs(origin string) []string {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	ret := make([]string, 0, len(x.requests[origin]))
+	for id := range x.requests[origin] {
+		ret = append(ret, id)
+	}
+	return ret
+}
+
+// failOrigins removes the requests of origins missing from live for longer than grace and for checks consecutive
+// checks, and their counter partitions. Requests without origin are kept, they expire as usual.
+// Returns the number of removed requests
+func (ls *LoadStats) failOrigins(live map[string]struct{}, now time.Time, grace time.Duration, checks int) int {
+	missing := make(map[string]struct{})
+	addMissing := func(o string) {
+		if _, ok := live[o]; !ok {
+			missing[o] = struct{}{}
+		}
+	}
+	for _, o := range ls.origins.origins() {
+		addMissing(o)
+	}
+	if ls.owner != nil {
+		for _, o := range ls.owner.partitionOrigins() {
+			addMissing(o)
+		}
+	}
+
+	failed := ls.originTracker.update(missing, now, grace, checks)
+	if len(failed) == 0 {
+		return 0
+	}
+	removed := 0
+	for _, o := range failed {
+		for _, id := range ls.origins.requestIDs(o) {
+			if ls.tryDeleteRequestStats(id, now, false) {
+				ls.logRemoval(id, now)
+				removed++
+			}
+		}
+		if ls.owner != nil {
+			ls.dropPartitions(o)
+		}
+	}
+	prom.OriginFailoverTotal.Add(float64(removed))
+	logger.Warnf("removed %d requests of origins %v gone from service discovery for %s", removed, failed, grace)
+	return removed
+}
+
+// dropPartitions removes the counter partitions of an origin from the engines holding one
+func (ls *LoadStats) dropPartitions(origin string) {
+	for ref := range ls.owner.takePartitions(origin) {
+		modelStats := ls.GetModelStats(ref.cluster)
+		if modelStats == nil {
+			continue
+		}
+		es, ok := modelStats.Engines.Load(ref.engine)
+		if !ok || es.counters == nil {
+			continue
+		}
+		if es.counters.drop(es, origin) {
+			prom.SetLoadMetric(ref.cluster, es.Key(), es.GetQueuedReqNum(), es.GetPromptLength())
+		}
+	}
+}
+
+// rangeEngines calls f for every engine with the name of its cluster
+func (ls *LoadStats) rangeEngines(f func(cluster string, es *EngineStats)) {
+	ls.RunningModelStats.Range(func(cluster string, modelStats *ModelStats) bool {
+		for _, es := range modelStats.ToEngines() {
+			f(cluster, es)
+		}
+		return true
+	})
+}
+
+// liveOrigins returns the origins of this instance and the instances found by service discovery
+// Returns an error while service discovery cannot tell which instances are up
+func liveOrigins() (map[string]struct{}, error) {
+	hosts, err := replicator.Hosts()
+	if err != nil {
+		return nil, err
+	}
+	live := make(map[string]struct{}, len(hosts)+1)
+	for _, host := range hosts {
+		live[host] = struct{}{}
+	}
+	live[origin] = struct{}{}
+	return live, nil
+}
+
+// cronFailOrigins removes the requests of origins gone from service discovery periodically
+func cronFailOrigins(ticker *time.Ticker, ls *LoadStats) {
+	defer func() {
+		if p := recover(); p != nil {
+			logger.Errorf("origin failover goroutine panicked: %v", p)
+		}
+		ticker.Stop()
+		logger.Errorf("origin failover goroutine exited")
+	}()
+
+	for now := range ticker.C {
+		live, err := liveOrigins()
+		if err != nil {
+			logger.Warnf("origin failover skipped, service discovery failed: %v", err)
+			continue
+		}
+		ls.failOrigins(live, now, originGrace, originMissingChecks)
+	}
+}
+
+// resolveOrigin defaults the origin recorded on the requests accepted by this instance to the pod IP,
+// which service discovery reports to the other instances, or to the hostname without one
+func resolveOrigin() {
+	if origin != "" {
+		return
+	}
+	if ip, err := helper.GetLocalHosts(); err == nil {
+		origin = ip
+		return
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		logger.Errorf("failed to get the hostname for the request origin: %v", err)
+		return
+	}
+	origin = host
+}
@@ -0,0 +1,103 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package load
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadStats_FailOrigins(t *testing.T) {
+	ls := NewLoadStats()
+	cluster := "failover"
+	ip := "10.0.10.1"
+	for _, req := range []*InferenceRequest{
+		{Cluster: cluster, RequestId: "live", PromptLength: 10, Ip: ip, Origin: "10.1.0.1"},
+		{Cluster: cluster, RequestId: "gone-0", PromptLength: 10, Ip: ip, Origin: "10.1.0.2"},
+		{Cluster: cluster, RequestId: "gone-1", PromptLength: 10, Ip: ip, Origin: "10.1.0.2"},
+		{Cluster: cluster, RequestId: "flapping", PromptLength: 10, Ip: ip, Origin: "10.1.0.3"},
+		{Cluster: cluster, RequestId: "legacy", PromptLength: 10, Ip: ip},
+	} {
+		ls.AddRequest(req)
+	}
+	es, ok := ls.GetModelStats(cluster).Load(ip)
+	require.True(t, ok)
+
+	grace := 30 * time.Second
+	checks := 2
+	now := time.Now()
+	live := map[string]struct{}{"10.1.0.1": {}}
+	assert.Equal(t, 0, ls.failOrigins(live, now, grace, checks), "requests are kept during the grace period")
+
+	// The flapping origin comes back within the grace period, its grace starts over when it leaves again
+	live["10.1.0.3"] = struct{}{}
+	assert.Equal(t, 0, ls.failOrigins(live, now.Add(grace/2), grace, checks))
+	delete(live, "10.1.0.3")
+	assert.Equal(t, 2, ls.failOrigins(live, now.Add(grace), grace, checks))
+	assert.Equal(t, int32(3), es.GetQueuedReqNum())
+	assert.Equal(t, int32(30), es.GetPromptLength())
+	_, ok = ls.Requests.Load("gone-0")
+	assert.False(t, ok)
+
+	assert.Equal(t, 1, ls.failOrigins(live, now.Add(2*grace), grace, checks))
+	_, ok = ls.Requests.Load("legacy")
+	assert.True(t, ok, "requests without origin expire as usual")
+	assert.Equal(t, int32(2), es.GetQueuedReqNum())
+	assert.Empty(t, ls.originTracker.gone)
+	assert.Equal(t, []string{"10.1.0.1"}, ls.origins.origins(), "removed requests leave the origin index")
+}
+
+func TestLoadStats_FailOriginsChecks(t *testing.T) {
+	ls := NewLoadStats()
+	ls.AddRequest(&InferenceRequest{Cluster: "failover-checks", RequestId: "gone", Ip: "10.0.10.3", Origin: "10.1.0.4"})
+	live := map[string]struct{}{}
+	now := time.Now()
+
+	// A single lookup missing an origin is not enough, even long after the grace period
+	assert.Equal(t, 0, ls.failOrigins(live, now, time.Second, 3))
+	assert.Equal(t, 0, ls.failOrigins(live, now.Add(time.Hour), time.Second, 3))
+	assert.Equal(t, 1, ls.failOrigins(live, now.Add(time.Hour+time.Second), time.Second, 3))
+	assert.Empty(t, ls.origins.origins())
+}
+
+func TestLiveOrigins(t *testing.T) {
+	_, err := liveOrigins()
+	assert.Error(t, err, "a lookup error is not an empty host list")
+}
+
+func TestLoadStats_FailOriginsPartitions(t *testing.T) {
+	ls := newPartitionedStats("10.1.0.1")
+	cluster := "failover-crdt"
+	ip := "10.0.10.2"
+	ls.AddRequest(&InferenceRequest{Cluster: cluster, RequestId: "own", PromptLength: 10, Ip: ip, Origin: "10.1.0.1"})
+	ls.AddRequest(&InferenceRequest{Cluster: cluster, RequestId: "gone", PromptLength: 10, Ip: ip, Origin: "10.1.0.2"})
+	ls.MergeCounters(&CounterState{Origin: "10.1.0.2", Engines: []*EngineCounterState{
+		{Cluster: cluster, Engine: ip, Slot: PNCounterSlot{Incarnation: 1, QueuedInc: 1, PromptInc: 10}},
+	}})
+	es, ok := ls.GetModelStats(cluster).Load(ip)
+	require.True(t, ok)
+	require.Equal(t, int32(2), es.GetQueuedReqNum())
+
+	live := map[string]struct{}{"10.1.0.1": {}}
+	now := time.Now()
+	ls.failOrigins(live, now, time.Second, 1)
+	assert.Equal(t, 1, ls.failOrigins(live, now.Add(time.Second), time.Second, 1))
+	assert.Equal(t, int32(1), es.GetQueuedReqNum(), "the partition of the gone origin is dropped")
+	assert.Equal(t, []string{"10.1.0.1"}, es.counters.origins())
+	assert.Empty(t, ls.owner.partitionOrigins())
+}
@@ -0,0 +1,212 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package load
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/aigw-project/metadata-center/pkg/utils/logger"
+)
+
+const snapshotFile = "snapshot.json"
+
+// snapshotRequest is a tracked request with its creation time, which the request JSON leaves out
+type snapshotRequest struct {
+	*InferenceRequest
+	Created int64 `json:"created"`
+}
+
+// stateSnapshot holds the tracked requests and the tenant limits after the write-ahead log entry Seq
+// Engine counters are rebuilt from the requests, engine reports are refreshed by the engines
+type stateSnapshot struct {
+	Seq      uint64             `json:"seq"`
+	Time     int64              `json:"time"`
+	Requests []*snapshotRequest `json:"requests"`
+	Limits   []*TenantLimit     `json:"limits,omitempty"`
+}
+
+// restore rebuilds the state from the snapshot and the write-ahead log tail in dir, then logs new events there
+// Requests that expired while the instance was down are not restored
+func (ls *LoadStats) restore(dir string) error {
+	start := time.Now()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	snapshot, err := readSnapshot(dir)
+	if err != nil {
+		return err
+	}
+	restored, skipped := 0, 0
+	for _, req := range snapshot.Requests {
+		created := time.Unix(0, req.Created)
+		if !start.Before(created.Add(requestExpireDuration)) {
+			skipped++
+			continue
+		}
+		ls.addRequest(req.InferenceRequest, created)
+		restored++
+	}
+	for _, limit := range snapshot.Limits {
+		ls.limits.Store(limit.Tenant, limit)
+	}
+
+	// Deletes of expired requests are dropped as well, instead of leaving tombstones
+	expired := make(map[string]struct{})
+	replayed := 0
+	seq, err := readWAL(dir, snapshot.Seq, func(e *walEntry) {
+		replayed++
+		now := time.Unix(0, e.Time)
+		switch {
+		case e.Op == LoadStatsSet && e.Request != nil:
+			if !start.Before(now.Add(requestExpireDuration)) {
+				expired[e.Request.RequestId] = struct{}{}
+				return
+			}
+			ls.addRequest(e.Request, now)
+		case e.Op == LoadStatsDelete && e.Deletion != nil:
+			if _, ok := expired[e.Deletion.RequestId]; !ok {
+				ls.deleteRequest(e.Deletion.RequestId, now)
+			}
+		case e.Op == LoadAdminEvictRequest && e.Deletion != nil:
+			ls.tryDeleteRequestStats(e.Deletion.RequestId, now, false)
+		case e.Op == LoadPromptDelete && e.Deletion != nil:
+			if _, ok := expired[e.Deletion.RequestId]; !ok {
+				ls.deletePromptLength(e.Deletion.RequestId, now)
+			}
+		case e.Op == LoadAdminSetTenantLimit && e.Limit != nil:
+			ls.limits.Store(e.Limit.Tenant, e.Limit)
+		case e.Op == LoadAdminDeleteTenantLimit && e.Tenant != nil:
+			ls.limits.Delete(e.Tenant.Tenant)
+		default:
+			logger.Warnf("ignoring unknown wal entry %d of type %s", e.Seq, e.Op)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	w, err := openWAL(dir, seq)
+	if err != nil {
+		return err
+	}
+	ls.wal = w
+	logger.Infof("restored %d requests from snapshot at entry %d, skipped %d expired, replayed %d wal entries up to %d, duration: %s",
+		restored, snapshot.Seq, skipped, replayed, seq, time.Since(start))
+	return nil
+}
+
+// readSnapshot reads the snapshot in dir, an empty snapshot when there is none
+func readSnapshot(dir string) (*stateSnapshot, error) {
+	data, err := os.ReadFile(filepath.Join(dir, snapshotFile))
+	if os.IsNotExist(err) {
+		return &stateSnapshot{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var snapshot stateSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot: %w", err)
+	}
+	return &snapshot, nil
+}
+
+// writeSnapshot writes the snapshot atomically, a crash leaves the previous one in place
+func writeSnapshot(dir string, snapshot *stateSnapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, snapshotFile)
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// compact snapshots the tracked requests and the tenant limits, and removes the write-ahead log segments the snapshot covers
+// Events logged while the snapshot is taken may be in both, replaying them again is harmless:
+// adds of tracked requests are ignored and deletes of removed requests only leave a tombstone
+func (ls *LoadStats) compact() error {
+	if ls.wal == nil {
+		return nil
+	}
+	seq, err := ls.wal.checkpoint()
+	if err != nil {
+		return err
+	}
+	snapshot := &stateSnapshot{Seq: seq, Time: time.Now().UnixNano()}
+	ls.Requests.Range(func(_ string, req *InferenceRequest) bool {
+		snapshot.Requests = append(snapshot.Requests, &snapshotRequest{
+			InferenceRequest: &InferenceRequest{
+				Cluster:      req.Cluster,
+				RequestId:    req.RequestId,
+				PromptLength: atomic.LoadInt32(&req.PromptLength),
+				Ip:           req.Ip,
+				Endpoint:     req.Endpoint,
+				Model:        req.Model,
+				Priority:     req.Priority,
+				Origin:       req.Origin,
+			},
+			Created: req.CreateTime.UnixNano(),
+		})
+		return true
+	})
+	ls.limits.Range(func(_, value any) bool {
+		snapshot.Limits = append(snapshot.Limits, value.(*TenantLimit))
+		return true
+	})
+	if err := writeSnapshot(ls.wal.dir, snapshot); err != nil {
+		return err
+	}
+	ls.wal.truncate(seq)
+	logger.Infof("snapshotted %d requests and %d tenant limits at wal entry %d", len(snapshot.Requests), len(snapshot.Limits), seq)
+	return nil
+}
+
+// cronSnapshot snapshots the state and compacts the write-ahead log periodically
+func cronSnapshot(ticker *time.Ticker, stats *LoadStats) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Errorf("load snapshot goroutine panicked: %v", r)
+		}
+		ticker.Stop()
+		logger.Errorf("load snapshot goroutine exited")
+	}()
+
+	for range ticker.C {
+		if err := stats.compact(); err != nil {
+			logger.Errorf("failed to snapshot load statistics: %v", err)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package load
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// restored creates a LoadStats restored from dir
+func restored(t *testing.T, dir string) *LoadStats {
+	ls := NewLoadStats()
+	require.NoError(t, ls.restore(dir))
+	t.Cleanup(func() { ls.wal.close() })
+	return ls
+}
+
+func TestLoadStats_Restore(t *testing.T) {
+	dir := t.TempDir()
+	cluster := "restore"
+	engine := "10.0.6.1"
+
+	ls := restored(t, dir)
+	for _, id := range []string{"r-1", "r-2", "r-3"} {
+		ls.AddRequest(&InferenceRequest{Cluster: cluster, RequestId: id, PromptLength: 10, Ip: engine, Model: "qwen"})
+	}
+	ls.DeletePromptLength(&DeletionInferenceRequest{RequestId: "r-1"})
+	require.NoError(t, ls.compact())
+
+	// Events after the snapshot are replayed from the wal tail
+	ls.DeleteRequest(&DeletionInferenceRequest{RequestId: "r-2"})
+	ls.AddRequest(&InferenceRequest{Cluster: cluster, RequestId: "r-4", PromptLength: 5, Ip: engine})
+	ls.DeleteRequest(&DeletionInferenceRequest{RequestId: "r-late"})
+	created, ok := ls.GetRequest("r-3")
+	require.True(t, ok)
+	require.NoError(t, ls.wal.close())
+
+	ls = restored(t, dir)
+	es, ok := ls.GetModelStats(cluster).Load(engine)
+	require.True(t, ok)
+	assert.Equal(t, int32(3), es.GetQueuedReqNum())
+	assert.Equal(t, int32(15), es.GetPromptLength())
+	c, ok := es.GetModelCounter("qwen")
+	require.True(t, ok)
+	assert.Equal(t, &LoadCounter{QueuedReqNum: 2, PromptLength: 10}, c)
+	req, ok := ls.Requests.Load("r-3")
+	require.True(t, ok)
+	assert.Equal(t, created.CreateTime, req.CreateTime.UnixNano(), "requests keep their creation time")
+	assert.Equal(t, int64(1), ls.tombstones.len(), "unmatched deletes are restored as tombstones")
+	assert.Empty(t, ls.CheckInvariants(false))
+}
+
+func TestLoadStats_RestoreSkipsExpired(t *testing.T) {
+	dir := t.TempDir()
+	cluster := "restore-expired"
+	engine := "10.0.6.2"
+
+	ls := restored(t, dir)
+	old := time.Now().Add(-requestExpireDuration - time.Minute)
+	for _, id := range []string{"old-1", "old-2"} {
+		req := &InferenceRequest{Cluster: cluster, RequestId: id, PromptLength: 10, Ip: engine}
+		ls.wal.append(LoadStatsSet, old, req)
+		ls.addRequest(req, old)
+	}
+	require.NoError(t, ls.compact())
+	ls.wal.append(LoadStatsSet, old, &InferenceRequest{Cluster: cluster, RequestId: "old-3", PromptLength: 10, Ip: engine})
+	ls.DeleteRequest(&DeletionInferenceRequest{RequestId: "old-3"})
+	ls.AddRequest(&InferenceRequest{Cluster: cluster, RequestId: "new", PromptLength: 10, Ip: engine})
+	require.NoError(t, ls.wal.close())
+
+	ls = restored(t, dir)
+	es, ok := ls.GetModelStats(cluster).Load(engine)
+	require.True(t, ok)
+	assert.Equal(t, int32(1), es.GetQueuedReqNum())
+	assert.Equal(t, int64(1), ls.requestCount.Load())
+	assert.Equal(t, int64(0), ls.tombstones.len(), "deletes of expired requests leave no tombstone")
+}
+
+func TestLoadStats_RestoreOrigins(t *testing.T) {
+	dir := t.TempDir()
+	cluster := "restore-origins"
+	engine := "10.0.6.5"
+
+	ls := restored(t, dir)
+	ls.AddRequest(&InferenceRequest{Cluster: cluster, RequestId: "snapshotted", Ip: engine, Origin: "10.1.0.7"})
+	require.NoError(t, ls.compact())
+	ls.AddRequest(&InferenceRequest{Cluster: cluster, RequestId: "replayed", Ip: engine, Origin: "10.1.0.8"})
+	require.NoError(t, ls.wal.close())
+
+	ls = restored(t, dir)
+	for id, origin := range map[string]string{"snapshotted": "10.1.0.7", "replayed": "10.1.0.8"} {
+		req, ok := ls.Requests.Load(id)
+		require.True(t, ok)
+		assert.Equal(t, origin, req.Origin, "requests keep their origin, so failover still finds them after a restart")
+	}
+}
+
+func TestLoadStats_RestoreTenantLimits(t *testing.T) {
+	dir := t.TempDir()
+	limit := func(n int64) *int64 { return &n }
+
+	ls := restored(t, dir)
+	ls.SetTenantLimit(&TenantLimit{Tenant: "team-a", MaxRequests: limit(10)}, AuditSourceAPI)
+	ls.SetTenantLimit(&TenantLimit{Tenant: "team-b", MaxClusters: limit(2)}, AuditSourceAPI)
+	require.NoError(t, ls.compact())
+
+	// Changes after the snapshot are replayed from the wal tail
+	ls.SetTenantLimit(&TenantLimit{Tenant: "team-a", MaxRequests: limit(20)}, AuditSourceReplica)
+	ls.DeleteTenantLimit(&TenantAction{Tenant: "team-b"}, AuditSourceAPI)
+	ls.SetTenantLimit(&TenantLimit{Tenant: "team-c", MaxRequests: limit(0)}, AuditSourceAPI)
+	require.NoError(t, ls.wal.close())
+
+	ls = restored(t, dir)
+	page := ls.ListTenants(PageRequest{})
+	require.Equal(t, 2, page.Total)
+	assert.Equal(t, &TenantInfo{Tenant: "team-a", MaxRequests: 20, Overridden: true}, page.Items[0])
+	assert.Equal(t, &TenantInfo{Tenant: "team-c", Overridden: true}, page.Items[1])
+
+	// A snapshot of the restored state keeps the limits as well
+	require.NoError(t, ls.compact())
+	require.NoError(t, ls.wal.close())
+	ls = restored(t, dir)
+	assert.Equal(t, 2, ls.ListTenants(PageRequest{}).Total)
+}
+
+func TestLoadStats_RestoreRemovals(t *testing.T) {
+	dir := t.TempDir()
+	cluster := "restore-removals"
+	engine := "10.0.6.3"
+
+	ls := restored(t, dir)
+	for _, id := range []string{"evicted", "kept"} {
+		ls.AddRequest(&InferenceRequest{Cluster: cluster, RequestId: id, PromptLength: 10, Ip: engine})
+	}
+	ls.AddRequest(&InferenceRequest{Cluster: cluster, RequestId: "dropped", PromptLength: 10, Ip: "10.0.6.4"})
+	ls.AddRequest(&InferenceRequest{Cluster: "restore-removed", RequestId: "cluster-dropped", Ip: engine})
+	ls.AddRequest(&InferenceRequest{Cluster: cluster, RequestId: "failed", Ip: engine, Origin: "10.1.0.9"})
+	require.NoError(t, ls.compact())
+
+	// Removals after the snapshot are replayed from the wal tail
+	require.True(t, ls.EvictRequest(&EvictRequest{RequestId: "evicted"}, AuditSourceAPI).Found)
+	require.True(t, ls.DeleteEngine(&EngineAction{Cluster: cluster, Ip: "10.0.6.4"}, AuditSourceAPI).Found)
+	require.True(t, ls.DeleteCluster(&ClusterAction{Cluster: "restore-removed"}, AuditSourceAPI).Found)
+	require.Equal(t, 1, ls.failOrigins(map[string]struct{}{"10.1.0.1": {}}, time.Now(), 0, 1))
+
+	SetMaxRequests(1)
+	ls.AddRequest(&InferenceRequest{Cluster: cluster, RequestId: "newest", Ip: engine})
+	SetMaxRequests(int(DefaultMaxRequests))
+	_, ok := ls.Requests.Load("kept")
+	require.False(t, ok, "the oldest request is evicted at capacity")
+	require.NoError(t, ls.wal.close())
+
+	ls = restored(t, dir)
+	var ids []string
+	ls.Requests.Range(func(id string, _ *InferenceRequest) bool {
+		ids = append(ids, id)
+		return true
+	})
+	assert.Equal(t, []string{"newest"}, ids)
+	es, ok := ls.GetModelStats(cluster).Load(engine)
+	require.True(t, ok)
+	assert.Equal(t, int32(1), es.GetQueuedReqNum())
+	assert.Equal(t, int32(0), es.GetPromptLength())
+	assert.Equal(t, int64(0), ls.tombstones.len(), "removals leave no tombstone")
+	assert.Empty(t, ls.CheckInvariants(false))
+}
@@ -0,0 +1,285 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package load
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+
+	"github.com/aigw-project/metadata-center/pkg/prom"
+	"github.com/aigw-project/metadata-center/pkg/replicator"
+	"github.com/aigw-project/metadata-center/pkg/utils/logger"
+)
+
+// reconcileMaxConcurrency limits the number of engines scraped in parallel
+const reconcileMaxConcurrency = 16
+
+// engineMetricNames describes the Prometheus metric names exported by an inference engine
+type engineMetricNames struct {
+	running string
+	waiting string
+	// kvUsage lists candidate names, engines renamed the KV cache usage metric across versions
+	kvUsage []string
+}
+
+// knownEngineMetrics lists the metric names of supported inference engines
+// The first set whose running or waiting metric is present in a scrape wins
+var knownEngineMetrics = []engineMetricNames{
+	// vLLM
+	{
+		running: "vllm:num_requests_running",
+		waiting: "vllm:num_requests_waiting",
+		kvUsage: []string{"vllm:gpu_cache_usage_perc", "vllm:kv_cache_usage_perc"},
+	},
+	// SGLang
+	{
+		running: "sglang:num_running_reqs",
+		waiting: "sglang:num_queue_reqs",
+		kvUsage: []string{"sglang:token_usage"},
+	},
+}
+
+// EngineMetrics holds the load values scraped from an engine
+type EngineMetrics struct {
+	RunningReqNum int32
+	WaitingReqNum int32
+	KVCacheUsage  float64
+}
+
+// QueuedReqNum returns the engine-side equivalent of EngineStats.QueuedReqNum
+func (m *EngineMetrics) QueuedReqNum() int32 {
+	return m.RunningReqNum + m.WaitingReqNum
+}
+
+// reconcileOperator is the operator recorded in the audit log for reconciler corrections
+const reconcileOperator = "reconciler"
+
+// replicateCorrection sends a correction to the other instances, which apply it like the admin operations
+var replicateCorrection = func(correction *EngineCorrection) {
+	replicator.Replicate(context.Background(), LoadAdminCorrectEngine, correction)
+}
+
+// Reconciler compares gateway-derived counters with engine-reported metrics
+type Reconciler struct {
+	stats  *LoadStats
+	client *http.Client
+	// correct overwrites the counters with the engine-reported values, otherwise the drift is only reported
+	correct bool
+}
+
+// NewReconciler creates a new Reconciler for the given load statistics
+func NewReconciler(stats *LoadStats) *Reconciler {
+	return &Reconciler{
+		stats:   stats,
+		client:  &http.Client{Timeout: reconcileScrapeTimeout},
+		correct: reconcileCorrects() && stats.owner == nil,
+	}
+}
+
+// reconcileCorrects reports whether the reconciler corrects counters
+// The invariant fix resets the counters to the request table, which a correction leaves untouched,
+// so the two would undo each other every cycle. The invariant fix wins, the reconciler then only reports drift.
+// In crdt mode the counters are the sum of partitions no instance may overwrite, so no reconciler corrects them
+func reconcileCorrects() bool {
+	return reconcileCorrect && !(invariantCheckInterval > 0 && invariantFix)
+}
+
+// cronReconcile runs periodic reconciliation against engine metrics
+func cronReconcile(ticker *time.Ticker, r *Reconciler) {
+	defer func() {
+		if p := recover(); p != nil {
+			logger.Errorf("load reconcile goroutine panicked: %v", p)
+		}
+		ticker.Stop()
+		logger.Errorf("load reconcile goroutine exited")
+	}()
+
+	for range ticker.C {
+		start := time.Now()
+		r.Reconcile(context.Background())
+		logger.Infof("completed engine metrics reconciliation, duration: %d", time.Since(start))
+	}
+}
+
+// Reconcile scrapes every known engine once and compares the results with the counters
+func (r *Reconciler) Reconcile(ctx context.Context) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, reconcileMaxConcurrency)
+	r.stats.RunningModelStats.Range(func(cluster string, modelStats *ModelStats) bool {
+		for _, engineStats := range modelStats.ToEngines() {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(es *EngineStats) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				r.reconcileEngine(ctx, cluster, es)
+			}(engineStats)
+		}
+		return true
+	})
+	wg.Wait()
+}
+
+// reconcileEngine scrapes a single engine and updates drift metrics and counters
+func (r *Reconciler) reconcileEngine(ctx context.Context, cluster string, es *EngineStats) {
+	engine := es.Key()
+	metrics, err := r.scrape(ctx, es)
+	if err != nil {
+		prom.ReconcileScrapeTotal.WithLabelValues("failure").Inc()
+		logger.Warnf("reconcile: scrape engine %s on model %s failed: %v", engine, cluster, err)
+		return
+	}
+	prom.ReconcileScrapeTotal.WithLabelValues("success").Inc()
+
+	// A scrape is as authoritative as a pushed report, keep it for blended queries
+	es.SetReport(&EngineReport{
+		Cluster:       cluster,
+		Ip:            es.Ip,
+		Endpoint:      es.Endpoint,
+		RunningReqNum: metrics.RunningReqNum,
+		WaitingReqNum: metrics.WaitingReqNum,
+		KVCacheUsage:  metrics.KVCacheUsage,
+		ReportedTime:  time.Now().UnixNano(),
+	})
+
+	reported := metrics.QueuedReqNum()
+	drift := es.GetQueuedReqNum() - reported
+	prom.SetReconcileMetric(cluster, engine, reported, drift, metrics.KVCacheUsage)
+	if drift == 0 {
+		return
+	}
+
+	logger.Debugf("reconcile: engine %s on model %s drift %d, estimated %d, reported %d",
+		engine, cluster, drift, es.GetQueuedReqNum(), reported)
+	if r.correct {
+		correction := &EngineCorrection{
+			EngineAction: EngineAction{
+				Cluster:  cluster,
+				Ip:       es.Ip,
+				Endpoint: es.Endpoint,
+				AdminAction: AdminAction{
+					Operator: reconcileOperator,
+					Reason:   fmt.Sprintf("engine reported %d queued requests, drift %d", reported, drift),
+				},
+			},
+			Correction: es.CorrectQueuedReqNum(cluster, reported),
+		}
+		audit("correct_engine", cluster+"/"+engine, &correction.AdminAction, AuditSourceReconciler,
+			&AdminResult{Found: true})
+		replicateCorrection(correction)
+		prom.ReconcileCorrectionTotal.WithLabelValues(cluster).Inc()
+		logger.Infof("reconcile: corrected engine %s on model %s queued request num to %d, drift %d",
+			engine, cluster, reported, drift)
+	}
+}
+
+// scrape fetches and parses the Prometheus metrics of the given engine
+// vLLM and SGLang serve metrics on the API port, so an engine endpoint is scraped as is
+func (r *Reconciler) scrape(ctx context.Context, es *EngineStats) (*EngineMetrics, error) {
+	addr := es.Endpoint
+	if addr == "" {
+		addr = net.JoinHostPort(es.Ip, strconv.Itoa(reconcileMetricsPort))
+	}
+	url := fmt.Sprintf("http://%s%s", addr, reconcileMetricsPath)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create scrape request: %w", err)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scrape %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("scrape %s returned non-200 status: %d", url, resp.StatusCode)
+	}
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse metrics from %s: %w", url, err)
+	}
+	return parseEngineMetrics(families)
+}
+
+// parseEngineMetrics extracts load values from the scraped metric families
+func parseEngineMetrics(families map[string]*dto.MetricFamily) (*EngineMetrics, error) {
+	for _, names := range knownEngineMetrics {
+		running, hasRunning := families[names.running]
+		waiting, hasWaiting := families[names.waiting]
+		if !hasRunning && !hasWaiting {
+			continue
+		}
+		metrics := &EngineMetrics{
+			RunningReqNum: int32(sumMetricFamily(running)),
+			WaitingReqNum: int32(sumMetricFamily(waiting)),
+		}
+		for _, name := range names.kvUsage {
+			if kvUsage, ok := families[name]; ok {
+				metrics.KVCacheUsage = maxMetricFamily(kvUsage)
+				break
+			}
+		}
+		return metrics, nil
+	}
+	return nil, fmt.Errorf("no known engine load metrics found")
+}
+
+// sumMetricFamily sums values across all series of a family
+// Engines serving multiple models export one series per model label
+func sumMetricFamily(mf *dto.MetricFamily) float64 {
+	var sum float64
+	for _, m := range mf.GetMetric() {
+		sum += metricValue(m)
+	}
+	return sum
+}
+
+// maxMetricFamily returns the largest value across all series of a family
+// Used for ratios such as KV cache usage, which must not be summed
+func maxMetricFamily(mf *dto.MetricFamily) float64 {
+	var maxValue float64
+	for _, m := range mf.GetMetric() {
+		if v := metricValue(m); v > maxValue {
+			maxValue = v
+		}
+	}
+	return maxValue
+}
+
+// metricValue returns the value of a gauge, counter or untyped series
+func metricValue(m *dto.Metric) float64 {
+	switch {
+	case m.GetGauge() != nil:
+		return m.GetGauge().GetValue()
+	case m.GetCounter() != nil:
+		return m.GetCounter().GetValue()
+	case m.GetUntyped() != nil:
+		return m.GetUntyped().GetValue()
+	}
+	return 0
+}
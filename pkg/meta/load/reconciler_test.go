@@ -0,0 +1,322 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package load
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const vllmMetrics = `# HELP vllm:num_requests_running Number of requests currently running on GPU.
+# TYPE vllm:num_requests_running gauge
+vllm:num_requests_running{model_name="qwen"} 2.0
+vllm:num_requests_running{model_name="llama"} 1.0
+# HELP vllm:num_requests_waiting Number of requests waiting to be processed.
+# TYPE vllm:num_requests_waiting gauge
+vllm:num_requests_waiting{model_name="qwen"} 1.0
+# HELP vllm:gpu_cache_usage_perc GPU KV-cache usage. 1 means 100 percent usage.
+# TYPE vllm:gpu_cache_usage_perc gauge
+vllm:gpu_cache_usage_perc{model_name="qwen"} 0.25
+vllm:gpu_cache_usage_perc{model_name="llama"} 0.5
+`
+
+const sglangMetrics = `# TYPE sglang:num_running_reqs gauge
+sglang:num_running_reqs{model_name="deepseek"} 3.0
+# TYPE sglang:num_queue_reqs gauge
+sglang:num_queue_reqs{model_name="deepseek"} 2.0
+# TYPE sglang:token_usage gauge
+sglang:token_usage{model_name="deepseek"} 0.75
+`
+
+// newFakeEngine starts a local /metrics server and points the reconciler at its port
+func newFakeEngine(t *testing.T, body string, status int) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != DefaultReconcileMetricsPath {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+
+	host, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	require.NoError(t, err)
+	p, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	SetReconcileMetricsPort(p)
+	t.Cleanup(func() {
+		SetReconcileMetricsPort(DefaultReconcileMetricsPort)
+	})
+	return host
+}
+
+func addRequests(ls *LoadStats, cluster, ip string, n int) {
+	for i := 0; i < n; i++ {
+		ls.AddRequest(&InferenceRequest{
+			Cluster:      cluster,
+			RequestId:    fmt.Sprintf("%s-%s-%d", cluster, ip, i),
+			PromptLength: 100,
+			Ip:           ip,
+		})
+	}
+}
+
+// stubReplicateCorrection records the corrections sent to other instances instead of replicating them
+func stubReplicateCorrection(t *testing.T) *[]*EngineCorrection {
+	var replicated []*EngineCorrection
+	original := replicateCorrection
+	replicateCorrection = func(correction *EngineCorrection) {
+		replicated = append(replicated, correction)
+	}
+	t.Cleanup(func() {
+		replicateCorrection = original
+	})
+	return &replicated
+}
+
+// gaugeValue returns the value of a gauge or counter series of the default registry by its labels
+func gaugeValue(t *testing.T, name string, labels map[string]string) float64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	require.NoError(t, err)
+	for _, mf := range families {
+		if mf.GetName() != name {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			values := make(map[string]string)
+			for _, label := range m.GetLabel() {
+				values[label.GetName()] = label.GetValue()
+			}
+			if reflect.DeepEqual(values, labels) && m.GetCounter() != nil {
+				return m.GetCounter().GetValue()
+			}
+			if reflect.DeepEqual(values, labels) {
+				return m.GetGauge().GetValue()
+			}
+		}
+	}
+	t.Fatalf("gauge %s%v not found", name, labels)
+	return 0
+}
+
+// engineLabels returns the labels of the metrics of an engine
+func engineLabels(cluster, engine string) map[string]string {
+	return map[string]string{"model_name": cluster, "engine_ip": engine}
+}
+
+func TestParseEngineMetrics(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    *EngineMetrics
+		wantErr bool
+	}{
+		{
+			name: "vllm",
+			body: vllmMetrics,
+			want: &EngineMetrics{RunningReqNum: 3, WaitingReqNum: 1, KVCacheUsage: 0.5},
+		},
+		{
+			name: "vllm v1 kv cache metric",
+			body: strings.ReplaceAll(vllmMetrics, "gpu_cache_usage_perc", "kv_cache_usage_perc"),
+			want: &EngineMetrics{RunningReqNum: 3, WaitingReqNum: 1, KVCacheUsage: 0.5},
+		},
+		{
+			name: "sglang",
+			body: sglangMetrics,
+			want: &EngineMetrics{RunningReqNum: 3, WaitingReqNum: 2, KVCacheUsage: 0.75},
+		},
+		{
+			name:    "unknown engine",
+			body:    "# TYPE foo gauge\nfoo 1\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var parser expfmt.TextParser
+			families, err := parser.TextToMetricFamilies(strings.NewReader(tt.body))
+			require.NoError(t, err)
+
+			got, err := parseEngineMetrics(families)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestReconciler_Reconcile(t *testing.T) {
+	cluster := "reconcile_domain"
+
+	t.Run("drift without correction", func(t *testing.T) {
+		ip := newFakeEngine(t, vllmMetrics, http.StatusOK)
+		ls := NewLoadStats()
+		addRequests(ls, cluster, ip, 6)
+
+		NewReconciler(ls).Reconcile(context.Background())
+
+		es, ok := ls.GetModelStats(cluster).Load(ip)
+		require.True(t, ok)
+		assert.Equal(t, int32(6), es.GetQueuedReqNum())
+		assert.Equal(t, float64(2), gaugeValue(t, "engine_queued_num_drift", engineLabels(cluster, ip)))
+		assert.Equal(t, float64(4), gaugeValue(t, "engine_reported_queued_num", engineLabels(cluster, ip)))
+	})
+
+	t.Run("invariant fix disables correction", func(t *testing.T) {
+		ip := newFakeEngine(t, sglangMetrics, http.StatusOK)
+		SetReconcileCorrect(true)
+		SetInvariantCheckInterval(time.Second)
+		SetInvariantFix(true)
+		defer func() {
+			SetReconcileCorrect(false)
+			SetInvariantCheckInterval(DefaultInvariantCheckInterval)
+			SetInvariantFix(false)
+		}()
+		ls := NewLoadStats()
+		addRequests(ls, cluster, ip, 7)
+
+		NewReconciler(ls).Reconcile(context.Background())
+
+		es, ok := ls.GetModelStats(cluster).Load(ip)
+		require.True(t, ok)
+		assert.Equal(t, int32(7), es.GetQueuedReqNum(), "counters stay consistent with the request table")
+		assert.Equal(t, float64(2), gaugeValue(t, "engine_queued_num_drift", engineLabels(cluster, ip)))
+		assert.Empty(t, ls.CheckInvariants(true))
+	})
+
+	t.Run("drift with correction", func(t *testing.T) {
+		ip := newFakeEngine(t, sglangMetrics, http.StatusOK)
+		SetReconcileCorrect(true)
+		defer SetReconcileCorrect(false)
+		replicated := stubReplicateCorrection(t)
+		ls := NewLoadStats()
+		addRequests(ls, cluster, ip, 7)
+
+		NewReconciler(ls).Reconcile(context.Background())
+
+		es, ok := ls.GetModelStats(cluster).Load(ip)
+		require.True(t, ok)
+		assert.Equal(t, int32(5), es.GetQueuedReqNum())
+		assert.Equal(t, int32(700), es.GetPromptLength())
+		assert.Equal(t, int32(-2), es.GetCorrection(), "the correction is an offset against the tracked requests")
+		assert.Empty(t, ls.CheckInvariants(false), "the invariant check accounts for the correction")
+
+		require.Len(t, *replicated, 1)
+		assert.Equal(t, cluster, (*replicated)[0].Cluster)
+		assert.Equal(t, ip, (*replicated)[0].Ip)
+		assert.Equal(t, int32(-2), (*replicated)[0].Correction)
+
+		// The tracked requests still decrement the corrected count, the last two are absorbed by the offset
+		for i := 0; i < 7; i++ {
+			ls.DeleteRequest(&DeletionInferenceRequest{RequestId: fmt.Sprintf("%s-%s-%d", cluster, ip, i)})
+		}
+		assert.Equal(t, int32(0), es.GetQueuedReqNum())
+		assert.Equal(t, int32(0), es.GetCorrection())
+	})
+
+	t.Run("replicated correction", func(t *testing.T) {
+		ip := "10.9.0.1"
+		ls := NewLoadStats()
+		addRequests(ls, cluster, ip, 3)
+		correction := &EngineCorrection{EngineAction: EngineAction{Cluster: cluster, Ip: ip}, Correction: 2}
+
+		// Applying the same offset twice, as every reconciler may, is idempotent
+		for i := 0; i < 2; i++ {
+			result := ls.CorrectEngine(correction, AuditSourceReplica)
+			require.True(t, result.Found)
+			assert.Equal(t, int32(5), result.Engine.QueuedReqNum)
+		}
+		addRequests(ls, cluster, "10.9.0.2", 1)
+		ls.AddRequest(&InferenceRequest{Cluster: cluster, RequestId: "replicated-extra", Ip: ip})
+		es, ok := ls.GetModelStats(cluster).Load(ip)
+		require.True(t, ok)
+		assert.Equal(t, int32(6), es.GetQueuedReqNum())
+
+		result := ls.CorrectEngine(&EngineCorrection{EngineAction: EngineAction{Cluster: cluster, Ip: "10.9.0.3"}}, AuditSourceReplica)
+		assert.False(t, result.Found)
+
+		ls.ResetEngine(&EngineAction{Cluster: cluster, Ip: ip}, AuditSourceAPI)
+		assert.Equal(t, int32(4), es.GetQueuedReqNum(), "a reset rebuilds the count from the tracked requests only")
+		assert.Equal(t, int32(0), es.GetCorrection())
+	})
+
+	t.Run("crdt mode disables correction", func(t *testing.T) {
+		ip := newFakeEngine(t, sglangMetrics, http.StatusOK)
+		SetReconcileCorrect(true)
+		defer SetReconcileCorrect(false)
+		ls := NewLoadStats()
+		ls.enableCounterPartitions("10.1.0.1")
+		addRequests(ls, cluster, ip, 7)
+
+		NewReconciler(ls).Reconcile(context.Background())
+
+		es, ok := ls.GetModelStats(cluster).Load(ip)
+		require.True(t, ok)
+		assert.Equal(t, int32(7), es.GetQueuedReqNum(), "partitions are left to their owners")
+		assert.Equal(t, float64(2), gaugeValue(t, "engine_queued_num_drift", engineLabels(cluster, ip)))
+	})
+
+	t.Run("scrape failure keeps counters", func(t *testing.T) {
+		ip := newFakeEngine(t, "", http.StatusInternalServerError)
+		SetReconcileCorrect(true)
+		defer SetReconcileCorrect(false)
+		ls := NewLoadStats()
+		addRequests(ls, cluster, ip, 2)
+
+		NewReconciler(ls).Reconcile(context.Background())
+
+		es, ok := ls.GetModelStats(cluster).Load(ip)
+		require.True(t, ok)
+		assert.Equal(t, int32(2), es.GetQueuedReqNum())
+	})
+}
+
+func TestReconciler_ScrapeEndpoint(t *testing.T) {
+	host := newFakeEngine(t, vllmMetrics, http.StatusOK)
+	endpoint := net.JoinHostPort(host, strconv.Itoa(reconcileMetricsPort))
+	// Engines with an endpoint are scraped on their own port, not the configured one
+	SetReconcileMetricsPort(DefaultReconcileMetricsPort)
+
+	ls := NewLoadStats()
+	cluster := "reconcile_endpoint_domain"
+	ls.AddRequest(&InferenceRequest{Cluster: cluster, RequestId: "r-1", Endpoint: endpoint})
+
+	NewReconciler(ls).Reconcile(context.Background())
+
+	es, ok := ls.GetModelStats(cluster).Load(endpoint)
+	require.True(t, ok)
+	report := es.GetReport()
+	require.NotNil(t, report)
+	assert.Equal(t, endpoint, report.Endpoint)
+	assert.Equal(t, int32(4), report.QueuedReqNum())
+}
@@ -0,0 +1,477 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package load
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/aigw-project/metadata-center/pkg/prom"
+	"github.com/aigw-project/metadata-center/pkg/utils/logger"
+)
+
+// Fields of the engine hashes of the Redis backend, sub-counter fields are suffixed with the model or priority
+const (
+	redisQueuedReqNum   = "queued"
+	redisPromptLength   = "prompt"
+	redisUpdatedTime    = "updated"
+	redisReport         = "report"
+	redisModelQueued    = "mq:"
+	redisModelPrompt    = "mp:"
+	redisPriorityQueued = "pq:"
+	redisPriorityPrompt = "pp:"
+)
+
+// redisExpireBatchSize is the number of due requests removed by one run of the expire script
+const redisExpireBatchSize = 256
+
+// Results of the add script
+const (
+	redisAddDuplicate = iota
+	redisAdded
+	redisAddedWithoutPrompt
+	redisAddDropped
+)
+
+// redisFunctions are shared by the scripts
+// An engine is stored as the hash <prefix>engine:<cluster>|<engine>, engine keys never contain |
+// release decrements the counters of a request r = {cluster, engine, model, priority, prompt} on its engine
+// Counters are clamped at 0 and engines that expired already are left alone, like in memory
+const redisFunctions = `
+local function dec(key, field, n)
+  if n > 0 and redis.call('HINCRBY', key, field, -n) < 0 then
+    redis.call('HSET', key, field, 0)
+  end
+end
+local function engine_key(prefix, r)
+  return prefix .. 'engine:' .. r[1] .. '|' .. r[2]
+end
+local function release(prefix, r, queued, prompt, now, ttl)
+  local key = engine_key(prefix, r)
+  if redis.call('EXISTS', key) == 0 then
+    return
+  end
+  dec(key, 'queued', queued)
+  dec(key, 'prompt', prompt)
+  if r[3] ~= '' then
+    dec(key, 'mq:' .. r[3], queued)
+    dec(key, 'mp:' .. r[3], prompt)
+  end
+  if r[4] ~= '' then
+    dec(key, 'pq:' .. r[4], queued)
+    dec(key, 'pp:' .. r[4], prompt)
+  end
+  redis.call('HSET', key, 'updated', now)
+  redis.call('PEXPIRE', key, ttl)
+end
+local function request(key)
+  return redis.call('HMGET', key, 'cluster', 'engine', 'model', 'priority', 'prompt')
+end
+`
+
+// redisAddScript adds a request unless it exists, a tombstone left by an earlier delete cancels out against it
+// KEYS: request, tombstone, expiry, clusters, cluster engines, engine
+// ARGV: id, cluster, engine, model, priority, prompt length, now, deadline ms, engine TTL ms, request TTL ms
+var redisAddScript = redis.NewScript(redisFunctions + `
+local prompt = tonumber(ARGV[6])
+local result = 1
+local tombstone = redis.call('GET', KEYS[2])
+if tombstone then
+  redis.call('DEL', KEYS[2])
+  if tombstone == 'request' then
+    return 3
+  end
+  prompt = 0
+  result = 2
+end
+if redis.call('EXISTS', KEYS[1]) == 1 then
+  return 0
+end
+redis.call('HSET', KEYS[1], 'cluster', ARGV[2], 'engine', ARGV[3], 'model', ARGV[4], 'priority', ARGV[5], 'prompt', prompt)
+redis.call('PEXPIRE', KEYS[1], ARGV[10])
+redis.call('ZADD', KEYS[3], ARGV[8], ARGV[1])
+redis.call('SADD', KEYS[4], ARGV[2])
+redis.call('SADD', KEYS[5], ARGV[3])
+redis.call('PEXPIRE', KEYS[5], ARGV[9])
+redis.call('HINCRBY', KEYS[6], 'queued', 1)
+redis.call('HINCRBY', KEYS[6], 'prompt', prompt)
+if ARGV[4] ~= '' then
+  redis.call('HINCRBY', KEYS[6], 'mq:' .. ARGV[4], 1)
+  redis.call('HINCRBY', KEYS[6], 'mp:' .. ARGV[4], prompt)
+end
+if ARGV[5] ~= '' then
+  redis.call('HINCRBY', KEYS[6], 'pq:' .. ARGV[5], 1)
+  redis.call('HINCRBY', KEYS[6], 'pp:' .. ARGV[5], prompt)
+end
+redis.call('HSET', KEYS[6], 'updated', ARGV[7])
+redis.call('PEXPIRE', KEYS[6], ARGV[9])
+return result
+`)
+
+// redisDeleteScript removes a request and decrements its engine, or leaves a tombstone when it is not added yet
+// KEYS: request, tombstone, expiry
+// ARGV: id, prefix, now, engine TTL ms, tombstone TTL ms
+var redisDeleteScript = redis.NewScript(redisFunctions + `
+local r = request(KEYS[1])
+if not r[1] then
+  if tonumber(ARGV[5]) > 0 then
+    redis.call('SET', KEYS[2], 'request', 'PX', ARGV[5])
+  end
+  return 0
+end
+redis.call('DEL', KEYS[1])
+redis.call('ZREM', KEYS[3], ARGV[1])
+release(ARGV[2], r, 1, tonumber(r[5]), ARGV[3], ARGV[4])
+return 1
+`)
+
+// redisPromptDeleteScript removes the prompt length of a request once, or leaves a tombstone when it is not added yet
+// A prompt tombstone never replaces a request tombstone
+// KEYS: request, tombstone
+// ARGV: prefix, now, engine TTL ms, tombstone TTL ms
+var redisPromptDeleteScript = redis.NewScript(redisFunctions + `
+local r = request(KEYS[1])
+if not r[1] then
+  if tonumber(ARGV[4]) > 0 then
+    redis.call('SET', KEYS[2], 'prompt', 'NX', 'PX', ARGV[4])
+  end
+  return 0
+end
+local prompt = tonumber(r[5])
+if prompt > 0 then
+  redis.call('HSET', KEYS[1], 'prompt', 0)
+  release(ARGV[1], r, 0, prompt, ARGV[2], ARGV[3])
+end
+return 1
+`)
+
+// redisExpireScript removes a batch of the requests whose deadline has passed
+// Returns the number of due entries and the number of requests removed
+// KEYS: expiry
+// ARGV: prefix, bound ms, now, engine TTL ms, batch size
+var redisExpireScript = redis.NewScript(redisFunctions + `
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[2], 'LIMIT', 0, ARGV[5])
+local expired = 0
+for _, id in ipairs(ids) do
+  local key = ARGV[1] .. 'req:' .. id
+  local r = request(key)
+  redis.call('ZREM', KEYS[1], id)
+  if r[1] then
+    redis.call('DEL', key)
+    release(ARGV[1], r, 1, tonumber(r[5]), ARGV[3], ARGV[4])
+    expired = expired + 1
+  end
+end
+return {#ids, expired}
+`)
+
+// redisPruneScript removes the members of an index whose key is gone
+// The key is checked again by the script, so a member re-created since it was read stays indexed
+// KEYS: index
+// ARGV: member key prefix, members...
+var redisPruneScript = redis.NewScript(`
+local pruned = 0
+for i = 2, #ARGV do
+  if redis.call('EXISTS', ARGV[1] .. ARGV[i]) == 0 then
+    pruned = pruned + redis.call('SREM', KEYS[1], ARGV[i])
+  end
+end
+return pruned
+`)
+
+// RedisBackend stores the load statistics in a Redis-protocol store shared by all instances
+// Every counter update runs as a Lua script, so concurrent instances never interleave
+// Each request is a key expiring after the request expire duration, engines expire once idle for as long
+// The scripts derive engine keys from the request, so they need a single server rather than Redis Cluster
+type RedisBackend struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// NewRedisBackend creates a Redis backend storing its keys under the given prefix
+func NewRedisBackend(client redis.UniversalClient, prefix string) *RedisBackend {
+	return &RedisBackend{client: client, prefix: prefix}
+}
+
+func (b *RedisBackend) requestKey(id string) string {
+	return b.prefix + "req:" + id
+}
+
+func (b *RedisBackend) tombstoneKey(id string) string {
+	return b.prefix + "tombstone:" + id
+}
+
+func (b *RedisBackend) expiryKey() string {
+	return b.prefix + "expiry"
+}
+
+func (b *RedisBackend) clustersKey() string {
+	return b.prefix + "clusters"
+}
+
+func (b *RedisBackend) enginesKey(cluster string) string {
+	return b.prefix + "engines:" + cluster
+}
+
+func (b *RedisBackend) engineKey(cluster, engine string) string {
+	return b.prefix + "engine:" + cluster + "|" + engine
+}
+
+// fail counts a failed operation and wraps its error
+func (b *RedisBackend) fail(op string, err error) error {
+	prom.BackendErrorTotal.WithLabelValues(op).Inc()
+	return fmt.Errorf("redis load backend %s: %w", op, err)
+}
+
+// AddRequest adds a new inference request, requests that exist already are ignored
+func (b *RedisBackend) AddRequest(req *InferenceRequest) error {
+	req.CreateTime = time.Now()
+	prom.SetReplicationLatencyMillisecond(req.TimeStamp, req.RequestId)
+	engine := req.EngineKey()
+	keys := []string{
+		b.requestKey(req.RequestId),
+		b.tombstoneKey(req.RequestId),
+		b.expiryKey(),
+		b.clustersKey(),
+		b.enginesKey(req.Cluster),
+		b.engineKey(req.Cluster, engine),
+	}
+	result, err := redisAddScript.Run(context.Background(), b.client, keys,
+		req.RequestId, req.Cluster, engine, req.Model, req.Priority, req.PromptLength,
+		req.CreateTime.UnixNano(), req.CreateTime.Add(requestExpireDuration).UnixMilli(),
+		requestExpireDuration.Milliseconds(), 2*requestExpireDuration.Milliseconds()).Int()
+	if err != nil {
+		return b.fail("add", err)
+	}
+	switch result {
+	case redisAddDuplicate:
+		logger.Infof("reqID [%s]: request ID already exists, ignoring add action", req.RequestId)
+	case redisAddedWithoutPrompt:
+		prom.TombstoneHitTotal.WithLabelValues(tombstonePrompt).Inc()
+		logger.Infof("reqID [%s]: add matched a %s delete", req.RequestId, tombstonePrompt)
+	case redisAddDropped:
+		prom.TombstoneHitTotal.WithLabelValues(tombstoneRequest).Inc()
+		logger.Infof("reqID [%s]: add matched a %s delete", req.RequestId, tombstoneRequest)
+	}
+	return nil
+}
+
+// DeleteRequest removes an inference request, a delete before its add leaves a tombstone
+func (b *RedisBackend) DeleteRequest(req *DeletionInferenceRequest) error {
+	prom.SetReplicationLatencyMillisecond(req.TimeStamp, req.RequestId)
+	keys := []string{b.requestKey(req.RequestId), b.tombstoneKey(req.RequestId), b.expiryKey()}
+	deleted, err := redisDeleteScript.Run(context.Background(), b.client, keys,
+		req.RequestId, b.prefix, time.Now().UnixNano(),
+		requestExpireDuration.Milliseconds(), tombstoneTTL.Milliseconds()).Int()
+	if err != nil {
+		return b.fail("delete", err)
+	}
+	if deleted == 0 {
+		logger.Infof("reqID [%s]: request ID not found, keeping %s delete as tombstone", req.RequestId, tombstoneRequest)
+	}
+	return nil
+}
+
+// DeletePromptLength removes the prompt length of an inference request
+func (b *RedisBackend) DeletePromptLength(req *DeletionInferenceRequest) error {
+	prom.SetReplicationLatencyMillisecond(req.TimeStamp, req.RequestId)
+	keys := []string{b.requestKey(req.RequestId), b.tombstoneKey(req.RequestId)}
+	deleted, err := redisPromptDeleteScript.Run(context.Background(), b.client, keys,
+		b.prefix, time.Now().UnixNano(),
+		requestExpireDuration.Milliseconds(), tombstoneTTL.Milliseconds()).Int()
+	if err != nil {
+		return b.fail("prompt_delete", err)
+	}
+	if deleted == 0 {
+		logger.Infof("reqID [%s]: request ID not found, keeping %s delete as tombstone", req.RequestId, tombstonePrompt)
+	}
+	return nil
+}
+
+// SetEngineReport stores an engine-reported load snapshot next to the estimated counters
+func (b *RedisBackend) SetEngineReport(report *EngineReport) error {
+	if report.ReportedTime == 0 {
+		report.ReportedTime = time.Now().UnixNano()
+	}
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	engine := report.EngineKey()
+	engineKey := b.engineKey(report.Cluster, engine)
+	ttl := requestExpireDuration
+	_, err = b.client.TxPipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		pipe.SAdd(context.Background(), b.clustersKey(), report.Cluster)
+		pipe.SAdd(context.Background(), b.enginesKey(report.Cluster), engine)
+		pipe.PExpire(context.Background(), b.enginesKey(report.Cluster), ttl)
+		pipe.HSet(context.Background(), engineKey, redisReport, data)
+		pipe.HSetNX(context.Background(), engineKey, redisUpdatedTime, time.Now().UnixNano())
+		pipe.PExpire(context.Background(), engineKey, ttl)
+		return nil
+	})
+	if err != nil {
+		return b.fail("report", err)
+	}
+	return nil
+}
+
+// Snapshots returns the engines of a cluster with the model filter and the blend mode applied
+// Engines that expired are removed from the cluster on the way
+func (b *RedisBackend) Snapshots(cluster, model, blend string, now int64) ([]*EngineSnapshot, error) {
+	ctx := context.Background()
+	engines, err := b.client.SMembers(ctx, b.enginesKey(cluster)).Result()
+	if err != nil {
+		return nil, b.fail("snapshots", err)
+	}
+	cmds := make([]*redis.MapStringStringCmd, len(engines))
+	_, err = b.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, engine := range engines {
+			cmds[i] = pipe.HGetAll(ctx, b.engineKey(cluster, engine))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, b.fail("snapshots", err)
+	}
+
+	snapshots := make([]*EngineSnapshot, 0, len(engines))
+	var expired []any
+	for i, engine := range engines {
+		fields := cmds[i].Val()
+		if len(fields) == 0 {
+			expired = append(expired, engine)
+			continue
+		}
+		snapshots = append(snapshots, redisSnapshot(engine, fields))
+	}
+	b.prune(ctx, b.enginesKey(cluster), b.engineKey(cluster, ""), expired)
+	return selectSnapshots(snapshots, model, blend, now), nil
+}
+
+// redisSnapshot builds the snapshot of an engine from the fields of its hash
+func redisSnapshot(engine string, fields map[string]string) *EngineSnapshot {
+	ip, endpoint := splitEngineKey(engine)
+	snapshot := &EngineSnapshot{Ip: ip, Endpoint: endpoint}
+	for field, value := range fields {
+		n, _ := strconv.ParseInt(value, 10, 64)
+		switch {
+		case field == redisQueuedReqNum:
+			snapshot.QueuedReqNum = int32(n)
+		case field == redisPromptLength:
+			snapshot.PromptLength = int32(n)
+		case field == redisUpdatedTime:
+			snapshot.UpdatedTime = n
+		case field == redisReport:
+			var report EngineReport
+			if err := json.Unmarshal([]byte(value), &report); err == nil {
+				snapshot.Reported = &report
+			}
+		case strings.HasPrefix(field, redisModelQueued):
+			counter(&snapshot.Models, field[len(redisModelQueued):]).QueuedReqNum = int32(n)
+		case strings.HasPrefix(field, redisModelPrompt):
+			counter(&snapshot.Models, field[len(redisModelPrompt):]).PromptLength = int32(n)
+		case strings.HasPrefix(field, redisPriorityQueued):
+			counter(&snapshot.Priorities, field[len(redisPriorityQueued):]).QueuedReqNum = int32(n)
+		case strings.HasPrefix(field, redisPriorityPrompt):
+			counter(&snapshot.Priorities, field[len(redisPriorityPrompt):]).PromptLength = int32(n)
+		}
+	}
+	return snapshot
+}
+
+// counter returns the counter of a key in a lazily created map
+func counter(counters *map[string]*LoadCounter, key string) *LoadCounter {
+	if *counters == nil {
+		*counters = make(map[string]*LoadCounter)
+	}
+	c, ok := (*counters)[key]
+	if !ok {
+		c = &LoadCounter{}
+		(*counters)[key] = c
+	}
+	return c
+}
+
+// Clusters returns the names of the clusters with statistics
+// Clusters whose engines all expired are removed on the way
+func (b *RedisBackend) Clusters() ([]string, error) {
+	ctx := context.Background()
+	clusters, err := b.client.SMembers(ctx, b.clustersKey()).Result()
+	if err != nil {
+		return nil, b.fail("clusters", err)
+	}
+	cmds := make([]*redis.IntCmd, len(clusters))
+	_, err = b.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, cluster := range clusters {
+			cmds[i] = pipe.Exists(ctx, b.enginesKey(cluster))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, b.fail("clusters", err)
+	}
+
+	names := clusters[:0]
+	var expired []any
+	for i, cluster := range clusters {
+		if cmds[i].Val() == 0 {
+			expired = append(expired, cluster)
+			continue
+		}
+		names = append(names, cluster)
+	}
+	b.prune(ctx, b.clustersKey(), b.enginesKey(""), expired)
+	return names, nil
+}
+
+// prune removes the members of an index whose key under the prefix expired
+// Pruning is best effort, a failure leaves the members for the next read
+func (b *RedisBackend) prune(ctx context.Context, index, prefix string, members []any) {
+	if len(members) == 0 {
+		return
+	}
+	if err := redisPruneScript.Run(ctx, b.client, []string{index}, append([]any{prefix}, members...)...).Err(); err != nil {
+		b.fail("prune", err)
+	}
+}
+
+// Expire removes the requests whose deadline has passed and decrements their engines
+// Every instance may run it, each request is removed by a single script run
+func (b *RedisBackend) Expire(now time.Time) (int, error) {
+	expired := 0
+	for {
+		result, err := redisExpireScript.Run(context.Background(), b.client, []string{b.expiryKey()},
+			b.prefix, now.UnixMilli(), now.UnixNano(),
+			requestExpireDuration.Milliseconds(), redisExpireBatchSize).Int64Slice()
+		if err != nil {
+			return expired, b.fail("expire", err)
+		}
+		expired += int(result[1])
+		if result[0] < redisExpireBatchSize {
+			return expired, nil
+		}
+	}
+}
+
+// Shared reports true, all instances use the same Redis state
+func (b *RedisBackend) Shared() bool {
+	return true
+}
@@ -0,0 +1,264 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package load
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aigw-project/metadata-center/pkg/utils/errors"
+)
+
+func newTestRedisBackend(t *testing.T) (*RedisBackend, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewRedisBackend(client, "test:"), mr
+}
+
+// engineCounters returns the counters of the engines of a cluster keyed by engine
+func engineCounters(t *testing.T, b Backend, cluster, model string) map[string]*EngineSnapshot {
+	snapshots, err := b.Snapshots(cluster, model, BlendEstimated, time.Now().UnixNano())
+	require.NoError(t, err)
+	ret := make(map[string]*EngineSnapshot, len(snapshots))
+	for _, s := range snapshots {
+		ret[engineKey(s.Ip, s.Endpoint)] = &EngineSnapshot{
+			Ip:           s.Ip,
+			Endpoint:     s.Endpoint,
+			QueuedReqNum: s.QueuedReqNum,
+			PromptLength: s.PromptLength,
+			Models:       s.Models,
+			Priorities:   s.Priorities,
+		}
+	}
+	return ret
+}
+
+func TestBackend_Parity(t *testing.T) {
+	redisBackend, _ := newTestRedisBackend(t)
+	backends := map[string]Backend{
+		BackendMemory: memoryBackend{ls: NewLoadStats()},
+		BackendRedis:  redisBackend,
+	}
+	for name, b := range backends {
+		t.Run(name, func(t *testing.T) {
+			cluster := "parity-" + name
+			for i := 0; i < 4; i++ {
+				require.NoError(t, b.AddRequest(&InferenceRequest{
+					Cluster:      cluster,
+					RequestId:    fmt.Sprintf("%s-%d", cluster, i),
+					PromptLength: 100,
+					Endpoint:     "10.0.0.1:8000",
+					Model:        []string{"qwen", "llama"}[i%2],
+					Priority:     "interactive",
+				}))
+			}
+			require.NoError(t, b.AddRequest(&InferenceRequest{Cluster: cluster, RequestId: cluster + "-0", PromptLength: 100, Ip: "10.0.0.2"}))
+			require.NoError(t, b.AddRequest(&InferenceRequest{Cluster: cluster, RequestId: cluster + "-ip", PromptLength: 7, Ip: "10.0.0.2"}))
+
+			require.NoError(t, b.DeletePromptLength(&DeletionInferenceRequest{RequestId: cluster + "-0"}))
+			require.NoError(t, b.DeletePromptLength(&DeletionInferenceRequest{RequestId: cluster + "-0"}))
+			require.NoError(t, b.DeleteRequest(&DeletionInferenceRequest{RequestId: cluster + "-1"}))
+			require.NoError(t, b.DeleteRequest(&DeletionInferenceRequest{RequestId: cluster + "-1"}))
+
+			assert.Equal(t, map[string]*EngineSnapshot{
+				"10.0.0.1:8000": {
+					Ip:           "10.0.0.1",
+					Endpoint:     "10.0.0.1:8000",
+					QueuedReqNum: 3,
+					PromptLength: 200,
+					Models: map[string]*LoadCounter{
+						"qwen":  {QueuedReqNum: 2, PromptLength: 100},
+						"llama": {QueuedReqNum: 1, PromptLength: 100},
+					},
+					Priorities: map[string]*LoadCounter{"interactive": {QueuedReqNum: 3, PromptLength: 200}},
+				},
+				"10.0.0.2": {Ip: "10.0.0.2", QueuedReqNum: 1, PromptLength: 7},
+			}, engineCounters(t, b, cluster, ""))
+
+			filtered := engineCounters(t, b, cluster, "llama")
+			require.Len(t, filtered, 1)
+			assert.Len(t, filtered["10.0.0.1:8000"].Models, 1)
+
+			clusters, err := b.Clusters()
+			require.NoError(t, err)
+			assert.Contains(t, clusters, cluster)
+		})
+	}
+}
+
+func TestRedisBackend_Tombstone(t *testing.T) {
+	b, _ := newTestRedisBackend(t)
+	cluster := "redis-tombstone"
+
+	require.NoError(t, b.DeleteRequest(&DeletionInferenceRequest{RequestId: "late"}))
+	require.NoError(t, b.DeletePromptLength(&DeletionInferenceRequest{RequestId: "late"}))
+	require.NoError(t, b.AddRequest(&InferenceRequest{Cluster: cluster, RequestId: "late", PromptLength: 10, Ip: "10.0.1.1"}))
+	require.NoError(t, b.DeletePromptLength(&DeletionInferenceRequest{RequestId: "late-prompt"}))
+	require.NoError(t, b.AddRequest(&InferenceRequest{Cluster: cluster, RequestId: "late-prompt", PromptLength: 10, Ip: "10.0.1.1"}))
+
+	counters := engineCounters(t, b, cluster, "")
+	require.Len(t, counters, 1, "the request tombstone drops the add")
+	assert.Equal(t, int32(1), counters["10.0.1.1"].QueuedReqNum)
+	assert.Equal(t, int32(0), counters["10.0.1.1"].PromptLength, "the prompt tombstone drops the prompt length")
+
+	SetTombstoneTTL(0)
+	defer SetTombstoneTTL(DefaultTombstoneTTL)
+	require.NoError(t, b.DeleteRequest(&DeletionInferenceRequest{RequestId: "lost"}))
+	require.NoError(t, b.AddRequest(&InferenceRequest{Cluster: cluster, RequestId: "lost", PromptLength: 10, Ip: "10.0.1.1"}))
+	assert.Equal(t, int32(2), engineCounters(t, b, cluster, "")["10.0.1.1"].QueuedReqNum)
+}
+
+func TestRedisBackend_Expire(t *testing.T) {
+	b, mr := newTestRedisBackend(t)
+	cluster := "redis-expire"
+	for i := 0; i < redisExpireBatchSize+10; i++ {
+		require.NoError(t, b.AddRequest(&InferenceRequest{Cluster: cluster, RequestId: fmt.Sprintf("expire-%d", i), PromptLength: 1, Ip: "10.0.2.1"}))
+	}
+	require.NoError(t, b.DeleteRequest(&DeletionInferenceRequest{RequestId: "expire-0"}))
+
+	expired, err := b.Expire(time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 0, expired)
+	expired, err = b.Expire(time.Now().Add(requestExpireDuration + time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, redisExpireBatchSize+9, expired)
+	counters := engineCounters(t, b, cluster, "")
+	assert.Equal(t, int32(0), counters["10.0.2.1"].QueuedReqNum)
+	assert.Equal(t, int32(0), counters["10.0.2.1"].PromptLength)
+	assert.False(t, mr.Exists("test:expiry"))
+
+	// Idle engines and clusters expire with their keys
+	mr.FastForward(requestExpireDuration + time.Second)
+	assert.Empty(t, engineCounters(t, b, cluster, ""))
+	clusters, err := b.Clusters()
+	require.NoError(t, err)
+	assert.NotContains(t, clusters, cluster)
+}
+
+func TestRedisBackend_PruneRace(t *testing.T) {
+	b, mr := newTestRedisBackend(t)
+	ctx := context.Background()
+	cluster := "redis-prune"
+	require.NoError(t, b.AddRequest(&InferenceRequest{Cluster: cluster, RequestId: "prune-1", Ip: "10.0.5.1"}))
+	require.NoError(t, b.AddRequest(&InferenceRequest{Cluster: cluster, RequestId: "prune-2", Ip: "10.0.5.2"}))
+	mr.FastForward(requestExpireDuration + time.Second)
+
+	// Both engines and the cluster were read as expired, then another instance re-created one engine
+	stale := []any{"10.0.5.1", "10.0.5.2"}
+	require.NoError(t, b.AddRequest(&InferenceRequest{Cluster: cluster, RequestId: "prune-3", Ip: "10.0.5.1"}))
+	b.prune(ctx, b.enginesKey(cluster), b.engineKey(cluster, ""), stale)
+	b.prune(ctx, b.clustersKey(), b.enginesKey(""), []any{cluster})
+
+	engines, err := b.client.SMembers(ctx, b.enginesKey(cluster)).Result()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.5.1"}, engines)
+	counters := engineCounters(t, b, cluster, "")
+	require.Contains(t, counters, "10.0.5.1")
+	assert.Equal(t, int32(1), counters["10.0.5.1"].QueuedReqNum)
+	clusters, err := b.Clusters()
+	require.NoError(t, err)
+	assert.Contains(t, clusters, cluster)
+
+	// Once the cluster expired for good it is pruned
+	mr.FastForward(requestExpireDuration + time.Second)
+	b.prune(ctx, b.clustersKey(), b.enginesKey(""), []any{cluster})
+	assert.False(t, mr.Exists(b.clustersKey()))
+}
+
+func TestRedisBackend_Report(t *testing.T) {
+	b, _ := newTestRedisBackend(t)
+	cluster := "redis-report"
+	require.NoError(t, b.AddRequest(&InferenceRequest{Cluster: cluster, RequestId: "report-1", Ip: "10.0.3.1"}))
+	require.NoError(t, b.SetEngineReport(&EngineReport{Cluster: cluster, Ip: "10.0.3.1", RunningReqNum: 4, WaitingReqNum: 2, KVCacheUsage: 0.5}))
+	require.NoError(t, b.SetEngineReport(&EngineReport{Cluster: cluster, Ip: "10.0.3.2", RunningReqNum: 1}))
+
+	snapshot, err := selectClusters(b, &MultiClusterQueryRequest{Pattern: "redis-rep*", Blend: BlendReported})
+	require.NoError(t, err)
+	engines := snapshot.Clusters[cluster]
+	require.Len(t, engines, 2)
+	for _, e := range engines {
+		require.NotNil(t, e.Reported)
+		assert.Equal(t, e.Reported.QueuedReqNum(), e.QueuedReqNum)
+		assert.NotZero(t, e.UpdatedTime)
+	}
+
+	snapshot, err = selectClusters(b, &MultiClusterQueryRequest{Clusters: []string{cluster, "redis-unknown"}})
+	require.NoError(t, err)
+	assert.Len(t, snapshot.Clusters[cluster], 2)
+	assert.Empty(t, snapshot.Clusters["redis-unknown"])
+}
+
+func TestRedisBackend_Unavailable(t *testing.T) {
+	b, mr := newTestRedisBackend(t)
+	mr.Close()
+	assert.Error(t, b.AddRequest(&InferenceRequest{Cluster: "redis-down", RequestId: "down", Ip: "10.0.4.1"}))
+	_, err := b.Snapshots("redis-down", "", "", time.Now().UnixNano())
+	assert.Error(t, err)
+}
+
+func TestNewBackend(t *testing.T) {
+	assert.IsType(t, memoryBackend{}, newBackend(NewLoadStats()))
+	SetBackend("unknown")
+	assert.Equal(t, BackendMemory, backendKind)
+	SetBackend(BackendRedis)
+	defer SetBackend(DefaultBackend)
+	b := newBackend(NewLoadStats())
+	assert.IsType(t, &RedisBackend{}, b)
+	assert.True(t, b.Shared())
+}
+
+func TestCheckSharedSettings(t *testing.T) {
+	SetMaxRequests(10)
+	SetWALDir(t.TempDir())
+	defer func() {
+		SetMaxRequests(int(DefaultMaxRequests))
+		SetWALDir("")
+	}()
+	require.NoError(t, checkSharedSettings(), "the memory backend serves every setting")
+
+	SetBackend(BackendRedis)
+	defer SetBackend(DefaultBackend)
+	err := checkSharedSettings()
+	require.Error(t, err)
+	assert.Equal(t, "capacity caps, the write-ahead log unsupported with the shared redis backend", err.Error())
+	assert.Error(t, Init(), "Init refuses settings the backend cannot serve")
+
+	SetMaxRequests(int(DefaultMaxRequests))
+	SetWALDir("")
+	SetTenantMaxClusters(1)
+	defer SetTenantMaxClusters(int(DefaultTenantMaxClusters))
+	assert.ErrorContains(t, checkSharedSettings(), "tenant quotas")
+}
+
+func TestCheckAdmin(t *testing.T) {
+	original := backend
+	defer func() { backend = original }()
+	backend = memoryBackend{ls: NewLoadStats()}
+	require.NoError(t, CheckAdmin())
+
+	backend, _ = newTestRedisBackend(t)
+	err := CheckAdmin()
+	require.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, err.(*errors.ErrorInfo).GetStatusCode())
+}
@@ -15,8 +15,11 @@
 package load
 
 import (
-	"encoding/json"
 	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aigw-project/metadata-center/pkg/replicator"
 )
@@ -29,6 +32,28 @@ const (
 	LoadStatsDelete = "load.stats.delete"
 	// LoadPromptDelete is the message type for deleting prompt statistics
 	LoadPromptDelete = "load.prompt.delete"
+	// LoadReservationSet is the message type for accepted engine slot reservations
+	LoadReservationSet = "load.reservation.set"
+	// LoadReservationRelease is the message type for released reservations
+	LoadReservationRelease = "load.reservation.release"
+	// LoadCounterMerge is the message type for counter partitions pushed in crdt mode
+	LoadCounterMerge = "load.counter.merge"
+	// LoadEngineReport is the message type for engine-reported load snapshots
+	LoadEngineReport = "load.engine.report"
+	// LoadAdminEvictRequest is the message type for forced request removals
+	LoadAdminEvictRequest = "load.admin.request.evict"
+	// LoadAdminResetEngine is the message type for engine counter resets
+	LoadAdminResetEngine = "load.admin.engine.reset"
+	// LoadAdminCorrectEngine is the message type for reconciler corrections of engine counters
+	LoadAdminCorrectEngine = "load.admin.engine.correct"
+	// LoadAdminDeleteEngine is the message type for immediate engine deletions
+	LoadAdminDeleteEngine = "load.admin.engine.delete"
+	// LoadAdminDeleteCluster is the message type for immediate cluster deletions
+	LoadAdminDeleteCluster = "load.admin.cluster.delete"
+	// LoadAdminSetTenantLimit is the message type for tenant quota overrides
+	LoadAdminSetTenantLimit = "load.admin.tenant.limit.set"
+	// LoadAdminDeleteTenantLimit is the message type for tenant quota override removals
+	LoadAdminDeleteTenantLimit = "load.admin.tenant.limit.delete"
 )
 
 // init registers the load statistics handlers with the replicator
@@ -36,37 +61,355 @@ func init() {
 	replicator.Register(LoadStatsSet, HandleLoadSet)
 	replicator.Register(LoadStatsDelete, HandleLoadDelete)
 	replicator.Register(LoadPromptDelete, HandleLoadPromptDelete)
+	replicator.Register(LoadReservationSet, HandleReservationSet)
+	replicator.Register(LoadReservationRelease, HandleReservationRelease)
+	replicator.Register(LoadCounterMerge, HandleCounterMerge)
+	replicator.Register(LoadEngineReport, HandleEngineReport)
+	replicator.Register(LoadAdminEvictRequest, HandleAdminEvictRequest)
+	replicator.Register(LoadAdminResetEngine, HandleAdminResetEngine)
+	replicator.Register(LoadAdminCorrectEngine, HandleAdminCorrectEngine)
+	replicator.Register(LoadAdminDeleteEngine, HandleAdminDeleteEngine)
+	replicator.Register(LoadAdminDeleteCluster, HandleAdminDeleteCluster)
+	replicator.Register(LoadAdminSetTenantLimit, HandleAdminSetTenantLimit)
+	replicator.Register(LoadAdminDeleteTenantLimit, HandleAdminDeleteTenantLimit)
 }
 
 // HandleLoadSet processes load statistics set messages
-func HandleLoadSet(payload json.RawMessage) error {
+func HandleLoadSet(payload replicator.Payload) error {
 	var req InferenceRequest
-	if err := json.Unmarshal(payload, &req); err != nil {
+	if err := payload.Decode(&req); err != nil {
 		return fmt.Errorf("failed to unmarshal payload for handleLoadSet: %w", err)
 	}
+	if err := req.validate(); err != nil {
+		return fmt.Errorf("invalid payload for handleLoadSet: %w", err)
+	}
 
 	loadStats.AddRequest(&req)
 	return nil
 }
 
 // HandleLoadDelete processes load statistics delete messages
-func HandleLoadDelete(payload json.RawMessage) error {
+func HandleLoadDelete(payload replicator.Payload) error {
 	var req DeletionInferenceRequest
-	if err := json.Unmarshal(payload, &req); err != nil {
+	if err := payload.Decode(&req); err != nil {
 		return fmt.Errorf("failed to unmarshal payload for handleLoadDelete: %w", err)
 	}
+	if err := req.validate(); err != nil {
+		return fmt.Errorf("invalid payload for handleLoadDelete: %w", err)
+	}
 
 	loadStats.DeleteRequest(&req)
 	return nil
 }
 
 // HandleLoadPromptDelete processes prompt statistics delete messages
-func HandleLoadPromptDelete(payload json.RawMessage) error {
+func HandleLoadPromptDelete(payload replicator.Payload) error {
 	var req DeletionInferenceRequest
-	if err := json.Unmarshal(payload, &req); err != nil {
+	if err := payload.Decode(&req); err != nil {
 		return fmt.Errorf("failed to unmarshal payload for handleLoadPromptDelete: %w", err)
 	}
+	if err := req.validate(); err != nil {
+		return fmt.Errorf("invalid payload for handleLoadPromptDelete: %w", err)
+	}
 
 	loadStats.DeletePromptLength(&req)
 	return nil
 }
+
+// HandleReservationSet processes accepted reservation messages
+// The sender decided the reservation already, so the limit is not checked again.
+// In consensus mode only the log changes the reservations, the request is added alone
+func HandleReservationSet(payload replicator.Payload) error {
+	var req ReservationRequest
+	if err := payload.Decode(&req); err != nil {
+		return fmt.Errorf("failed to unmarshal payload for handleReservationSet: %w", err)
+	}
+	if err := req.validate(); err != nil {
+		return fmt.Errorf("invalid payload for handleReservationSet: %w", err)
+	}
+
+	if loadStats.consensus.Load() != nil {
+		loadStats.AddRequest(&req.InferenceRequest)
+		return nil
+	}
+	loadStats.reserve(&req, time.Now(), true)
+	return nil
+}
+
+// HandleReservationRelease processes reservation release messages
+// In consensus mode only the log changes the reservations, the request is deleted alone
+func HandleReservationRelease(payload replicator.Payload) error {
+	var req DeletionInferenceRequest
+	if err := payload.Decode(&req); err != nil {
+		return fmt.Errorf("failed to unmarshal payload for handleReservationRelease: %w", err)
+	}
+	if err := req.validate(); err != nil {
+		return fmt.Errorf("invalid payload for handleReservationRelease: %w", err)
+	}
+
+	if loadStats.consensus.Load() != nil {
+		loadStats.deleteTracked(req.RequestId)
+		return nil
+	}
+	loadStats.releaseReservation(req.RequestId)
+	return nil
+}
+
+// HandleCounterMerge processes counter partitions pushed by other instances in crdt mode
+func HandleCounterMerge(payload replicator.Payload) error {
+	var state CounterState
+	if err := payload.Decode(&state); err != nil {
+		return fmt.Errorf("failed to unmarshal payload for handleCounterMerge: %w", err)
+	}
+	if err := state.validate(); err != nil {
+		return fmt.Errorf("invalid payload for handleCounterMerge: %w", err)
+	}
+
+	loadStats.MergeCounters(&state)
+	return nil
+}
+
+// HandleEngineReport processes engine-reported load snapshot messages
+func HandleEngineReport(payload replicator.Payload) error {
+	var report EngineReport
+	if err := payload.Decode(&report); err != nil {
+		return fmt.Errorf("failed to unmarshal payload for handleEngineReport: %w", err)
+	}
+	if err := report.validate(); err != nil {
+		return fmt.Errorf("invalid payload for handleEngineReport: %w", err)
+	}
+
+	loadStats.SetEngineReport(&report)
+	return nil
+}
+
+// HandleAdminEvictRequest processes forced request removal messages
+func HandleAdminEvictRequest(payload replicator.Payload) error {
+	var req EvictRequest
+	if err := payload.Decode(&req); err != nil {
+		return fmt.Errorf("failed to unmarshal payload for handleAdminEvictRequest: %w", err)
+	}
+	if err := req.validate(); err != nil {
+		return fmt.Errorf("invalid payload for handleAdminEvictRequest: %w", err)
+	}
+
+	loadStats.EvictRequest(&req, AuditSourceReplica)
+	return nil
+}
+
+// HandleAdminResetEngine processes engine counter reset messages
+func HandleAdminResetEngine(payload replicator.Payload) error {
+	var action EngineAction
+	if err := payload.Decode(&action); err != nil {
+		return fmt.Errorf("failed to unmarshal payload for handleAdminResetEngine: %w", err)
+	}
+	if err := action.validate(); err != nil {
+		return fmt.Errorf("invalid payload for handleAdminResetEngine: %w", err)
+	}
+
+	loadStats.ResetEngine(&action, AuditSourceReplica)
+	return nil
+}
+
+// HandleAdminCorrectEngine processes engine counter correction messages
+func HandleAdminCorrectEngine(payload replicator.Payload) error {
+	var correction EngineCorrection
+	if err := payload.Decode(&correction); err != nil {
+		return fmt.Errorf("failed to unmarshal payload for handleAdminCorrectEngine: %w", err)
+	}
+	if err := correction.validate(); err != nil {
+		return fmt.Errorf("invalid payload for handleAdminCorrectEngine: %w", err)
+	}
+
+	loadStats.CorrectEngine(&correction, AuditSourceReplica)
+	return nil
+}
+
+// HandleAdminDeleteEngine processes immediate engine deletion messages
+func HandleAdminDeleteEngine(payload replicator.Payload) error {
+	var action EngineAction
+	if err := payload.Decode(&action); err != nil {
+		return fmt.Errorf("failed to unmarshal payload for handleAdminDeleteEngine: %w", err)
+	}
+	if err := action.validate(); err != nil {
+		return fmt.Errorf("invalid payload for handleAdminDeleteEngine: %w", err)
+	}
+
+	loadStats.DeleteEngine(&action, AuditSourceReplica)
+	return nil
+}
+
+// HandleAdminDeleteCluster processes immediate cluster deletion messages
+func HandleAdminDeleteCluster(payload replicator.Payload) error {
+	var action ClusterAction
+	if err := payload.Decode(&action); err != nil {
+		return fmt.Errorf("failed to unmarshal payload for handleAdminDeleteCluster: %w", err)
+	}
+	if err := action.validate(); err != nil {
+		return fmt.Errorf("invalid payload for handleAdminDeleteCluster: %w", err)
+	}
+
+	loadStats.DeleteCluster(&action, AuditSourceReplica)
+	return nil
+}
+
+// HandleAdminSetTenantLimit processes tenant quota override messages
+func HandleAdminSetTenantLimit(payload replicator.Payload) error {
+	var limit TenantLimit
+	if err := payload.Decode(&limit); err != nil {
+		return fmt.Errorf("failed to unmarshal payload for handleAdminSetTenantLimit: %w", err)
+	}
+	if err := limit.validate(); err != nil {
+		return fmt.Errorf("invalid payload for handleAdminSetTenantLimit: %w", err)
+	}
+
+	loadStats.SetTenantLimit(&limit, AuditSourceReplica)
+	return nil
+}
+
+// HandleAdminDeleteTenantLimit processes tenant quota override removal messages
+func HandleAdminDeleteTenantLimit(payload replicator.Payload) error {
+	var action TenantAction
+	if err := payload.Decode(&action); err != nil {
+		return fmt.Errorf("failed to unmarshal payload for handleAdminDeleteTenantLimit: %w", err)
+	}
+	if err := action.validate(); err != nil {
+		return fmt.Errorf("invalid payload for handleAdminDeleteTenantLimit: %w", err)
+	}
+
+	loadStats.DeleteTenantLimit(&action, AuditSourceReplica)
+	return nil
+}
+
+// Replicated payloads carry LoadStats keys instead of the names bound by the API, so they are validated again
+
+// checkScopedName rejects a replicated cluster name or request ID that is not a valid LoadStats key
+func checkScopedName(kind, key string) error {
+	tenant, name, found := strings.Cut(key, tenantSeparator)
+	if !found {
+		name = key
+	}
+	if name == "" || strings.Contains(name, tenantSeparator) || (found && !ValidTenant(tenant)) {
+		return fmt.Errorf("invalid %s %q", kind, key)
+	}
+	return nil
+}
+
+// checkEngineAddress rejects a replicated engine identity that is neither an ip nor an ip:port endpoint
+func checkEngineAddress(ip, endpoint string) error {
+	if ip == "" && endpoint == "" {
+		return fmt.Errorf("missing engine ip or endpoint")
+	}
+	if ip != "" && net.ParseIP(ip) == nil {
+		return fmt.Errorf("invalid engine ip %q", ip)
+	}
+	if endpoint != "" {
+		host, port, err := net.SplitHostPort(endpoint)
+		if err != nil || net.ParseIP(host) == nil {
+			return fmt.Errorf("invalid engine endpoint %q", endpoint)
+		}
+		if p, err := strconv.Atoi(port); err != nil || p <= 0 || p > 65535 {
+			return fmt.Errorf("invalid engine endpoint %q", endpoint)
+		}
+	}
+	return nil
+}
+
+// checkTenant rejects a replicated tenant name
+func checkTenant(tenant string) error {
+	if !ValidTenant(tenant) {
+		return fmt.Errorf("invalid tenant %q", tenant)
+	}
+	return nil
+}
+
+// validate checks a replicated request
+func (r *InferenceRequest) validate() error {
+	if err := checkScopedName("cluster", r.Cluster); err != nil {
+		return err
+	}
+	if err := checkScopedName("request id", r.RequestId); err != nil {
+		return err
+	}
+	if err := checkEngineAddress(r.Ip, r.Endpoint); err != nil {
+		return err
+	}
+	if r.PromptLength < 0 {
+		return fmt.Errorf("negative prompt length %d", r.PromptLength)
+	}
+	if len(r.Model) > 128 {
+		return fmt.Errorf("model longer than 128 characters")
+	}
+	return CheckPriority(r.Priority)
+}
+
+// validate checks a replicated reservation
+func (r *ReservationRequest) validate() error {
+	if r.Limit <= 0 {
+		return fmt.Errorf("invalid reservation limit %d", r.Limit)
+	}
+	return r.InferenceRequest.validate()
+}
+
+// validate checks a replicated deletion
+func (r *DeletionInferenceRequest) validate() error {
+	return checkScopedName("request id", r.RequestId)
+}
+
+// validate checks replicated counter partitions
+func (s *CounterState) validate() error {
+	for _, e := range s.Engines {
+		if e == nil {
+			return fmt.Errorf("missing engine counter state")
+		}
+		if err := checkScopedName("cluster", e.Cluster); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validate checks a replicated engine report
+func (r *EngineReport) validate() error {
+	if err := checkScopedName("cluster", r.Cluster); err != nil {
+		return err
+	}
+	if err := checkEngineAddress(r.Ip, r.Endpoint); err != nil {
+		return err
+	}
+	if r.RunningReqNum < 0 || r.WaitingReqNum < 0 || r.KVCacheUsage < 0 || r.KVCacheUsage > 1 {
+		return fmt.Errorf("invalid engine report of %s", r.EngineKey())
+	}
+	return nil
+}
+
+// validate checks a replicated eviction
+func (r *EvictRequest) validate() error {
+	return checkScopedName("request id", r.RequestId)
+}
+
+// validate checks a replicated engine action
+func (a *EngineAction) validate() error {
+	if err := checkScopedName("cluster", a.Cluster); err != nil {
+		return err
+	}
+	return checkEngineAddress(a.Ip, a.Endpoint)
+}
+
+// validate checks a replicated cluster action
+func (a *ClusterAction) validate() error {
+	return checkScopedName("cluster", a.Cluster)
+}
+
+// validate checks a replicated tenant quota override
+func (l *TenantLimit) validate() error {
+	if (l.MaxRequests != nil && *l.MaxRequests < 0) || (l.MaxClusters != nil && *l.MaxClusters < 0) {
+		return fmt.Errorf("negative quota of tenant %s", l.Tenant)
+	}
+	return checkTenant(l.Tenant)
+}
+
+// validate checks a replicated tenant action
+func (a *TenantAction) validate() error {
+	return checkTenant(a.Tenant)
+}
@@ -16,10 +16,14 @@ package load
 
 import (
 	"encoding/json"
+	"reflect"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/ugorji/go/codec"
+
+	"github.com/aigw-project/metadata-center/pkg/replicator"
 )
 
 func TestHandleLoadSet(t *testing.T) {
@@ -36,7 +40,7 @@ func TestHandleLoadSet(t *testing.T) {
 
 	tests := []struct {
 		name            string
-		payload         json.RawMessage
+		payload         replicator.JSONPayload
 		wantErrContains string
 		postCheck       func(t *testing.T)
 	}{
@@ -56,19 +60,43 @@ func TestHandleLoadSet(t *testing.T) {
 			},
 		},
 		{
-			name:    "success: add an empty inference request",
-			payload: emptyPayload,
+			name:            "error: empty inference request",
+			payload:         emptyPayload,
+			wantErrContains: "invalid payload for handleLoadSet",
 			postCheck: func(t *testing.T) {
-				// We just ensure it doesn't panic or error out.
-				// Querying with an empty ModelInfo should show some stats.
-				modelStats := Query(&ModelQueryRequest{Cluster: emptyReq.Cluster})
-				require.NotNil(t, modelStats)
-				assert.Equal(t, int32(1), modelStats.Size())
+				assert.Nil(t, Query(&ModelQueryRequest{Cluster: emptyReq.Cluster}))
+			},
+		},
+		{
+			name:    "success: add a request of a tenant",
+			payload: replicator.JSONPayload(`{"cluster":"team-a/test-domain","request_id":"team-a/r-1","ip":"192.168.1.1"}`),
+			postCheck: func(t *testing.T) {
+				assert.NotNil(t, Query(&ModelQueryRequest{Cluster: "team-a/test-domain"}))
 			},
 		},
+		{
+			name:            "error: invalid tenant in cluster",
+			payload:         replicator.JSONPayload(`{"cluster":"team a/test-domain","request_id":"r-1","ip":"192.168.1.1"}`),
+			wantErrContains: "invalid cluster",
+		},
+		{
+			name:            "error: nested separator in request id",
+			payload:         replicator.JSONPayload(`{"cluster":"test-domain","request_id":"team-a/b/r-1","ip":"192.168.1.1"}`),
+			wantErrContains: "invalid request id",
+		},
+		{
+			name:            "error: invalid engine endpoint",
+			payload:         replicator.JSONPayload(`{"cluster":"test-domain","request_id":"r-1","endpoint":"engine:80"}`),
+			wantErrContains: "invalid engine endpoint",
+		},
+		{
+			name:            "error: negative prompt length",
+			payload:         replicator.JSONPayload(`{"cluster":"test-domain","request_id":"r-1","ip":"192.168.1.1","prompt_length":-1}`),
+			wantErrContains: "negative prompt length",
+		},
 		{
 			name:            "error: invalid json payload",
-			payload:         json.RawMessage(`{invalid json}`),
+			payload:         replicator.JSONPayload(`{invalid json}`),
 			wantErrContains: "failed to unmarshal payload for handleLoadSet",
 		},
 	}
@@ -111,14 +139,14 @@ func TestHandleLoadDelete(t *testing.T) {
 	tests := []struct {
 		name            string
 		setup           func(t *testing.T)
-		payload         json.RawMessage
+		payload         replicator.JSONPayload
 		wantErrContains string
 		postCheck       func(t *testing.T)
 	}{
 		{
 			name: "success: delete an existing inference request",
 			setup: func(t *testing.T) {
-				require.NoError(t, HandleLoadSet(basePayload))
+				require.NoError(t, HandleLoadSet(replicator.JSONPayload(basePayload)))
 				modelStats := Query(&ModelQueryRequest{Cluster: baseReq.Cluster})
 				engineStats, ok := modelStats.Load(baseReq.Ip)
 				require.True(t, ok)
@@ -138,7 +166,7 @@ func TestHandleLoadDelete(t *testing.T) {
 		},
 		{
 			name:            "error: invalid json payload",
-			payload:         json.RawMessage(`{invalid json}`),
+			payload:         replicator.JSONPayload(`{invalid json}`),
 			wantErrContains: "failed to unmarshal payload for handleLoadDelete",
 		},
 	}
@@ -186,14 +214,14 @@ func TestHandleLoadPromptDelete(t *testing.T) {
 	tests := []struct {
 		name            string
 		setup           func(t *testing.T)
-		payload         json.RawMessage
+		payload         replicator.JSONPayload
 		wantErrContains string
 		postCheck       func(t *testing.T)
 	}{
 		{
 			name: "success: delete prompt length from an existing request",
 			setup: func(t *testing.T) {
-				require.NoError(t, HandleLoadSet(basePayload))
+				require.NoError(t, HandleLoadSet(replicator.JSONPayload(basePayload)))
 				modelStats := Query(&ModelQueryRequest{Cluster: baseReq.Cluster})
 				engineStats, ok := modelStats.Load(baseReq.Ip)
 				require.True(t, ok)
@@ -215,7 +243,7 @@ func TestHandleLoadPromptDelete(t *testing.T) {
 		},
 		{
 			name:            "error: invalid json payload",
-			payload:         json.RawMessage(`{invalid json}`),
+			payload:         replicator.JSONPayload(`{invalid json}`),
 			wantErrContains: "failed to unmarshal payload for handleLoadPromptDelete",
 		},
 	}
@@ -257,7 +285,7 @@ func TestIntegrationAllHandlers(t *testing.T) {
 		payload, _ := json.Marshal(req)
 
 		// Set request
-		err := HandleLoadSet(payload)
+		err := HandleLoadSet(replicator.JSONPayload(payload))
 		require.NoError(t, err)
 
 		modelStats := Query(&ModelQueryRequest{Cluster: req.Cluster})
@@ -267,7 +295,7 @@ func TestIntegrationAllHandlers(t *testing.T) {
 		assert.Equal(t, int32(400), engineStats.GetPromptLength())
 
 		// Delete prompt length
-		err = HandleLoadPromptDelete(payload)
+		err = HandleLoadPromptDelete(replicator.JSONPayload(payload))
 		require.NoError(t, err)
 
 		modelStats = Query(&ModelQueryRequest{Cluster: req.Cluster})
@@ -277,7 +305,7 @@ func TestIntegrationAllHandlers(t *testing.T) {
 		assert.Equal(t, int32(0), engineStats.GetPromptLength())
 
 		// Delete request
-		err = HandleLoadDelete(payload)
+		err = HandleLoadDelete(replicator.JSONPayload(payload))
 		require.NoError(t, err)
 
 		modelStats = Query(&ModelQueryRequest{Cluster: req.Cluster})
@@ -287,3 +315,165 @@ func TestIntegrationAllHandlers(t *testing.T) {
 		assert.Equal(t, int32(0), engineStats.GetPromptLength())
 	})
 }
+
+func TestHandleEngineReport(t *testing.T) {
+	Init()
+
+	report := EngineReport{
+		Cluster:       "report-domain",
+		Ip:            "192.168.1.5",
+		RunningReqNum: 3,
+		WaitingReqNum: 1,
+		KVCacheUsage:  0.3,
+		ReportedTime:  12345,
+	}
+	payload, _ := json.Marshal(report)
+
+	require.NoError(t, HandleEngineReport(replicator.JSONPayload(payload)))
+
+	modelStats := Query(&ModelQueryRequest{Cluster: report.Cluster})
+	require.NotNil(t, modelStats)
+	engineStats, ok := modelStats.Load(report.Ip)
+	require.True(t, ok)
+	got := engineStats.GetReport()
+	require.NotNil(t, got)
+	assert.Equal(t, int32(4), got.QueuedReqNum())
+	assert.Equal(t, int64(12345), got.ReportedTime, "replicas keep the original report time")
+
+	err := HandleEngineReport(replicator.JSONPayload(`{invalid json}`))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to unmarshal payload for handleEngineReport")
+}
+
+func TestHandleAdminActions(t *testing.T) {
+	Init()
+
+	cluster := "admin-replica-domain"
+	for _, id := range []string{"a-1", "a-2", "a-3"} {
+		loadStats.AddRequest(&InferenceRequest{Cluster: cluster, RequestId: id, PromptLength: 10, Ip: "192.168.1.6"})
+	}
+	engineStats, ok := Query(&ModelQueryRequest{Cluster: cluster}).Load("192.168.1.6")
+	require.True(t, ok)
+
+	payload, _ := json.Marshal(EvictRequest{RequestId: "a-1", AdminAction: AdminAction{Operator: "ops"}})
+	require.NoError(t, HandleAdminEvictRequest(replicator.JSONPayload(payload)))
+	assert.Equal(t, int32(2), engineStats.GetQueuedReqNum())
+
+	payload, _ = json.Marshal(EngineCorrection{EngineAction: EngineAction{Cluster: cluster, Ip: "192.168.1.6"}, Correction: 7})
+	require.NoError(t, HandleAdminCorrectEngine(replicator.JSONPayload(payload)))
+	assert.Equal(t, int32(9), engineStats.GetQueuedReqNum())
+	assert.Equal(t, int32(7), engineStats.GetCorrection())
+
+	payload, _ = json.Marshal(EngineAction{Cluster: cluster, Ip: "192.168.1.6"})
+	require.NoError(t, HandleAdminResetEngine(replicator.JSONPayload(payload)))
+	assert.Equal(t, int32(2), engineStats.GetQueuedReqNum())
+
+	require.NoError(t, HandleAdminDeleteEngine(replicator.JSONPayload(payload)))
+	assert.Equal(t, int32(0), Query(&ModelQueryRequest{Cluster: cluster}).Size())
+
+	payload, _ = json.Marshal(ClusterAction{Cluster: cluster})
+	require.NoError(t, HandleAdminDeleteCluster(replicator.JSONPayload(payload)))
+	assert.Nil(t, Query(&ModelQueryRequest{Cluster: cluster}))
+
+	maxRequests := int64(500)
+	payload, _ = json.Marshal(TenantLimit{Tenant: "team-a", MaxRequests: &maxRequests})
+	require.NoError(t, HandleAdminSetTenantLimit(replicator.JSONPayload(payload)))
+	requests, _, overridden := loadStats.quotas("team-a")
+	assert.True(t, overridden)
+	assert.Equal(t, int64(500), requests)
+
+	payload, _ = json.Marshal(TenantAction{Tenant: "team-a"})
+	require.NoError(t, HandleAdminDeleteTenantLimit(replicator.JSONPayload(payload)))
+	_, _, overridden = loadStats.quotas("team-a")
+	assert.False(t, overridden)
+
+	for _, tc := range []struct {
+		handler func(replicator.Payload) error
+		payload string
+	}{
+		{HandleAdminEvictRequest, `{"request_id":""}`},
+		{HandleAdminResetEngine, `{"cluster":"c","ip":"not-an-ip"}`},
+		{HandleAdminCorrectEngine, `{"cluster":"c"}`},
+		{HandleAdminDeleteEngine, `{"cluster":"/c","ip":"192.168.1.6"}`},
+		{HandleAdminDeleteCluster, `{"cluster":"a/b/c"}`},
+		{HandleAdminSetTenantLimit, `{"tenant":"team/a","max_requests":1}`},
+		{HandleAdminSetTenantLimit, `{"tenant":"team-a","max_requests":-1}`},
+		{HandleAdminDeleteTenantLimit, `{"tenant":""}`},
+	} {
+		err := tc.handler(replicator.JSONPayload(tc.payload))
+		require.Error(t, err, tc.payload)
+		assert.Contains(t, err.Error(), "invalid payload", tc.payload)
+	}
+	_, _, overridden = loadStats.quotas("team/a")
+	assert.False(t, overridden)
+
+	for _, handler := range []func(replicator.Payload) error{
+		HandleAdminEvictRequest,
+		HandleAdminResetEngine,
+		HandleAdminDeleteEngine,
+		HandleAdminDeleteCluster,
+		HandleAdminSetTenantLimit,
+		HandleAdminDeleteTenantLimit,
+	} {
+		err := handler(replicator.JSONPayload(`{invalid json}`))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to unmarshal payload")
+	}
+}
+
+// encodedFields returns the fields of a struct in the order msgpack encodes them as an array
+// Embedded structs are inlined, unexported fields and fields not serialized are skipped
+func encodedFields(t reflect.Type) []string {
+	var fields []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			fields = append(fields, encodedFields(f.Type)...)
+			continue
+		}
+		if f.IsExported() && f.Tag.Get("json") != "-" {
+			fields = append(fields, f.Name)
+		}
+	}
+	return fields
+}
+
+// TestReplicatedPayloadFieldOrder pins the fields of the replicated payloads
+// msgpack sends structs as arrays of their fields in declaration order, so fields may only be appended:
+// inserting, removing or reordering one breaks instances running the previous version
+func TestReplicatedPayloadFieldOrder(t *testing.T) {
+	testCases := []struct {
+		payload any
+		fields  []string
+	}{
+		{InferenceRequest{}, []string{"Cluster", "RequestId", "PromptLength", "Ip", "Endpoint", "Model", "Priority", "TimeStamp", "Origin"}},
+		{DeletionInferenceRequest{}, []string{"RequestId", "TimeStamp"}},
+		{ReservationRequest{}, []string{"Cluster", "RequestId", "PromptLength", "Ip", "Endpoint", "Model", "Priority", "TimeStamp", "Origin", "Limit"}},
+		{CounterState{}, []string{"Origin", "Engines"}},
+		{EngineCounterState{}, []string{"Cluster", "Engine", "Slot"}},
+		{PNCounterSlot{}, []string{"Incarnation", "QueuedInc", "QueuedDec", "PromptInc", "PromptDec"}},
+		{EngineReport{}, []string{"Cluster", "Ip", "Endpoint", "RunningReqNum", "WaitingReqNum", "KVCacheUsage", "TimeStamp", "ReportedTime"}},
+		{EvictRequest{}, []string{"RequestId", "Operator", "Reason"}},
+		{EngineAction{}, []string{"Cluster", "Ip", "Endpoint", "Operator", "Reason"}},
+		{EngineCorrection{}, []string{"Cluster", "Ip", "Endpoint", "Operator", "Reason", "Correction"}},
+		{ClusterAction{}, []string{"Cluster", "Operator", "Reason"}},
+		{TenantLimit{}, []string{"Tenant", "MaxRequests", "MaxClusters", "Operator", "Reason"}},
+		{TenantAction{}, []string{"Tenant", "Operator", "Reason"}},
+	}
+
+	h := &codec.MsgpackHandle{WriteExt: true}
+	h.StructToArray = true
+	for _, tc := range testCases {
+		typ := reflect.TypeOf(tc.payload)
+		t.Run(typ.Name(), func(t *testing.T) {
+			assert.Equal(t, tc.fields, encodedFields(typ), "fields of replicated payloads may only be appended")
+
+			// The encoded array holds exactly these fields
+			var body []byte
+			require.NoError(t, codec.NewEncoderBytes(&body, h).Encode(tc.payload))
+			var decoded []any
+			require.NoError(t, codec.NewDecoderBytes(body, h).Decode(&decoded))
+			assert.Len(t, decoded, len(tc.fields))
+		})
+	}
+}
@@ -0,0 +1,290 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package load
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aigw-project/metadata-center/pkg/prom"
+	"github.com/aigw-project/metadata-center/pkg/utils/errors"
+	"github.com/aigw-project/metadata-center/pkg/utils/logger"
+)
+
+// ReservationRequest reserves a slot on an engine for an inference request
+// The request is added only while the engine holds fewer than Limit reservations, a compare-and-increment
+// on the reservation count of the engine. A reserved request is tracked like one added by Set until it is released
+type ReservationRequest struct {
+	InferenceRequest
+	Limit int32 `json:"limit" binding:"gt=0"`
+}
+
+// reservation is an accepted reservation, Created is the time of the instance that decided it
+type reservation struct {
+	Request *ReservationRequest `json:"request"`
+	Created int64               `json:"created"`
+}
+
+// engine returns the reservation counter key of the reserved engine
+func (r *reservation) engine() string {
+	return reservationKey(r.Request.Cluster, r.Request.EngineKey())
+}
+
+// reservationKey returns the reservation counter key of an engine, the separator is not valid in an engine key
+func reservationKey(cluster, engine string) string {
+	return cluster + "|" + engine
+}
+
+// reserveOutcome is the decision on a reservation, the zero value is no decision
+type reserveOutcome int
+
+const (
+	reserveAccepted reserveOutcome = iota + 1
+	// reserveFull rejects a reservation on an engine at the limit
+	reserveFull
+	// reserveDuplicate rejects a request ID reserved already
+	reserveDuplicate
+)
+
+// err returns the error reported for a rejected reservation, nil once accepted
+func (o reserveOutcome) err(req *ReservationRequest) error {
+	switch o {
+	case reserveAccepted:
+		return nil
+	case reserveDuplicate:
+		return errors.Duplicate("request %s is reserved already", req.RequestId)
+	}
+	return errors.Conflict("engine %s of cluster %s holds %d reservations", req.EngineKey(), req.Cluster, req.Limit)
+}
+
+// reservationTable holds the accepted reservations and their count per engine
+// Reservations are made by the reserver, so in consensus mode every instance applies them in the same order.
+// A deleted request releases its reservation on every instance the delete reaches, through the log in consensus mode
+type reservationTable struct {
+	mu       sync.Mutex
+	requests map[string]*reservation
+	engines  map[string]int32
+}
+
+// reserve records a reservation if its engine is below the limit, force skips the limit for decisions made elsewhere
+func (t *reservationTable) reserve(req *ReservationRequest, created int64, force bool) reserveOutcome {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.requests == nil {
+		t.requests = make(map[string]*reservation)
+		t.engines = make(map[string]int32)
+	}
+	if _, ok := t.requests[req.RequestId]; ok {
+		return reserveDuplicate
+	}
+	r := &reservation{Request: req, Created: created}
+	key := r.engine()
+	if !force && t.engines[key] >= req.Limit {
+		return reserveFull
+	}
+	t.requests[req.RequestId] = r
+	t.engines[key]++
+	return reserveAccepted
+}
+
+// release removes a reservation, returns false if there is none
+func (t *reservationTable) release(requestID string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	r, ok := t.requests[requestID]
+	if !ok {
+		return false
+	}
+	t.removeLocked(requestID, r)
+	return true
+}
+
+// expire removes the reservations created before the given time and returns their request IDs
+func (t *reservationTable) expire(before int64) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var expired []string
+	for id, r := range t.requests {
+		if r.Created < before {
+			t.removeLocked(id, r)
+			expired = append(expired, id)
+		}
+	}
+	return expired
+}
+
+// removeLocked removes a reservation, must be called with mu held
+func (t *reservationTable) removeLocked(requestID string, r *reservation) {
+	delete(t.requests, requestID)
+	key := r.engine()
+	if t.engines[key]--; t.engines[key] <= 0 {
+		delete(t.engines, key)
+	}
+}
+
+// count returns the number of reservations held by an engine
+func (t *reservationTable) count(cluster, engine string) int32 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.engines[reservationKey(cluster, engine)]
+}
+
+// has reports whether a request holds a reservation
+func (t *reservationTable) has(requestID string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, ok := t.requests[requestID]
+	return ok
+}
+
+// replace swaps all reservations for the given ones, counted without limit
+func (t *reservationTable) replace(reservations []*reservation) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.requests = make(map[string]*reservation, len(reservations))
+	t.engines = make(map[string]int32)
+	for _, r := range reservations {
+		if _, ok := t.requests[r.Request.RequestId]; ok {
+			continue
+		}
+		t.requests[r.Request.RequestId] = r
+		t.engines[r.engine()]++
+	}
+}
+
+// list returns the reservations in request ID order
+func (t *reservationTable) list() []*reservation {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ret := make([]*reservation, 0, len(t.requests))
+	for _, r := range t.requests {
+		ret = append(ret, r)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Request.RequestId < ret[j].Request.RequestId })
+	return ret
+}
+
+// reserve adds the request of an accepted reservation, the decision is made at created
+// A request deleted before its reservation arrives cancels out against its tombstone and releases the reservation
+func (ls *LoadStats) reserve(req *ReservationRequest, created time.Time, force bool) reserveOutcome {
+	outcome := ls.reservations.reserve(req, created.UnixNano(), force)
+	if outcome != reserveAccepted {
+		return outcome
+	}
+	ls.AddRequest(&req.InferenceRequest)
+	if !ls.isTracked(&req.InferenceRequest) {
+		ls.reservations.release(req.RequestId)
+	}
+	return outcome
+}
+
+// releaseReservation removes a reservation and its request, returns false if there is none
+func (ls *LoadStats) releaseReservation(requestID string) bool {
+	if !ls.reservations.release(requestID) {
+		return false
+	}
+	ls.deleteTracked(requestID)
+	return true
+}
+
+// expireReservations removes the reservations older than the request expiry at now, along with their requests
+func (ls *LoadStats) expireReservations(now time.Time) int {
+	expired := ls.reservations.expire(now.Add(-requestExpireDuration).UnixNano())
+	for _, id := range expired {
+		ls.deleteTracked(id)
+	}
+	return len(expired)
+}
+
+// deleteTracked deletes a request that may have expired already, without leaving a tombstone for it
+func (ls *LoadStats) deleteTracked(requestID string) {
+	if _, ok := ls.Requests.Load(requestID); ok {
+		ls.DeleteRequest(&DeletionInferenceRequest{RequestId: requestID})
+	}
+}
+
+// reserver decides reservations, on this instance or through the consensus log
+type reserver interface {
+	reserve(req *ReservationRequest) error
+	release(req *DeletionInferenceRequest) error
+	expire(now time.Time) error
+	// replicated reports whether accepted reservations reach the other instances without fan-out replication
+	replicated() bool
+}
+
+// localReserver decides reservations with the state of this instance and replicates the outcome best effort
+// Two instances may accept conflicting reservations before they see each other's
+type localReserver struct {
+	ls *LoadStats
+}
+
+func (r localReserver) reserve(req *ReservationRequest) error {
+	if err := r.ls.reserve(req, time.Now(), false).err(req); err != nil {
+		return rejectReservation(err)
+	}
+	prom.ReservationTotal.WithLabelValues(reservationAccepted).Inc()
+	return nil
+}
+
+func (r localReserver) release(req *DeletionInferenceRequest) error {
+	if !r.ls.releaseReservation(req.RequestId) {
+		return errNotReserved(req.RequestId)
+	}
+	return nil
+}
+
+func (r localReserver) expire(now time.Time) error {
+	r.ls.expireReservations(now)
+	return nil
+}
+
+func (r localReserver) replicated() bool {
+	return false
+}
+
+// Reservation outcomes, the result label of load_reservations_total
+const (
+	reservationAccepted = "accepted"
+	reservationRejected = "rejected"
+)
+
+// rejectReservation counts a rejected reservation and returns its error
+func rejectReservation(err error) error {
+	prom.ReservationTotal.WithLabelValues(reservationRejected).Inc()
+	return err
+}
+
+// errNotReserved returns the error for releasing a request without reservation
+func errNotReserved(requestID string) error {
+	return errors.NotFound("reservation %s not found", requestID)
+}
+
+// cronExpireReservations removes expired reservations periodically
+func cronExpireReservations(ticker *time.Ticker, r reserver) {
+	defer func() {
+		if p := recover(); p != nil {
+			logger.Errorf("reservation expiry goroutine panicked: %v", p)
+		}
+		ticker.Stop()
+		logger.Errorf("reservation expiry goroutine exited")
+	}()
+
+	for now := range ticker.C {
+		if err := r.expire(now); err != nil {
+			logger.Errorf("failed to expire reservations: %v", err)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package load
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aigw-project/metadata-center/pkg/utils/errors"
+)
+
+func newReservationRequest(cluster, id, ip string, limit int32) *ReservationRequest {
+	return &ReservationRequest{
+		InferenceRequest: InferenceRequest{Cluster: cluster, RequestId: id, PromptLength: 10, Ip: ip},
+		Limit:            limit,
+	}
+}
+
+func TestLocalReserver(t *testing.T) {
+	ls := NewLoadStats()
+	r := localReserver{ls: ls}
+	cluster := "reserve"
+	ip := "10.0.7.1"
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, r.reserve(newReservationRequest(cluster, fmt.Sprintf("res-%d", i), ip, 2)))
+	}
+	err := r.reserve(newReservationRequest(cluster, "res-2", ip, 2))
+	require.Error(t, err)
+	assert.Equal(t, errors.ConflictCode, err.(*errors.ErrorInfo).Code)
+	err = r.reserve(newReservationRequest(cluster, "res-0", ip, 3))
+	require.Error(t, err, "a request is reserved once")
+	assert.Equal(t, errors.DuplicateCode, err.(*errors.ErrorInfo).Code)
+	require.NoError(t, r.reserve(newReservationRequest(cluster, "res-2", "10.0.7.2", 2)), "the limit is per engine")
+
+	es, ok := ls.GetModelStats(cluster).Load(ip)
+	require.True(t, ok)
+	assert.Equal(t, int32(2), es.GetQueuedReqNum(), "reserved requests are tracked")
+	assert.Equal(t, int32(2), ls.reservations.count(cluster, ip))
+
+	require.NoError(t, r.release(newDeletionInferenceRequest("res-0")))
+	assert.Equal(t, int32(1), es.GetQueuedReqNum())
+	err = r.release(newDeletionInferenceRequest("res-0"))
+	require.Error(t, err)
+	assert.Equal(t, errors.NotFoundCode, err.(*errors.ErrorInfo).Code)
+	require.NoError(t, r.reserve(newReservationRequest(cluster, "res-3", ip, 2)), "a released slot is free again")
+
+	// Replicas apply the decision of the instance that accepted it
+	assert.Equal(t, reserveAccepted, ls.reserve(newReservationRequest(cluster, "res-4", ip, 2), time.Now(), true))
+	assert.Equal(t, int32(3), ls.reservations.count(cluster, ip))
+
+	// A reserved request deleted without release frees its slot as well
+	ls.DeleteRequest(newDeletionInferenceRequest("res-4"))
+	assert.Equal(t, int32(2), ls.reservations.count(cluster, ip))
+	ls.EvictRequest(&EvictRequest{RequestId: "res-3"}, AuditSourceAPI)
+	assert.Equal(t, int32(1), ls.reservations.count(cluster, ip))
+	require.NoError(t, r.reserve(newReservationRequest(cluster, "res-5", ip, 2)))
+
+	// A reservation arriving after the delete of its request holds no slot
+	ls.DeleteRequest(newDeletionInferenceRequest("res-late"))
+	assert.Equal(t, reserveAccepted, ls.reserve(newReservationRequest(cluster, "res-late", ip, 3), time.Now(), true))
+	assert.Equal(t, int32(2), ls.reservations.count(cluster, ip))
+
+	require.NoError(t, r.expire(time.Now().Add(requestExpireDuration+time.Second)))
+	assert.Equal(t, int32(0), ls.reservations.count(cluster, ip))
+	assert.Equal(t, int32(0), es.GetQueuedReqNum())
+	assert.Equal(t, int64(0), ls.tombstones.len(), "expired reservations leave no tombstone")
+}
@@ -0,0 +1,250 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package load
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aigw-project/metadata-center/pkg/utils/errors"
+	"github.com/aigw-project/metadata-center/pkg/utils/logger"
+)
+
+const (
+	// ScorerQueue ranks engines by queued requests only
+	ScorerQueue = "queue"
+	// ScorerLatency ranks engines by queued requests weighted by observed TTFT per prompt token
+	ScorerLatency = "latency"
+	// DefaultScorer is used by the score endpoint when no scorer is given
+	DefaultScorer = ScorerLatency
+)
+
+const (
+	// latencyMinSamples is the number of TTFT samples an engine needs before its latency counts
+	latencyMinSamples = 8
+	// latencyWeightMin and latencyWeightMax bound the latency weight so one outlier cannot starve or flood an engine
+	latencyWeightMin = 0.25
+	latencyWeightMax = 4
+)
+
+// ScoreInput holds the values an engine is scored on
+type ScoreInput struct {
+	Ip             string  `json:"ip"`
+	Endpoint       string  `json:"endpoint,omitempty"`
+	QueuedReqNum   int32   `json:"queued_req_num"`
+	PromptLength   int32   `json:"prompt_length"`
+	TTFTPerTokenMs float64 `json:"ttft_per_token_ms"`
+	TTFTSamples    int     `json:"ttft_samples"`
+}
+
+// ScoreResult is the score of one engine, lower is better
+// Factors lists the intermediate values the score was computed from
+type ScoreResult struct {
+	Score   float64            `json:"score"`
+	Factors map[string]float64 `json:"factors,omitempty"`
+}
+
+// Scorer ranks the engines of one cluster
+// Score receives all engines at once so scorers can compare an engine against its peers
+type Scorer interface {
+	Name() string
+	Score(inputs []*ScoreInput) []ScoreResult
+}
+
+var (
+	scorersMu sync.RWMutex
+	scorers   = map[string]Scorer{}
+)
+
+func init() {
+	RegisterScorer(queueScorer{})
+	RegisterScorer(latencyScorer{})
+}
+
+// RegisterScorer makes a scorer available by its name, duplicates are ignored
+func RegisterScorer(s Scorer) {
+	scorersMu.Lock()
+	defer scorersMu.Unlock()
+	if _, exists := scorers[s.Name()]; exists {
+		logger.Errorf("load scorer %s already registered", s.Name())
+		return
+	}
+	scorers[s.Name()] = s
+}
+
+// GetScorer returns the scorer registered under the given name
+func GetScorer(name string) (Scorer, bool) {
+	scorersMu.RLock()
+	defer scorersMu.RUnlock()
+	s, ok := scorers[name]
+	return s, ok
+}
+
+// scoreInputs builds the scoring inputs of the engine snapshots
+func scoreInputs(snapshots []*EngineSnapshot) []*ScoreInput {
+	inputs := make([]*ScoreInput, len(snapshots))
+	for i, s := range snapshots {
+		inputs[i] = newScoreInput(s)
+	}
+	return inputs
+}
+
+// newScoreInput builds the scoring input of an engine snapshot
+func newScoreInput(s *EngineSnapshot) *ScoreInput {
+	input := &ScoreInput{
+		Ip:           s.Ip,
+		Endpoint:     s.Endpoint,
+		QueuedReqNum: s.QueuedReqNum,
+		PromptLength: s.PromptLength,
+	}
+	if s.Latency != nil && s.Latency.TTFTPerToken != nil {
+		input.TTFTPerTokenMs = s.Latency.TTFTPerToken.P50
+		input.TTFTSamples = s.Latency.TTFTPerToken.Count
+	}
+	return input
+}
+
+// queueScorer scores an engine by its queued request number
+type queueScorer struct{}
+
+func (queueScorer) Name() string {
+	return ScorerQueue
+}
+
+func (queueScorer) Score(inputs []*ScoreInput) []ScoreResult {
+	results := make([]ScoreResult, len(inputs))
+	for i, in := range inputs {
+		queued := float64(in.QueuedReqNum)
+		results[i] = ScoreResult{Score: queued, Factors: map[string]float64{"queued_req_num": queued}}
+	}
+	return results
+}
+
+// latencyScorer scores an engine by (queued + 1) * weight
+// weight is the engine's median TTFT per prompt token relative to the cluster mean, engines with
+// too few samples get a neutral weight of 1 so new engines still receive traffic
+type latencyScorer struct{}
+
+func (latencyScorer) Name() string {
+	return ScorerLatency
+}
+
+func (latencyScorer) Score(inputs []*ScoreInput) []ScoreResult {
+	var sum float64
+	var n int
+	for _, in := range inputs {
+		if in.TTFTSamples >= latencyMinSamples && in.TTFTPerTokenMs > 0 {
+			sum += in.TTFTPerTokenMs
+			n++
+		}
+	}
+	var mean float64
+	if n > 0 {
+		mean = sum / float64(n)
+	}
+
+	results := make([]ScoreResult, len(inputs))
+	for i, in := range inputs {
+		weight := 1.0
+		if mean > 0 && in.TTFTSamples >= latencyMinSamples && in.TTFTPerTokenMs > 0 {
+			weight = min(max(in.TTFTPerTokenMs/mean, latencyWeightMin), latencyWeightMax)
+		}
+		queued := float64(in.QueuedReqNum) + 1
+		results[i] = ScoreResult{
+			Score: queued * weight,
+			Factors: map[string]float64{
+				"queued_req_num":            queued - 1,
+				"ttft_per_token_ms":         in.TTFTPerTokenMs,
+				"cluster_ttft_per_token_ms": mean,
+				"latency_weight":            weight,
+			},
+		}
+	}
+	return results
+}
+
+// ScoreQueryRequest represents a query for the scores of the engines in a cluster
+type ScoreQueryRequest struct {
+	Cluster string `json:"cluster" binding:"required,excludes=/" form:"cluster"`
+	Model   string `json:"model,omitempty" form:"model"`
+	Blend   string `json:"blend,omitempty" binding:"omitempty,oneof=estimated reported max" form:"blend"`
+	Scorer  string `json:"scorer,omitempty" form:"scorer"`
+}
+
+// ScorerName returns the requested scorer or the default one
+func (r *ScoreQueryRequest) ScorerName() string {
+	if r.Scorer == "" {
+		return DefaultScorer
+	}
+	return r.Scorer
+}
+
+// EngineScore is the score of an engine along with the inputs and factors behind it
+type EngineScore struct {
+	Rank   int         `json:"rank"`
+	Input  *ScoreInput `json:"input"`
+	Result ScoreResult `json:"result"`
+}
+
+// ClusterScores is the ranking of the engines of a cluster, best engine first
+type ClusterScores struct {
+	Scorer  string         `json:"scorer"`
+	Engines []*EngineScore `json:"engines"`
+}
+
+// scoreSnapshots scores the snapshots with the given scorer and returns them ranked, best first
+func scoreSnapshots(scorer Scorer, snapshots []*EngineSnapshot) []*EngineScore {
+	inputs := scoreInputs(snapshots)
+	results := scorer.Score(inputs)
+
+	scores := make([]*EngineScore, len(inputs))
+	for i := range inputs {
+		scores[i] = &EngineScore{Input: inputs[i], Result: results[i]}
+	}
+	sort.SliceStable(scores, func(i, j int) bool {
+		if scores[i].Result.Score != scores[j].Result.Score {
+			return scores[i].Result.Score < scores[j].Result.Score
+		}
+		return engineKey(scores[i].Input.Ip, scores[i].Input.Endpoint) <
+			engineKey(scores[j].Input.Ip, scores[j].Input.Endpoint)
+	})
+	for i, s := range scores {
+		s.Rank = i + 1
+	}
+	return scores
+}
+
+// applyScores sets the score of each snapshot with the given scorer
+func applyScores(scorer Scorer, snapshots []*EngineSnapshot) {
+	for i, r := range scorer.Score(scoreInputs(snapshots)) {
+		score := r.Score
+		snapshots[i].Score = &score
+	}
+}
+
+// Score ranks the engines of a cluster with the requested scorer
+// Returns an invalid input error when the scorer is unknown
+func Score(req *ScoreQueryRequest) (*ClusterScores, error) {
+	scorer, ok := GetScorer(req.ScorerName())
+	if !ok {
+		return nil, errors.InvalidInput("unknown scorer %s", req.ScorerName())
+	}
+	snapshots, err := backend.Snapshots(req.Cluster, req.Model, req.Blend, time.Now().UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	return &ClusterScores{Scorer: scorer.Name(), Engines: scoreSnapshots(scorer, snapshots)}, nil
+}
@@ -0,0 +1,118 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package load
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatencyScorer(t *testing.T) {
+	tests := []struct {
+		name   string
+		inputs []*ScoreInput
+		want   []float64
+	}{
+		{
+			name: "slow engine is penalized",
+			inputs: []*ScoreInput{
+				{Ip: "10.0.0.1", QueuedReqNum: 3, TTFTPerTokenMs: 1, TTFTSamples: latencyMinSamples},
+				{Ip: "10.0.0.2", QueuedReqNum: 3, TTFTPerTokenMs: 3, TTFTSamples: latencyMinSamples},
+			},
+			// cluster mean 2, weights 0.5 and 1.5
+			want: []float64{2, 6},
+		},
+		{
+			name: "too few samples get a neutral weight",
+			inputs: []*ScoreInput{
+				{Ip: "10.0.0.1", QueuedReqNum: 1, TTFTPerTokenMs: 1, TTFTSamples: latencyMinSamples},
+				{Ip: "10.0.0.2", QueuedReqNum: 1, TTFTPerTokenMs: 100, TTFTSamples: latencyMinSamples - 1},
+			},
+			want: []float64{2, 2},
+		},
+		{
+			name: "weight is bounded",
+			inputs: []*ScoreInput{
+				{Ip: "10.0.0.1", TTFTPerTokenMs: 1, TTFTSamples: latencyMinSamples},
+				{Ip: "10.0.0.2", TTFTPerTokenMs: 1, TTFTSamples: latencyMinSamples},
+				{Ip: "10.0.0.3", TTFTPerTokenMs: 1, TTFTSamples: latencyMinSamples},
+				{Ip: "10.0.0.4", TTFTPerTokenMs: 1, TTFTSamples: latencyMinSamples},
+				{Ip: "10.0.0.5", TTFTPerTokenMs: 1, TTFTSamples: latencyMinSamples},
+				{Ip: "10.0.0.6", TTFTPerTokenMs: 95, TTFTSamples: latencyMinSamples},
+			},
+			// cluster mean 100/6, raw weights 0.06 and 5.7
+			want: []float64{
+				latencyWeightMin, latencyWeightMin, latencyWeightMin, latencyWeightMin, latencyWeightMin, latencyWeightMax,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := latencyScorer{}.Score(tt.inputs)
+			require.Len(t, results, len(tt.want))
+			for i, want := range tt.want {
+				assert.InDelta(t, want, results[i].Score, 1e-9, "engine %d", i)
+			}
+		})
+	}
+}
+
+func TestScoreSnapshots_Rank(t *testing.T) {
+	ttft := func(p50 float64) *LatencyStats {
+		return &LatencyStats{TTFTPerToken: &LatencyPercentiles{Count: latencyMinSamples, P50: p50}}
+	}
+	snapshots := []*EngineSnapshot{
+		{Ip: "10.0.0.1", QueuedReqNum: 2, Latency: ttft(4)},
+		{Ip: "10.0.0.2", QueuedReqNum: 4, Latency: ttft(1)},
+		{Ip: "10.0.0.3", QueuedReqNum: 2},
+	}
+
+	scorer, ok := GetScorer(ScorerLatency)
+	require.True(t, ok)
+	scores := scoreSnapshots(scorer, snapshots)
+	require.Len(t, scores, 3)
+	// mean 2.5: 3 * 1.6 = 4.8, 5 * 0.4 = 2, 3 * 1 = 3
+	assert.Equal(t, []string{"10.0.0.2", "10.0.0.3", "10.0.0.1"},
+		[]string{scores[0].Input.Ip, scores[1].Input.Ip, scores[2].Input.Ip})
+	assert.Equal(t, []int{1, 2, 3}, []int{scores[0].Rank, scores[1].Rank, scores[2].Rank})
+	assert.InDelta(t, 0.4, scores[0].Result.Factors["latency_weight"], 1e-9)
+
+	scorer, ok = GetScorer(ScorerQueue)
+	require.True(t, ok)
+	scores = scoreSnapshots(scorer, snapshots)
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.3", "10.0.0.2"},
+		[]string{scores[0].Input.Ip, scores[1].Input.Ip, scores[2].Input.Ip}, "ties are ranked by engine key")
+
+	_, ok = GetScorer("unknown")
+	assert.False(t, ok)
+}
+
+func TestModelStats_SelectWithScorer(t *testing.T) {
+	ls := NewLoadStats()
+	cluster := "scorer-domain"
+	addRequests(ls, cluster, "10.0.1.1", 3)
+
+	ms := ls.GetModelStats(cluster)
+	snapshots := ms.Select(&ModelQueryRequest{Cluster: cluster})
+	require.Len(t, snapshots, 1)
+	assert.Nil(t, snapshots[0].Score)
+
+	snapshots = ms.Select(&ModelQueryRequest{Cluster: cluster, Scorer: ScorerQueue})
+	require.Len(t, snapshots, 1)
+	require.NotNil(t, snapshots[0].Score)
+	assert.Equal(t, 3.0, *snapshots[0].Score)
+}
@@ -0,0 +1,186 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package load
+
+import (
+	"hash/maphash"
+	"sync"
+)
+
+const (
+	// StorageSharded stores entries in lock-striped shards, suited to high-churn keys
+	StorageSharded = "sharded"
+	// StorageSyncMap stores entries in a single sync.Map, suited to read-mostly keys
+	StorageSyncMap = "syncmap"
+)
+
+// store is a concurrent map keyed by string
+// Range does not see a consistent snapshot, and its callback may modify the store
+type store[V comparable] interface {
+	Load(key string) (V, bool)
+	LoadOrStore(key string, value V) (V, bool)
+	LoadAndDelete(key string) (V, bool)
+	CompareAndDelete(key string, old V) bool
+	Range(f func(key string, value V) bool)
+}
+
+// engineShards is the shard count of the engines of a cluster, which hold far fewer keys than the requests
+const engineShards = 8
+
+// newStore creates a store of the configured storage kind, shards is the shard count of a sharded store
+func newStore[V comparable](shards int) store[V] {
+	if storageKind == StorageSyncMap {
+		return &syncMapStore[V]{}
+	}
+	return newShardedStore[V](shards)
+}
+
+// syncMapStore is a store backed by a sync.Map
+type syncMapStore[V comparable] struct {
+	m sync.Map
+}
+
+func (s *syncMapStore[V]) Load(key string) (V, bool) {
+	v, ok := s.m.Load(key)
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	return v.(V), true
+}
+
+func (s *syncMapStore[V]) LoadOrStore(key string, value V) (V, bool) {
+	v, loaded := s.m.LoadOrStore(key, value)
+	return v.(V), loaded
+}
+
+func (s *syncMapStore[V]) LoadAndDelete(key string) (V, bool) {
+	v, ok := s.m.LoadAndDelete(key)
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	return v.(V), true
+}
+
+func (s *syncMapStore[V]) CompareAndDelete(key string, old V) bool {
+	return s.m.CompareAndDelete(key, old)
+}
+
+func (s *syncMapStore[V]) Range(f func(key string, value V) bool) {
+	s.m.Range(func(k, v any) bool {
+		return f(k.(string), v.(V))
+	})
+}
+
+// shard is one lock-striped part of a shardedStore
+type shard[V comparable] struct {
+	mu sync.RWMutex
+	m  map[string]V
+}
+
+// shardedStore is a store split into shards by key hash, each guarded by its own lock
+// Writes to different shards never contend, and Range only holds one shard lock at a time
+type shardedStore[V comparable] struct {
+	seed   maphash.Seed
+	mask   uint64
+	shards []shard[V]
+}
+
+// newShardedStore creates a sharded store, the shard count is rounded up to a power of two
+func newShardedStore[V comparable](n int) *shardedStore[V] {
+	size := 1
+	for size < n {
+		size <<= 1
+	}
+	s := &shardedStore[V]{
+		seed:   maphash.MakeSeed(),
+		mask:   uint64(size - 1),
+		shards: make([]shard[V], size),
+	}
+	for i := range s.shards {
+		s.shards[i].m = make(map[string]V)
+	}
+	return s
+}
+
+// shard returns the shard owning the key
+func (s *shardedStore[V]) shard(key string) *shard[V] {
+	return &s.shards[maphash.String(s.seed, key)&s.mask]
+}
+
+func (s *shardedStore[V]) Load(key string) (V, bool) {
+	sh := s.shard(key)
+	sh.mu.RLock()
+	v, ok := sh.m[key]
+	sh.mu.RUnlock()
+	return v, ok
+}
+
+func (s *shardedStore[V]) LoadOrStore(key string, value V) (V, bool) {
+	sh := s.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if v, ok := sh.m[key]; ok {
+		return v, true
+	}
+	sh.m[key] = value
+	return value, false
+}
+
+func (s *shardedStore[V]) LoadAndDelete(key string) (V, bool) {
+	sh := s.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	v, ok := sh.m[key]
+	if ok {
+		delete(sh.m, key)
+	}
+	return v, ok
+}
+
+func (s *shardedStore[V]) CompareAndDelete(key string, old V) bool {
+	sh := s.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if v, ok := sh.m[key]; !ok || v != old {
+		return false
+	}
+	delete(sh.m, key)
+	return true
+}
+
+// Range copies each shard under its read lock and calls f without holding any lock
+func (s *shardedStore[V]) Range(f func(key string, value V) bool) {
+	type entry struct {
+		key   string
+		value V
+	}
+	var entries []entry
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.RLock()
+		entries = entries[:0]
+		for k, v := range sh.m {
+			entries = append(entries, entry{k, v})
+		}
+		sh.mu.RUnlock()
+		for _, e := range entries {
+			if !f(e.key, e.value) {
+				return
+			}
+		}
+	}
+}
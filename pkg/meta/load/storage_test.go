@@ -0,0 +1,180 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package load
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aigw-project/metadata-center/pkg/utils/logger"
+)
+
+var storageKinds = []string{StorageSharded, StorageSyncMap}
+
+// withStorage runs f with the given storage kind configured
+func withStorage(kind string, f func()) {
+	SetStorageKind(kind)
+	defer SetStorageKind(DefaultStorageKind)
+	f()
+}
+
+func TestStore(t *testing.T) {
+	for _, kind := range storageKinds {
+		t.Run(kind, func(t *testing.T) {
+			var s store[*InferenceRequest]
+			withStorage(kind, func() { s = newStore[*InferenceRequest](storageShards) })
+
+			a, b := &InferenceRequest{RequestId: "a"}, &InferenceRequest{RequestId: "a"}
+			_, ok := s.Load("a")
+			assert.False(t, ok)
+			v, loaded := s.LoadOrStore("a", a)
+			assert.False(t, loaded)
+			assert.Same(t, a, v)
+			v, loaded = s.LoadOrStore("a", b)
+			assert.True(t, loaded)
+			assert.Same(t, a, v)
+
+			assert.False(t, s.CompareAndDelete("a", b), "a different value is kept")
+			assert.True(t, s.CompareAndDelete("a", a))
+			_, ok = s.LoadAndDelete("a")
+			assert.False(t, ok)
+
+			for i := 0; i < 100; i++ {
+				id := fmt.Sprintf("r-%d", i)
+				s.LoadOrStore(id, &InferenceRequest{RequestId: id})
+			}
+			// Range callbacks may delete entries
+			seen := 0
+			s.Range(func(id string, req *InferenceRequest) bool {
+				seen++
+				assert.Equal(t, id, req.RequestId)
+				assert.True(t, s.CompareAndDelete(id, req))
+				return true
+			})
+			assert.Equal(t, 100, seen)
+			s.Range(func(string, *InferenceRequest) bool {
+				t.Fatal("store should be empty")
+				return false
+			})
+		})
+	}
+}
+
+func TestLoadStats_StorageKind(t *testing.T) {
+	ls, ms := NewLoadStats(), NewModelStats("kind")
+	assert.IsType(t, &shardedStore[*ModelStats]{}, ls.RunningModelStats)
+	require.IsType(t, &shardedStore[*EngineStats]{}, ms.Engines)
+	assert.Len(t, ms.Engines.(*shardedStore[*EngineStats]).shards, engineShards)
+
+	withStorage(StorageSyncMap, func() { ls, ms = NewLoadStats(), NewModelStats("kind") })
+	assert.IsType(t, &syncMapStore[*InferenceRequest]{}, ls.Requests)
+	assert.IsType(t, &syncMapStore[*ModelStats]{}, ls.RunningModelStats)
+	assert.IsType(t, &syncMapStore[*EngineStats]{}, ms.Engines)
+}
+
+func TestShardedStore_Concurrent(t *testing.T) {
+	s := newShardedStore[*InferenceRequest](5)
+	require.Len(t, s.shards, 8, "shard count is rounded up to a power of two")
+
+	var wg sync.WaitGroup
+	var deleted atomic.Int64
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				id := fmt.Sprintf("%d-%d", w, i)
+				s.LoadOrStore(id, &InferenceRequest{RequestId: id})
+				if i%2 == 0 {
+					if _, ok := s.LoadAndDelete(id); ok {
+						deleted.Add(1)
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	count := 0
+	s.Range(func(string, *InferenceRequest) bool {
+		count++
+		return true
+	})
+	assert.Equal(t, int64(4000), deleted.Load())
+	assert.Equal(t, 4000, count)
+}
+
+// benchmarkMixed runs a workload of adds, deletes, queries and occasional full scans on a LoadStats
+// readPct and scanPct are the percentages of queries and scans, the rest is split between adds and deletes
+func benchmarkMixed(b *testing.B, readPct, scanPct int) {
+	for _, kind := range storageKinds {
+		b.Run(kind, func(b *testing.B) {
+			logger.SetLevel(logger.ErrorLevel)
+			var ls *LoadStats
+			withStorage(kind, func() { ls = NewLoadStats() })
+			for _, req := range benchMarkRequest {
+				ls.AddRequest(req)
+			}
+			// Queries take the read path of the query API
+			mem := memoryBackend{ls: ls}
+			var seq atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				// Deletes target the requests added by the same goroutine, oldest first
+				var added []string
+				for pb.Next() {
+					n := seq.Add(1)
+					cluster := benchMarkRequest[n%int64(len(benchMarkRequest))].Cluster
+					switch op := int(n % 100); {
+					case op < scanPct:
+						ls.CheckInvariants(false)
+					case op < scanPct+readPct:
+						_, _ = mem.Snapshots(cluster, "", "", time.Now().UnixNano())
+					case n%2 == 0 || len(added) == 0:
+						id := fmt.Sprintf("mixed-%d", n)
+						ls.AddRequest(&InferenceRequest{
+							Cluster:      cluster,
+							Ip:           fmt.Sprintf("10.0.0.%d", n%256),
+							PromptLength: 512,
+							RequestId:    id,
+						})
+						added = append(added, id)
+					default:
+						ls.DeleteRequest(&DeletionInferenceRequest{RequestId: added[0]})
+						added = added[1:]
+					}
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkStorage_WriteHeavy(b *testing.B) {
+	benchmarkMixed(b, 20, 0)
+}
+
+func BenchmarkStorage_ReadHeavy(b *testing.B) {
+	benchmarkMixed(b, 80, 0)
+}
+
+func BenchmarkStorage_WithScans(b *testing.B) {
+	benchmarkMixed(b, 50, 1)
+}
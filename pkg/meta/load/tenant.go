@@ -0,0 +1,265 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package load
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aigw-project/metadata-center/pkg/prom"
+	"github.com/aigw-project/metadata-center/pkg/utils/errors"
+	"github.com/aigw-project/metadata-center/pkg/utils/logger"
+)
+
+// tenantSeparator joins a tenant and a cluster name or request ID into a LoadStats key
+// Keys of the default tenant have no prefix, so existing clusters and replicas are unaffected
+const tenantSeparator = "/"
+
+// tenantPattern restricts tenant names, they must not contain the separator used in LoadStats keys
+var tenantPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ValidTenant reports whether the name can be used as a tenant
+func ValidTenant(tenant string) bool {
+	return tenantPattern.MatchString(tenant)
+}
+
+// Quota names used in rejections and metrics
+const (
+	quotaRequests = "requests"
+	quotaClusters = "clusters"
+)
+
+// ScopedName returns the LoadStats key of a cluster name or request ID owned by the tenant
+// Names of the default tenant, and empty names, are returned unchanged
+func ScopedName(tenant, name string) string {
+	if tenant == "" || name == "" {
+		return name
+	}
+	return tenant + tenantSeparator + name
+}
+
+// UnscopedName returns the name of a LoadStats key as seen by the tenant
+func UnscopedName(tenant, key string) string {
+	if tenant == "" {
+		return key
+	}
+	return strings.TrimPrefix(key, tenant+tenantSeparator)
+}
+
+// tenantOf returns the tenant owning a LoadStats key, empty for the default tenant
+func tenantOf(key string) string {
+	tenant, _, found := strings.Cut(key, tenantSeparator)
+	if !found {
+		return ""
+	}
+	return tenant
+}
+
+// TenantUsage holds the number of in-flight requests and clusters tracked for a tenant
+type TenantUsage struct {
+	Requests int64 `json:"requests"`
+	Clusters int64 `json:"clusters"`
+}
+
+// tenantUsage holds the live usage counters of a tenant
+type tenantUsage struct {
+	requests atomic.Int64
+	clusters atomic.Int64
+}
+
+// usage returns the usage counters of a tenant, creating them if needed
+func (ls *LoadStats) usage(tenant string) *tenantUsage {
+	v, ok := ls.tenants.Load(tenant)
+	if !ok {
+		v, _ = ls.tenants.LoadOrStore(tenant, &tenantUsage{})
+	}
+	return v.(*tenantUsage)
+}
+
+// trackRequests updates the tracked requests in total and of the tenant owning the cluster
+func (ls *LoadStats) trackRequests(cluster string, delta int64) {
+	tenant := tenantOf(cluster)
+	u := ls.usage(tenant)
+	u.requests.Add(delta)
+	prom.TrackedRequests.Set(float64(ls.requestCount.Add(delta)))
+	prom.SetTenantUsageMetric(tenant, u.requests.Load(), u.clusters.Load())
+}
+
+// trackClusters updates the tracked clusters in total and of the tenant owning the cluster
+func (ls *LoadStats) trackClusters(cluster string, delta int64) {
+	tenant := tenantOf(cluster)
+	u := ls.usage(tenant)
+	u.clusters.Add(delta)
+	prom.TrackedClusters.Set(float64(ls.clusterCount.Add(delta)))
+	prom.SetTenantUsageMetric(tenant, u.requests.Load(), u.clusters.Load())
+}
+
+// GetTenantUsage returns the usage of a tenant
+func (ls *LoadStats) GetTenantUsage(tenant string) *TenantUsage {
+	u := ls.usage(tenant)
+	return &TenantUsage{Requests: u.requests.Load(), Clusters: u.clusters.Load()}
+}
+
+// TenantLimit overrides the quotas of a tenant at runtime, nil fields keep the configured default
+// A limit of 0 means unlimited
+type TenantLimit struct {
+	Tenant      string `json:"tenant" binding:"required,max=64,excludes=/"`
+	MaxRequests *int64 `json:"max_requests,omitempty" binding:"omitempty,gte=0"`
+	MaxClusters *int64 `json:"max_clusters,omitempty" binding:"omitempty,gte=0"`
+	AdminAction
+}
+
+// TenantAction represents an admin action on a tenant
+type TenantAction struct {
+	Tenant string `json:"tenant" binding:"required,max=64,excludes=/"`
+	AdminAction
+}
+
+// TenantInfo describes the effective quotas and the usage of a tenant
+// Overridden is true when the quotas were set at runtime instead of coming from the configuration
+type TenantInfo struct {
+	Tenant      string `json:"tenant"`
+	MaxRequests int64  `json:"max_requests"`
+	MaxClusters int64  `json:"max_clusters"`
+	Overridden  bool   `json:"overridden"`
+	TenantUsage
+}
+
+// quotas returns the effective request and cluster quotas of a tenant
+func (ls *LoadStats) quotas(tenant string) (int64, int64, bool) {
+	maxRequests, maxClusters := tenantMaxRequests, tenantMaxClusters
+	v, ok := ls.limits.Load(tenant)
+	if !ok {
+		return maxRequests, maxClusters, false
+	}
+	limit := v.(*TenantLimit)
+	if limit.MaxRequests != nil {
+		maxRequests = *limit.MaxRequests
+	}
+	if limit.MaxClusters != nil {
+		maxClusters = *limit.MaxClusters
+	}
+	return maxRequests, maxClusters, true
+}
+
+// CheckQuota returns an error when adding to the cluster would put its tenant over a quota
+// request is true when a new in-flight request is added, false for engine reports
+// Every instance tracks the requests of the whole fleet, so quotas are global
+// Quotas apply to the default tenant as well, so callers omitting their tenant are bounded too
+// The check is not atomic with the add so a burst may overshoot slightly
+func (ls *LoadStats) CheckQuota(cluster string, request bool) error {
+	tenant := tenantOf(cluster)
+	maxRequests, maxClusters, _ := ls.quotas(tenant)
+	u := ls.usage(tenant)
+	if request && maxRequests > 0 && u.requests.Load() >= maxRequests {
+		return ls.rejectQuota(tenant, quotaRequests, maxRequests)
+	}
+	if maxClusters > 0 && ls.GetModelStats(cluster) == nil && u.clusters.Load() >= maxClusters {
+		return ls.rejectQuota(tenant, quotaClusters, maxClusters)
+	}
+	return nil
+}
+
+// rejectQuota records a quota rejection and returns the error reported to the client
+func (ls *LoadStats) rejectQuota(tenant, quota string, limit int64) error {
+	prom.TenantQuotaRejectedTotal.WithLabelValues(tenant, quota).Inc()
+	name := tenant
+	if name == "" {
+		name = "(default)"
+	}
+	logger.Warnf("tenant %s rejected over %s quota %d", name, quota, limit)
+	return errors.QuotaExceeded("rejected: limit exceeded, tenant %s is at its %s quota %d", name, quota, limit)
+}
+
+// SetTenantLimit overrides the quotas of a tenant, replacing a previous override
+// Overrides are persisted in the write-ahead log, so a restarted instance keeps them
+func (ls *LoadStats) SetTenantLimit(limit *TenantLimit, source string) *AdminResult {
+	ls.wal.append(LoadAdminSetTenantLimit, time.Now(), limit)
+	_, loaded := ls.limits.Swap(limit.Tenant, limit)
+	result := &AdminResult{Found: loaded}
+	audit("set_tenant_limit", limit.Tenant, &limit.AdminAction, source, result)
+	return result
+}
+
+// DeleteTenantLimit removes the quota override of a tenant, the configured defaults apply again
+func (ls *LoadStats) DeleteTenantLimit(action *TenantAction, source string) *AdminResult {
+	ls.wal.append(LoadAdminDeleteTenantLimit, time.Now(), action)
+	_, loaded := ls.limits.LoadAndDelete(action.Tenant)
+	result := &AdminResult{Found: loaded}
+	audit("delete_tenant_limit", action.Tenant, &action.AdminAction, source, result)
+	return result
+}
+
+// tenantNames returns the named tenants with usage or a quota override
+func (ls *LoadStats) tenantNames() []string {
+	names := make(map[string]struct{})
+	for _, m := range []*sync.Map{&ls.tenants, &ls.limits} {
+		m.Range(func(key, _ any) bool {
+			if tenant := key.(string); tenant != "" {
+				names[tenant] = struct{}{}
+			}
+			return true
+		})
+	}
+	ret := make([]string, 0, len(names))
+	for tenant := range names {
+		ret = append(ret, tenant)
+	}
+	return ret
+}
+
+// ListTenants returns the named tenants with usage or a quota override, sorted by name
+func (ls *LoadStats) ListTenants(page PageRequest) *Page[*TenantInfo] {
+	names := ls.tenantNames()
+	tenants := make([]*TenantInfo, 0, len(names))
+	for _, tenant := range names {
+		maxRequests, maxClusters, overridden := ls.quotas(tenant)
+		tenants = append(tenants, &TenantInfo{
+			Tenant:      tenant,
+			MaxRequests: maxRequests,
+			MaxClusters: maxClusters,
+			Overridden:  overridden,
+			TenantUsage: *ls.GetTenantUsage(tenant),
+		})
+	}
+	sort.Slice(tenants, func(i, j int) bool {
+		return tenants[i].Tenant < tenants[j].Tenant
+	})
+	return paginate(tenants, page)
+}
+
+// CheckQuota returns an error when adding to the cluster would put its tenant over a quota
+func CheckQuota(cluster string, request bool) error {
+	return loadStats.CheckQuota(cluster, request)
+}
+
+// SetTenantLimit overrides the quotas of a tenant
+func SetTenantLimit(limit *TenantLimit) *AdminResult {
+	return loadStats.SetTenantLimit(limit, AuditSourceAPI)
+}
+
+// DeleteTenantLimit removes the quota override of a tenant
+func DeleteTenantLimit(action *TenantAction) *AdminResult {
+	return loadStats.DeleteTenantLimit(action, AuditSourceAPI)
+}
+
+// ListTenants returns the named tenants with their quotas and usage
+func ListTenants(page PageRequest) *Page[*TenantInfo] {
+	return loadStats.ListTenants(page)
+}
@@ -0,0 +1,164 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package load
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aigw-project/metadata-center/pkg/utils/errors"
+)
+
+func TestScopedName(t *testing.T) {
+	tests := []struct {
+		tenant string
+		name   string
+		key    string
+		owner  string
+	}{
+		{"", "qwen", "qwen", ""},
+		{"team-a", "qwen", "team-a/qwen", "team-a"},
+		{"team-a", "", "", ""},
+	}
+	for _, tc := range tests {
+		key := ScopedName(tc.tenant, tc.name)
+		assert.Equal(t, tc.key, key)
+		assert.Equal(t, tc.owner, tenantOf(key))
+		assert.Equal(t, tc.name, UnscopedName(tc.tenant, key))
+	}
+}
+
+func TestLoadStats_TenantIsolation(t *testing.T) {
+	ls := NewLoadStats()
+	ip := "192.168.80.1"
+	for _, tenant := range []string{"", "team-a", "team-b"} {
+		ls.AddRequest(&InferenceRequest{
+			Cluster:      ScopedName(tenant, "qwen"),
+			RequestId:    ScopedName(tenant, "req-1"),
+			PromptLength: 100,
+			Ip:           ip,
+		})
+	}
+
+	for _, tenant := range []string{"", "team-a", "team-b"} {
+		es, ok := ls.GetModelStats(ScopedName(tenant, "qwen")).Load(ip)
+		require.True(t, ok)
+		assert.Equal(t, int32(1), es.GetQueuedReqNum(), "tenant %q", tenant)
+
+		page := ls.ListClusters(tenant, PageRequest{})
+		require.Equal(t, 1, page.Total, "tenant %q", tenant)
+		assert.Equal(t, "qwen", page.Items[0].Cluster)
+		assert.Equal(t, &TenantUsage{Requests: 1, Clusters: 1}, ls.GetTenantUsage(tenant))
+	}
+
+	ls.DeleteRequest(newDeletionInferenceRequest(ScopedName("team-a", "req-1")))
+	es, _ := ls.GetModelStats(ScopedName("team-a", "qwen")).Load(ip)
+	assert.Equal(t, int32(0), es.GetQueuedReqNum())
+	es, _ = ls.GetModelStats("qwen").Load(ip)
+	assert.Equal(t, int32(1), es.GetQueuedReqNum(), "other tenants keep their requests")
+	assert.Equal(t, int64(0), ls.GetTenantUsage("team-a").Requests)
+
+	ls.DeleteCluster(&ClusterAction{Cluster: ScopedName("team-b", "qwen")}, AuditSourceAPI)
+	assert.Equal(t, &TenantUsage{}, ls.GetTenantUsage("team-b"))
+
+	// Patterns of the default tenant do not match across the tenant separator
+	snapshot := ls.SelectClusters(&MultiClusterQueryRequest{Pattern: "*"})
+	assert.Len(t, snapshot.Clusters, 1)
+	assert.Contains(t, snapshot.Clusters, "qwen")
+}
+
+func TestLoadStats_CheckQuota(t *testing.T) {
+	SetTenantMaxRequests(2)
+	SetTenantMaxClusters(1)
+	SetRequestExpireDuration(time.Hour)
+	defer func() {
+		SetTenantMaxRequests(int(DefaultTenantMaxRequests))
+		SetTenantMaxClusters(int(DefaultTenantMaxClusters))
+		SetRequestExpireDuration(DefaultRequestExpireDuration)
+	}()
+
+	ls := NewLoadStats()
+	cluster := ScopedName("team-a", "qwen")
+	other := ScopedName("team-a", "llama")
+	add := func(id string) *InferenceRequest {
+		req := &InferenceRequest{Cluster: cluster, RequestId: ScopedName("team-a", id), Ip: "192.168.81.1"}
+		require.NoError(t, ls.CheckQuota(req.Cluster, true))
+		ls.AddRequest(req)
+		return req
+	}
+
+	require.NoError(t, ls.CheckQuota(other, false), "first cluster is within quota")
+	first := add("req-1")
+	add("req-2")
+
+	err := ls.CheckQuota(cluster, true)
+	require.Error(t, err)
+	assert.Equal(t, errors.QuotaExceededCode, err.(*errors.ErrorInfo).Code)
+	assert.NoError(t, ls.CheckQuota(cluster, false), "reports on an existing cluster are not limited by requests")
+	assert.Error(t, ls.CheckQuota(other, false), "second cluster is over quota")
+
+	// The default tenant has its own usage under the same quotas, so callers omitting their tenant are bounded too
+	assert.NoError(t, ls.CheckQuota("qwen", true))
+	ls.AddRequest(&InferenceRequest{Cluster: "qwen", RequestId: "default-1", Ip: "192.168.81.1"})
+	ls.AddRequest(&InferenceRequest{Cluster: "qwen", RequestId: "default-2", Ip: "192.168.81.1"})
+	err = ls.CheckQuota("qwen", true)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "tenant (default)")
+	assert.Error(t, ls.CheckQuota("llama", false), "second cluster of the default tenant is over quota")
+
+	// Expired requests free the quota
+	backdate(ls, first, 2*time.Hour)
+	ls.GC()
+	assert.NoError(t, ls.CheckQuota(cluster, true))
+	assert.Equal(t, int64(1), ls.GetTenantUsage("team-a").Requests)
+}
+
+func TestLoadStats_TenantLimit(t *testing.T) {
+	SetTenantMaxRequests(1)
+	defer SetTenantMaxRequests(int(DefaultTenantMaxRequests))
+
+	ls := NewLoadStats()
+	cluster := ScopedName("team-a", "qwen")
+	ls.AddRequest(&InferenceRequest{Cluster: cluster, RequestId: ScopedName("team-a", "req-1"), Ip: "192.168.81.1"})
+	require.Error(t, ls.CheckQuota(cluster, true), "default quota applies")
+
+	maxRequests := int64(2)
+	result := ls.SetTenantLimit(&TenantLimit{Tenant: "team-a", MaxRequests: &maxRequests}, AuditSourceAPI)
+	assert.False(t, result.Found)
+	require.NoError(t, ls.CheckQuota(cluster, true), "override raises the quota")
+	ls.AddRequest(&InferenceRequest{Cluster: cluster, RequestId: ScopedName("team-a", "req-2"), Ip: "192.168.81.1"})
+
+	err := ls.CheckQuota(cluster, true)
+	require.Error(t, err)
+	assert.Contains(t, err.(*errors.ErrorInfo).Reason, "rejected: limit exceeded")
+
+	unlimited := int64(0)
+	assert.True(t, ls.SetTenantLimit(&TenantLimit{Tenant: "team-a", MaxRequests: &unlimited}, AuditSourceAPI).Found)
+	assert.NoError(t, ls.CheckQuota(cluster, true), "0 means unlimited")
+
+	maxClusters := int64(5)
+	ls.SetTenantLimit(&TenantLimit{Tenant: "team-b", MaxClusters: &maxClusters}, AuditSourceAPI)
+	page := ls.ListTenants(PageRequest{})
+	require.Equal(t, 2, page.Total)
+	assert.Equal(t, &TenantInfo{Tenant: "team-a", Overridden: true, TenantUsage: TenantUsage{Requests: 2, Clusters: 1}}, page.Items[0])
+	assert.Equal(t, &TenantInfo{Tenant: "team-b", MaxRequests: 1, MaxClusters: 5, Overridden: true}, page.Items[1])
+
+	assert.True(t, ls.DeleteTenantLimit(&TenantAction{Tenant: "team-a"}, AuditSourceAPI).Found)
+	assert.False(t, ls.DeleteTenantLimit(&TenantAction{Tenant: "team-a"}, AuditSourceAPI).Found)
+	assert.Error(t, ls.CheckQuota(cluster, true), "default quota applies again")
+}
@@ -0,0 +1,172 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package load
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aigw-project/metadata-center/pkg/prom"
+	"github.com/aigw-project/metadata-center/pkg/utils/logger"
+)
+
+// Tombstone kinds, a request tombstone also covers the prompt length of the request
+const (
+	tombstoneRequest = "request"
+	tombstonePrompt  = "prompt"
+)
+
+// tombstone records a delete that arrived before the add of its request
+type tombstone struct {
+	kind    string
+	created time.Time
+}
+
+// tombstoneEntry is a tombstone in creation order, for expiry
+type tombstoneEntry struct {
+	id string
+	t  *tombstone
+}
+
+// tombstoneTable holds the unmatched deletes until their request is added or they expire
+// Every tombstone lives for the same TTL, so a FIFO queue is in expiry order
+type tombstoneTable struct {
+	tombstones sync.Map
+	mu         sync.Mutex
+	queue      []tombstoneEntry
+	size       atomic.Int64
+}
+
+// store records an unmatched delete, a prompt tombstone never replaces a request tombstone
+func (tt *tombstoneTable) store(id, kind string, now time.Time) {
+	t := &tombstone{kind: kind, created: now}
+	if kind == tombstonePrompt {
+		if _, loaded := tt.tombstones.LoadOrStore(id, t); loaded {
+			return
+		}
+	} else if old, loaded := tt.tombstones.Swap(id, t); loaded {
+		prom.TombstoneCount.WithLabelValues(old.(*tombstone).kind).Dec()
+		tt.size.Add(-1)
+	}
+	prom.TombstoneCount.WithLabelValues(kind).Inc()
+	tt.size.Add(1)
+
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	tt.queue = append(tt.queue, tombstoneEntry{id: id, t: t})
+	tt.evict()
+}
+
+// evict removes the oldest tombstones until the table is within the cap, mu must be held
+func (tt *tombstoneTable) evict() {
+	i := 0
+	for ; maxTombstones > 0 && tt.size.Load() > maxTombstones && i < len(tt.queue); i++ {
+		e := tt.queue[i]
+		// Tombstones that were taken or replaced are no longer in the table
+		if tt.tombstones.CompareAndDelete(e.id, e.t) {
+			prom.TombstoneCount.WithLabelValues(e.t.kind).Dec()
+			prom.TombstoneEvictedTotal.WithLabelValues(e.t.kind).Inc()
+			tt.size.Add(-1)
+			logger.Warnf("reqID [%s]: %s delete evicted without a matching add, tombstones at capacity %d",
+				e.id, e.t.kind, maxTombstones)
+		}
+	}
+	clear(tt.queue[:i])
+	tt.queue = tt.queue[i:]
+}
+
+// take removes and returns the tombstone of a request, nil when there is none
+func (tt *tombstoneTable) take(id string) *tombstone {
+	v, ok := tt.tombstones.LoadAndDelete(id)
+	if !ok {
+		return nil
+	}
+	t := v.(*tombstone)
+	prom.TombstoneCount.WithLabelValues(t.kind).Dec()
+	tt.size.Add(-1)
+	return t
+}
+
+// expire removes the tombstones older than the TTL and returns how many were removed
+func (tt *tombstoneTable) expire(now time.Time, ttl time.Duration) int {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	expired, i := 0, 0
+	for ; i < len(tt.queue) && !now.Before(tt.queue[i].t.created.Add(ttl)); i++ {
+		e := tt.queue[i]
+		// Tombstones that were taken or replaced are no longer in the table
+		if tt.tombstones.CompareAndDelete(e.id, e.t) {
+			prom.TombstoneCount.WithLabelValues(e.t.kind).Dec()
+			prom.TombstoneExpiredTotal.WithLabelValues(e.t.kind).Inc()
+			tt.size.Add(-1)
+			logger.Warnf("reqID [%s]: %s delete expired without a matching add, statistics may be inaccurate", e.id, e.t.kind)
+			expired++
+		}
+	}
+	clear(tt.queue[:i])
+	tt.queue = tt.queue[i:]
+	return expired
+}
+
+// len returns the number of tombstones
+func (tt *tombstoneTable) len() int64 {
+	return tt.size.Load()
+}
+
+// storeTombstone records a delete whose request is not tracked
+// The request may be added while the tombstone is stored, it is then applied right away
+func (ls *LoadStats) storeTombstone(id, kind string) {
+	if tombstoneTTL <= 0 {
+		logger.Warnf("reqID [%s]: request ID not found for %s delete, statistics may be inaccurate", id, kind)
+		return
+	}
+	logger.Infof("reqID [%s]: request ID not found, keeping %s delete as tombstone", id, kind)
+	ls.tombstones.store(id, kind, time.Now())
+	if _, ok := ls.Requests.Load(id); ok {
+		ls.applyTombstone(id)
+	}
+}
+
+// matchTombstone applies the tombstone of a request that is about to be added
+// Returns true when the request was deleted already and must not be added
+func (ls *LoadStats) matchTombstone(req *InferenceRequest) bool {
+	t := ls.tombstones.take(req.RequestId)
+	if t == nil {
+		return false
+	}
+	prom.TombstoneHitTotal.WithLabelValues(t.kind).Inc()
+	logger.Infof("reqID [%s]: add matched a %s delete received %s earlier", req.RequestId, t.kind, time.Since(t.created))
+	if t.kind == tombstoneRequest {
+		return true
+	}
+	atomic.StoreInt32(&req.PromptLength, 0)
+	return false
+}
+
+// applyTombstone applies the tombstone of a request that is tracked already
+func (ls *LoadStats) applyTombstone(id string) {
+	t := ls.tombstones.take(id)
+	if t == nil {
+		return
+	}
+	prom.TombstoneHitTotal.WithLabelValues(t.kind).Inc()
+	logger.Infof("reqID [%s]: %s delete applied to a concurrently added request", id, t.kind)
+	if t.kind == tombstoneRequest {
+		ls.tryDeleteRequestStats(id, time.Now(), true)
+	} else {
+		ls.tryDecPromptLength(id, time.Now())
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package load
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aigw-project/metadata-center/pkg/prom"
+)
+
+func TestLoadStats_PromptTombstone(t *testing.T) {
+	ls := NewLoadStats()
+	cluster := "tombstone_domain"
+	ip := "192.168.40.1"
+
+	ls.DeletePromptLength(newDeletionInferenceRequest("t-1"))
+	ls.AddRequest(&InferenceRequest{Cluster: cluster, RequestId: "t-1", PromptLength: 100, Ip: ip})
+	es, ok := ls.GetModelStats(cluster).Load(ip)
+	require.True(t, ok)
+	assert.Equal(t, int32(1), es.GetQueuedReqNum())
+	assert.Equal(t, int32(0), es.GetPromptLength(), "the prompt length was deleted before the add")
+
+	// A request tombstone is not replaced by a later prompt tombstone
+	ls.DeleteRequest(newDeletionInferenceRequest("t-2"))
+	ls.DeletePromptLength(newDeletionInferenceRequest("t-2"))
+	assert.Equal(t, int64(1), ls.tombstones.len())
+	ls.AddRequest(&InferenceRequest{Cluster: cluster, RequestId: "t-2", PromptLength: 100, Ip: ip})
+	assert.Equal(t, int32(1), es.GetQueuedReqNum())
+	assert.Equal(t, int32(0), es.GetPromptLength())
+
+	// A request tombstone replaces a prompt tombstone
+	ls.DeletePromptLength(newDeletionInferenceRequest("t-3"))
+	ls.DeleteRequest(newDeletionInferenceRequest("t-3"))
+	ls.AddRequest(&InferenceRequest{Cluster: cluster, RequestId: "t-3", PromptLength: 100, Ip: ip})
+	assert.Equal(t, int32(1), es.GetQueuedReqNum())
+	assert.Equal(t, int64(0), ls.tombstones.len())
+}
+
+func TestLoadStats_TombstoneExpiry(t *testing.T) {
+	SetTombstoneTTL(time.Minute)
+	defer SetTombstoneTTL(DefaultTombstoneTTL)
+
+	ls := NewLoadStats()
+	ls.DeleteRequest(newDeletionInferenceRequest("e-1"))
+	ls.DeleteRequest(newDeletionInferenceRequest("e-2"))
+	ls.DeleteRequest(newDeletionInferenceRequest("e-2"))
+	ls.DeletePromptLength(newDeletionInferenceRequest("e-3"))
+	assert.Equal(t, int64(3), ls.tombstones.len())
+
+	ls.AddRequest(&InferenceRequest{Cluster: "expire_tombstone", RequestId: "e-1", Ip: "192.168.40.2"})
+	assert.Equal(t, 0, ls.tombstones.expire(time.Now(), tombstoneTTL))
+	assert.Equal(t, 2, ls.tombstones.expire(time.Now().Add(time.Minute), tombstoneTTL), "taken and replaced tombstones are skipped")
+	assert.Equal(t, int64(0), ls.tombstones.len())
+	assert.Empty(t, ls.tombstones.queue)
+
+	// Once expired, a late add is tracked again
+	ls.AddRequest(&InferenceRequest{Cluster: "expire_tombstone", RequestId: "e-2", Ip: "192.168.40.2"})
+	_, ok := ls.Requests.Load("e-2")
+	assert.True(t, ok)
+
+	SetTombstoneTTL(0)
+	ls.DeleteRequest(newDeletionInferenceRequest("e-4"))
+	assert.Equal(t, int64(0), ls.tombstones.len(), "tombstones are disabled")
+}
+
+func TestLoadStats_TombstoneCapacity(t *testing.T) {
+	SetMaxTombstones(2)
+	defer SetMaxTombstones(int(DefaultMaxTombstones))
+	evicted := func() float64 {
+		return gaugeValue(t, "tombstone_evicted_total", map[string]string{"kind": tombstoneRequest})
+	}
+	prom.TombstoneEvictedTotal.WithLabelValues(tombstoneRequest)
+	before := evicted()
+
+	ls := NewLoadStats()
+	ls.DeleteRequest(newDeletionInferenceRequest("c-1"))
+	ls.DeleteRequest(newDeletionInferenceRequest("c-2"))
+	ls.AddRequest(&InferenceRequest{Cluster: "cap_tombstone", RequestId: "c-2", Ip: "192.168.40.3"})
+	ls.DeleteRequest(newDeletionInferenceRequest("c-3"))
+	assert.Equal(t, int64(2), ls.tombstones.len(), "a taken tombstone frees its slot")
+
+	ls.DeleteRequest(newDeletionInferenceRequest("c-4"))
+	assert.Equal(t, int64(2), ls.tombstones.len())
+	assert.Equal(t, before+1, evicted())
+	assert.Nil(t, ls.tombstones.take("c-1"), "the oldest tombstone is evicted first")
+	assert.NotNil(t, ls.tombstones.take("c-3"))
+	assert.NotNil(t, ls.tombstones.take("c-4"))
+}
@@ -0,0 +1,361 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package load
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aigw-project/metadata-center/pkg/prom"
+	"github.com/aigw-project/metadata-center/pkg/utils/logger"
+)
+
+// Fsync policies of the write-ahead log
+const (
+	// WALSyncAlways syncs every entry before the call returns, surviving power loss
+	WALSyncAlways = "always"
+	// WALSyncInterval syncs periodically, a power loss may lose the last interval
+	WALSyncInterval = "interval"
+	// WALSyncNone leaves syncing to the OS, entries survive process crashes only
+	WALSyncNone = "none"
+)
+
+const (
+	walSegmentExt = ".wal"
+	// walHeaderSize is the length and the CRC32 of the payload that precede each entry
+	walHeaderSize = 8
+	// walMaxEntrySize bounds the payload of an entry, so a corrupt length is not allocated
+	walMaxEntrySize = 1 << 20
+)
+
+var errWALCorrupt = errors.New("corrupt wal entry")
+
+// walEntry is a request or tenant limit event in the write-ahead log
+// Op is the replication event type of the event, so both share one vocabulary
+type walEntry struct {
+	Seq      uint64                    `json:"seq"`
+	Op       string                    `json:"op"`
+	Time     int64                     `json:"time"`
+	Request  *InferenceRequest         `json:"request,omitempty"`
+	Deletion *DeletionInferenceRequest `json:"deletion,omitempty"`
+	Limit    *TenantLimit              `json:"limit,omitempty"`
+	Tenant   *TenantAction             `json:"tenant,omitempty"`
+}
+
+// walSegment is a log file holding the entries from first up to the first entry of the next segment
+type walSegment struct {
+	first uint64
+	path  string
+}
+
+// wal is an append-only log of request events split into segments
+// Each entry is its payload length and CRC32 followed by the JSON payload, so a torn tail is detected on replay
+type wal struct {
+	dir      string
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	seq      uint64
+	dirty    bool
+	segments []walSegment
+}
+
+// openWAL opens the log in dir for appending after seq, a new segment is started
+func openWAL(dir string, seq uint64) (*wal, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	segments, err := listWALSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+	// A segment starting right after seq holds no readable entry, it is replaced
+	if n := len(segments); n > 0 && segments[n-1].first == seq+1 {
+		segments = segments[:n-1]
+	}
+	w := &wal{dir: dir, seq: seq, segments: segments}
+	if err := w.rotateLocked(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// listWALSegments returns the segments in dir in log order
+func listWALSegments(dir string) ([]walSegment, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var segments []walSegment
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasSuffix(name, walSegmentExt) {
+			continue
+		}
+		first, err := strconv.ParseUint(strings.TrimSuffix(name, walSegmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, walSegment{first: first, path: filepath.Join(dir, name)})
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].first < segments[j].first })
+	return segments, nil
+}
+
+// append logs a request or tenant limit event, failures are logged and counted since the in-memory state stays authoritative
+func (w *wal) append(op string, now time.Time, payload any) {
+	if w == nil {
+		return
+	}
+	entry := &walEntry{Op: op, Time: now.UnixNano()}
+	switch p := payload.(type) {
+	case *InferenceRequest:
+		entry.Request = p
+	case *DeletionInferenceRequest:
+		entry.Deletion = p
+	case *TenantLimit:
+		entry.Limit = p
+	case *TenantAction:
+		entry.Tenant = p
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.seq++
+	entry.Seq = w.seq
+	if err := w.writeLocked(entry); err != nil {
+		prom.WALErrorTotal.Inc()
+		logger.Errorf("failed to append %s entry %d to the wal: %v", op, entry.Seq, err)
+	}
+}
+
+// writeLocked encodes and writes an entry, must be called with mu held
+func (w *wal) writeLocked(entry *walEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if len(data) > walMaxEntrySize {
+		return fmt.Errorf("entry of %d bytes exceeds the maximum of %d", len(data), walMaxEntrySize)
+	}
+	// A failed rotation left no segment open
+	if w.file == nil || w.size > 0 && w.size+int64(walHeaderSize+len(data)) > walSegmentSize {
+		if err := w.rotateLocked(); err != nil {
+			return err
+		}
+	}
+	buf := make([]byte, walHeaderSize+len(data))
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(data)))
+	binary.LittleEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(data))
+	copy(buf[walHeaderSize:], data)
+	n, err := w.file.Write(buf)
+	w.size += int64(n)
+	if err != nil {
+		return err
+	}
+	if walSync == WALSyncAlways {
+		return w.file.Sync()
+	}
+	w.dirty = true
+	return nil
+}
+
+// rotateLocked closes the current segment and starts one for the next entry, must be called with mu held
+func (w *wal) rotateLocked() error {
+	if w.file != nil {
+		if err := w.file.Sync(); err != nil {
+			return err
+		}
+		if err := w.file.Close(); err != nil {
+			return err
+		}
+		w.file = nil
+	}
+	first := w.seq + 1
+	path := filepath.Join(w.dir, fmt.Sprintf("%020d%s", first, walSegmentExt))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	w.file, w.size, w.dirty = f, 0, false
+	w.segments = append(w.segments, walSegment{first: first, path: path})
+	prom.WALSegments.Set(float64(len(w.segments)))
+	return nil
+}
+
+// checkpoint starts a new segment and returns the sequence of the last entry before it
+// A snapshot taken afterwards includes every entry up to the returned sequence
+func (w *wal) checkpoint() (uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	seq := w.seq
+	return seq, w.rotateLocked()
+}
+
+// truncate removes the segments whose entries all precede seq+1
+func (w *wal) truncate(seq uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	kept := w.segments[:0]
+	for i, s := range w.segments {
+		// A segment ends where the next one starts, the current segment is always kept
+		if i+1 < len(w.segments) && w.segments[i+1].first <= seq+1 {
+			if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+				logger.Errorf("failed to remove wal segment %s: %v", s.path, err)
+				kept = append(kept, s)
+			}
+			continue
+		}
+		kept = append(kept, s)
+	}
+	w.segments = kept
+	prom.WALSegments.Set(float64(len(w.segments)))
+}
+
+// sync flushes the current segment to disk if it has unsynced entries
+func (w *wal) sync() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.dirty || w.file == nil {
+		return
+	}
+	if err := w.file.Sync(); err != nil {
+		prom.WALErrorTotal.Inc()
+		logger.Errorf("failed to sync the wal: %v", err)
+		return
+	}
+	w.dirty = false
+}
+
+// close syncs and closes the current segment
+func (w *wal) close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// readWAL calls f for every entry in dir after the given sequence, in log order
+// A torn or corrupt entry ends the log, the segment is truncated there so later appends are readable
+// Returns the sequence of the last entry read
+func readWAL(dir string, after uint64, f func(*walEntry)) (uint64, error) {
+	segments, err := listWALSegments(dir)
+	if err != nil {
+		return after, err
+	}
+	last := after
+	for i, s := range segments {
+		// Skip segments that end before the requested entries
+		if i+1 < len(segments) && segments[i+1].first <= after+1 {
+			continue
+		}
+		offset, err := readWALSegment(s.path, func(e *walEntry) {
+			if e.Seq > last {
+				last = e.Seq
+				f(e)
+			}
+		})
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, errWALCorrupt) {
+			return last, err
+		}
+		logger.Warnf("wal segment %s ends with a torn entry at offset %d, dropping the rest of the log", s.path, offset)
+		if err := os.Truncate(s.path, offset); err != nil {
+			return last, err
+		}
+		for _, rest := range segments[i+1:] {
+			if err := os.Remove(rest.path); err != nil {
+				return last, err
+			}
+		}
+		break
+	}
+	return last, nil
+}
+
+// readWALSegment calls f for every entry of a segment
+// Returns the offset after the last valid entry and errWALCorrupt when the segment does not end cleanly
+func readWALSegment(path string, f func(*walEntry)) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	var offset int64
+	header := make([]byte, walHeaderSize)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return offset, nil
+			}
+			return offset, errWALCorrupt
+		}
+		size := binary.LittleEndian.Uint32(header[0:4])
+		if size > walMaxEntrySize {
+			return offset, errWALCorrupt
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return offset, errWALCorrupt
+		}
+		if crc32.ChecksumIEEE(data) != binary.LittleEndian.Uint32(header[4:8]) {
+			return offset, errWALCorrupt
+		}
+		var entry walEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return offset, errWALCorrupt
+		}
+		f(&entry)
+		offset += int64(walHeaderSize + len(data))
+	}
+}
+
+// cronSyncWAL syncs the write-ahead log periodically
+func cronSyncWAL(ticker *time.Ticker, w *wal) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Errorf("wal sync goroutine panicked: %v", r)
+		}
+		ticker.Stop()
+		logger.Errorf("wal sync goroutine exited")
+	}()
+
+	for range ticker.C {
+		w.sync()
+	}
+}
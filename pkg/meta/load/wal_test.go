@@ -0,0 +1,103 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package load
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readEntries returns the entries of the log in dir after the given sequence
+func readEntries(t *testing.T, dir string, after uint64) []*walEntry {
+	var entries []*walEntry
+	_, err := readWAL(dir, after, func(e *walEntry) {
+		entries = append(entries, e)
+	})
+	require.NoError(t, err)
+	return entries
+}
+
+func TestWAL(t *testing.T) {
+	SetWALSegmentSize(512)
+	defer SetWALSegmentSize(int(DefaultWALSegmentSize))
+	dir := t.TempDir()
+
+	w, err := openWAL(dir, 0)
+	require.NoError(t, err)
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		w.append(LoadStatsSet, now, &InferenceRequest{Cluster: "wal", RequestId: fmt.Sprintf("wal-%d", i), PromptLength: 10, Ip: "10.0.5.1"})
+		w.append(LoadPromptDelete, now, &DeletionInferenceRequest{RequestId: fmt.Sprintf("wal-%d", i)})
+	}
+	w.sync()
+	require.Greater(t, len(w.segments), 2, "segments are rotated by size")
+
+	entries := readEntries(t, dir, 0)
+	require.Len(t, entries, 20)
+	for i, e := range entries {
+		assert.Equal(t, uint64(i+1), e.Seq)
+		assert.Equal(t, now.UnixNano(), e.Time)
+	}
+	assert.Equal(t, "wal-0", entries[0].Request.RequestId)
+	assert.Equal(t, LoadPromptDelete, entries[1].Op)
+	assert.Equal(t, "wal-0", entries[1].Deletion.RequestId)
+	assert.Len(t, readEntries(t, dir, 15), 5)
+
+	seq, err := w.checkpoint()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(20), seq)
+	w.append(LoadStatsDelete, now, &DeletionInferenceRequest{RequestId: "wal-0"})
+	w.truncate(seq)
+	assert.Len(t, w.segments, 1)
+	entries = readEntries(t, dir, 0)
+	require.Len(t, entries, 1, "segments covered by the checkpoint are removed")
+	assert.Equal(t, uint64(21), entries[0].Seq)
+	require.NoError(t, w.close())
+}
+
+func TestWAL_TornTail(t *testing.T) {
+	dir := t.TempDir()
+	w, err := openWAL(dir, 0)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		w.append(LoadStatsDelete, time.Now(), &DeletionInferenceRequest{RequestId: fmt.Sprintf("torn-%d", i)})
+	}
+	path := w.segments[0].path
+	require.NoError(t, w.close())
+
+	// A crash in the middle of the last write
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(path, info.Size()-3))
+
+	seq, err := readWAL(dir, 0, func(*walEntry) {})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), seq)
+
+	// The torn entry is cut off, so entries appended after a restart are readable
+	w, err = openWAL(dir, seq)
+	require.NoError(t, err)
+	w.append(LoadStatsDelete, time.Now(), &DeletionInferenceRequest{RequestId: "torn-again"})
+	require.NoError(t, w.close())
+	entries := readEntries(t, dir, 0)
+	require.Len(t, entries, 3)
+	assert.Equal(t, "torn-again", entries[2].Deletion.RequestId)
+	assert.Equal(t, uint64(3), entries[2].Seq)
+}
@@ -20,7 +20,7 @@ import "github.com/gin-gonic/gin"
 type middlewareCreator func() gin.HandlerFunc
 
 // middlewareCreators contains all middleware creation functions
-var middlewareCreators = []middlewareCreator{Trace, Logger, Recovery, RequestMetrics}
+var middlewareCreators = []middlewareCreator{Trace, Logger, Recovery, Tenant, RequestMetrics}
 
 // GetMiddlewares returns all configured middleware handlers
 // Filters out any middleware creators that return nil
@@ -0,0 +1,76 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package middleware
+
+import (
+	"crypto/subtle"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/aigw-project/metadata-center/pkg/config"
+	"github.com/aigw-project/metadata-center/pkg/ginx"
+	"github.com/aigw-project/metadata-center/pkg/meta/load"
+	"github.com/aigw-project/metadata-center/pkg/utils/errors"
+)
+
+// DefaultTenantHeader is used when no tenant header is configured
+const DefaultTenantHeader = "X-Tenant-Id"
+
+// TenantTokenHeader carries the token authenticating the tenant of a request
+const TenantTokenHeader = "X-Tenant-Token"
+
+// Tenant creates a middleware that stores the tenant of a request in the context
+// The tenant is read from the configured header, then from the basic auth username
+// Requests without either belong to the default tenant
+// With tenant tokens configured a named tenant must present its token, in TenantTokenHeader or as the basic auth password
+func Tenant() gin.HandlerFunc {
+	header := config.C.Tenant.Header
+	if header == "" {
+		header = DefaultTenantHeader
+	}
+	tokens := config.C.Tenant.Tokens
+	return func(c *gin.Context) {
+		tenant, token := c.GetHeader(header), c.GetHeader(TenantTokenHeader)
+		if tenant == "" {
+			var password string
+			tenant, password, _ = c.Request.BasicAuth()
+			if token == "" {
+				token = password
+			}
+		}
+		if tenant == "" {
+			return
+		}
+		if !load.ValidTenant(tenant) {
+			ginx.ResError(c, errors.InvalidInput("invalid tenant %q", tenant))
+			return
+		}
+		if !authenticated(tokens, tenant, token) {
+			ginx.ResError(c, errors.Unauthorized("tenant %s is not authenticated", tenant))
+			return
+		}
+		c.Set(ginx.TenantCtxKey, tenant)
+	}
+}
+
+// authenticated reports whether the token matches the one configured for the tenant
+// Every tenant is trusted as asserted while no token is configured
+func authenticated(tokens map[string]string, tenant, token string) bool {
+	if len(tokens) == 0 {
+		return true
+	}
+	expected, ok := tokens[tenant]
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1
+}
@@ -0,0 +1,178 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aigw-project/metadata-center/pkg/config"
+	"github.com/aigw-project/metadata-center/pkg/ginx"
+)
+
+func TestTenant(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []tenantTest{
+		{
+			name:       "no tenant",
+			setup:      func(r *http.Request) {},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "default header",
+			setup:      func(r *http.Request) { r.Header.Set(DefaultTenantHeader, "team-a") },
+			wantStatus: http.StatusOK,
+			wantTenant: "team-a",
+		},
+		{
+			name:       "configured header",
+			header:     "X-Team",
+			setup:      func(r *http.Request) { r.Header.Set("X-Team", "team_b") },
+			wantStatus: http.StatusOK,
+			wantTenant: "team_b",
+		},
+		{
+			name:       "basic auth fallback",
+			setup:      func(r *http.Request) { r.SetBasicAuth("team-c", "secret") },
+			wantStatus: http.StatusOK,
+			wantTenant: "team-c",
+		},
+		{
+			name: "header takes precedence over basic auth",
+			setup: func(r *http.Request) {
+				r.Header.Set(DefaultTenantHeader, "team-a")
+				r.SetBasicAuth("team-c", "secret")
+			},
+			wantStatus: http.StatusOK,
+			wantTenant: "team-a",
+		},
+		{
+			name:       "separator in header",
+			setup:      func(r *http.Request) { r.Header.Set(DefaultTenantHeader, "team-a/qwen") },
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "invalid basic auth username",
+			setup:      func(r *http.Request) { r.SetBasicAuth("team a", "secret") },
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "name too long",
+			setup:      func(r *http.Request) { r.Header.Set(DefaultTenantHeader, strings.Repeat("t", 65)) },
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	runTenantTests(t, tests)
+}
+
+func TestTenant_Tokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	config.C.Tenant.Tokens = map[string]string{"team-a": "secret-a", "team-c": "secret-c"}
+	defer func() { config.C.Tenant.Tokens = nil }()
+
+	runTenantTests(t, []tenantTest{
+		{
+			name:       "no tenant",
+			setup:      func(r *http.Request) {},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "header with token",
+			setup: func(r *http.Request) {
+				r.Header.Set(DefaultTenantHeader, "team-a")
+				r.Header.Set(TenantTokenHeader, "secret-a")
+			},
+			wantStatus: http.StatusOK,
+			wantTenant: "team-a",
+		},
+		{
+			name:       "header without token",
+			setup:      func(r *http.Request) { r.Header.Set(DefaultTenantHeader, "team-a") },
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "token of another tenant",
+			setup: func(r *http.Request) {
+				r.Header.Set(DefaultTenantHeader, "team-a")
+				r.Header.Set(TenantTokenHeader, "secret-c")
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "tenant without token configured",
+			setup: func(r *http.Request) {
+				r.Header.Set(DefaultTenantHeader, "team-b")
+				r.Header.Set(TenantTokenHeader, "secret-a")
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "basic auth password",
+			setup:      func(r *http.Request) { r.SetBasicAuth("team-c", "secret-c") },
+			wantStatus: http.StatusOK,
+			wantTenant: "team-c",
+		},
+		{
+			name:       "basic auth wrong password",
+			setup:      func(r *http.Request) { r.SetBasicAuth("team-c", "secret-a") },
+			wantStatus: http.StatusUnauthorized,
+		},
+	})
+}
+
+// tenantTest describes a request through the tenant middleware and its expected outcome
+type tenantTest struct {
+	name       string
+	header     string
+	setup      func(r *http.Request)
+	wantStatus int
+	wantTenant string
+}
+
+// runTenantTests serves each request through the tenant middleware and checks the tenant handed to the handler
+func runTenantTests(t *testing.T, tests []tenantTest) {
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			config.C.Tenant.Header = tc.header
+			defer func() { config.C.Tenant.Header = "" }()
+
+			var called bool
+			var tenant string
+			r := gin.New()
+			r.Use(Tenant())
+			r.GET("/", func(c *gin.Context) {
+				called = true
+				tenant = ginx.GetTenant(c)
+				c.Status(http.StatusOK)
+			})
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			tc.setup(req)
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.wantStatus, w.Code, w.Body.String())
+			assert.Equal(t, tc.wantStatus == http.StatusOK, called, "handler called")
+			assert.Equal(t, tc.wantTenant, tenant)
+		})
+	}
+}
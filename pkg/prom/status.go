@@ -69,6 +69,359 @@ var (
 		[]string{"model_name", "engine_ip"},
 	)
 
+	// engineModelQueuedNumGauge tracks the queued request count per model served by an engine
+	engineModelQueuedNumGauge = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "engine_model_queued_num",
+			Help: "The queuedNum value for each model served by an engine",
+		},
+		[]string{"model_name", "engine_ip", "model"},
+	)
+
+	// engineModelPromptLengthGauge tracks the prompt length per model served by an engine
+	engineModelPromptLengthGauge = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "engine_model_prompt_length",
+			Help: "The prompt length value for each model served by an engine",
+		},
+		[]string{"model_name", "engine_ip", "model"},
+	)
+
+	// enginePriorityQueuedNumGauge tracks the queued request count per priority class of an engine
+	enginePriorityQueuedNumGauge = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "engine_priority_queued_num",
+			Help: "The queuedNum value for each priority class of an engine",
+		},
+		[]string{"model_name", "engine_ip", "priority"},
+	)
+
+	// enginePriorityPromptLengthGauge tracks the prompt length per priority class of an engine
+	enginePriorityPromptLengthGauge = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "engine_priority_prompt_length",
+			Help: "The prompt length value for each priority class of an engine",
+		},
+		[]string{"model_name", "engine_ip", "priority"},
+	)
+
+	// engineReportedQueuedNumGauge tracks the in-flight request count reported by the engine itself
+	engineReportedQueuedNumGauge = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "engine_reported_queued_num",
+			Help: "The running plus waiting request count scraped from each engine",
+		},
+		[]string{"model_name", "engine_ip"},
+	)
+
+	// engineQueuedNumDriftGauge tracks the difference between estimated and engine-reported queued count
+	engineQueuedNumDriftGauge = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "engine_queued_num_drift",
+			Help: "The estimated queuedNum minus the engine-reported value for each model and engine combination",
+		},
+		[]string{"model_name", "engine_ip"},
+	)
+
+	// engineKVCacheUsageGauge tracks the KV cache usage ratio reported by the engine
+	engineKVCacheUsageGauge = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "engine_kv_cache_usage",
+			Help: "The KV cache usage ratio scraped from each engine",
+		},
+		[]string{"model_name", "engine_ip"},
+	)
+
+	// ReconcileScrapeTotal counts engine metric scrapes by result
+	ReconcileScrapeTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "reconcile_scrape_total",
+			Help: "Total number of engine metric scrapes, partitioned by result",
+		},
+		[]string{"result"},
+	)
+
+	// ReconcileCorrectionTotal counts counter corrections applied by the reconciler
+	ReconcileCorrectionTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "reconcile_correction_total",
+			Help: "Total number of queuedNum corrections applied from engine-reported metrics",
+		},
+		[]string{"model_name"},
+	)
+
+	// engineQueuedNumMismatchGauge tracks the live queuedNum minus the value recomputed from tracked requests
+	engineQueuedNumMismatchGauge = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "engine_queued_num_mismatch",
+			Help: "The live queuedNum minus the value recomputed from tracked requests for each model and engine combination",
+		},
+		[]string{"model_name", "engine_ip"},
+	)
+
+	// enginePromptLengthMismatchGauge tracks the live promptLength minus the value recomputed from tracked requests
+	enginePromptLengthMismatchGauge = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "engine_prompt_length_mismatch",
+			Help: "The live promptLength minus the value recomputed from tracked requests for each model and engine combination",
+		},
+		[]string{"model_name", "engine_ip"},
+	)
+
+	// InvariantViolationTotal counts engines found by the invariant checker with counters off the request table
+	InvariantViolationTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "invariant_violation_total",
+			Help: "Total number of engine counter invariant violations found by the checker, partitioned by counter",
+		},
+		[]string{"model_name", "counter"},
+	)
+
+	// InvariantFixTotal counts engine counters recomputed by the invariant checker
+	InvariantFixTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "invariant_fix_total",
+			Help: "Total number of engine counters recomputed from tracked requests by the invariant checker",
+		},
+		[]string{"model_name"},
+	)
+
+	// NegativeCounterTotal counts decrements that would have made an engine counter negative
+	NegativeCounterTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "negative_counter_total",
+			Help: "Total number of engine counters clamped to 0 after a decrement went negative, partitioned by counter",
+		},
+		[]string{"model_name", "counter"},
+	)
+
+	// requestTTFT tracks the time from the add event to the prompt deletion of requests
+	requestTTFT = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "request_ttft_ms",
+			Help:    "Histogram of request time to first token in milliseconds, from add to prompt length deletion",
+			Buckets: prometheus.ExponentialBuckets(10, 2, 12),
+		},
+		[]string{"model_name"},
+	)
+
+	// requestDuration tracks the time from the add event to the deletion of requests
+	requestDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "request_duration_ms",
+			Help:    "Histogram of request end-to-end duration in milliseconds, from add to deletion",
+			Buckets: prometheus.ExponentialBuckets(100, 2, 13),
+		},
+		[]string{"model_name"},
+	)
+
+	// tenantRequestsGauge tracks the in-flight requests of each tenant
+	tenantRequestsGauge = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "tenant_inflight_requests",
+			Help: "The number of in-flight requests tracked for each tenant",
+		},
+		[]string{"tenant"},
+	)
+
+	// tenantClustersGauge tracks the clusters of each tenant
+	tenantClustersGauge = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "tenant_clusters",
+			Help: "The number of clusters tracked for each tenant",
+		},
+		[]string{"tenant"},
+	)
+
+	// TenantQuotaRejectedTotal counts requests rejected by a tenant quota
+	TenantQuotaRejectedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tenant_quota_rejected_total",
+			Help: "Total number of requests rejected by a tenant quota, partitioned by quota",
+		},
+		[]string{"tenant", "quota"},
+	)
+
+	// TrackedRequests tracks the number of in-flight requests held in memory
+	TrackedRequests = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "tracked_requests",
+			Help: "The number of in-flight requests tracked in memory",
+		},
+	)
+
+	// TrackedClusters tracks the number of clusters held in memory
+	TrackedClusters = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "tracked_clusters",
+			Help: "The number of clusters tracked in memory",
+		},
+	)
+
+	// TrackedEngines tracks the number of engines held in memory across all clusters
+	TrackedEngines = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "tracked_engines",
+			Help: "The number of engines tracked in memory across all clusters",
+		},
+	)
+
+	// requestExpiryLag tracks how late requests are removed after their deadline
+	requestExpiryLag = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "request_expiry_lag_ms",
+			Help:    "The delay between the deadline of an expired request and its removal in milliseconds",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 16),
+		},
+	)
+
+	// RequestExpiryIndexSize tracks the entries of the expiry index, including removed requests not yet dropped
+	RequestExpiryIndexSize = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "request_expiry_index_size",
+			Help: "The number of entries in the request expiry index, including removed requests not yet dropped",
+		},
+	)
+
+	// TombstoneCount tracks the deletes waiting for their request to be added
+	TombstoneCount = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "tombstones",
+			Help: "The number of deletes waiting for their request to be added, partitioned by kind",
+		},
+		[]string{"kind"},
+	)
+
+	// TombstoneHitTotal counts adds that matched an earlier delete
+	TombstoneHitTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tombstone_hits_total",
+			Help: "Total number of adds that matched an earlier delete, partitioned by kind",
+		},
+		[]string{"kind"},
+	)
+
+	// TombstoneExpiredTotal counts deletes that expired without a matching add
+	TombstoneExpiredTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tombstone_expired_total",
+			Help: "Total number of deletes that expired without a matching add, partitioned by kind",
+		},
+		[]string{"kind"},
+	)
+
+	// TombstoneEvictedTotal counts deletes evicted unmatched to stay within the tombstone cap
+	TombstoneEvictedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tombstone_evicted_total",
+			Help: "Total number of deletes evicted without a matching add at the tombstone cap, partitioned by kind",
+		},
+		[]string{"kind"},
+	)
+
+	// CapacityEvictedTotal counts entries evicted to stay within a capacity cap
+	CapacityEvictedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "capacity_evicted_total",
+			Help: "Total number of entries evicted to stay within a capacity cap, partitioned by kind",
+		},
+		[]string{"kind"},
+	)
+
+	// CapacityRejectedTotal counts API calls rejected by a capacity cap
+	CapacityRejectedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "capacity_rejected_total",
+			Help: "Total number of API calls rejected by a capacity cap, partitioned by kind",
+		},
+		[]string{"kind"},
+	)
+
+	// BackendErrorTotal counts failed operations of a shared load backend
+	BackendErrorTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "load_backend_errors_total",
+			Help: "Total number of failed operations of the shared load backend, partitioned by operation",
+		},
+		[]string{"op"},
+	)
+
+	// WALSegments tracks the segments of the load write-ahead log
+	WALSegments = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "wal_segments",
+			Help: "The number of segments of the load write-ahead log",
+		},
+	)
+
+	// WALErrorTotal counts failed writes and syncs of the load write-ahead log
+	WALErrorTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "wal_errors_total",
+			Help: "Total number of failed writes and syncs of the load write-ahead log",
+		},
+	)
+
+	// ReservationTotal counts engine slot reservations by outcome
+	ReservationTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "load_reservations_total",
+			Help: "Total number of engine slot reservations, partitioned by result",
+		},
+		[]string{"result"},
+	)
+
+	// RaftLeader tracks whether this instance leads the consensus cluster
+	RaftLeader = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "raft_leader",
+			Help: "Whether this instance is the leader of the consensus cluster, 1 for leader and 0 otherwise",
+		},
+	)
+
+	// CounterMergeTotal counts merged counter partitions by whether they changed the engine counters
+	CounterMergeTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "load_counter_merges_total",
+			Help: "Total number of counter partitions merged from other instances, partitioned by result",
+		},
+		[]string{"result"},
+	)
+
+	// OriginFailoverTotal counts the requests removed because their origin left service discovery
+	OriginFailoverTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "origin_failover_requests_total",
+			Help: "Total number of requests removed after their origin instance left service discovery",
+		},
+	)
+
+	// GoneOrigins tracks the origins of tracked requests missing from service discovery
+	GoneOrigins = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "gone_origins",
+			Help: "Number of origin instances missing from service discovery whose requests are within the grace period",
+		},
+	)
+
+	// FederationStaleness tracks the age of the last successful poll of each federated region
+	FederationStaleness = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "federation_staleness_seconds",
+			Help: "Seconds since the load statistics of a federated region were last polled successfully",
+		},
+		[]string{"region"},
+	)
+
+	// FederationPollErrors counts failed polls of federated regions
+	FederationPollErrors = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "federation_poll_errors_total",
+			Help: "Total number of failed polls of federated regions",
+		},
+		[]string{"region"},
+	)
+
 	// AppVersionInfo provides application version information
 	AppVersionInfo = promauto.NewGaugeVec(
 		prometheus.GaugeOpts{
@@ -118,10 +471,68 @@ func SetLoadMetric(name, ip string, queuedNum, Length int32) {
 	promptLengthGauge.WithLabelValues(name, ip).Set(float64(Length))
 }
 
+// SetEngineModelLoadMetric sets load metrics for a model served by an engine
+func SetEngineModelLoadMetric(name, ip, model string, queuedNum, length int32) {
+	engineModelQueuedNumGauge.WithLabelValues(name, ip, model).Set(float64(queuedNum))
+	engineModelPromptLengthGauge.WithLabelValues(name, ip, model).Set(float64(length))
+}
+
+// SetEnginePriorityLoadMetric sets load metrics for a priority class of an engine
+func SetEnginePriorityLoadMetric(name, ip, priority string, queuedNum, length int32) {
+	enginePriorityQueuedNumGauge.WithLabelValues(name, ip, priority).Set(float64(queuedNum))
+	enginePriorityPromptLengthGauge.WithLabelValues(name, ip, priority).Set(float64(length))
+}
+
+// SetTenantUsageMetric sets the in-flight request and cluster counts of a tenant
+func SetTenantUsageMetric(tenant string, requests, clusters int64) {
+	tenantRequestsGauge.WithLabelValues(tenant).Set(float64(requests))
+	tenantClustersGauge.WithLabelValues(tenant).Set(float64(clusters))
+}
+
+// SetReconcileMetric sets engine-reported metrics and the drift against the estimated queued count
+func SetReconcileMetric(name, ip string, reportedQueuedNum, drift int32, kvCacheUsage float64) {
+	engineReportedQueuedNumGauge.WithLabelValues(name, ip).Set(float64(reportedQueuedNum))
+	engineQueuedNumDriftGauge.WithLabelValues(name, ip).Set(float64(drift))
+	engineKVCacheUsageGauge.WithLabelValues(name, ip).Set(kvCacheUsage)
+}
+
+// SetInvariantMismatchMetric sets the difference between live counters and the recomputed values
+func SetInvariantMismatchMetric(name, ip string, queuedNumMismatch, promptLengthMismatch int32) {
+	engineQueuedNumMismatchGauge.WithLabelValues(name, ip).Set(float64(queuedNumMismatch))
+	enginePromptLengthMismatchGauge.WithLabelValues(name, ip).Set(float64(promptLengthMismatch))
+}
+
 // DeleteEngineMetric removes metrics for a specific engine
 func DeleteEngineMetric(name, ip string) {
 	queuedNumGauge.DeleteLabelValues(name, ip)
 	promptLengthGauge.DeleteLabelValues(name, ip)
+	engineReportedQueuedNumGauge.DeleteLabelValues(name, ip)
+	engineQueuedNumDriftGauge.DeleteLabelValues(name, ip)
+	engineKVCacheUsageGauge.DeleteLabelValues(name, ip)
+	engineQueuedNumMismatchGauge.DeleteLabelValues(name, ip)
+	enginePromptLengthMismatchGauge.DeleteLabelValues(name, ip)
+	DeleteEngineModelMetric(name, ip)
+	DeleteEnginePriorityMetric(name, ip)
+}
+
+// DeleteEngineModelMetric removes the per-model metrics of a specific engine
+func DeleteEngineModelMetric(name, ip string) {
+	label := prometheus.Labels{
+		"model_name": name,
+		"engine_ip":  ip,
+	}
+	engineModelQueuedNumGauge.DeletePartialMatch(label)
+	engineModelPromptLengthGauge.DeletePartialMatch(label)
+}
+
+// DeleteEnginePriorityMetric removes the per-priority metrics of a specific engine
+func DeleteEnginePriorityMetric(name, ip string) {
+	label := prometheus.Labels{
+		"model_name": name,
+		"engine_ip":  ip,
+	}
+	enginePriorityQueuedNumGauge.DeletePartialMatch(label)
+	enginePriorityPromptLengthGauge.DeletePartialMatch(label)
 }
 
 // DeleteModelMetric removes all metrics for a specific model
@@ -131,6 +542,36 @@ func DeleteModelMetric(name string) {
 	}
 	queuedNumGauge.DeletePartialMatch(label)
 	promptLengthGauge.DeletePartialMatch(label)
+	engineReportedQueuedNumGauge.DeletePartialMatch(label)
+	engineQueuedNumDriftGauge.DeletePartialMatch(label)
+	engineKVCacheUsageGauge.DeletePartialMatch(label)
+	engineModelQueuedNumGauge.DeletePartialMatch(label)
+	engineModelPromptLengthGauge.DeletePartialMatch(label)
+	enginePriorityQueuedNumGauge.DeletePartialMatch(label)
+	enginePriorityPromptLengthGauge.DeletePartialMatch(label)
+	engineQueuedNumMismatchGauge.DeletePartialMatch(label)
+	enginePromptLengthMismatchGauge.DeletePartialMatch(label)
+	InvariantViolationTotal.DeletePartialMatch(label)
+	NegativeCounterTotal.DeletePartialMatch(label)
+	ReconcileCorrectionTotal.DeleteLabelValues(name)
+	InvariantFixTotal.DeleteLabelValues(name)
+	requestTTFT.DeleteLabelValues(name)
+	requestDuration.DeleteLabelValues(name)
+}
+
+// ObserveRequestTTFTMillisecond records the time to first token of a request
+func ObserveRequestTTFTMillisecond(name string, ms float64) {
+	requestTTFT.WithLabelValues(name).Observe(ms)
+}
+
+// ObserveRequestDurationMillisecond records the end-to-end duration of a request
+func ObserveRequestDurationMillisecond(name string, ms float64) {
+	requestDuration.WithLabelValues(name).Observe(ms)
+}
+
+// ObserveRequestExpiryLagMillisecond records how late an expired request was removed
+func ObserveRequestExpiryLagMillisecond(ms float64) {
+	requestExpiryLag.Observe(ms)
 }
 
 // SetReplicationLatencyMillisecond records replication latency metrics
@@ -268,3 +268,20 @@ func TestSetReplicationLatencyMetric(t *testing.T) {
 		assert.True(t, true)
 	})
 }
+
+func TestDeleteEngineModelMetric(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(engineModelQueuedNumGauge, engineModelPromptLengthGauge)
+
+	SetEngineModelLoadMetric("cluster1", "1.1.1.1", "qwen", 1, 100)
+	SetEngineModelLoadMetric("cluster1", "1.1.1.1", "llama", 2, 200)
+	SetEngineModelLoadMetric("cluster1", "2.2.2.2", "qwen", 3, 300)
+	SetEngineModelLoadMetric("cluster2", "3.3.3.3", "qwen", 4, 400)
+	require.Equal(t, 8, getMetricCount(registry))
+
+	DeleteEngineMetric("cluster1", "1.1.1.1")
+	assert.Equal(t, 4, getMetricCount(registry))
+
+	DeleteModelMetric("cluster1")
+	assert.Equal(t, 2, getMetricCount(registry))
+}
@@ -0,0 +1,184 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replicator
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/aigw-project/metadata-center/pkg/utils/logger"
+)
+
+// BatchEventType is the event type of an envelope carrying several events
+const BatchEventType = "replica.batch"
+
+// batchEvent is an event of a batched envelope, its payload is encoded like the envelope
+// Within a msgpack envelope the payload is a byte string, decoded into the type of its handler after the envelope
+type batchEvent struct {
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// batchEnvelope carries the events replicated to a host within a batch interval
+type batchEnvelope struct {
+	Events []batchEvent `json:"events"`
+}
+
+// queuedEvent is an event waiting for its batch, encoded once the batch is sent
+// Replicated payloads are values built for the replication, they are not changed once replicated
+type queuedEvent struct {
+	eventType string
+	payload   any
+}
+
+// batcher collects the events replicated to a host until the batch is full or the batch interval elapses
+// Taken batches are queued and sent one at a time in order, so a full batch and a timer flush never race
+type batcher struct {
+	r       *Replicator
+	host    string
+	mu      sync.Mutex
+	events  []queuedEvent
+	traceID string
+	timer   *time.Timer
+	// pending holds the taken batches not sent yet, sending reports whether a goroutine is sending them
+	pending []pendingBatch
+	sending bool
+}
+
+// pendingBatch is a taken batch waiting for the batches of the host taken before it
+type pendingBatch struct {
+	traceID string
+	events  []queuedEvent
+}
+
+// add appends an event to the batch, and queues the batch once it is full
+func (b *batcher) add(traceID, eventType string, payload any) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.events) == 0 {
+		b.traceID = traceID
+		b.timer = time.AfterFunc(b.r.batchInterval, b.flush)
+	}
+	b.events = append(b.events, queuedEvent{eventType: eventType, payload: payload})
+	if len(b.events) < b.r.batchSize {
+		return
+	}
+	b.timer.Stop()
+	b.enqueueLocked()
+}
+
+// flush queues the pending events when the batch interval elapses
+func (b *batcher) flush() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.events) > 0 {
+		b.enqueueLocked()
+	}
+}
+
+// enqueueLocked empties the batch into the send queue and starts sending unless already, must be called with mu held
+func (b *batcher) enqueueLocked() {
+	b.pending = append(b.pending, pendingBatch{traceID: b.traceID, events: b.events})
+	b.events = nil
+	if !b.sending {
+		b.sending = true
+		go b.drain()
+	}
+}
+
+// drain sends the queued batches one at a time until the queue is empty
+func (b *batcher) drain() {
+	for {
+		b.mu.Lock()
+		if len(b.pending) == 0 {
+			b.sending = false
+			b.mu.Unlock()
+			return
+		}
+		next := b.pending[0]
+		b.pending[0] = pendingBatch{}
+		b.pending = b.pending[1:]
+		b.mu.Unlock()
+		b.r.sendBatch(b.host, next.traceID, next.events)
+	}
+}
+
+// batcherOf returns the batcher of a host
+func (r *Replicator) batcherOf(host string) *batcher {
+	if b, ok := r.batchers.Load(host); ok {
+		return b.(*batcher)
+	}
+	b, _ := r.batchers.LoadOrStore(host, &batcher{r: r, host: host})
+	return b.(*batcher)
+}
+
+// sendBatch encodes the events into an envelope, compresses it if configured, and sends it to the host
+// A host rejecting the envelope is sent the events as JSON one by one, see sendRequestWithRetry
+// The events outlive the requests that replicated them, so the envelope is sent without their contexts
+func (r *Replicator) sendBatch(host, traceID string, events []queuedEvent) {
+	ctx := context.Background()
+	if !r.isLegacy(host) {
+		body, contentEncoding, err := r.encodeBatch(events)
+		if err != nil {
+			logger.Errorf("Replicator: encode batch of %d events error: %v", len(events), err)
+			return
+		}
+		if !r.sendRequestWithRetry(ctx, host, traceID, BatchEventType, r.contentType, body, contentEncoding) {
+			return
+		}
+		r.fallBack(host)
+	}
+	for _, event := range events {
+		body, err := encodePayload(event.payload, ContentTypeJSON)
+		if err != nil {
+			logger.Errorf("Replicator: marshal payload of %s error: %v", event.eventType, err)
+			continue
+		}
+		r.sendRequestWithRetry(ctx, host, traceID, event.eventType, ContentTypeJSON, body, "")
+	}
+}
+
+// encodeBatch encodes the events and their envelope in the configured encoding, and compresses the envelope if configured
+// Events failing to encode are logged and left out
+func (r *Replicator) encodeBatch(events []queuedEvent) ([]byte, string, error) {
+	envelope := batchEnvelope{Events: make([]batchEvent, 0, len(events))}
+	for _, event := range events {
+		payload, err := encodePayload(event.payload, r.contentType)
+		if err != nil {
+			logger.Errorf("Replicator: encode payload of %s error: %v", event.eventType, err)
+			continue
+		}
+		envelope.Events = append(envelope.Events, batchEvent{Type: event.eventType, Payload: payload})
+	}
+	body, err := encodePayload(envelope, r.contentType)
+	if err != nil || r.compression != CompressionGzip {
+		return body, "", err
+	}
+	if body, err = compress(body); err != nil {
+		return nil, "", err
+	}
+	return body, CompressionGzip, nil
+}
+
+// decodeBatch decodes a batched envelope of a media type returned by mediaTypeOf
+func decodeBatch(body []byte, mediaType string) (*batchEnvelope, error) {
+	var envelope batchEnvelope
+	if err := payloadOf(body, mediaType).Decode(&envelope); err != nil {
+		return nil, err
+	}
+	return &envelope, nil
+}
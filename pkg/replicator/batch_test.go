@@ -0,0 +1,289 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replicator
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aigw-project/metadata-center/pkg/ginx"
+	"github.com/aigw-project/metadata-center/pkg/utils/errors"
+)
+
+type staticDiscovery []string
+
+func (d staticDiscovery) GetHosts() []string {
+	return d
+}
+
+func (d staticDiscovery) Err() error {
+	return nil
+}
+
+type batchTestEvent struct {
+	Id        string `json:"id"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// newReceiver serves the replication API with a handler and records the encodings of the received requests
+func newReceiver(t *testing.T, encodings chan<- string, handle gin.HandlerFunc) (host string, port int) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.POST(ReplicaEventPath, func(c *gin.Context) {
+		encodings <- c.GetHeader("Content-Type") + "," + c.GetHeader("Content-Encoding") + "," + c.GetHeader(EventTypeHeader)
+		handle(c)
+	})
+	srv := httptest.NewServer(engine)
+	t.Cleanup(srv.Close)
+	h, p, err := net.SplitHostPort(srv.Listener.Addr().String())
+	require.NoError(t, err)
+	port, err = strconv.Atoi(p)
+	require.NoError(t, err)
+	return h, port
+}
+
+// registerBatchTest registers the handler of the test events, and returns the ids of the received events
+func registerBatchTest(t *testing.T) (receivedIds func() []string, reset func()) {
+	handlers = make(map[string]EventHandler)
+	var mu sync.Mutex
+	var received []string
+	Register("batch.test", func(payload Payload) error {
+		var v batchTestEvent
+		if err := payload.Decode(&v); err != nil {
+			return err
+		}
+		assert.Equal(t, int64(1760745600123456789), v.Timestamp)
+		mu.Lock()
+		received = append(received, v.Id)
+		mu.Unlock()
+		return nil
+	})
+	receivedIds = func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), received...)
+	}
+	reset = func() {
+		mu.Lock()
+		received = nil
+		mu.Unlock()
+	}
+	return receivedIds, reset
+}
+
+func TestReplicator_Encodings(t *testing.T) {
+	receivedIds, reset := registerBatchTest(t)
+
+	testCases := []struct {
+		name      string
+		r         *Replicator
+		events    int
+		encodings []string
+	}{
+		{
+			name:      "json events are sent one by one",
+			r:         &Replicator{contentType: ContentTypeJSON, batchSize: 1},
+			events:    2,
+			encodings: []string{"application/json,,batch.test", "application/json,,batch.test"},
+		},
+		{
+			name:      "msgpack events are sent one by one",
+			r:         &Replicator{contentType: ContentTypeMsgpack, batchSize: 1},
+			events:    1,
+			encodings: []string{"application/msgpack,,batch.test"},
+		},
+		{
+			name:      "full batches are sent at once, the rest after the interval",
+			r:         &Replicator{contentType: ContentTypeMsgpack, batchSize: 2, batchInterval: 20 * time.Millisecond, compression: CompressionGzip},
+			events:    3,
+			encodings: []string{"application/msgpack,gzip,replica.batch", "application/msgpack,gzip,replica.batch"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			reset()
+			encodings := make(chan string, 8)
+			host, port := newReceiver(t, encodings, HandleReplicateEvent)
+			tc.r.client = http.DefaultClient
+			tc.r.serviceDiscovery = staticDiscovery{host}
+			tc.r.port = port
+
+			var want []string
+			for i := 0; i < tc.events; i++ {
+				id := strconv.Itoa(i)
+				want = append(want, id)
+				tc.r.replicate(context.Background(), "batch.test", batchTestEvent{Id: id, Timestamp: 1760745600123456789})
+			}
+			assert.Eventually(t, func() bool { return len(receivedIds()) == tc.events }, time.Second, 5*time.Millisecond)
+			assert.ElementsMatch(t, want, receivedIds())
+			var got []string
+			for range tc.encodings {
+				got = append(got, <-encodings)
+			}
+			assert.Equal(t, tc.encodings, got)
+		})
+	}
+}
+
+// legacyReceiver serves the replication API as receivers predating msgpack and batches did
+func legacyReceiver(c *gin.Context) {
+	handler, ok := handlers[c.GetHeader(EventTypeHeader)]
+	if !ok {
+		ginx.ResError(c, errors.InvalidInput("Unsupported event type"))
+		return
+	}
+	body, _ := io.ReadAll(c.Request.Body)
+	if err := handler(JSONPayload(body)); err != nil {
+		ginx.ResError(c, errors.InvalidInput("handler execute error"))
+		return
+	}
+	ginx.ResSuccess(c, nil)
+}
+
+// jsonReceiver serves the replication API for JSON only, as a receiver not supporting msgpack would
+func jsonReceiver(c *gin.Context) {
+	if c.GetHeader("Content-Type") == ContentTypeMsgpack {
+		c.Header(AcceptPostHeader, ContentTypeJSON)
+		ginx.ResError(c, errors.UnsupportedMediaType("unsupported media type"))
+		return
+	}
+	HandleReplicateEvent(c)
+}
+
+func TestReplicator_FallBack(t *testing.T) {
+	receivedIds, reset := registerBatchTest(t)
+
+	testCases := []struct {
+		name    string
+		r       *Replicator
+		receive gin.HandlerFunc
+		// first events are sent before the fallback, the next one after it
+		first     int
+		encodings []string
+	}{
+		{
+			name:    "msgpack falls back on receivers predating it",
+			r:       &Replicator{contentType: ContentTypeMsgpack, batchSize: 1},
+			receive: legacyReceiver,
+			first:   1,
+			encodings: []string{"application/msgpack,,batch.test", "application/json,,batch.test",
+				"application/json,,batch.test"},
+		},
+		{
+			name:    "msgpack falls back on 415",
+			r:       &Replicator{contentType: ContentTypeMsgpack, batchSize: 1},
+			receive: jsonReceiver,
+			first:   1,
+			encodings: []string{"application/msgpack,,batch.test", "application/json,,batch.test",
+				"application/json,,batch.test"},
+		},
+		{
+			name:    "batches fall back on receivers predating them",
+			r:       &Replicator{contentType: ContentTypeJSON, batchSize: 2, batchInterval: time.Second, compression: CompressionGzip},
+			receive: legacyReceiver,
+			first:   2,
+			encodings: []string{"application/json,gzip,replica.batch", "application/json,,batch.test",
+				"application/json,,batch.test", "application/json,,batch.test"},
+		},
+		{
+			name:    "msgpack batches fall back on 415",
+			r:       &Replicator{contentType: ContentTypeMsgpack, batchSize: 2, batchInterval: time.Second},
+			receive: jsonReceiver,
+			first:   2,
+			encodings: []string{"application/msgpack,,replica.batch", "application/json,,batch.test",
+				"application/json,,batch.test", "application/json,,batch.test"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			reset()
+			encodings := make(chan string, 8)
+			host, port := newReceiver(t, encodings, tc.receive)
+			tc.r.client = http.DefaultClient
+			tc.r.serviceDiscovery = staticDiscovery{host}
+			tc.r.port = port
+
+			replicate := func(id int) {
+				tc.r.replicate(context.Background(), "batch.test", batchTestEvent{Id: strconv.Itoa(id), Timestamp: 1760745600123456789})
+			}
+			for i := 0; i < tc.first; i++ {
+				replicate(i)
+			}
+			require.Eventually(t, func() bool { return len(receivedIds()) == tc.first }, time.Second, 5*time.Millisecond)
+			assert.True(t, tc.r.isLegacy(host))
+			replicate(tc.first)
+			require.Eventually(t, func() bool { return len(receivedIds()) == tc.first+1 }, time.Second, 5*time.Millisecond)
+
+			var got []string
+			for range tc.encodings {
+				got = append(got, <-encodings)
+			}
+			assert.Equal(t, tc.encodings, got)
+
+			tc.r.legacy.Store(host, time.Now().Add(-legacyRetryInterval))
+			assert.False(t, tc.r.isLegacy(host), "the configured encoding is tried again after the retry interval")
+		})
+	}
+}
+
+func TestReplicator_BatchOrder(t *testing.T) {
+	receivedIds, reset := registerBatchTest(t)
+	reset()
+
+	var inFlight, maxInFlight atomic.Int32
+	encodings := make(chan string, 64)
+	host, port := newReceiver(t, encodings, func(c *gin.Context) {
+		n := inFlight.Add(1)
+		for {
+			m := maxInFlight.Load()
+			if n <= m || maxInFlight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		HandleReplicateEvent(c)
+		inFlight.Add(-1)
+	})
+	r := &Replicator{contentType: ContentTypeMsgpack, batchSize: 2, batchInterval: time.Millisecond,
+		client: http.DefaultClient, serviceDiscovery: staticDiscovery{host}, port: port}
+
+	var want []string
+	for i := 0; i < 21; i++ {
+		id := strconv.Itoa(i)
+		want = append(want, id)
+		r.replicate(context.Background(), "batch.test", batchTestEvent{Id: id, Timestamp: 1760745600123456789})
+		if i%4 == 0 {
+			// Let the timer flush some batches while full ones are queued
+			time.Sleep(2 * time.Millisecond)
+		}
+	}
+	require.Eventually(t, func() bool { return len(receivedIds()) == len(want) }, 2*time.Second, 5*time.Millisecond)
+	assert.Equal(t, want, receivedIds(), "the batches of a host arrive in order")
+	assert.Equal(t, int32(1), maxInFlight.Load(), "the batches of a host are sent one at a time")
+}
@@ -0,0 +1,166 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replicator
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+
+	"github.com/ugorji/go/codec"
+)
+
+const (
+	ContentTypeJSON    = "application/json"
+	ContentTypeMsgpack = "application/msgpack"
+	EncodingJSON       = "json"
+	EncodingMsgpack    = "msgpack"
+	CompressionNone    = "none"
+	CompressionGzip    = "gzip"
+	// AcceptPostHeader lists the Content-Types a receiver accepts, receivers predating msgpack do not send it
+	AcceptPostHeader = "Accept-Post"
+)
+
+var (
+	errUnsupportedMediaType = errors.New("unsupported media type")
+	errBodyTooLarge         = errors.New("body too large")
+)
+
+// maxBodySize bounds a received body, both as sent and once decompressed
+var maxBodySize int64 = 32 << 20
+
+// msgpackHandle encodes structs as arrays of their fields in declaration order, so that field names are not sent
+// Fields of replicated payloads are therefore only appended, decoding ignores the trailing fields it does not know
+var msgpackHandle = func() *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{WriteExt: true}
+	h.StructToArray = true
+	return h
+}()
+
+// Payload is the body of a replication event, decoded into the type its handler expects
+type Payload interface {
+	Decode(v any) error
+}
+
+// JSONPayload is a payload encoded as JSON
+type JSONPayload json.RawMessage
+
+// Decode unmarshals the JSON payload into v
+func (p JSONPayload) Decode(v any) error {
+	return json.Unmarshal(p, v)
+}
+
+// msgpackPayload is a payload encoded as msgpack
+type msgpackPayload []byte
+
+// Decode unmarshals the msgpack payload into v
+func (p msgpackPayload) Decode(v any) error {
+	return codec.NewDecoderBytes(p, msgpackHandle).Decode(v)
+}
+
+// contentTypeOf returns the Content-Type of an encoding, empty for an unknown one
+func contentTypeOf(encoding string) string {
+	switch encoding {
+	case EncodingJSON:
+		return ContentTypeJSON
+	case EncodingMsgpack:
+		return ContentTypeMsgpack
+	}
+	return ""
+}
+
+// mediaTypeOf returns the encoding of a received Content-Type, ContentTypeJSON or ContentTypeMsgpack
+// An empty Content-Type is taken as JSON, as sent by instances predating msgpack
+func mediaTypeOf(contentType string) (string, error) {
+	if contentType == "" {
+		return ContentTypeJSON, nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errUnsupportedMediaType, err)
+	}
+	switch mediaType {
+	case ContentTypeJSON:
+		return ContentTypeJSON, nil
+	case ContentTypeMsgpack, "application/x-msgpack":
+		return ContentTypeMsgpack, nil
+	}
+	return "", fmt.Errorf("%w: %s", errUnsupportedMediaType, contentType)
+}
+
+// encodePayload marshals a payload in the encoding of the Content-Type
+func encodePayload(payload any, contentType string) ([]byte, error) {
+	if contentType != ContentTypeMsgpack {
+		return json.Marshal(payload)
+	}
+	var out []byte
+	if err := codec.NewEncoderBytes(&out, msgpackHandle).Encode(payload); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// payloadOf wraps a body of a media type returned by mediaTypeOf, it is decoded by the handler
+func payloadOf(body []byte, mediaType string) Payload {
+	if mediaType == ContentTypeMsgpack {
+		return msgpackPayload(body)
+	}
+	return JSONPayload(body)
+}
+
+// compress gzips a batched envelope
+func compress(body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(body); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompress reverses the Content-Encoding of a request body, rejecting bodies that inflate beyond maxBodySize
+func decompress(body []byte, contentEncoding string) ([]byte, error) {
+	switch contentEncoding {
+	case "", "identity":
+		return body, nil
+	case CompressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return readLimited(r)
+	}
+	return nil, fmt.Errorf("%w: content encoding %s", errUnsupportedMediaType, contentEncoding)
+}
+
+// readLimited reads a body of at most maxBodySize bytes
+func readLimited(r io.Reader) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r, maxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > maxBodySize {
+		return nil, errBodyTooLarge
+	}
+	return body, nil
+}
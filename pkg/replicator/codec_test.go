@@ -0,0 +1,117 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replicator
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type codecPayload struct {
+	RequestId    string            `json:"request_id"`
+	PromptLength int32             `json:"prompt_length,omitempty"`
+	TimeStamp    int64             `json:"timestamp"`
+	Ratio        float64           `json:"ratio"`
+	Tags         []string          `json:"tags"`
+	Limit        *int64            `json:"limit,omitempty"`
+	Nested       map[string]string `json:"nested"`
+	Local        string            `json:"-"`
+}
+
+// codecPayloadV0 is codecPayload before the fields following TimeStamp were added
+type codecPayloadV0 struct {
+	RequestId    string `json:"request_id"`
+	PromptLength int32  `json:"prompt_length,omitempty"`
+	TimeStamp    int64  `json:"timestamp"`
+}
+
+func TestCodec_RoundTrip(t *testing.T) {
+	limit := int64(5)
+	payload := codecPayload{RequestId: "r-1", PromptLength: 1024, TimeStamp: 1760745600123456789, Ratio: 0.5,
+		Tags: []string{"a", "b"}, Limit: &limit, Nested: map[string]string{"ok": "yes"}, Local: "not sent"}
+	want := payload
+	want.Local = ""
+
+	bodies := make(map[string][]byte)
+	for _, contentType := range []string{ContentTypeJSON, ContentTypeMsgpack} {
+		body, err := encodePayload(payload, contentType)
+		require.NoError(t, err, contentType)
+		bodies[contentType] = body
+		mediaType, err := mediaTypeOf(contentType + "; charset=utf-8")
+		require.NoError(t, err, contentType)
+		var decoded codecPayload
+		require.NoError(t, payloadOf(body, mediaType).Decode(&decoded), contentType)
+		assert.Equal(t, want, decoded, contentType)
+	}
+
+	msgpack := bodies[ContentTypeMsgpack]
+	assert.Less(t, len(msgpack), len(bodies[ContentTypeJSON]), "msgpack is more compact than JSON")
+	assert.False(t, bytes.Contains(msgpack, []byte("request_id")), "msgpack does not carry field names")
+
+	var old codecPayloadV0
+	require.NoError(t, payloadOf(msgpack, ContentTypeMsgpack).Decode(&old), "trailing fields are ignored")
+	assert.Equal(t, codecPayloadV0{RequestId: "r-1", PromptLength: 1024, TimeStamp: 1760745600123456789}, old)
+	oldBody, err := encodePayload(old, ContentTypeMsgpack)
+	require.NoError(t, err)
+	var decoded codecPayload
+	require.NoError(t, payloadOf(oldBody, ContentTypeMsgpack).Decode(&decoded), "missing fields are left zero")
+	assert.Equal(t, codecPayload{RequestId: "r-1", PromptLength: 1024, TimeStamp: 1760745600123456789}, decoded)
+
+	mediaType, err := mediaTypeOf("")
+	require.NoError(t, err)
+	assert.Equal(t, ContentTypeJSON, mediaType, "bodies without Content-Type are JSON")
+	mediaType, err = mediaTypeOf("application/x-msgpack")
+	require.NoError(t, err)
+	assert.Equal(t, ContentTypeMsgpack, mediaType)
+	_, err = mediaTypeOf("application/protobuf")
+	assert.ErrorIs(t, err, errUnsupportedMediaType)
+}
+
+func TestCodec_Compression(t *testing.T) {
+	body := []byte(`{"events":[]}`)
+	compressed, err := compress(body)
+	require.NoError(t, err)
+	decompressed, err := decompress(compressed, CompressionGzip)
+	require.NoError(t, err)
+	assert.Equal(t, body, decompressed)
+
+	decompressed, err = decompress(body, "")
+	require.NoError(t, err)
+	assert.Equal(t, body, decompressed)
+	_, err = decompress(body, "br")
+	assert.ErrorIs(t, err, errUnsupportedMediaType)
+}
+
+func TestCodec_MaxBodySize(t *testing.T) {
+	defer func(size int64) { maxBodySize = size }(maxBodySize)
+	maxBodySize = 1024
+
+	body, err := readLimited(strings.NewReader(strings.Repeat("a", 1024)))
+	require.NoError(t, err)
+	assert.Len(t, body, 1024)
+	_, err = readLimited(strings.NewReader(strings.Repeat("a", 1025)))
+	assert.ErrorIs(t, err, errBodyTooLarge)
+
+	// A small body may inflate beyond the limit
+	compressed, err := compress(bytes.Repeat([]byte{0}, 64<<10))
+	require.NoError(t, err)
+	require.Less(t, len(compressed), 1024)
+	_, err = decompress(compressed, CompressionGzip)
+	assert.ErrorIs(t, err, errBodyTooLarge)
+}
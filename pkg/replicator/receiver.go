@@ -15,8 +15,8 @@
 package replicator
 
 import (
-	"encoding/json"
-	"io"
+	"crypto/subtle"
+	goerrors "errors"
 
 	"github.com/gin-gonic/gin"
 
@@ -25,15 +25,31 @@ import (
 	"github.com/aigw-project/metadata-center/pkg/utils/logger"
 )
 
+// unsupportedEventType is the reason of the response to an unknown event type, senders fall back from batches on it
+const unsupportedEventType = "Unsupported event type"
+
+// acceptedContentTypes is the Accept-Post header of every response
+const acceptedContentTypes = ContentTypeJSON + ", " + ContentTypeMsgpack
+
 // EventHandler defines the function signature for handling replication events
-type EventHandler func(payload json.RawMessage) error
+type EventHandler func(payload Payload) error
 
 // handlers stores registered event handlers by event type
 var handlers = make(map[string]EventHandler)
 
 // HandleReplicateEvent processes incoming replication events
 // Validates event type, finds appropriate handler, and executes it
+// The body is decoded by its Content-Type, JSON or msgpack, so instances sending either are served during rolling upgrades
+// Other Content-Types and Content-Encodings are answered with 415, on which senders fall back to JSON
 func HandleReplicateEvent(c *gin.Context) {
+	c.Header(AcceptPostHeader, acceptedContentTypes)
+
+	if !authorized(c.GetHeader(TokenHeader)) {
+		logger.Errorf("ReplicateAPI: rejected event from %s without a valid %s header", c.ClientIP(), TokenHeader)
+		ginx.ResError(c, errors.Unauthorized("a valid %s header is required", TokenHeader))
+		return
+	}
+
 	eventType := c.GetHeader(EventTypeHeader)
 	if eventType == "" {
 		logger.Errorf("ReplicateAPI: missing Event-Type header")
@@ -44,20 +60,40 @@ func HandleReplicateEvent(c *gin.Context) {
 	c.Set(EventTypeCtxKey, eventType)
 
 	handler, ok := handlers[eventType]
-	if !ok {
+	if !ok && eventType != BatchEventType {
 		logger.Errorf("ReplicateAPI: No handler found for event type: %s", eventType)
-		ginx.ResError(c, errors.InvalidInput("Unsupported event type"))
+		ginx.ResError(c, errors.InvalidInput(unsupportedEventType))
 		return
 	}
 
-	body, err := io.ReadAll(c.Request.Body)
+	mediaType, err := mediaTypeOf(c.GetHeader("Content-Type"))
+	if err != nil {
+		logger.Errorf("ReplicateAPI: %v", err)
+		ginx.ResError(c, errors.UnsupportedMediaType("%v", err))
+		return
+	}
+
+	body, err := readLimited(c.Request.Body)
+	if err == nil {
+		body, err = decompress(body, c.GetHeader("Content-Encoding"))
+	}
 	if err != nil {
 		logger.Errorf("ReplicateAPI: read body error: %v", err)
-		ginx.ResError(c, errors.InvalidInput("invalid body"))
+		ginx.ResError(c, bodyError(err))
+		return
+	}
+
+	if eventType == BatchEventType {
+		if err := handleBatch(body, mediaType); err != nil {
+			logger.Errorf("ReplicateAPI: batch error: %v", err)
+			ginx.ResError(c, errors.InvalidInput("invalid batch"))
+			return
+		}
+		ginx.ResSuccess(c, nil)
 		return
 	}
 
-	if err := handler(body); err != nil {
+	if err := handler(payloadOf(body, mediaType)); err != nil {
 		logger.Errorf("ReplicateAPI: handler error: %v", err)
 		ginx.ResError(c, errors.InvalidInput("handler execute error"))
 		return
@@ -66,6 +102,42 @@ func HandleReplicateEvent(c *gin.Context) {
 	ginx.ResSuccess(c, nil)
 }
 
+// authorized reports whether the caller presented the token of the instances, any caller is authorized without one
+func authorized(header string) bool {
+	return token == "" || subtle.ConstantTimeCompare([]byte(header), []byte(token)) == 1
+}
+
+// bodyError returns the response to a body that could not be read
+func bodyError(err error) error {
+	switch {
+	case goerrors.Is(err, errBodyTooLarge):
+		return errors.TooLarge("body exceeds %d bytes", maxBodySize)
+	case goerrors.Is(err, errUnsupportedMediaType):
+		return errors.UnsupportedMediaType("%v", err)
+	}
+	return errors.InvalidInput("invalid body: %v", err)
+}
+
+// handleBatch executes the handler of every event of a batched envelope
+// Failed events are logged and skipped, the batch is not retried, which would apply the other events twice
+func handleBatch(body []byte, mediaType string) error {
+	envelope, err := decodeBatch(body, mediaType)
+	if err != nil {
+		return err
+	}
+	for _, event := range envelope.Events {
+		handler, ok := handlers[event.Type]
+		if !ok {
+			logger.Errorf("ReplicateAPI: No handler found for batched event type: %s", event.Type)
+			continue
+		}
+		if err := handler(payloadOf(event.Payload, mediaType)); err != nil {
+			logger.Errorf("ReplicateAPI: handler error of batched event %s: %v", event.Type, err)
+		}
+	}
+	return nil
+}
+
 // Register adds a new event handler for the specified event type
 // Validates input parameters and prevents duplicate registrations
 func Register(eventType string, handler EventHandler) {
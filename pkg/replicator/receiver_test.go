@@ -16,7 +16,6 @@ package replicator
 
 import (
 	"bytes"
-	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -25,6 +24,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestHandleReplicateEvent(t *testing.T) {
@@ -34,7 +34,7 @@ func TestHandleReplicateEvent(t *testing.T) {
 		handlers = make(map[string]EventHandler)
 	}
 
-	var receivedPayload json.RawMessage
+	var receivedPayload Payload
 
 	testCases := []struct {
 		name                 string
@@ -50,7 +50,7 @@ func TestHandleReplicateEvent(t *testing.T) {
 			eventTypeHeader: "test.event",
 			requestBody:     `{"key":"value"}`,
 			setupFunc: func() {
-				Register("test.event", func(payload json.RawMessage) error {
+				Register("test.event", func(payload Payload) error {
 					return nil
 				})
 			},
@@ -74,7 +74,7 @@ func TestHandleReplicateEvent(t *testing.T) {
 			eventTypeHeader: "error.event",
 			requestBody:     `{"error":"test"}`,
 			setupFunc: func() {
-				Register("error.event", func(payload json.RawMessage) error {
+				Register("error.event", func(payload Payload) error {
 					return io.ErrUnexpectedEOF
 				})
 			},
@@ -86,7 +86,7 @@ func TestHandleReplicateEvent(t *testing.T) {
 			eventTypeHeader: "empty.test",
 			requestBody:     "",
 			setupFunc: func() {
-				Register("empty.test", func(payload json.RawMessage) error {
+				Register("empty.test", func(payload Payload) error {
 					return nil
 				})
 			},
@@ -97,14 +97,14 @@ func TestHandleReplicateEvent(t *testing.T) {
 			eventTypeHeader: "json.test",
 			requestBody:     `{"test":"value","array":[1,2,3]}`,
 			setupFunc: func() {
-				Register("json.test", func(payload json.RawMessage) error {
+				Register("json.test", func(payload Payload) error {
 					receivedPayload = payload
 					return nil
 				})
 			},
 			expectedStatus: http.StatusOK,
 			verifyFunc: func(t *testing.T) {
-				assert.JSONEq(t, `{"test":"value","array":[1,2,3]}`, string(receivedPayload))
+				assert.JSONEq(t, `{"test":"value","array":[1,2,3]}`, string(receivedPayload.(JSONPayload)))
 			},
 		},
 	}
@@ -140,7 +140,7 @@ func TestHandleReplicateEvent(t *testing.T) {
 
 	t.Run("should return 400 on request body read error", func(t *testing.T) {
 		cleanHandlers()
-		Register("test.event", func(payload json.RawMessage) error { return nil })
+		Register("test.event", func(payload Payload) error { return nil })
 
 		w := httptest.NewRecorder()
 		c, _ := gin.CreateTestContext(w)
@@ -152,6 +152,123 @@ func TestHandleReplicateEvent(t *testing.T) {
 		assert.Equal(t, http.StatusBadRequest, w.Code)
 		assert.Contains(t, w.Body.String(), "invalid body")
 	})
+
+	t.Run("should return 415 for an unsupported content type", func(t *testing.T) {
+		cleanHandlers()
+		Register("test.event", func(payload Payload) error { return nil })
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/replicate", strings.NewReader(`{}`))
+		c.Request.Header.Set("Event-Type", "test.event")
+		c.Request.Header.Set("Content-Type", "application/protobuf")
+
+		HandleReplicateEvent(c)
+
+		assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+		assert.Contains(t, w.Body.String(), "unsupported media type")
+		assert.Equal(t, acceptedContentTypes, w.Header().Get(AcceptPostHeader))
+	})
+
+	t.Run("should return 415 for an unsupported content encoding", func(t *testing.T) {
+		cleanHandlers()
+		Register("test.event", func(payload Payload) error { return nil })
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/replicate", strings.NewReader(`{}`))
+		c.Request.Header.Set("Event-Type", "test.event")
+		c.Request.Header.Set("Content-Encoding", "br")
+
+		HandleReplicateEvent(c)
+
+		assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	})
+
+	t.Run("should return 413 for an oversized body", func(t *testing.T) {
+		cleanHandlers()
+		defer func(size int64) { maxBodySize = size }(maxBodySize)
+		maxBodySize = 1024
+		var called bool
+		Register("test.event", func(payload Payload) error {
+			called = true
+			return nil
+		})
+		compressed, err := compress(bytes.Repeat([]byte{' '}, 64<<10))
+		require.NoError(t, err)
+
+		for _, tc := range []struct {
+			body            []byte
+			contentEncoding string
+		}{
+			{bytes.Repeat([]byte{' '}, 1025), ""},
+			{compressed, CompressionGzip},
+		} {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/replicate", bytes.NewReader(tc.body))
+			c.Request.Header.Set("Event-Type", "test.event")
+			c.Request.Header.Set("Content-Encoding", tc.contentEncoding)
+
+			HandleReplicateEvent(c)
+
+			assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code, tc.contentEncoding)
+		}
+		assert.False(t, called)
+	})
+
+	t.Run("should decode msgpack payloads into the type of the handler", func(t *testing.T) {
+		cleanHandlers()
+		var got codecPayload
+		Register("test.event", func(payload Payload) error { return payload.Decode(&got) })
+		body, err := encodePayload(codecPayload{RequestId: "r-1", TimeStamp: 1760745600123456789}, ContentTypeMsgpack)
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/replicate", bytes.NewReader(body))
+		c.Request.Header.Set("Event-Type", "test.event")
+		c.Request.Header.Set("Content-Type", ContentTypeMsgpack)
+
+		HandleReplicateEvent(c)
+
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Equal(t, codecPayload{RequestId: "r-1", TimeStamp: 1760745600123456789}, got)
+	})
+
+	t.Run("should require the token of the instances once configured", func(t *testing.T) {
+		cleanHandlers()
+		called := 0
+		Register("test.event", func(payload Payload) error {
+			called++
+			return nil
+		})
+		token = "replica-secret"
+		defer func() { token = "" }()
+
+		for _, tc := range []struct {
+			header string
+			status int
+		}{
+			{"", http.StatusUnauthorized},
+			{"replica-secre", http.StatusUnauthorized},
+			{"replica-secret", http.StatusOK},
+		} {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/replicate", strings.NewReader(`{}`))
+			c.Request.Header.Set("Event-Type", "test.event")
+			if tc.header != "" {
+				c.Request.Header.Set(TokenHeader, tc.header)
+			}
+
+			HandleReplicateEvent(c)
+
+			assert.Equal(t, tc.status, w.Code, tc.header)
+			assert.Equal(t, acceptedContentTypes, w.Header().Get(AcceptPostHeader), "senders must not fall back to JSON")
+		}
+		assert.Equal(t, 1, called)
+	})
 }
 
 func TestRegister(t *testing.T) {
@@ -163,7 +280,7 @@ func TestRegister(t *testing.T) {
 		cleanHandlers()
 
 		count := len(handlers)
-		testHandler := func(payload json.RawMessage) error { return nil }
+		testHandler := func(payload Payload) error { return nil }
 
 		Register("test.event", testHandler)
 
@@ -175,7 +292,7 @@ func TestRegister(t *testing.T) {
 		cleanHandlers()
 
 		count := len(handlers)
-		testHandler := func(payload json.RawMessage) error { return nil }
+		testHandler := func(payload Payload) error { return nil }
 
 		Register("", testHandler)
 
@@ -196,11 +313,11 @@ func TestRegister(t *testing.T) {
 		cleanHandlers()
 
 		var handlerCalled bool
-		firstHandler := func(payload json.RawMessage) error {
+		firstHandler := func(payload Payload) error {
 			handlerCalled = true
 			return nil
 		}
-		secondHandler := func(payload json.RawMessage) error { return nil }
+		secondHandler := func(payload Payload) error { return nil }
 
 		Register("duplicate.event", firstHandler)
 		count := len(handlers)
@@ -223,9 +340,9 @@ func TestRegister(t *testing.T) {
 	t.Run("should register multiple different handlers", func(t *testing.T) {
 		cleanHandlers()
 
-		handler1 := func(payload json.RawMessage) error { return nil }
-		handler2 := func(payload json.RawMessage) error { return nil }
-		handler3 := func(payload json.RawMessage) error { return nil }
+		handler1 := func(payload Payload) error { return nil }
+		handler2 := func(payload Payload) error { return nil }
+		handler3 := func(payload Payload) error { return nil }
 
 		Register("event.1", handler1)
 		Register("event.2", handler2)
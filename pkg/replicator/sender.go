@@ -17,12 +17,13 @@ package replicator
 import (
 	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"os"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/aigw-project/metadata-center/pkg/servicediscovery"
@@ -44,18 +45,42 @@ const (
 	ReplicaClientMaxIdleConnTimeout    = "REPLICA_CLIENT_IDLE_CONN_TIMEOUT"
 	ReplicaClientKeepAlivePeriod       = "REPLICA_CLIENT_KEEPALIVE_PERIOD"
 	ReplicaDnsLookUpInterval           = "REPLICA_DNS_LOOKUP_INTERVAL"
+	ReplicaClientEncoding              = "REPLICA_CLIENT_ENCODING"
+	ReplicaClientBatchSize             = "REPLICA_CLIENT_BATCH_SIZE"
+	ReplicaClientBatchInterval         = "REPLICA_CLIENT_BATCH_INTERVAL"
+	ReplicaClientCompression           = "REPLICA_CLIENT_COMPRESSION"
+	ReplicaToken                       = "REPLICA_TOKEN"
+	// TokenHeader carries the token shared by the instances, required by receivers with a token configured
+	TokenHeader = "X-Replica-Token"
 )
 
+// legacyRetryInterval is how long a host that rejected the configured encoding or batches is sent JSON events one by one
+// The configured encoding is tried again afterwards, the host may have been upgraded meanwhile
+const legacyRetryInterval = 10 * time.Minute
+
 // Replicator handles sending replication events to other nodes
 type Replicator struct {
 	client           *http.Client
 	serviceDiscovery types.ServiceDiscovery
 	port             int
+	// contentType is the encoding of the sent payloads, receivers predating msgpack accept JSON only
+	contentType string
+	// batchSize is the number of events sent to a host in one envelope, 1 sends each event on its own
+	batchSize     int
+	batchInterval time.Duration
+	// compression applies to batched envelopes only
+	compression string
+	batchers    sync.Map // host -> *batcher
+	// legacy holds the hosts that rejected the configured encoding or batches, by the time they did
+	legacy sync.Map // host -> time.Time
 }
 
 // replicator is the singleton instance of the replication client
 var replicator *Replicator
 
+// token authenticates the instances to each other, events are accepted from any caller without one
+var token string
+
 // createDefaultHTTPClient creates a configured HTTP client for replication
 // Uses environment variables for timeout and connection pool configuration
 func createDefaultHTTPClient() *http.Client {
@@ -85,8 +110,21 @@ func Replicate(c context.Context, eventType string, payload any) {
 	replicator.replicate(c, eventType, payload)
 }
 
+// Hosts returns the other instances found by service discovery
+// Returns an error before the replicator is initialized or while the lookups fail, an empty list is then no evidence
+func Hosts() ([]string, error) {
+	if replicator == nil {
+		return nil, fmt.Errorf("replicator is not initialized")
+	}
+	if err := replicator.serviceDiscovery.Err(); err != nil {
+		return nil, err
+	}
+	return replicator.serviceDiscovery.GetHosts(), nil
+}
+
 // replicate sends replication events to all available hosts
-// Marshals payload and initiates concurrent requests with retry logic
+// Encodes payload and initiates concurrent requests with retry logic, or adds it to the batch of each host
+// Hosts that rejected the configured encoding or batches are sent the event as JSON
 func (r *Replicator) replicate(c context.Context, eventType string, payload any) {
 	hosts := r.serviceDiscovery.GetHosts()
 	if len(hosts) == 0 {
@@ -94,29 +132,99 @@ func (r *Replicator) replicate(c context.Context, eventType string, payload any)
 		return
 	}
 
-	body, err := json.Marshal(payload)
+	traceID := helper.GetTraceIDFromCtx(c)
+	logger.Debugf("Replicating event to hosts: %v", hosts)
+
+	// Every encoding is done once for all hosts
+	bodies := make(map[string][]byte, 2)
+	encode := func(contentType string) ([]byte, bool) {
+		if body, ok := bodies[contentType]; ok {
+			return body, body != nil
+		}
+		body, err := encodePayload(payload, contentType)
+		if err != nil {
+			logger.Errorf("Replicator: encode payload as %s error: %v", contentType, err)
+		}
+		bodies[contentType] = body
+		return body, body != nil
+	}
+
+	for _, host := range hosts {
+		switch {
+		case r.isLegacy(host):
+			if body, ok := encode(ContentTypeJSON); ok {
+				go r.sendRequestWithRetry(c, host, traceID, eventType, ContentTypeJSON, body, "")
+			}
+		case r.batchSize > 1:
+			r.batcherOf(host).add(traceID, eventType, payload)
+		default:
+			if body, ok := encode(r.contentType); ok {
+				go r.sendEvent(c, host, traceID, eventType, payload, body)
+			}
+		}
+	}
+}
+
+// sendEvent sends an event in the configured encoding, and again as JSON if the host rejects that encoding
+func (r *Replicator) sendEvent(ctx context.Context, host, traceID, eventType string, payload any, body []byte) {
+	if !r.sendRequestWithRetry(ctx, host, traceID, eventType, r.contentType, body, "") {
+		return
+	}
+	r.fallBack(host)
+	body, err := encodePayload(payload, ContentTypeJSON)
 	if err != nil {
-		logger.Errorf("Replicator: marshal payload error: %v", err)
+		logger.Errorf("Replicator: marshal payload of %s error: %v", eventType, err)
 		return
 	}
+	r.sendRequestWithRetry(ctx, host, traceID, eventType, ContentTypeJSON, body, "")
+}
 
-	traceID := helper.GetTraceIDFromCtx(c)
-	logger.Debugf("Replicating event to hosts: %v", hosts)
-	for _, host := range hosts {
-		go r.sendRequestWithRetry(c, host, traceID, eventType, body)
+// isLegacy tells whether a host is sent JSON events one by one, because it rejected the configured encoding or batches
+func (r *Replicator) isLegacy(host string) bool {
+	since, ok := r.legacy.Load(host)
+	if !ok {
+		return false
+	}
+	if time.Since(since.(time.Time)) < legacyRetryInterval {
+		return true
+	}
+	r.legacy.Delete(host)
+	return false
+}
+
+// fallBack sends JSON events one by one to a host for legacyRetryInterval
+func (r *Replicator) fallBack(host string) {
+	r.legacy.Store(host, time.Now())
+	logger.Warnf("Replicator: %s rejected %s or batches, sending it JSON events one by one for %s", host, r.contentType, legacyRetryInterval)
+}
+
+// rejectsEncoding tells whether a failed response rejects the encoding of the request rather than its event
+// Receivers answer 415 to an unknown Content-Type or Content-Encoding. Receivers predating msgpack and batches answer 400
+// without the Accept-Post header, to batches with an unknown event type
+func rejectsEncoding(resp *http.Response, eventType string, respBody []byte) bool {
+	switch {
+	case resp.StatusCode == http.StatusUnsupportedMediaType:
+		return true
+	case resp.StatusCode != http.StatusBadRequest:
+		return false
+	case eventType == BatchEventType && bytes.Contains(respBody, []byte(unsupportedEventType)):
+		return true
 	}
+	return resp.Header.Get(AcceptPostHeader) == ""
 }
 
 // sendRequestWithRetry sends a replication request with retry logic
 // Handles panics and retries failed requests up to maxAttempts
-func (r *Replicator) sendRequestWithRetry(ctx context.Context, targetHost, traceID, eventType string, body []byte) {
+// Returns whether the host rejected the encoding of a msgpack or batched body, which is not retried in the same encoding
+func (r *Replicator) sendRequestWithRetry(ctx context.Context, targetHost, traceID, eventType, contentType string, body []byte, contentEncoding string) (rejected bool) {
 	defer func() {
 		if p := recover(); p != nil {
 			logger.Errorf("Replicator: Recovered from panic in replicate goroutine for Host %s. Panic: %v", targetHost, p)
 		}
 	}()
 
-	url := fmt.Sprintf("http://%s:%d%s", targetHost, r.port, ReplicaEventPath)
+	url := fmt.Sprintf("http://%s%s", net.JoinHostPort(targetHost, strconv.Itoa(r.port)), ReplicaEventPath)
+	negotiated := contentType != ContentTypeJSON || eventType == BatchEventType
 
 	maxAttempts := 2 // 1 initial attempt + 1 retry
 	var lastErr error
@@ -125,12 +233,18 @@ func (r *Replicator) sendRequestWithRetry(ctx context.Context, targetHost, trace
 		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
 		if err != nil {
 			logger.Errorf("Replicator: Failed to create request for Host %s, aborting. Error: %v", targetHost, err)
-			return
+			return false
 		}
 
-		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Type", contentType)
+		if contentEncoding != "" {
+			req.Header.Set("Content-Encoding", contentEncoding)
+		}
 		req.Header.Set(TraceIdHeader, traceID)
 		req.Header.Set(EventTypeHeader, eventType)
+		if token != "" {
+			req.Header.Set(TokenHeader, token)
+		}
 
 		resp, err := r.client.Do(req)
 		if err != nil {
@@ -147,16 +261,21 @@ func (r *Replicator) sendRequestWithRetry(ctx context.Context, targetHost, trace
 		if resp.StatusCode == http.StatusOK {
 			logger.Debugf("Replicator: Successfully replicated event to %s (attempt %d/%d)", targetHost, attempt+1, maxAttempts)
 			resp.Body.Close()
-			return
+			return false
 		}
+		resp.Body.Close()
 
 		lastErr = fmt.Errorf("server returned non-200 status: %d, body: %q", resp.StatusCode, string(respBody))
+		if negotiated && rejectsEncoding(resp, eventType, respBody) {
+			logger.Warnf("Replicator: %s rejected the encoding of %s: %v", targetHost, eventType, lastErr)
+			return true
+		}
 		// Non-200 status codes trigger retry
 		logger.Warnf("Replicator: Replication failed (attempt %d/%d): %v. Retrying...", targetHost, attempt+1, maxAttempts, lastErr)
-		resp.Body.Close()
 	}
 
 	logger.Errorf("Replicator: Failed to send event to %s after %d attempts. Last error: %v", targetHost, maxAttempts, lastErr)
+	return false
 }
 
 // Init initializes the replicator singleton with service discovery
@@ -173,11 +292,33 @@ func Init() {
 		logger.Fatalf("Failed to initialize service discovery: %v", err)
 	}
 
+	encoding := helper.GetStringFromEnv(ReplicaClientEncoding, EncodingJSON)
+	contentType := contentTypeOf(encoding)
+	if contentType == "" {
+		logger.Errorf("unknown replication encoding %s, keeping %s", encoding, EncodingJSON)
+		encoding, contentType = EncodingJSON, ContentTypeJSON
+	}
+	compression := helper.GetStringFromEnv(ReplicaClientCompression, CompressionNone)
+	if compression != CompressionNone && compression != CompressionGzip {
+		logger.Errorf("unknown replication compression %s, keeping %s", compression, CompressionNone)
+		compression = CompressionNone
+	}
+
+	token = os.Getenv(ReplicaToken)
+	if token == "" {
+		logger.Warnf("%s is not set, replication events are accepted from any caller", ReplicaToken)
+	}
+
 	replicator = &Replicator{
 		client:           createDefaultHTTPClient(),
 		serviceDiscovery: sd,
 		port:             helper.GetIntFromEnv(ReplicaEventTargetPort, 80),
+		contentType:      contentType,
+		batchSize:        helper.GetIntFromEnv(ReplicaClientBatchSize, 1),
+		batchInterval:    helper.GetDurationFromEnv(ReplicaClientBatchInterval, 5*time.Millisecond),
+		compression:      compression,
 	}
 
-	logger.Infof("Replicator initialized successfully.")
+	logger.Infof("Replicator initialized successfully, encoding: %s, batch size: %d, batch interval: %s, compression: %s",
+		encoding, replicator.batchSize, replicator.batchInterval, compression)
 }
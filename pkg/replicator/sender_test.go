@@ -0,0 +1,49 @@
+// Copyright The AIGW Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replicator
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type failingDiscovery struct {
+	staticDiscovery
+}
+
+func (d failingDiscovery) Err() error {
+	return errors.New("lookup failed")
+}
+
+func TestHosts(t *testing.T) {
+	original := replicator
+	defer func() { replicator = original }()
+
+	replicator = nil
+	_, err := Hosts()
+	assert.Error(t, err, "no hosts are known before the replicator is initialized")
+
+	replicator = &Replicator{serviceDiscovery: failingDiscovery{staticDiscovery{"10.0.0.2"}}}
+	_, err = Hosts()
+	assert.Error(t, err, "the hosts of a failing discovery are no evidence")
+
+	replicator = &Replicator{serviceDiscovery: staticDiscovery{}}
+	hosts, err := Hosts()
+	require.NoError(t, err)
+	assert.Empty(t, hosts, "an empty host list is reported as such")
+}
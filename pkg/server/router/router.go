@@ -24,7 +24,7 @@ import (
 )
 
 // RegisterLoadAPI registers load-related API endpoints
-// Includes stats and prompt management endpoints
+// Includes stats, prompt management, history, score, engine report and reservation endpoints
 func RegisterLoadAPI(g *gin.RouterGroup) {
 	loadAPI := api.LoadAPI{}
 	gGroup := g.Group("/v1/load")
@@ -33,11 +33,47 @@ func RegisterLoadAPI(g *gin.RouterGroup) {
 		stats.GET("", loadAPI.Query)
 		stats.POST("", loadAPI.Set)
 		stats.DELETE("", loadAPI.Delete)
+		stats.GET("clusters", loadAPI.QueryClusters)
 	}
 	prompt := gGroup.Group("prompt")
 	{
 		prompt.DELETE("", loadAPI.DeletePrompt)
 	}
+	history := gGroup.Group("history")
+	{
+		history.GET("", loadAPI.History)
+	}
+	score := gGroup.Group("score")
+	{
+		score.GET("", loadAPI.Score)
+	}
+	report := gGroup.Group("report")
+	{
+		report.POST("", loadAPI.Report)
+	}
+	reserve := gGroup.Group("reserve")
+	{
+		reserve.POST("", loadAPI.Reserve)
+		reserve.DELETE("", loadAPI.Release)
+	}
+}
+
+// RegisterAdminAPI registers introspection and correction endpoints for clusters, engines, requests and tenants
+func RegisterAdminAPI(g *gin.RouterGroup) {
+	adminAPI := api.AdminAPI{}
+	gGroup := g.Group("/v1/admin")
+	{
+		gGroup.GET("clusters", adminAPI.Clusters)
+		gGroup.GET("engine/requests", adminAPI.EngineRequests)
+		gGroup.GET("request", adminAPI.Request)
+		gGroup.DELETE("request", adminAPI.Evict)
+		gGroup.POST("engine/reset", adminAPI.ResetEngine)
+		gGroup.DELETE("engine", adminAPI.DeleteEngine)
+		gGroup.DELETE("cluster", adminAPI.DeleteCluster)
+		gGroup.GET("tenants", adminAPI.Tenants)
+		gGroup.PUT("tenant/limit", adminAPI.SetTenantLimit)
+		gGroup.DELETE("tenant/limit", adminAPI.DeleteTenantLimit)
+	}
 }
 
 // RegisterStatusAPI registers metrics endpoint for Prometheus
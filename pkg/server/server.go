@@ -49,6 +49,7 @@ func NewServer() *Server {
 		g := engine.Group("")
 		router.RegisterLogAPI(g)
 		router.RegisterLoadAPI(g)
+		router.RegisterAdminAPI(g)
 		router.RegisterStatusAPI(g)
 		router.RegisterReplicateAPI(g)
 	}
@@ -101,13 +102,17 @@ func (s *Server) Stop() {
 }
 
 // Init initializes server dependencies including logging and replication
-func (s *Server) Init() {
+func (s *Server) Init() error {
 	_, err := log.InitLogger()
 	if err != nil {
 		logger.Errorf("logger inint error: %v", err)
-		return
+		return err
 	}
 
-	load.Init()
+	if err := load.Init(); err != nil {
+		logger.Errorf("load init error: %v", err)
+		return err
+	}
 	replicator.Init()
+	return nil
 }
@@ -40,6 +40,7 @@ type dnsServiceDiscovery struct {
 	nodeList  map[string]struct{} // Set of discovered host IP addresses
 	hosts     []string            // List of discovered hosts (excluding local host)
 	localHost string              // Local host address to exclude from results
+	err       error               // Error of the last lookup, set until the first lookup completes
 }
 
 // NewDNSDiscovery creates a new DNS-based service discovery instance.
@@ -61,6 +62,7 @@ func NewDNSDiscovery(config DNSConfig) (types.ServiceDiscovery, error) {
 		config:    config,
 		nodeList:  make(map[string]struct{}),
 		localHost: localHost,
+		err:       fmt.Errorf("no DNS lookup for domain %s completed yet", config.Domain),
 	}
 
 	sd.start()
@@ -94,6 +96,7 @@ func (sd *dnsServiceDiscovery) dnsLookUp() {
 	hosts, err := net.LookupIP(sd.config.Domain)
 	if err != nil {
 		logger.Errorf("LookupIP failed for domain %s, err: %v", sd.config.Domain, err)
+		sd.setErr(err)
 		return
 	}
 
@@ -103,12 +106,16 @@ func (sd *dnsServiceDiscovery) dnsLookUp() {
 		newHosts[host.String()] = struct{}{}
 	}
 
+	// The domain resolves to this instance as well, an empty result is a lookup failure
+	var lookupErr error
 	if len(hosts) == 0 {
 		logger.Errorf("DNS lookup returned empty result for domain %s", sd.config.Domain)
+		lookupErr = fmt.Errorf("DNS lookup returned empty result for domain %s", sd.config.Domain)
 	}
 
 	sd.mutex.Lock()
 	defer sd.mutex.Unlock()
+	sd.err = lookupErr
 
 	for oldHost := range sd.nodeList {
 		if _, exists := newHosts[oldHost]; !exists {
@@ -144,3 +151,19 @@ func (sd *dnsServiceDiscovery) GetHosts() []string {
 
 	return sd.hosts
 }
+
+// Err returns the error of the last lookup, nil once one succeeded.
+func (sd *dnsServiceDiscovery) Err() error {
+	sd.mutex.RLock()
+	defer sd.mutex.RUnlock()
+
+	return sd.err
+}
+
+// setErr records a failed lookup, the hosts of the last successful one are kept.
+func (sd *dnsServiceDiscovery) setErr(err error) {
+	sd.mutex.Lock()
+	defer sd.mutex.Unlock()
+
+	sd.err = err
+}
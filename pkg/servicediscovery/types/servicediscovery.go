@@ -18,4 +18,7 @@ type GetLocalHostsFunc func() (hosts string, err error)
 
 type ServiceDiscovery interface {
 	GetHosts() []string
+	// Err returns the error of the last lookup, nil once one succeeded
+	// An empty host list is only reliable without error
+	Err() error
 }
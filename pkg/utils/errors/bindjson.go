@@ -76,18 +76,22 @@ func extractTypeErr(message string) string {
 
 // tagErrMap maps validation tag names to user-friendly error messages
 var tagErrMap = map[string]string{
-	"max":         "exceeds maximum value",
-	"min":         "below minimum value",
-	"lte":         "exceeds maximum value",
-	"gte":         "below minimum value",
-	"required":    "is required",
-	"required_if": "is required",
-	"lowercase":   "only lowercase characters allowed",
-	"alpha":       "invalid input",                // Only ASCII letters allowed (no numbers, punctuation, control chars)
-	"oneof":       "invalid input",                // Enum value validation
-	"unique":      "duplicate values not allowed", // Duplicate values not allowed
-	"fqdn":        "invalid domain",
-	"ip":          "invalid IP address",
+	"max":              "exceeds maximum value",
+	"min":              "below minimum value",
+	"lte":              "exceeds maximum value",
+	"gte":              "below minimum value",
+	"required":         "is required",
+	"required_if":      "is required",
+	"lowercase":        "only lowercase characters allowed",
+	"alpha":            "invalid input",                // Only ASCII letters allowed (no numbers, punctuation, control chars)
+	"oneof":            "invalid input",                // Enum value validation
+	"unique":           "duplicate values not allowed", // Duplicate values not allowed
+	"fqdn":             "invalid domain",
+	"ip":               "invalid IP address",
+	"ip_port":          "invalid IP:port endpoint",
+	"glob":             "invalid glob pattern",
+	"required_without": "is required",
+	"excludes":         "contains an invalid character",
 }
 
 // tagRegexp matches validation tag errors from field validation
@@ -15,7 +15,24 @@
 package errors
 
 const (
+	// DuplicateCode 400, the resource exists already
+	DuplicateCode    = 40001000
 	InvalidInputCode = 40001400
+	// UnauthorizedCode 401, the caller could not be authenticated
+	UnauthorizedCode = 40101001
+	// ForbiddenCode 403, the caller may not use the endpoint
+	ForbiddenCode = 40301000
+	NotFoundCode  = 40401000
+	// ConflictCode 409, a conditional update lost against the current state
+	ConflictCode = 40901000
+	// TooLargeCode 413, the request body exceeds its size limit
+	TooLargeCode = 41301000
+	// UnsupportedMediaTypeCode 415, the Content-Type or Content-Encoding of the body is unknown
+	UnsupportedMediaTypeCode = 41501000
+	// QuotaExceededCode 429, the tenant is over one of its quotas
+	QuotaExceededCode = 42901000
 	// ServerErrorCode 5xx
 	ServerErrorCode = 50001000
+	// CapacityExceededCode 503, a capacity cap of the tracked state is reached
+	CapacityExceededCode = 50301000
 )
@@ -16,7 +16,16 @@ package errors
 
 // Error message constants for consistent error responses
 var (
+	duplicateMsg      = "Data duplicate"
 	invalidInputMsg   = "Invalid input parameters"
+	unauthorizedMsg   = "Authentication failed"
+	forbiddenMsg      = "Forbidden"
+	notFoundMsg       = "Resource not found"
+	conflictMsg       = "Conflict"
+	tooLargeMsg       = "Request entity too large"
+	mediaTypeMsg      = "Unsupported media type"
+	quotaExceededMsg  = "Quota exceeded"
 	serverErrorMsg    = "Internal server error"
+	capacityMsg       = "Capacity exceeded"
 	ParseJsonFieldMsg = "Invalid input parameters"
 )
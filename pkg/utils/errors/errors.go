@@ -27,6 +27,78 @@ func InvalidInput(reason string, args ...interface{}) *ErrorInfo {
 	}
 }
 
+// Duplicate creates an error for resources that exist already
+func Duplicate(reason string, args ...interface{}) *ErrorInfo {
+	return &ErrorInfo{
+		Code:    DuplicateCode,
+		Message: duplicateMsg,
+		Reason:  fmt.Sprintf(reason, args...),
+	}
+}
+
+// Unauthorized creates an error for callers whose identity could not be verified
+func Unauthorized(reason string, args ...interface{}) *ErrorInfo {
+	return &ErrorInfo{
+		Code:    UnauthorizedCode,
+		Message: unauthorizedMsg,
+		Reason:  fmt.Sprintf(reason, args...),
+	}
+}
+
+// Forbidden creates an error for callers that may not use an endpoint
+func Forbidden(reason string, args ...interface{}) *ErrorInfo {
+	return &ErrorInfo{
+		Code:    ForbiddenCode,
+		Message: forbiddenMsg,
+		Reason:  fmt.Sprintf(reason, args...),
+	}
+}
+
+// NotFound creates an error for missing resources
+func NotFound(reason string, args ...interface{}) *ErrorInfo {
+	return &ErrorInfo{
+		Code:    NotFoundCode,
+		Message: notFoundMsg,
+		Reason:  fmt.Sprintf(reason, args...),
+	}
+}
+
+// Conflict creates an error for conditional updates rejected by the current state
+func Conflict(reason string, args ...interface{}) *ErrorInfo {
+	return &ErrorInfo{
+		Code:    ConflictCode,
+		Message: conflictMsg,
+		Reason:  fmt.Sprintf(reason, args...),
+	}
+}
+
+// TooLarge creates an error for request bodies over their size limit
+func TooLarge(reason string, args ...interface{}) *ErrorInfo {
+	return &ErrorInfo{
+		Code:    TooLargeCode,
+		Message: tooLargeMsg,
+		Reason:  fmt.Sprintf(reason, args...),
+	}
+}
+
+// UnsupportedMediaType creates an error for request bodies in an unknown format
+func UnsupportedMediaType(reason string, args ...interface{}) *ErrorInfo {
+	return &ErrorInfo{
+		Code:    UnsupportedMediaTypeCode,
+		Message: mediaTypeMsg,
+		Reason:  fmt.Sprintf(reason, args...),
+	}
+}
+
+// QuotaExceeded creates an error for requests rejected by a tenant quota
+func QuotaExceeded(reason string, args ...interface{}) *ErrorInfo {
+	return &ErrorInfo{
+		Code:    QuotaExceededCode,
+		Message: quotaExceededMsg,
+		Reason:  fmt.Sprintf(reason, args...),
+	}
+}
+
 // ServerError creates an error for internal server errors
 func ServerError(reason string, args ...interface{}) *ErrorInfo {
 	return &ErrorInfo{
@@ -35,3 +107,12 @@ func ServerError(reason string, args ...interface{}) *ErrorInfo {
 		Reason:  fmt.Sprintf(reason, args...),
 	}
 }
+
+// CapacityExceeded creates an error for calls rejected because the tracked state is at capacity
+func CapacityExceeded(reason string, args ...interface{}) *ErrorInfo {
+	return &ErrorInfo{
+		Code:    CapacityExceededCode,
+		Message: capacityMsg,
+		Reason:  fmt.Sprintf(reason, args...),
+	}
+}
@@ -102,6 +102,15 @@ func GetIntFromEnv(name string, defaultValue int) int {
 	return defaultValue
 }
 
+// GetStringFromEnv retrieves string value from environment variable
+// Returns defaultValue if environment variable is not set
+func GetStringFromEnv(name string, defaultValue string) string {
+	if env := os.Getenv(name); env != "" {
+		return env
+	}
+	return defaultValue
+}
+
 // GetDurationFromEnv retrieves duration value from environment variable
 // Returns defaultValue if environment variable is not set or invalid
 func GetDurationFromEnv(name string, defaultValue time.Duration) time.Duration {
@@ -124,7 +133,7 @@ func GetTraceIDFromCtx(ctx context.Context) string {
 }
 
 // GetLocalHosts retrieves and validates the pod IP from environment
-// Panics if POD_IP is not set or invalid
+// Both IPv4 and IPv6 addresses are accepted for IPv6-only clusters
 func GetLocalHosts() (string, error) {
 	podIP := os.Getenv(MetaDataCenterPodIp)
 	if podIP == "" {
@@ -132,9 +141,10 @@ func GetLocalHosts() (string, error) {
 	}
 
 	ip := net.ParseIP(podIP)
-	if ip == nil || ip.To4() == nil {
+	if ip == nil {
 		return "", fmt.Errorf("invalid IP format in POD_IP env var: got %q", podIP)
 	}
 
-	return podIP, nil
+	// DNS lookups return canonical addresses, keep the same form for local host exclusion
+	return ip.String(), nil
 }
@@ -18,6 +18,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -34,6 +35,9 @@ type Entry = logrus.Entry
 // Hook logrus.Hook alias
 type Hook = logrus.Hook
 
+// Fields logrus.Fields alias
+type Fields = logrus.Fields
+
 type Level = logrus.Level
 
 // Define logger level
@@ -58,11 +62,17 @@ func (f *customFormatter) Format(entry *logrus.Entry) ([]byte, error) {
 	fmt.Fprintf(b, "[%s] ", entry.Level.String())
 	fmt.Fprintf(b, "%s ", entry.Message)
 
-	for key, value := range entry.Data {
+	// Fields are sorted so records such as audit entries keep a stable layout
+	keys := make([]string, 0, len(entry.Data))
+	for key := range entry.Data {
 		if key != "time" {
-			fmt.Fprintf(b, "%s=%v ", key, value)
+			keys = append(keys, key)
 		}
 	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(b, "%s=%v ", key, entry.Data[key])
+	}
 
 	b.WriteByte('\n')
 	return b.Bytes(), nil
@@ -119,6 +129,7 @@ var (
 	Fatalf          = logrus.Fatalf
 	Panicf          = logrus.Panicf
 	Printf          = logrus.Printf
+	WithFields      = logrus.WithFields
 	SetOutput       = logrus.SetOutput
 	SetReportCaller = logrus.SetReportCaller
 	StandardLogger  = logrus.StandardLogger
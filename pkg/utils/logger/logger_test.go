@@ -31,4 +31,15 @@ func TestFormat(t *testing.T) {
 		},
 	})
 	assert.Equal(t, "0001-01-01T00:00:00Z [debug] meeage key=value \n", string(s))
+
+	s, _ = formatter.Format(&logrus.Entry{
+		Level:   logrus.InfoLevel,
+		Message: "audit",
+		Data: logrus.Fields{
+			"target": "cluster",
+			"action": "delete_cluster",
+			"found":  true,
+		},
+	})
+	assert.Equal(t, "0001-01-01T00:00:00Z [info] audit action=delete_cluster found=true target=cluster \n", string(s))
 }